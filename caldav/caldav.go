@@ -0,0 +1,213 @@
+// Package caldav implements the WebDAV and CalDAV (RFC 4918, RFC 4791,
+// RFC 6578) wire format: request bodies, multistatus responses and
+// calendar-query filters. Mapping resources onto storage is left to the
+// caller.
+package caldav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// XML namespaces used by CalDAV clients.
+const (
+	NSDAV      = "DAV:"
+	NSCalDAV   = "urn:ietf:params:xml:ns:caldav"
+	NSCalendar = "http://calendarserver.org/ns/"
+)
+
+// Element is a generic XML element. It is used to read request bodies and
+// to build property values.
+type Element struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []Element  `xml:",any"`
+}
+
+// New builds an element in namespace space.
+func New(space, local string, children ...Element) Element {
+	return Element{XMLName: xml.Name{Space: space, Local: local}, Children: children}
+}
+
+// NewText builds an element holding character data.
+func NewText(space, local, text string) Element {
+	e := New(space, local)
+	e.Text = text
+	return e
+}
+
+// Href builds a DAV:href element.
+func Href(href string) Element {
+	return NewText(NSDAV, "href", href)
+}
+
+// Is reports whether e has the given name.
+func (e Element) Is(space, local string) bool {
+	return e.XMLName.Space == space && e.XMLName.Local == local
+}
+
+// Child returns the first child with the given name, or nil.
+func (e Element) Child(space, local string) *Element {
+	for i := range e.Children {
+		if e.Children[i].Is(space, local) {
+			return &e.Children[i]
+		}
+	}
+	return nil
+}
+
+// Attr returns the value of an unqualified attribute.
+func (e Element) Attr(local string) string {
+	for _, a := range e.Attrs {
+		if a.Name.Space == "" && a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Content returns the element's character data without surrounding space.
+func (e Element) Content() string {
+	return strings.TrimSpace(e.Text)
+}
+
+// Decode reads an XML request body. An empty body yields nil.
+func Decode(r io.Reader) (*Element, error) {
+	var e Element
+	err := xml.NewDecoder(r).Decode(&e)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("caldav: invalid XML body: %v", err)
+	}
+	return &e, nil
+}
+
+// PropRequest says which properties a PROPFIND or REPORT asked for.
+type PropRequest struct {
+	AllProp bool
+	Names   []xml.Name
+}
+
+// ParsePropRequest reads the DAV:prop or DAV:allprop child of e. A missing
+// element means allprop, as for an empty PROPFIND body.
+func ParsePropRequest(e *Element) PropRequest {
+	if e == nil || e.Child(NSDAV, "allprop") != nil {
+		return PropRequest{AllProp: true}
+	}
+	prop := e.Child(NSDAV, "prop")
+	if prop == nil {
+		return PropRequest{AllProp: true}
+	}
+	var req PropRequest
+	for _, p := range prop.Children {
+		req.Names = append(req.Names, p.XMLName)
+	}
+	return req
+}
+
+// Multistatus is a 207 Multi-Status response body.
+type Multistatus struct {
+	XMLName   xml.Name   `xml:"DAV: multistatus"`
+	Responses []Response `xml:"response"`
+	SyncToken string     `xml:"sync-token,omitempty"`
+}
+
+// Response describes one resource. Either Status or Propstats is set.
+type Response struct {
+	Href      string     `xml:"href"`
+	Status    string     `xml:"status,omitempty"`
+	Propstats []Propstat `xml:"propstat"`
+}
+
+type Propstat struct {
+	Prop   Prop   `xml:"prop"`
+	Status string `xml:"status"`
+}
+
+type Prop struct {
+	Values []Element `xml:",any"`
+}
+
+// Status formats an HTTP status line for a multistatus body.
+func Status(code int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", code, http.StatusText(code))
+}
+
+// NewResponse answers req for the resource at href. Properties the resource
+// has are returned with 200; requested ones it lacks are listed under 404.
+// hidden names are left out of allprop responses because they are
+// expensive or large, like calendar-data.
+func NewResponse(href string, req PropRequest, props []Element, hidden ...xml.Name) Response {
+	resp := Response{Href: href}
+	var found, missing []Element
+
+	if req.AllProp {
+		for _, p := range props {
+			if !containsName(hidden, p.XMLName) {
+				found = append(found, p)
+			}
+		}
+	} else {
+		for _, name := range req.Names {
+			if p := findProp(props, name); p != nil {
+				found = append(found, *p)
+			} else {
+				missing = append(missing, Element{XMLName: name})
+			}
+		}
+	}
+
+	if len(found) > 0 || len(missing) == 0 {
+		resp.Propstats = append(resp.Propstats, Propstat{Prop: Prop{found}, Status: Status(http.StatusOK)})
+	}
+	if len(missing) > 0 {
+		resp.Propstats = append(resp.Propstats, Propstat{Prop: Prop{missing}, Status: Status(http.StatusNotFound)})
+	}
+	return resp
+}
+
+// NewStatusResponse reports a bare status for href, e.g. 404 for a member
+// removed since the last sync.
+func NewStatusResponse(href string, code int) Response {
+	return Response{Href: href, Status: Status(code)}
+}
+
+func findProp(props []Element, name xml.Name) *Element {
+	for i := range props {
+		if props[i].XMLName == name {
+			return &props[i]
+		}
+	}
+	return nil
+}
+
+func containsName(names []xml.Name, name xml.Name) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteMultistatus sends ms with status 207.
+func WriteMultistatus(w http.ResponseWriter, ms Multistatus) error {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	return xml.NewEncoder(w).Encode(ms)
+}
+
+// WriteError sends a DAV:error body naming the failed precondition.
+func WriteError(w http.ResponseWriter, code int, condition Element) error {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(code)
+	io.WriteString(w, xml.Header)
+	return xml.NewEncoder(w).Encode(New(NSDAV, "error", condition))
+}
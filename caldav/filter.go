@@ -0,0 +1,215 @@
+package caldav
+
+import (
+	"fmt"
+	"strings"
+	"task-manager/ical"
+	"time"
+)
+
+// CompFilter is a CALDAV:comp-filter from a calendar-query REPORT.
+type CompFilter struct {
+	Name         string
+	IsNotDefined bool
+	TimeRange    *TimeRange
+	Props        []PropFilter
+	Comps        []CompFilter
+}
+
+// PropFilter is a CALDAV:prop-filter.
+type PropFilter struct {
+	Name         string
+	IsNotDefined bool
+	TimeRange    *TimeRange
+	TextMatch    *TextMatch
+}
+
+// TextMatch is a CALDAV:text-match. Only the i;octet collation is case
+// sensitive; everything else is compared like i;ascii-casemap.
+type TextMatch struct {
+	Text          string
+	Negate        bool
+	CaseSensitive bool
+}
+
+// TimeRange is a CALDAV:time-range. A zero Start or End is unbounded.
+type TimeRange struct {
+	Start, End time.Time
+}
+
+// ParseFilter reads a CALDAV:filter element.
+func ParseFilter(filter *Element) (CompFilter, error) {
+	if filter == nil {
+		return CompFilter{Name: "VCALENDAR"}, nil
+	}
+	comp := filter.Child(NSCalDAV, "comp-filter")
+	if comp == nil {
+		return CompFilter{}, fmt.Errorf("caldav: filter without comp-filter")
+	}
+	return parseCompFilter(*comp)
+}
+
+func parseCompFilter(e Element) (CompFilter, error) {
+	f := CompFilter{Name: strings.ToUpper(e.Attr("name"))}
+	if f.Name == "" {
+		return f, fmt.Errorf("caldav: comp-filter without name")
+	}
+	for _, child := range e.Children {
+		switch {
+		case child.Is(NSCalDAV, "is-not-defined"):
+			f.IsNotDefined = true
+		case child.Is(NSCalDAV, "time-range"):
+			tr, err := parseTimeRange(child)
+			if err != nil {
+				return f, err
+			}
+			f.TimeRange = tr
+		case child.Is(NSCalDAV, "prop-filter"):
+			pf, err := parsePropFilter(child)
+			if err != nil {
+				return f, err
+			}
+			f.Props = append(f.Props, pf)
+		case child.Is(NSCalDAV, "comp-filter"):
+			cf, err := parseCompFilter(child)
+			if err != nil {
+				return f, err
+			}
+			f.Comps = append(f.Comps, cf)
+		}
+	}
+	return f, nil
+}
+
+func parsePropFilter(e Element) (PropFilter, error) {
+	f := PropFilter{Name: strings.ToUpper(e.Attr("name"))}
+	if f.Name == "" {
+		return f, fmt.Errorf("caldav: prop-filter without name")
+	}
+	for _, child := range e.Children {
+		switch {
+		case child.Is(NSCalDAV, "is-not-defined"):
+			f.IsNotDefined = true
+		case child.Is(NSCalDAV, "time-range"):
+			tr, err := parseTimeRange(child)
+			if err != nil {
+				return f, err
+			}
+			f.TimeRange = tr
+		case child.Is(NSCalDAV, "text-match"):
+			f.TextMatch = &TextMatch{
+				Text:          child.Content(),
+				Negate:        child.Attr("negate-condition") == "yes",
+				CaseSensitive: child.Attr("collation") == "i;octet",
+			}
+		}
+	}
+	return f, nil
+}
+
+func parseTimeRange(e Element) (*TimeRange, error) {
+	var tr TimeRange
+	for attr, dst := range map[string]*time.Time{"start": &tr.Start, "end": &tr.End} {
+		v := e.Attr(attr)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse("20060102T150405Z", v)
+		if err != nil {
+			return nil, fmt.Errorf("caldav: invalid time-range %s %q", attr, v)
+		}
+		*dst = t
+	}
+	return &tr, nil
+}
+
+// Match reports whether the top-level component c satisfies f.
+func (f CompFilter) Match(c *ical.Component) bool {
+	return c.Name == f.Name && f.matchContent(c)
+}
+
+func (f CompFilter) matchContent(c *ical.Component) bool {
+	if f.TimeRange != nil && !f.TimeRange.overlaps(c) {
+		return false
+	}
+	for _, pf := range f.Props {
+		if !pf.match(c) {
+			return false
+		}
+	}
+	for _, cf := range f.Comps {
+		found := false
+		for _, child := range c.Components {
+			if child.Name == cf.Name && (cf.IsNotDefined || cf.matchContent(child)) {
+				found = true
+				break
+			}
+		}
+		if found == cf.IsNotDefined {
+			return false
+		}
+	}
+	return true
+}
+
+func (f PropFilter) match(c *ical.Component) bool {
+	var props []ical.Property
+	for _, p := range c.Props {
+		if p.Name == f.Name {
+			props = append(props, p)
+		}
+	}
+	if f.IsNotDefined {
+		return len(props) == 0
+	}
+	for _, p := range props {
+		if f.TextMatch != nil && !f.TextMatch.match(p.TextValue()) {
+			continue
+		}
+		if f.TimeRange != nil {
+			t, _, err := p.Time(time.UTC)
+			if err != nil || !f.TimeRange.contains(t) {
+				continue
+			}
+		}
+		return true
+	}
+	// A negated text-match also matches when the property is absent.
+	return len(props) == 0 && f.TextMatch != nil && f.TextMatch.Negate && f.TimeRange == nil
+}
+
+func (m TextMatch) match(value string) bool {
+	text := m.Text
+	if !m.CaseSensitive {
+		value, text = strings.ToLower(value), strings.ToLower(text)
+	}
+	return strings.Contains(value, text) != m.Negate
+}
+
+func (tr TimeRange) contains(t time.Time) bool {
+	return (tr.Start.IsZero() || !t.Before(tr.Start)) && (tr.End.IsZero() || t.Before(tr.End))
+}
+
+// overlaps approximates RFC 4791 section 9.9 using DTSTART and DUE (or
+// DTEND). Components without either always match, as VTODOs do.
+func (tr TimeRange) overlaps(c *ical.Component) bool {
+	var start, end time.Time
+	if p := c.Prop("DTSTART"); p != nil {
+		start, _, _ = p.Time(time.UTC)
+	}
+	for _, name := range []string{"DUE", "DTEND"} {
+		if p := c.Prop(name); p != nil {
+			end, _, _ = p.Time(time.UTC)
+			break
+		}
+	}
+	switch {
+	case start.IsZero() && end.IsZero():
+		return true
+	case start.IsZero():
+		start = end
+	case end.IsZero():
+		end = start
+	}
+	return (tr.Start.IsZero() || !end.Before(tr.Start)) && (tr.End.IsZero() || start.Before(tr.End))
+}
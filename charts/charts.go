@@ -0,0 +1,160 @@
+// Package charts draws simple bar and line charts as standalone SVG, for
+// pages and reports rendered on the server.
+package charts
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"strings"
+)
+
+// Chart size and margins, in SVG user units.
+const (
+	width   = 640
+	height  = 240
+	marginL = 44
+	marginR = 12
+	marginT = 16
+	marginB = 40
+)
+
+// Palette is the order colors are given to series without one.
+var Palette = []string{"#15f9ad", "#6366f1", "#f59e0b", "#ef4444", "#0ea5e9", "#64748b"}
+
+// Series is one line of a line chart. Values are aligned with the chart's
+// labels; NaN leaves a gap.
+type Series struct {
+	Name   string
+	Values []float64
+	Color  string
+	Dashed bool
+}
+
+// Bar draws one bar per label.
+func Bar(title string, labels []string, values []float64, color string) template.HTML {
+	if color == "" {
+		color = Palette[0]
+	}
+	max := maxOf(values)
+
+	var b strings.Builder
+	open(&b, title)
+	slot := plotWidth() / float64(max1(len(values)))
+	axes(&b, labels, max, func(i int) float64 { return marginL + float64(i)*slot + slot/2 })
+	for i, v := range values {
+		if math.IsNaN(v) || v <= 0 {
+			continue
+		}
+		h := v / max * plotHeight()
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %s</title></rect>`,
+			marginL+float64(i)*slot+slot*0.15, marginT+plotHeight()-h, slot*0.7, h, attr(color),
+			template.HTMLEscapeString(labels[i]), number(v))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// Line draws each series as a line over the labels, with a legend.
+func Line(title string, labels []string, series []Series) template.HTML {
+	var all []float64
+	for _, s := range series {
+		all = append(all, s.Values...)
+	}
+	max := maxOf(all)
+
+	var b strings.Builder
+	open(&b, title)
+	step := plotWidth() / float64(max1(len(labels)-1))
+	axes(&b, labels, max, func(i int) float64 { return marginL + float64(i)*step })
+	for n, s := range series {
+		color := s.Color
+		if color == "" {
+			color = Palette[n%len(Palette)]
+		}
+		dash := ""
+		if s.Dashed {
+			dash = ` stroke-dasharray="6 4"`
+		}
+		var points []string
+		flush := func() {
+			if len(points) > 0 {
+				fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="2"%s points="%s"/>`,
+					attr(color), dash, strings.Join(points, " "))
+			}
+			points = points[:0]
+		}
+		for i, v := range s.Values {
+			if math.IsNaN(v) {
+				flush()
+				continue
+			}
+			points = append(points, fmt.Sprintf("%.1f,%.1f", marginL+float64(i)*step, marginT+plotHeight()-v/max*plotHeight()))
+		}
+		flush()
+
+		// Legend, along the top.
+		x := marginL + 8 + float64(n)*120
+		fmt.Fprintf(&b, `<rect x="%.1f" y="4" width="10" height="3" fill="%s"/><text x="%.1f" y="10" font-size="10" fill="#475569">%s</text>`,
+			x, attr(color), x+14, template.HTMLEscapeString(s.Name))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+func open(b *strings.Builder, title string) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="100%%" role="img" aria-label="%s" font-family="sans-serif">`,
+		width, height, attr(title))
+	fmt.Fprintf(b, `<title>%s</title>`, template.HTMLEscapeString(title))
+}
+
+// axes draws three horizontal grid lines with their values and up to about
+// twelve of the labels, label i centered on x(i).
+func axes(b *strings.Builder, labels []string, max float64, x func(int) float64) {
+	for i := 0; i <= 3; i++ {
+		y := marginT + plotHeight()*float64(i)/3
+		fmt.Fprintf(b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#e2e8f0"/>`, marginL, y, width-marginR, y)
+		fmt.Fprintf(b, `<text x="%d" y="%.1f" font-size="10" fill="#64748b" text-anchor="end">%s</text>`,
+			marginL-6, y+3, number(max*float64(3-i)/3))
+	}
+	every := (len(labels) + 11) / 12
+	for i, label := range labels {
+		if i%max1(every) != 0 {
+			continue
+		}
+		fmt.Fprintf(b, `<text x="%.1f" y="%d" font-size="10" fill="#64748b" text-anchor="middle">%s</text>`,
+			x(i), height-marginB+16, template.HTMLEscapeString(label))
+	}
+}
+
+func plotWidth() float64  { return width - marginL - marginR }
+func plotHeight() float64 { return height - marginT - marginB }
+
+// maxOf returns the top of the value axis: the largest value, at least 1.
+func maxOf(values []float64) float64 {
+	max := 1.0
+	for _, v := range values {
+		if !math.IsNaN(v) && v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func number(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.1f", v)
+}
+
+func attr(s string) string {
+	return template.HTMLEscapeString(s)
+}
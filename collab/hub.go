@@ -0,0 +1,461 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"task-manager/events"
+	"task-manager/models"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// LockTTL is how long an editing lock lasts unless the holder renews it by
+// sending another "lock" message.
+var LockTTL = 60 * time.Second
+
+const (
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = 50 * time.Second
+	maxMessage = 4096
+)
+
+// Access decides what a connected user may do. The hub itself knows nothing
+// about the database.
+type Access interface {
+	CanJoin(ctx context.Context, userID, projectID int) bool
+	CanLock(ctx context.Context, userID int, resource string, id int) bool
+}
+
+// Hub tracks project rooms, who is present in them and soft editing locks
+// on tasks and notes.
+type Hub struct {
+	access Access
+
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	rooms   map[int]map[*client]struct{}
+	locks   map[lockKey]*lock
+	closed  bool
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+type lockKey struct {
+	Resource string
+	ID       int
+}
+
+type lock struct {
+	holder  *client
+	expires time.Time
+}
+
+type client struct {
+	hub      *Hub
+	ctx      context.Context // the upgraded request's
+	conn     *websocket.Conn
+	send     chan []byte
+	id       int64
+	userID   int
+	username string
+	room     int
+	viewing  *int
+}
+
+// Member is one connection present in a room.
+type Member struct {
+	ClientID int64  `json:"client_id"`
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	TaskID   *int   `json:"task_id,omitempty"`
+}
+
+// LockInfo describes a held lock.
+type LockInfo struct {
+	Resource  string    `json:"resource"`
+	ID        int       `json:"id"`
+	ClientID  int64     `json:"client_id"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// inbound is a message from a client. Supported types:
+//
+//	{"type":"join","project_id":3}
+//	{"type":"leave"}
+//	{"type":"view","task_id":5}            (task_id null to close)
+//	{"type":"lock","resource":"task","id":5}  (also renews)
+//	{"type":"unlock","resource":"note","id":2}
+type inbound struct {
+	Type      string `json:"type"`
+	ProjectID int    `json:"project_id"`
+	TaskID    *int   `json:"task_id"`
+	Resource  string `json:"resource"`
+	ID        int    `json:"id"`
+}
+
+func NewHub(access Access) *Hub {
+	return &Hub{
+		access:  access,
+		clients: make(map[*client]struct{}),
+		rooms:   make(map[int]map[*client]struct{}),
+		locks:   make(map[lockKey]*lock),
+		stop:    make(chan struct{}),
+	}
+}
+
+var clientSeq int64
+
+// Serve runs a connection until it closes. The caller must already have
+// authenticated userID. ctx is passed to the access checks.
+func (h *Hub) Serve(ctx context.Context, conn *websocket.Conn, userID int, username string) {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(writeWait))
+		conn.Close()
+		return
+	}
+	clientSeq++
+	c := &client{
+		hub:      h,
+		ctx:      ctx,
+		conn:     conn,
+		send:     make(chan []byte, 32),
+		id:       clientSeq,
+		userID:   userID,
+		username: username,
+	}
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+
+	go c.writePump()
+	c.send <- mustJSON(map[string]interface{}{"type": "hello", "client_id": c.id})
+	c.readPump()
+}
+
+// Start begins expiring stale locks.
+func (h *Hub) Start() {
+	h.stopped.Add(1)
+	go func() {
+		defer h.stopped.Done()
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+				h.expireLocks()
+			}
+		}
+	}()
+}
+
+// Close disconnects every client.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	close(h.stop)
+	for c := range h.clients {
+		c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(writeWait))
+		c.conn.Close()
+	}
+	h.mu.Unlock()
+	h.stopped.Wait()
+}
+
+// Publish forwards a change event to the clients who can see it: the
+// project's room for task and project events, otherwise every connection of
+// the owning user.
+func (h *Hub) Publish(e events.Event) {
+	msg := mustJSON(map[string]interface{}{"type": "change", "event": e})
+
+	projectID := 0
+	switch data := e.Data.(type) {
+	case models.Task:
+		if data.ProjectID != nil {
+			projectID = *data.ProjectID
+		}
+	case models.Project:
+		projectID = data.ID
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if projectID != 0 {
+		h.broadcastRoomLocked(projectID, msg)
+		return
+	}
+	for c := range h.clients {
+		if c.userID == e.UserID {
+			c.queue(msg)
+		}
+	}
+}
+
+func (c *client) readPump() {
+	defer c.hub.disconnect(c)
+
+	c.conn.SetReadLimit(maxMessage)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		var msg inbound
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				slog.Error("WebSocket read error", "err", err)
+			}
+			return
+		}
+		c.hub.handle(c, msg)
+	}
+}
+
+func (c *client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// queue sends without blocking; a client that cannot keep up is dropped.
+// Must be called with h.mu held.
+func (c *client) queue(msg []byte) {
+	select {
+	case c.send <- msg:
+	default:
+		c.conn.Close()
+	}
+}
+
+func (h *Hub) handle(c *client, msg inbound) {
+	switch msg.Type {
+	case "join":
+		if !h.access.CanJoin(c.ctx, c.userID, msg.ProjectID) {
+			h.reply(c, errorMessage("project not found"))
+			return
+		}
+		h.join(c, msg.ProjectID)
+	case "leave":
+		h.mu.Lock()
+		h.leaveLocked(c)
+		h.mu.Unlock()
+	case "view":
+		h.mu.Lock()
+		c.viewing = msg.TaskID
+		if c.room != 0 {
+			h.broadcastPresenceLocked(c.room)
+		}
+		h.mu.Unlock()
+	case "lock":
+		if msg.Resource != "task" && msg.Resource != "note" {
+			h.reply(c, errorMessage("resource must be task or note"))
+			return
+		}
+		if !h.access.CanLock(c.ctx, c.userID, msg.Resource, msg.ID) {
+			h.reply(c, errorMessage(msg.Resource+" not found"))
+			return
+		}
+		h.lock(c, lockKey{msg.Resource, msg.ID})
+	case "unlock":
+		h.mu.Lock()
+		key := lockKey{msg.Resource, msg.ID}
+		if l, ok := h.locks[key]; ok && l.holder == c {
+			h.releaseLocked(key, l)
+		}
+		h.mu.Unlock()
+	default:
+		h.reply(c, errorMessage("unknown message type"))
+	}
+}
+
+func (h *Hub) reply(c *client, msg []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c.queue(msg)
+}
+
+func (h *Hub) join(c *client, projectID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.leaveLocked(c)
+	room := h.rooms[projectID]
+	if room == nil {
+		room = make(map[*client]struct{})
+		h.rooms[projectID] = room
+	}
+	room[c] = struct{}{}
+	c.room = projectID
+
+	locks := make([]LockInfo, 0)
+	for key, l := range h.locks {
+		if l.holder.userID == c.userID {
+			locks = append(locks, l.info(key))
+		}
+	}
+	c.queue(mustJSON(map[string]interface{}{
+		"type":       "joined",
+		"project_id": projectID,
+		"members":    h.membersLocked(projectID),
+		"locks":      locks,
+	}))
+	h.broadcastPresenceLocked(projectID)
+}
+
+func (h *Hub) leaveLocked(c *client) {
+	if c.room == 0 {
+		return
+	}
+	projectID := c.room
+	delete(h.rooms[projectID], c)
+	if len(h.rooms[projectID]) == 0 {
+		delete(h.rooms, projectID)
+	}
+	c.room = 0
+	c.viewing = nil
+	h.broadcastPresenceLocked(projectID)
+}
+
+func (h *Hub) lock(c *client, key lockKey) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	if l, ok := h.locks[key]; ok && l.holder != c && now.Before(l.expires) {
+		c.queue(mustJSON(map[string]interface{}{"type": "lock_denied", "lock": l.info(key)}))
+		return
+	}
+
+	l := &lock{holder: c, expires: now.Add(LockTTL)}
+	h.locks[key] = l
+	h.broadcastUserLocked(c.userID, mustJSON(map[string]interface{}{"type": "locked", "lock": l.info(key)}))
+}
+
+func (h *Hub) releaseLocked(key lockKey, l *lock) {
+	delete(h.locks, key)
+	h.broadcastUserLocked(l.holder.userID, mustJSON(map[string]interface{}{
+		"type":     "unlocked",
+		"resource": key.Resource,
+		"id":       key.ID,
+	}))
+}
+
+func (h *Hub) expireLocks() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	for key, l := range h.locks {
+		if !now.Before(l.expires) {
+			h.releaseLocked(key, l)
+		}
+	}
+}
+
+func (h *Hub) disconnect(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	delete(h.clients, c)
+	h.leaveLocked(c)
+	for key, l := range h.locks {
+		if l.holder == c {
+			h.releaseLocked(key, l)
+		}
+	}
+	close(c.send)
+}
+
+func (h *Hub) membersLocked(projectID int) []Member {
+	members := make([]Member, 0, len(h.rooms[projectID]))
+	for c := range h.rooms[projectID] {
+		members = append(members, Member{ClientID: c.id, UserID: c.userID, Username: c.username, TaskID: c.viewing})
+	}
+	return members
+}
+
+func (h *Hub) broadcastPresenceLocked(projectID int) {
+	h.broadcastRoomLocked(projectID, mustJSON(map[string]interface{}{
+		"type":       "presence",
+		"project_id": projectID,
+		"members":    h.membersLocked(projectID),
+	}))
+}
+
+func (h *Hub) broadcastRoomLocked(projectID int, msg []byte) {
+	for c := range h.rooms[projectID] {
+		c.queue(msg)
+	}
+}
+
+// Locks are visible to every connection that can see the locked item,
+// which under TaskLift's ownership model is every connection of its owner.
+func (h *Hub) broadcastUserLocked(userID int, msg []byte) {
+	for c := range h.clients {
+		if c.userID == userID {
+			c.queue(msg)
+		}
+	}
+}
+
+func (l *lock) info(key lockKey) LockInfo {
+	return LockInfo{
+		Resource:  key.Resource,
+		ID:        key.ID,
+		ClientID:  l.holder.id,
+		UserID:    l.holder.userID,
+		Username:  l.holder.username,
+		ExpiresAt: l.expires.UTC(),
+	}
+}
+
+func errorMessage(text string) []byte {
+	return mustJSON(map[string]string{"type": "error", "message": text})
+}
+
+func mustJSON(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("WebSocket encode error", "err", err)
+		return []byte(`{"type":"error","message":"encode failed"}`)
+	}
+	return b
+}
@@ -0,0 +1,345 @@
+// Package config loads the server's settings. Each setting has a default
+// and can be set in a YAML file, an environment variable and a
+// command-line flag, in increasing order of precedence.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"task-manager/logging"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the server's configuration. The yaml tag of a field is its key
+// in the file and, joined with its section's, the name of its flag, e.g.
+// -server.port. The env tag names its environment variable.
+type Config struct {
+	Server    Server    `yaml:"server"`
+	Database  Database  `yaml:"database"`
+	Session   Session   `yaml:"session"`
+	Storage   Storage   `yaml:"storage"`
+	SMTP      SMTP      `yaml:"smtp"`
+	Reminders Reminders `yaml:"reminders"`
+	Digests   Digests   `yaml:"digests"`
+	Reports   Reports   `yaml:"reports"`
+	Webhooks  Webhooks  `yaml:"webhooks"`
+	Jobs      Jobs      `yaml:"jobs"`
+	Metrics   Metrics   `yaml:"metrics"`
+	Log       Log       `yaml:"log"`
+}
+
+type Server struct {
+	Port        int    `yaml:"port" env:"PORT" help:"port to listen on"`
+	BaseURL     string `yaml:"base_url" env:"BASE_URL" help:"public URL of the server, used in links in emails and feeds (default http://localhost:<port>)"`
+	TemplateDir string `yaml:"template_dir" env:"TEMPLATE_DIR" help:"directory of the HTML and email templates"`
+	StaticDir   string `yaml:"static_dir" env:"STATIC_DIR" help:"directory of the files served under /static/"`
+
+	DrainDelay      time.Duration `yaml:"drain_delay" env:"DRAIN_DELAY" help:"how long /readyz reports draining after SIGTERM or SIGINT before new connections are refused"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" help:"how long requests in flight get to finish after SIGTERM or SIGINT"`
+}
+
+type Database struct {
+	Path string `yaml:"path" env:"DATABASE_PATH" help:"SQLite database file"`
+}
+
+type Session struct {
+	Lifetime      time.Duration `yaml:"lifetime" env:"SESSION_LIFETIME" help:"how long a login lasts"`
+	SecureCookies bool          `yaml:"secure_cookies" env:"SECURE_COOKIES" help:"only send cookies over HTTPS; turn on when serving over HTTPS"`
+	Secret        string        `yaml:"secret" env:"APP_SECRET" secret:"true" help:"key signing links such as unsubscribe links (default a random key kept in the database)"`
+}
+
+type Storage struct {
+	Dir string `yaml:"dir" env:"STORAGE_DIR" help:"directory of uploaded documents and export archives"`
+}
+
+type SMTP struct {
+	Host     string `yaml:"host" env:"SMTP_HOST" help:"mail server; notifications are only logged when empty"`
+	Port     int    `yaml:"port" env:"SMTP_PORT" help:"mail server port"`
+	Username string `yaml:"username" env:"SMTP_USERNAME" help:"mail server login, if it needs one"`
+	Password string `yaml:"password" env:"SMTP_PASSWORD" secret:"true" help:"mail server password"`
+	From     string `yaml:"from" env:"SMTP_FROM" help:"sender of notifications"`
+}
+
+type Reminders struct {
+	Lead     time.Duration `yaml:"lead" env:"REMINDER_LEAD" help:"how long before a task is due its reminder is sent, unless the user chose otherwise"`
+	Interval time.Duration `yaml:"interval" env:"REMINDER_INTERVAL" help:"how often due reminders are looked for"`
+}
+
+type Digests struct {
+	Interval time.Duration `yaml:"interval" env:"DIGEST_INTERVAL" help:"how often due digests are looked for"`
+}
+
+type Reports struct {
+	Interval time.Duration `yaml:"interval" env:"REPORT_INTERVAL" help:"how often due scheduled reports are looked for"`
+}
+
+type Webhooks struct {
+	Workers int `yaml:"workers" env:"WEBHOOK_WORKERS" help:"how many webhook deliveries are sent at once"`
+}
+
+type Jobs struct {
+	Workers int `yaml:"workers" env:"JOB_WORKERS" help:"how many background jobs such as imports run at once"`
+}
+
+type Metrics struct {
+	Addr  string `yaml:"addr" env:"METRICS_ADDR" help:"separate address to serve /metrics on, such as 127.0.0.1:9090"`
+	Token string `yaml:"token" env:"METRICS_TOKEN" secret:"true" help:"bearer token for /metrics; without addr, /metrics is served on the main port"`
+}
+
+type Log struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" help:"debug, info, warn or error"`
+	Format string `yaml:"format" env:"LOG_FORMAT" help:"json or text"`
+}
+
+// Default returns the settings used when nothing else is given.
+func Default() *Config {
+	return &Config{
+		Server:    Server{Port: 5050, TemplateDir: "./templates", StaticDir: "./static", DrainDelay: 5 * time.Second, ShutdownTimeout: 20 * time.Second},
+		Database:  Database{Path: "./task-manager.db"},
+		Session:   Session{Lifetime: 24 * time.Hour},
+		Storage:   Storage{Dir: "./storage"},
+		SMTP:      SMTP{Port: 25, From: "TaskLift <no-reply@tasklift.local>"},
+		Reminders: Reminders{Lead: 24 * time.Hour, Interval: 5 * time.Minute},
+		Digests:   Digests{Interval: 5 * time.Minute},
+		Reports:   Reports{Interval: 5 * time.Minute},
+		Webhooks:  Webhooks{Workers: 4},
+		Jobs:      Jobs{Workers: 2},
+		Log:       Log{Level: "info", Format: "json"},
+	}
+}
+
+// setting is one leaf field of Config.
+type setting struct {
+	key    string // e.g. "server.port"
+	env    string
+	help   string
+	secret bool
+	value  reflect.Value
+}
+
+// settings lists the leaf fields of cfg in declaration order.
+func settings(cfg *Config) []setting {
+	var list []setting
+	sections := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Type().Field(i)
+		fields := sections.Field(i)
+		for j := 0; j < fields.NumField(); j++ {
+			field := fields.Type().Field(j)
+			list = append(list, setting{
+				key:    section.Tag.Get("yaml") + "." + field.Tag.Get("yaml"),
+				env:    field.Tag.Get("env"),
+				help:   field.Tag.Get("help"),
+				secret: field.Tag.Get("secret") == "true",
+				value:  fields.Field(j),
+			})
+		}
+	}
+	return list
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// String formats the setting's value the way set parses it.
+func (s setting) String() string {
+	switch {
+	case s.value.Type() == durationType:
+		return time.Duration(s.value.Int()).String()
+	case s.value.Kind() == reflect.Int:
+		return strconv.FormatInt(s.value.Int(), 10)
+	case s.value.Kind() == reflect.Bool:
+		return strconv.FormatBool(s.value.Bool())
+	}
+	return s.value.String()
+}
+
+// set parses v into the setting's field.
+func (s setting) set(v string) error {
+	switch {
+	case s.value.Type() == durationType:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("want a duration such as 30m, got %q", v)
+		}
+		s.value.SetInt(int64(d))
+	case s.value.Kind() == reflect.Int:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("want a whole number, got %q", v)
+		}
+		s.value.SetInt(int64(n))
+	case s.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("want true or false, got %q", v)
+		}
+		s.value.SetBool(b)
+	default:
+		s.value.SetString(v)
+	}
+	return nil
+}
+
+// Load builds the configuration from the defaults, then the YAML file
+// named by -config or CONFIG_FILE, then environment variables, then the
+// flags in args. It returns flag.ErrHelp when args ask for -help, after
+// printing the flags to stderr.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	list := settings(cfg)
+
+	fs := flag.NewFlagSet("tasklift", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration `file` (env CONFIG_FILE)")
+	flagValues := make(map[string]*string, len(list))
+	for _, s := range list {
+		usage := s.help
+		if s.env != "" {
+			usage += " (env " + s.env + ")"
+		}
+		flagValues[s.key] = fs.String(s.key, s.String(), usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%s: %w", *configFile, err)
+		}
+	}
+
+	var errs []error
+	for _, s := range list {
+		if s.env == "" {
+			continue
+		}
+		if v := os.Getenv(s.env); v != "" {
+			if err := s.set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, s := range list {
+		if set[s.key] {
+			if err := s.set(*flagValues[s.key]); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", s.key, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if cfg.Server.BaseURL == "" {
+		cfg.Server.BaseURL = "http://localhost:" + strconv.Itoa(cfg.Server.Port)
+	}
+	cfg.Server.BaseURL = strings.TrimRight(cfg.Server.BaseURL, "/")
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate reports every setting that the server could not start with.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]interface{}{key}, args...)...))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		fail("server.port", "must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	if u, err := url.Parse(c.Server.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fail("server.base_url", "must be an http or https URL, got %q", c.Server.BaseURL)
+	}
+	for key, dir := range map[string]string{"server.template_dir": c.Server.TemplateDir, "server.static_dir": c.Server.StaticDir} {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			fail(key, "%q is not a directory", dir)
+		}
+	}
+	if c.Database.Path == "" {
+		fail("database.path", "must be set")
+	} else if info, err := os.Stat(filepath.Dir(c.Database.Path)); err != nil || !info.IsDir() {
+		fail("database.path", "directory of %q does not exist", c.Database.Path)
+	}
+	if c.Storage.Dir == "" {
+		fail("storage.dir", "must be set")
+	}
+	if c.SMTP.Port < 1 || c.SMTP.Port > 65535 {
+		fail("smtp.port", "must be between 1 and 65535, got %d", c.SMTP.Port)
+	}
+	if c.SMTP.Host != "" && c.SMTP.From == "" {
+		fail("smtp.from", "must be set when smtp.host is")
+	}
+	if c.Server.DrainDelay < 0 {
+		fail("server.drain_delay", "must not be negative, got %s", c.Server.DrainDelay)
+	}
+	for key, d := range map[string]time.Duration{
+		"server.shutdown_timeout": c.Server.ShutdownTimeout,
+		"session.lifetime":        c.Session.Lifetime,
+		"reminders.lead":          c.Reminders.Lead,
+		"reminders.interval":      c.Reminders.Interval,
+		"digests.interval":        c.Digests.Interval,
+		"reports.interval":        c.Reports.Interval,
+	} {
+		if d <= 0 {
+			fail(key, "must be positive, got %s", d)
+		}
+	}
+	for key, n := range map[string]int{"webhooks.workers": c.Webhooks.Workers, "jobs.workers": c.Jobs.Workers} {
+		if n < 1 {
+			fail(key, "must be at least 1, got %d", n)
+		}
+	}
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		fail("log.level", "%v", err)
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		fail("log.format", "must be json or text, got %q", c.Log.Format)
+	}
+
+	// Maps are walked in random order; keep the messages stable.
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
+
+// Print writes the configuration as YAML, with secrets that are set
+// replaced by "[redacted]".
+func (c *Config) Print(w io.Writer) error {
+	redacted := *c
+	for _, s := range settings(&redacted) {
+		if s.secret && s.value.String() != "" {
+			s.value.SetString("[redacted]")
+		}
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&redacted); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -1,43 +1,93 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"log/slog"
 	"os"
+	"task-manager/metrics"
+	"task-manager/schema"
+	"task-manager/tracing"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 var DB *sql.DB
 
-func InitDB() {
+var (
+	dbQueryDuration = metrics.Default.NewHistogramVec("tasklift_db_query_duration_seconds",
+		"Time taken by database statements, including reading their rows, by operation.",
+		metrics.DefBuckets, "operation")
+	dbQueryErrors = metrics.Default.NewCounterVec("tasklift_db_query_errors_total",
+		"Database statements that failed, by operation.", "operation")
+)
+
+// The database is opened through a wrapper of the SQLite driver that times
+// every statement for the metrics and traces the ones run for a traced
+// request.
+func init() {
+	sql.Register("sqlite3-instrumented", metrics.WrapDriver(&sqlite3.SQLiteDriver{},
+		func(ctx context.Context, operation, query string) func(error) {
+			start := time.Now()
+			endSpan := tracing.Query(ctx, operation, query)
+			return func(err error) {
+				dbQueryDuration.Observe(time.Since(start).Seconds(), operation)
+				if err != nil {
+					dbQueryErrors.Inc(operation)
+				}
+				endSpan(err)
+			}
+		}))
+}
+
+// InitDB opens the SQLite database at path, creating it if needed, and
+// brings its schema up to date.
+func InitDB(path string) {
 	var err error
-	DB, err = sql.Open("sqlite3", "./task-manager.db")
+	// The busy timeout lets background workers and request handlers share the
+	// database without failing immediately on "database is locked". Secure
+	// delete zeroes deleted content, so the plaintext of a note that was
+	// encrypted does not linger in free pages.
+	DB, err = sql.Open("sqlite3-instrumented", path+"?_busy_timeout=5000&_secure_delete=on")
 	if err != nil {
-		log.Fatal("Failed to open database:", err)
+		fatal("Failed to open database", "err", err)
 	}
 
-	// Use the createBasicSchema function from main.go to create tables
-	log.Println("Creating database schema...")
-	createBasicSchema()
+	metrics.Default.NewGaugeFunc("tasklift_db_connections",
+		"Open database connections, by state.", []string{"state"},
+		func(_ context.Context, emit func(float64, ...string)) {
+			stats := DB.Stats()
+			emit(float64(stats.InUse), "in_use")
+			emit(float64(stats.Idle), "idle")
+		})
+	metrics.Default.NewCounterFunc("tasklift_db_connection_waits_total",
+		"Times a statement had to wait for a free database connection.", nil,
+		func(_ context.Context, emit func(float64, ...string)) {
+			emit(float64(DB.Stats().WaitCount))
+		})
+
+	slog.Info("Applying database schema")
+	if err := schema.Apply(DB); err != nil {
+		fatal("Failed to apply database schema", "err", err)
+	}
 
 	// Then execute data.sql to add sample data (optional)
 	if _, err := os.Stat("data.sql"); err == nil {
-		log.Println("Adding sample data...")
+		slog.Info("Adding sample data")
 		data, err := os.ReadFile("data.sql")
 		if err != nil {
-			log.Println("Warning: Failed to read data.sql:", err)
+			slog.Warn("Failed to read data.sql", "err", err)
 			return
 		}
 
 		_, err = DB.Exec(string(data))
 		if err != nil {
-			log.Println("Warning: Failed to execute data.sql:", err)
-			log.Println("This might be normal if data already exists")
+			slog.Warn("Failed to execute data.sql, which might be normal if the data already exists", "err", err)
 			return
 		}
-		log.Println("Sample data added successfully")
+		slog.Info("Sample data added")
 	} else {
-		log.Println("No data.sql found, skipping sample data")
+		slog.Info("No data.sql found, skipping sample data")
 	}
 }
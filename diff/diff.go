@@ -0,0 +1,318 @@
+// Package diff compares two texts line by line or word by word using
+// Myers' O(ND) algorithm and formats line diffs as unified diffs.
+package diff
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The work a comparison may do grows with the length of the texts times
+// the number of edits between them, so both are capped.
+const (
+	maxTokens = 50000 // lines or words on either side
+	maxEdits  = 2000  // inserted plus deleted lines or words
+)
+
+// ErrTooDifferent is returned for texts that are too long or too different
+// to compare within the limits above.
+var ErrTooDifferent = errors.New("texts are too large or too different to diff")
+
+type Kind int
+
+const (
+	Equal Kind = iota
+	Insert
+	Delete
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Insert:
+		return "insert"
+	case Delete:
+		return "delete"
+	}
+	return "equal"
+}
+
+func (k Kind) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.String())
+}
+
+// Op is a run of text that both sides share, or that only the new side
+// (Insert) or only the old side (Delete) contains.
+type Op struct {
+	Kind Kind   `json:"op"`
+	Text string `json:"text"`
+}
+
+// Lines returns the line-level edit script turning a into b. Each op holds
+// one line including its newline.
+func Lines(a, b string) ([]Op, error) {
+	return script(splitLines(a), splitLines(b))
+}
+
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}_]+|\s+|.`)
+
+// Words returns a word-level edit script turning a into b, with adjacent
+// ops of the same kind merged.
+func Words(a, b string) ([]Op, error) {
+	ops, err := script(wordPattern.FindAllString(a, -1), wordPattern.FindAllString(b, -1))
+	if err != nil {
+		return nil, err
+	}
+	var merged []Op
+	for _, op := range ops {
+		if n := len(merged); n > 0 && merged[n-1].Kind == op.Kind {
+			merged[n-1].Text += op.Text
+			continue
+		}
+		merged = append(merged, op)
+	}
+	return merged, nil
+}
+
+// Unified formats the line diff of a and b in the unified format with
+// three lines of context. It returns "" when the texts are equal.
+func Unified(a, b, fromLabel, toLabel string) (string, error) {
+	const context = 3
+
+	type line struct {
+		Op
+		aPos, bPos int // lines of a and b before this one
+	}
+	var lines []line
+	var changes []int
+	ops, err := Lines(a, b)
+	if err != nil {
+		return "", err
+	}
+	aPos, bPos := 0, 0
+	for _, op := range ops {
+		if op.Kind != Equal {
+			changes = append(changes, len(lines))
+		}
+		lines = append(lines, line{op, aPos, bPos})
+		if op.Kind != Insert {
+			aPos++
+		}
+		if op.Kind != Delete {
+			bPos++
+		}
+	}
+	if len(changes) == 0 {
+		return "", nil
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromLabel, toLabel)
+	for i := 0; i < len(changes); {
+		start := max(changes[i]-context, 0)
+		// Changes separated by at most 2*context equal lines share a hunk.
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*context+1 {
+			j++
+		}
+		end := min(changes[j]+context+1, len(lines))
+		i = j + 1
+
+		aCount, bCount := 0, 0
+		for _, l := range lines[start:end] {
+			if l.Kind != Insert {
+				aCount++
+			}
+			if l.Kind != Delete {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(lines[start].aPos, aCount), hunkRange(lines[start].bPos, bCount))
+		for _, l := range lines[start:end] {
+			prefix := " "
+			switch l.Kind {
+			case Insert:
+				prefix = "+"
+			case Delete:
+				prefix = "-"
+			}
+			out.WriteString(prefix + l.Text)
+			if !strings.HasSuffix(l.Text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return out.String(), nil
+}
+
+// hunkRange formats a hunk's start and length the way diff -u does: an
+// empty range names the line before it.
+func hunkRange(before, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+// splitLines splits s after every newline, keeping the newlines.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// script returns the shortest edit script turning a into b.
+func script(a, b []string) ([]Op, error) {
+	if len(a) > maxTokens || len(b) > maxTokens {
+		return nil, ErrTooDifferent
+	}
+	ops := make([]Op, 0, max(len(a), len(b)))
+	if err := compare(a, b, &ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// compare appends a shortest edit script turning a into b to ops. It
+// splits the texts at the middle of a shortest path and compares the two
+// halves, so it needs memory linear in the length of the texts rather than
+// a copy of the search state for every edit.
+func compare(a, b []string, ops *[]Op) error {
+	// Common prefixes and suffixes are cheap to strip and usually make up
+	// most of two revisions of the same text.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	for _, t := range a[:prefix] {
+		*ops = append(*ops, Op{Equal, t})
+	}
+	tail := a[len(a)-suffix:]
+
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	switch {
+	case len(a) == 0:
+		for _, t := range b {
+			*ops = append(*ops, Op{Insert, t})
+		}
+	case len(b) == 0:
+		for _, t := range a {
+			*ops = append(*ops, Op{Delete, t})
+		}
+	default:
+		x, y, err := middleSnake(a, b)
+		if err != nil {
+			return err
+		}
+		if err := compare(a[:x], b[:y], ops); err != nil {
+			return err
+		}
+		if err := compare(a[x:], b[y:], ops); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range tail {
+		*ops = append(*ops, Op{Equal, t})
+	}
+	return nil
+}
+
+// middleSnake searches from both ends at once with the greedy algorithm
+// from "An O(ND) Difference Algorithm and Its Variations" (Myers, 1986)
+// and returns a point on a shortest path from where the searches meet.
+// a and b must be non-empty and differ in their first and last tokens.
+func middleSnake(a, b []string) (int, int, error) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	// forward[offset+k] is the furthest x reached on diagonal k = x-y from
+	// the start, backward[offset+k] the furthest reached from the end.
+	forward := make([]int, 2*maxD+2)
+	backward := make([]int, 2*maxD+2)
+	for i := range forward {
+		forward[i] = -1
+		backward[i] = -1
+	}
+	forward[offset+1] = 0
+	backward[offset+1] = 0
+
+	delta := n - m
+	// With an odd delta the forward search is the one to reach the overlap.
+	odd := delta%2 != 0
+	// Diagonals that ran off the edge of the grid are not searched again.
+	fStart, fEnd, bStart, bEnd := 0, 0, 0, 0
+	for d := 0; d < maxD; d++ {
+		if 2*d > maxEdits {
+			return 0, 0, ErrTooDifferent
+		}
+		for k := -d + fStart; k <= d-fEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			case odd:
+				if i := offset + delta - k; i >= 0 && i < len(backward) && backward[i] != -1 && x >= n-backward[i] {
+					return x, y, nil
+				}
+			}
+		}
+		for k := -d + bStart; k <= d-bEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			switch {
+			case x > n:
+				bEnd += 2
+			case y > m:
+				bStart += 2
+			case !odd:
+				if i := offset + delta - k; i >= 0 && i < len(forward) && forward[i] != -1 {
+					fx := forward[i]
+					if fx >= n-x {
+						return fx, fx - (delta - k), nil
+					}
+				}
+			}
+		}
+	}
+	// The searches always meet; this only keeps the compiler satisfied.
+	return n, 0, nil
+}
@@ -0,0 +1,170 @@
+package diff
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want []Op
+	}{
+		{"", "", nil},
+		{"same text", "same text", []Op{{Equal, "same text"}}},
+		{"", "new", []Op{{Insert, "new"}}},
+		{"old", "", []Op{{Delete, "old"}}},
+		{
+			"The quick brown fox", "The slow brown fox",
+			[]Op{{Equal, "The "}, {Delete, "quick"}, {Insert, "slow"}, {Equal, " brown fox"}},
+		},
+		{
+			"Buy milk.", "Buy milk and eggs.",
+			[]Op{{Equal, "Buy milk"}, {Insert, " and eggs"}, {Equal, "."}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := Words(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("Words(%q, %q): %v", tt.a, tt.b, err)
+			continue
+		}
+		if !equalOps(got, tt.want) {
+			t.Errorf("Words(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestUnified(t *testing.T) {
+	numbered := func(lines ...string) string { return strings.Join(lines, "\n") + "\n" }
+	tests := []struct {
+		name, a, b, want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{
+			"one change",
+			numbered("1", "2", "3", "4", "5", "6", "7", "8"),
+			numbered("1", "2", "3", "4", "five", "6", "7", "8"),
+			"--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			"separate hunks",
+			numbered("1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12"),
+			numbered("one", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "twelve"),
+			"--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			"insert into empty",
+			"", "first\n",
+			"--- old\n+++ new\n@@ -0,0 +1 @@\n+first\n",
+		},
+		{
+			"missing final newline",
+			"a\nb", "a\nb\n",
+			"--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+	}
+	for _, tt := range tests {
+		got, err := Unified(tt.a, tt.b, "old", "new")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestLinesIsShortest checks random edits against the length of the
+// longest common subsequence.
+func TestLinesIsShortest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func() []string {
+		lines := make([]string, rng.Intn(30))
+		for i := range lines {
+			lines[i] = string(rune('a'+rng.Intn(4))) + "\n"
+		}
+		return lines
+	}
+	for i := 0; i < 500; i++ {
+		a, b := random(), random()
+		ops, err := Lines(strings.Join(a, ""), strings.Join(b, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotA, gotB strings.Builder
+		edits := 0
+		for _, op := range ops {
+			if op.Kind != Insert {
+				gotA.WriteString(op.Text)
+			}
+			if op.Kind != Delete {
+				gotB.WriteString(op.Text)
+			}
+			if op.Kind != Equal {
+				edits++
+			}
+		}
+		if gotA.String() != strings.Join(a, "") || gotB.String() != strings.Join(b, "") {
+			t.Fatalf("%q -> %q: script %v does not rebuild both sides", a, b, ops)
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); edits != want {
+			t.Fatalf("%q -> %q: %d edits, want %d", a, b, edits, want)
+		}
+	}
+}
+
+func TestWordsTooDifferent(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	text := func(n int) string {
+		words := make([]string, n)
+		for i := range words {
+			words[i] = string(rune('a'+rng.Intn(26))) + string(rune('a'+rng.Intn(26)))
+		}
+		return strings.Join(words, " ")
+	}
+	if _, err := Words(text(20000), text(20000)); !errors.Is(err, ErrTooDifferent) {
+		t.Errorf("unrelated texts: got %v, want ErrTooDifferent", err)
+	}
+	if _, err := Words(text(maxTokens), ""); !errors.Is(err, ErrTooDifferent) {
+		t.Errorf("oversized text: got %v, want ErrTooDifferent", err)
+	}
+
+	// A long text with a few edits is still compared.
+	a := text(10000)
+	b := "Intro. " + strings.Replace(a, " ", " changed ", 3) + " Outro."
+	if _, err := Words(a, b); err != nil {
+		t.Errorf("similar texts: %v", err)
+	}
+}
+
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func equalOps(a, b []Op) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+// Package duedate finds due dates written in plain English in a line of
+// text, such as "Follow up with client by Friday" or "Send the deck
+// tomorrow".
+package duedate
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tues": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+var months = map[string]time.Month{
+	"jan": time.January, "january": time.January,
+	"feb": time.February, "february": time.February,
+	"mar": time.March, "march": time.March,
+	"apr": time.April, "april": time.April,
+	"may": time.May,
+	"jun": time.June, "june": time.June,
+	"jul": time.July, "july": time.July,
+	"aug": time.August, "august": time.August,
+	"sep": time.September, "sept": time.September, "september": time.September,
+	"oct": time.October, "october": time.October,
+	"nov": time.November, "november": time.November,
+	"dec": time.December, "december": time.December,
+}
+
+const (
+	weekdayNames = `sun(?:day)?|mon(?:day)?|tue(?:s|sday)?|wed(?:nesday)?|thu(?:r|rs|rsday)?|fri(?:day)?|sat(?:urday)?`
+	monthNames   = `jan(?:uary)?|feb(?:ruary)?|mar(?:ch)?|apr(?:il)?|may|june?|july?|aug(?:ust)?|sep(?:t|tember)?|oct(?:ober)?|nov(?:ember)?|dec(?:ember)?`
+)
+
+// A date needs a keyword in front of it ("by Friday", "due March 3") unless
+// it cannot be anything but a date ("tomorrow", "next Monday") and ends the
+// text or a clause, so "Discuss next week plan" keeps its words.
+var (
+	keyword = `(?:due(?:\s+(?:by|on))?|by|on|before|until)`
+
+	datePhrase = `(today|tonight|eod|tomorrow|tmrw` +
+		`|next\s+week|end\s+of\s+(?:the\s+)?(?:week|month)|eow|eom` +
+		`|in\s+\d{1,3}\s+(?:days?|weeks?)` +
+		`|(?:next\s+)?(?:` + weekdayNames + `)` +
+		`|\d{4}-\d{2}-\d{2}` +
+		`|(?:` + monthNames + `)\.?\s+\d{1,2}(?:st|nd|rd|th)?(?:,?\s+\d{4})?` +
+		`|\d{1,2}(?:st|nd|rd|th)?\s+(?:` + monthNames + `)(?:,?\s+\d{4})?)`
+
+	withKeyword    = regexp.MustCompile(`(?i)(?:^|\s)` + keyword + `:?\s+` + datePhrase + `\b`)
+	withoutKeyword = regexp.MustCompile(`(?i)(?:^|\s)(today|tonight|eod|tomorrow|tmrw|next\s+week|next\s+(?:` + weekdayNames + `)` +
+		`|in\s+\d{1,3}\s+(?:days?|weeks?)|\d{4}-\d{2}-\d{2})\s*(?:[,;.!?]|$)`)
+
+	numberPattern = regexp.MustCompile(`\d+`)
+)
+
+// Extract looks for a due date in text, relative to now. It returns the
+// text without the date phrase, the date (midnight in now's location) and
+// whether a date was found. When text holds several dates the first one
+// counts.
+func Extract(text string, now time.Time) (string, time.Time, bool) {
+	for _, pattern := range []*regexp.Regexp{withKeyword, withoutKeyword} {
+		for _, m := range pattern.FindAllStringSubmatchIndex(text, -1) {
+			due, ok := parse(text[m[2]:m[3]], now)
+			if !ok {
+				continue
+			}
+			// Drop the punctuation that separated the phrase from the rest.
+			before := strings.TrimRight(text[:m[0]], " \t,;:-–—")
+			after := strings.TrimLeft(text[m[1]:], " \t,;:.!")
+			return strings.Join(strings.Fields(before+" "+after), " "), due, true
+		}
+	}
+	return text, time.Time{}, false
+}
+
+// parse turns one of the phrases datePhrase matches into a date.
+func parse(phrase string, now time.Time) (time.Time, bool) {
+	phrase = strings.ToLower(strings.Join(strings.Fields(phrase), " "))
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch phrase {
+	case "today", "tonight", "eod":
+		return today, true
+	case "tomorrow", "tmrw":
+		return today.AddDate(0, 0, 1), true
+	case "next week":
+		return startOfWeek(today).AddDate(0, 0, 7), true
+	case "end of week", "end of the week", "eow":
+		friday := startOfWeek(today).AddDate(0, 0, 4)
+		if friday.Before(today) {
+			return today, true
+		}
+		return friday, true
+	case "end of month", "end of the month", "eom":
+		return time.Date(today.Year(), today.Month()+1, 0, 0, 0, 0, 0, today.Location()), true
+	}
+
+	if strings.HasPrefix(phrase, "in ") {
+		n, _ := strconv.Atoi(numberPattern.FindString(phrase))
+		if strings.Contains(phrase, "week") {
+			n *= 7
+		}
+		return today.AddDate(0, 0, n), true
+	}
+
+	next := strings.HasPrefix(phrase, "next ")
+	if day, ok := weekdays[strings.TrimPrefix(phrase, "next ")]; ok {
+		// The weekday's next occurrence from today, so "by Friday" on a
+		// Friday is today; "next" skips to the following week if that
+		// occurrence is still in this week.
+		d := today.AddDate(0, 0, (int(day)-int(today.Weekday())+7)%7)
+		if next && startOfWeek(d).Equal(startOfWeek(today)) {
+			d = d.AddDate(0, 0, 7)
+		}
+		return d, true
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", phrase, now.Location()); err == nil {
+		return t, true
+	}
+	return parseMonthDay(phrase, today)
+}
+
+// parseMonthDay parses "march 3", "mar. 3rd, 2025" or "3 march". Without a
+// year, a date that has passed means its next occurrence, which for
+// February 29 can be up to four years away.
+func parseMonthDay(phrase string, today time.Time) (time.Time, bool) {
+	fields := strings.Fields(strings.NewReplacer(",", " ", ".", " ").Replace(phrase))
+	var month time.Month
+	var day, year int
+	for _, f := range fields {
+		if m, ok := months[f]; ok {
+			month = m
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimRight(f, "stndrh"))
+		if err != nil {
+			return time.Time{}, false
+		}
+		if day == 0 && n <= 31 {
+			day = n
+		} else {
+			year = n
+		}
+	}
+	if month == 0 || day == 0 {
+		return time.Time{}, false
+	}
+	if year != 0 {
+		t := time.Date(year, month, day, 0, 0, 0, 0, today.Location())
+		return t, t.Day() == day // not e.g. February 30
+	}
+	for y := today.Year(); y <= today.Year()+8; y++ {
+		t := time.Date(y, month, day, 0, 0, 0, 0, today.Location())
+		if t.Day() == day && !t.Before(today) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// startOfWeek returns the Monday of d's week.
+func startOfWeek(d time.Time) time.Time {
+	return d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7))
+}
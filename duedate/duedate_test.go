@@ -0,0 +1,57 @@
+package duedate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExtract(t *testing.T) {
+	friday := time.Date(2026, 10, 16, 15, 30, 0, 0, time.UTC)
+	sunday := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	wednesday := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	date := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		text  string
+		now   time.Time
+		title string
+		due   time.Time // zero when no date should be found
+	}{
+		{"Follow up with client by Friday", friday, "Follow up with client", date(2026, 10, 16)},
+		{"Follow up with client by Friday", wednesday, "Follow up with client", date(2026, 10, 16)},
+		{"Call the bank on Monday", friday, "Call the bank", date(2026, 10, 19)},
+		{"Book venue next Monday", wednesday, "Book venue", date(2026, 10, 19)},
+		{"Book venue next Monday", sunday, "Book venue", date(2026, 10, 19)},
+		{"Book venue next Friday", wednesday, "Book venue", date(2026, 10, 23)},
+		{"Book venue next Friday", friday, "Book venue", date(2026, 10, 23)},
+		{"Renew licence due Feb 29", friday, "Renew licence", date(2028, 2, 29)},
+		{"Renew licence due Feb 29", time.Date(2028, 3, 1, 0, 0, 0, 0, time.UTC), "Renew licence", date(2032, 2, 29)},
+		{"Renew licence due Feb 29, 2027", friday, "Renew licence due Feb 29, 2027", time.Time{}},
+		{"Send the deck by March 3rd", friday, "Send the deck", date(2027, 3, 3)},
+		{"Send the deck tomorrow", friday, "Send the deck", date(2026, 10, 17)},
+		{"Send the deck tomorrow, then relax", friday, "Send the deck then relax", date(2026, 10, 17)},
+		{"Ship it 2026-11-02.", friday, "Ship it", date(2026, 11, 2)},
+		{"Wrap up in 2 weeks", friday, "Wrap up", date(2026, 10, 30)},
+		{"Discuss next week plan", friday, "Discuss next week plan", time.Time{}},
+		{"Review today's agenda", friday, "Review today's agenda", time.Time{}},
+		{"Discuss next week", friday, "Discuss", date(2026, 10, 19)},
+		{"Meet Sam on the roof", friday, "Meet Sam on the roof", time.Time{}},
+	}
+	for _, tt := range tests {
+		title, due, ok := Extract(tt.text, tt.now)
+		if ok != !tt.due.IsZero() || title != tt.title || !due.Equal(tt.due) {
+			t.Errorf("Extract(%q) on %s = %q, %s, %v; want %q, %s",
+				tt.text, tt.now.Format("Mon Jan 2 2006"), title, due.Format("2006-01-02"), ok, tt.title, tt.due.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestExtractUsesNowsLocation(t *testing.T) {
+	zone := time.FixedZone("UTC+10", 10*60*60)
+	// Still Friday in UTC, but already Saturday in zone.
+	now := time.Date(2026, 10, 16, 20, 0, 0, 0, time.UTC).In(zone)
+	_, due, ok := Extract("Pay rent tomorrow", now)
+	if want := time.Date(2026, 10, 18, 0, 0, 0, 0, zone); !ok || !due.Equal(want) || due.Location() != zone {
+		t.Errorf("got %s, want %s", due, want)
+	}
+}
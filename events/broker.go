@@ -0,0 +1,116 @@
+package events
+
+import "sync"
+
+// Broker keeps a bounded log of recent events and fans them out to
+// per-user subscriptions, such as Server-Sent Events connections. It is fed
+// by subscribing Publish to a Bus, so publishers never touch connections.
+type Broker struct {
+	mu     sync.Mutex
+	log    []Event
+	next   int
+	full   bool
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// Subscription receives a user's events on C. C is closed when the
+// subscriber falls too far behind or the broker shuts down; the client is
+// expected to reconnect and resume from the last event it saw.
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	userID int
+}
+
+// NewBroker creates a broker remembering the last size events.
+func NewBroker(size int) *Broker {
+	return &Broker{
+		log:  make([]Event, size),
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish records e and hands it to the owner's subscriptions without blocking.
+func (b *Broker) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+
+	b.log[b.next] = e
+	b.next = (b.next + 1) % len(b.log)
+	if b.next == 0 {
+		b.full = true
+	}
+
+	for sub := range b.subs {
+		if sub.userID != e.UserID {
+			continue
+		}
+		select {
+		case sub.c <- e:
+		default:
+			// Slow consumer: drop it rather than block publishers.
+			delete(b.subs, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// Subscribe registers a subscription for userID and returns the user's
+// retained events newer than lastID. complete is false when events after
+// lastID may have been dropped from the log (or predate this process), in
+// which case the client should reload its state.
+func (b *Broker) Subscribe(userID int, lastID int64) (sub *Subscription, backlog []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan Event, 64)
+	sub = &Subscription{C: c, c: c, userID: userID}
+	if b.closed {
+		close(c)
+		return sub, nil, false
+	}
+	b.subs[sub] = struct{}{}
+
+	retained := b.retained()
+	complete = lastID == 0 || (len(retained) > 0 && lastID >= retained[0].ID-1)
+	for _, e := range retained {
+		if e.UserID == userID && e.ID > lastID {
+			backlog = append(backlog, e)
+		}
+	}
+	return sub, backlog, complete
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+}
+
+// Close ends every subscription and rejects new ones.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+}
+
+// retained returns the logged events, oldest first.
+func (b *Broker) retained() []Event {
+	if !b.full {
+		return append([]Event(nil), b.log[:b.next]...)
+	}
+	out := make([]Event, 0, len(b.log))
+	out = append(out, b.log[b.next:]...)
+	return append(out, b.log[:b.next]...)
+}
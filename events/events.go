@@ -0,0 +1,52 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Event describes a change to a user's data, e.g. "task.created".
+type Event struct {
+	ID     int64       `json:"id"`
+	Type   string      `json:"type"`
+	UserID int         `json:"-"`
+	Time   time.Time   `json:"time"`
+	Data   interface{} `json:"data"`
+}
+
+// Bus fans events out to in-process subscribers. Subscribers are called
+// synchronously from Publish, so they must not block; anything slow belongs
+// on a queue owned by the subscriber. They get the publisher's context, so
+// their database work joins the publisher's trace.
+type Bus struct {
+	mu   sync.RWMutex
+	seq  int64
+	subs []func(context.Context, Event)
+}
+
+// NewBus creates a bus whose event IDs start from the current time in
+// microseconds, so IDs keep increasing across server restarts.
+func NewBus() *Bus {
+	return &Bus{seq: time.Now().UnixMicro()}
+}
+
+func (b *Bus) Subscribe(fn func(context.Context, Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, fn)
+}
+
+// Publish assigns the event an increasing ID and delivers it to every subscriber.
+func (b *Bus) Publish(ctx context.Context, userID int, eventType string, data interface{}) Event {
+	b.mu.Lock()
+	b.seq++
+	e := Event{ID: b.seq, Type: eventType, UserID: userID, Time: time.Now().UTC(), Data: data}
+	subs := b.subs
+	b.mu.Unlock()
+
+	for _, fn := range subs {
+		fn(ctx, e)
+	}
+	return e
+}
@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"task-manager/duedate"
+	"task-manager/events"
+	"task-manager/markdown"
+	"task-manager/models"
+	"time"
+)
+
+// A task created from a checklist item stores the item's index and text.
+// Items are found again by index while the text there still matches, else
+// by text, so the link survives items being added or moved above it.
+
+// sourceTask is a task created from a note's checklist item.
+type sourceTask struct {
+	id        int64
+	done      bool
+	itemIndex sql.NullInt64
+	itemText  sql.NullString
+}
+
+func loadSourceTasks(ctx context.Context, userID int, noteID int64) ([]sourceTask, error) {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT id, done, source_item_index, source_item_text FROM tasks
+		WHERE source_note_id = ? AND user_id = ? ORDER BY id`, noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []sourceTask
+	for rows.Next() {
+		var t sourceTask
+		if err := rows.Scan(&t.id, &t.done, &t.itemIndex, &t.itemText); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// findItem returns the position in items of the item a task was created
+// from, or -1.
+func findItem(items []markdown.TaskItem, index sql.NullInt64, text sql.NullString) int {
+	if !text.Valid {
+		return -1
+	}
+	if index.Valid && int(index.Int64) < len(items) && items[index.Int64].Text == text.String {
+		return int(index.Int64)
+	}
+	for i, item := range items {
+		if item.Text == text.String {
+			return i
+		}
+	}
+	return -1
+}
+
+// userToday is the current time in the user's time zone, which relative
+// due dates like "tomorrow" are resolved against.
+func userToday(ctx context.Context, userID int) time.Time {
+	settings, err := loadDigestSettings(ctx, userID)
+	if err != nil {
+		return time.Now()
+	}
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		return time.Now()
+	}
+	return time.Now().In(loc)
+}
+
+// noteActionItems proposes a task for every checklist item in a note.
+func noteActionItems(ctx context.Context, userID int, note models.Note) ([]models.ActionItem, error) {
+	tasks, err := loadSourceTasks(ctx, userID, int64(note.ID))
+	if err != nil {
+		return nil, err
+	}
+	items := markdown.TaskItems(note.Content)
+	taskIDs := make(map[int]int)
+	for _, t := range tasks {
+		if i := findItem(items, t.itemIndex, t.itemText); i >= 0 {
+			if _, taken := taskIDs[i]; !taken {
+				taskIDs[i] = int(t.id)
+			}
+		}
+	}
+
+	now := userToday(ctx, userID)
+	proposals := make([]models.ActionItem, 0, len(items))
+	for _, item := range items {
+		if item.Text == "" {
+			continue
+		}
+		p := models.ActionItem{Index: item.Index, Line: item.Line, Text: item.Text, Title: item.Text, Checked: item.Checked}
+		if title, due, ok := duedate.Extract(item.Text, now); ok && title != "" {
+			p.Title = title
+			p.DueDate = due.Format("2006-01-02")
+		}
+		if id, ok := taskIDs[item.Index]; ok {
+			p.TaskID = &id
+		}
+		proposals = append(proposals, p)
+	}
+	return proposals, nil
+}
+
+// NoteActionItems lists the checklist items of note ?id= as proposed tasks,
+// with due dates parsed from phrases like "by Friday" and the task each item
+// already became, if any.
+func NoteActionItems(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Note ID required", http.StatusBadRequest)
+		return
+	}
+	note, err := loadNote(r.Context(), userID, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Load note error", "err", err)
+		http.Error(w, "Failed to retrieve action items", http.StatusInternalServerError)
+		return
+	}
+
+	items, err := noteActionItems(r.Context(), userID, note)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Action items error", "err", err)
+		http.Error(w, "Failed to retrieve action items", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"note_id": note.ID, "items": items})
+}
+
+// ConvertNoteToTasks creates tasks from note "id" in "project_id" (optional)
+// with "priority" (default medium). "items" is a comma-separated list of
+// checklist item indexes; without it every open item that is not a task yet
+// is converted. A note without a checklist becomes a single task named after
+// the note. Each task links back to the note, and completing it ticks its
+// item.
+func ConvertNoteToTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Note ID required", http.StatusBadRequest)
+		return
+	}
+	projectID, err := noteProjectFormValue(r.Context(), userID, r.FormValue("project_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	priority := r.FormValue("priority")
+	if priority == "" {
+		priority = "medium"
+	}
+	if priority != "high" && priority != "medium" && priority != "low" {
+		http.Error(w, "priority must be high, medium or low", http.StatusBadRequest)
+		return
+	}
+
+	note, err := loadNote(r.Context(), userID, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Load note error", "err", err)
+		http.Error(w, "Failed to create tasks", http.StatusInternalServerError)
+		return
+	}
+	if note.Encrypted {
+		// Tasks are stored in the clear.
+		http.Error(w, "Private notes cannot be turned into tasks", http.StatusConflict)
+		return
+	}
+	proposals, err := noteActionItems(r.Context(), userID, note)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Action items error", "err", err)
+		http.Error(w, "Failed to create tasks", http.StatusInternalServerError)
+		return
+	}
+
+	type newTask struct {
+		title, dueDate string
+		done           bool
+		index          interface{}
+		text           interface{}
+	}
+	var create []newTask
+	skipped := make([]models.SkippedItem, 0)
+	if v := strings.TrimSpace(r.FormValue("items")); v != "" {
+		byIndex := make(map[int]models.ActionItem)
+		for _, p := range proposals {
+			byIndex[p.Index] = p
+		}
+		for _, s := range strings.Split(v, ",") {
+			index, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				http.Error(w, "items must be a comma-separated list of item indexes", http.StatusBadRequest)
+				return
+			}
+			p, ok := byIndex[index]
+			switch {
+			case !ok:
+				http.Error(w, "Item "+strconv.Itoa(index)+" not found", http.StatusBadRequest)
+				return
+			case p.TaskID != nil:
+				skipped = append(skipped, models.SkippedItem{Kind: "item", Name: p.Text, Reason: "already a task"})
+			default:
+				create = append(create, newTask{p.Title, p.DueDate, p.Checked, p.Index, p.Text})
+			}
+		}
+	} else if len(proposals) > 0 {
+		for _, p := range proposals {
+			if p.Checked || p.TaskID != nil {
+				continue
+			}
+			create = append(create, newTask{p.Title, p.DueDate, false, p.Index, p.Text})
+		}
+	} else {
+		create = append(create, newTask{title: note.Title})
+	}
+
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Failed to create tasks", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var taskIDs []int64
+	now := time.Now()
+	for _, t := range create {
+		res, err := tx.ExecContext(r.Context(), `
+			INSERT INTO tasks (user_id, project_id, description, priority, due_date, done,
+			                   source_note_id, source_item_index, source_item_text, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID, projectID, t.title, priority, t.dueDate, t.done, id, t.index, t.text, now, now)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Create task from note error", "err", err)
+			http.Error(w, "Failed to create tasks", http.StatusInternalServerError)
+			return
+		}
+		taskID, _ := res.LastInsertId()
+		taskIDs = append(taskIDs, taskID)
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create tasks", http.StatusInternalServerError)
+		return
+	}
+
+	tasks := make([]models.Task, 0, len(taskIDs))
+	for _, taskID := range taskIDs {
+		if task, err := loadTask(r.Context(), userID, taskID); err == nil {
+			publish(r.Context(), userID, "task.created", task)
+			tasks = append(tasks, task)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "created", "tasks": tasks, "skipped": skipped})
+}
+
+// StartNoteTaskSync ticks and unticks the checklist items tasks were
+// created from as the tasks are completed and reopened.
+func StartNoteTaskSync() {
+	Events.Subscribe(func(ctx context.Context, e events.Event) {
+		if e.Type != "task.updated" {
+			return
+		}
+		if task, ok := e.Data.(models.Task); ok && task.SourceNoteID != nil {
+			// The sync outlives the request that published the event.
+			go syncSourceItem(context.WithoutCancel(ctx), e.UserID, int64(task.ID))
+		}
+	})
+}
+
+// syncSourceItem sets the checkbox of the item task id was created from to
+// the task's state.
+func syncSourceItem(ctx context.Context, userID int, id int64) {
+	var noteID sql.NullInt64
+	var t sourceTask
+	err := DB.QueryRowContext(ctx, `
+		SELECT source_note_id, done, source_item_index, source_item_text FROM tasks
+		WHERE id = ? AND user_id = ?`, id, userID).Scan(&noteID, &t.done, &t.itemIndex, &t.itemText)
+	if err != nil || !noteID.Valid {
+		return
+	}
+	note, err := loadNote(ctx, userID, noteID.Int64)
+	if err != nil {
+		return
+	}
+	items := markdown.TaskItems(note.Content)
+	i := findItem(items, t.itemIndex, t.itemText)
+	if i < 0 || items[i].Checked == t.done {
+		return
+	}
+
+	content, err := markdown.SetTask(note.Content, i, t.done)
+	if err != nil {
+		return
+	}
+	res, err := DB.ExecContext(ctx, `
+		UPDATE notes SET content = ?, updated_at = ?
+		WHERE id = ? AND user_id = ? AND COALESCE(content, '') = ?`,
+		content, time.Now(), note.ID, userID, note.Content)
+	if err != nil {
+		slog.Error("Sync note item error", "err", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		slog.Warn("Sync note item: note changed concurrently, item left as is", "note_id", note.ID)
+		return
+	}
+	if note, err := loadNote(ctx, userID, noteID.Int64); err == nil {
+		if err := recordNoteRevision(ctx, DB, noteID.Int64, userID, note.Title, note.Content, true); err != nil {
+			slog.Error("Record note revision error", "err", err)
+		}
+		publish(ctx, userID, "note.updated", note)
+	}
+}
+
+// syncItemTasks completes or reopens the tasks created from note noteID's
+// checklist items to match the items, after a checkbox was toggled.
+func syncItemTasks(ctx context.Context, userID int, noteID int64, content string) {
+	tasks, err := loadSourceTasks(ctx, userID, noteID)
+	if err != nil {
+		slog.Error("Sync item tasks error", "err", err)
+		return
+	}
+	items := markdown.TaskItems(content)
+	for _, t := range tasks {
+		i := findItem(items, t.itemIndex, t.itemText)
+		if i < 0 || items[i].Checked == t.done {
+			continue
+		}
+		if _, err := DB.ExecContext(ctx, "UPDATE tasks SET done = ?, source_item_index = ?, updated_at = ? WHERE id = ? AND user_id = ?",
+			items[i].Checked, i, time.Now(), t.id, userID); err != nil {
+			slog.Error("Sync item tasks error", "err", err)
+			continue
+		}
+		if task, err := loadTask(ctx, userID, t.id); err == nil {
+			publish(ctx, userID, "task.updated", task)
+			if task.Done {
+				publish(ctx, userID, "task.completed", task)
+			}
+		}
+	}
+}
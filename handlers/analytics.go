@@ -0,0 +1,434 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"task-manager/charts"
+	"task-manager/models"
+	"time"
+)
+
+// Historical analytics are worked out from the tasks' created_at,
+// completed_at and due_date, bucketed in the user's time zone. Deleted
+// tasks drop out of the history, and a task that was reopened counts as
+// completed only from its last completion.
+
+// maxAnalyticsPeriods bounds the number of periods one request can ask for.
+const maxAnalyticsPeriods = 400
+
+var errAnalyticsRange = errors.New("Too many periods, use a shorter range or a coarser granularity")
+
+// analyticsTask is what the analytics need to know about a task.
+type analyticsTask struct {
+	created   time.Time
+	completed time.Time // zero while open
+	due       time.Time // zero without a due date
+}
+
+// openAt reports whether the task existed and was not completed at t.
+func (t analyticsTask) openAt(at time.Time) bool {
+	return t.created.Before(at) && (t.completed.IsZero() || !t.completed.Before(at))
+}
+
+// overdueAt reports whether the task was open at t after its due date had
+// passed.
+func (t analyticsTask) overdueAt(at time.Time) bool {
+	return !t.due.IsZero() && t.openAt(at) && !at.Before(t.due.AddDate(0, 0, 1))
+}
+
+func loadAnalyticsTasks(ctx context.Context, userID int, projectID int64, loc *time.Location) ([]analyticsTask, error) {
+	query := "SELECT created_at, completed_at, COALESCE(due_date, '') FROM tasks WHERE user_id = ?"
+	args := []interface{}{userID}
+	if projectID > 0 {
+		query += " AND project_id = ?"
+		args = append(args, projectID)
+	}
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []analyticsTask
+	for rows.Next() {
+		var created, completed sql.NullTime
+		var due string
+		if err := rows.Scan(&created, &completed, &due); err != nil {
+			return nil, err
+		}
+		var t analyticsTask
+		t.created = created.Time
+		if completed.Valid {
+			t.completed = completed.Time
+		}
+		t.due, _ = parseAnalyticsDate(due, loc)
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// parseAnalyticsDate reads the date part of a stored DATE value, which may
+// come back as YYYY-MM-DD or as a full timestamp.
+func parseAnalyticsDate(s string, loc *time.Location) (time.Time, bool) {
+	if len(s) < 10 {
+		return time.Time{}, false
+	}
+	d, err := time.ParseInLocation("2006-01-02", s[:10], loc)
+	return d, err == nil
+}
+
+// analyticsPeriods splits [from, to] into days, weeks (starting Monday) or
+// months. It returns the start of every period and the end of the last.
+func analyticsPeriods(from, to time.Time, granularity string) ([]time.Time, bool) {
+	var step func(time.Time) time.Time
+	switch granularity {
+	case "day":
+		step = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	case "week":
+		from = startOfWeek(from)
+		step = func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }
+	case "month":
+		from = time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, from.Location())
+		step = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	default:
+		return nil, false
+	}
+
+	bounds := []time.Time{from}
+	for t := from; !t.After(to); {
+		t = step(t)
+		bounds = append(bounds, t)
+		if len(bounds) > maxAnalyticsPeriods+1 {
+			return nil, false
+		}
+	}
+	return bounds, true
+}
+
+// startOfWeek returns midnight on the Monday of d's week.
+func startOfWeek(d time.Time) time.Time {
+	d = time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, d.Location())
+	return d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7))
+}
+
+// analyticsDateRange parses the from and to dates of a request. Without to
+// the range ends today, and without from it spans defaultDays days.
+func analyticsDateRange(fromValue, toValue string, now time.Time, defaultDays int) (time.Time, time.Time, error) {
+	loc := now.Location()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	if toValue != "" {
+		var ok bool
+		if to, ok = parseAnalyticsDate(toValue, loc); !ok {
+			return to, to, errors.New("Invalid to date, use YYYY-MM-DD")
+		}
+	}
+	from := to.AddDate(0, 0, 1-defaultDays)
+	if fromValue != "" {
+		var ok bool
+		if from, ok = parseAnalyticsDate(fromValue, loc); !ok {
+			return from, to, errors.New("Invalid from date, use YYYY-MM-DD")
+		}
+	}
+	if from.After(to) {
+		return from, to, errors.New("from must not be after to")
+	}
+	return from, to, nil
+}
+
+// trendPoints counts tasks into the periods that start at bounds.
+func trendPoints(tasks []analyticsTask, bounds []time.Time, now time.Time) []models.TrendPoint {
+	points := make([]models.TrendPoint, 0, len(bounds)-1)
+	for i := 0; i+1 < len(bounds); i++ {
+		start, end := bounds[i], bounds[i+1]
+		p := models.TrendPoint{Period: start.Format("2006-01-02")}
+		at := end
+		if now.Before(at) {
+			at = now
+		}
+		for _, t := range tasks {
+			if !t.created.Before(start) && t.created.Before(end) {
+				p.Created++
+			}
+			if !t.completed.IsZero() && !t.completed.Before(start) && t.completed.Before(end) {
+				p.Completed++
+			}
+			if start.After(now) {
+				continue
+			}
+			if t.openAt(at) {
+				p.Open++
+			}
+			if t.overdueAt(at) {
+				p.Overdue++
+			}
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// TaskTrends reports tasks created and completed per period, with the open
+// and overdue counts at the end of each period. ?from= and ?to= are dates
+// (default the last 30 days) and ?granularity= is day, week or month
+// (default day). ?project_id= limits it to one project.
+func TaskTrends(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	now := userToday(r.Context(), userID)
+	loc := now.Location()
+	q := r.URL.Query()
+
+	from, to, err := analyticsDateRange(q.Get("from"), q.Get("to"), now, 30)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	granularity := q.Get("granularity")
+	if granularity == "" {
+		granularity = "day"
+	}
+	if granularity != "day" && granularity != "week" && granularity != "month" {
+		http.Error(w, "granularity must be day, week or month", http.StatusBadRequest)
+		return
+	}
+	bounds, ok := analyticsPeriods(from, to, granularity)
+	if !ok {
+		http.Error(w, errAnalyticsRange.Error(), http.StatusBadRequest)
+		return
+	}
+	var projectID int64
+	if v := q.Get("project_id"); v != "" {
+		if projectID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "Invalid project ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tasks, err := loadAnalyticsTasks(r.Context(), userID, projectID, loc)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Task trends error", "err", err)
+		http.Error(w, "Failed to retrieve analytics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":        from.Format("2006-01-02"),
+		"to":          to.Format("2006-01-02"),
+		"granularity": granularity,
+		"timezone":    loc.String(),
+		"points":      trendPoints(tasks, bounds, now),
+	})
+}
+
+// ProjectBurndown reports the burn-down and burn-up of project ?id= from
+// its creation until its due date, or until today when it has none or is
+// late. ?granularity= is day, week or month (default day).
+func ProjectBurndown(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Project ID required", http.StatusBadRequest)
+		return
+	}
+	granularity := r.URL.Query().Get("granularity")
+	if granularity == "" {
+		granularity = "day"
+	}
+
+	now := userToday(r.Context(), userID)
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	var name, due string
+	var createdAt sql.NullTime
+	err = DB.QueryRowContext(r.Context(), "SELECT name, COALESCE(due_date, ''), created_at FROM projects WHERE id = ? AND user_id = ?", id, userID).
+		Scan(&name, &due, &createdAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Load project error", "err", err)
+		http.Error(w, "Failed to retrieve burn-down", http.StatusInternalServerError)
+		return
+	}
+
+	tasks, err := loadAnalyticsTasks(r.Context(), userID, id, loc)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Project burn-down error", "err", err)
+		http.Error(w, "Failed to retrieve burn-down", http.StatusInternalServerError)
+		return
+	}
+
+	// Tasks imported or moved into the project may predate it.
+	created := createdAt.Time.In(loc)
+	for _, t := range tasks {
+		if t.created.Before(created) {
+			created = t.created.In(loc)
+		}
+	}
+	start := time.Date(created.Year(), created.Month(), created.Day(), 0, 0, 0, 0, loc)
+	end := today
+	dueDate, hasDue := parseAnalyticsDate(due, loc)
+	if hasDue && dueDate.After(end) {
+		end = dueDate
+	}
+	if end.Before(start) {
+		end = start
+	}
+	bounds, ok := analyticsPeriods(start, end, granularity)
+	if !ok {
+		http.Error(w, "granularity must be day, week or month, and coarse enough for the project's length", http.StatusBadRequest)
+		return
+	}
+
+	burndown := models.Burndown{
+		ProjectID:   int(id),
+		Name:        name,
+		Start:       start.Format("2006-01-02"),
+		Granularity: granularity,
+		Points:      make([]models.BurndownPoint, 0, len(bounds)-1),
+	}
+	if hasDue {
+		burndown.DueDate = dueDate.Format("2006-01-02")
+	}
+
+	scope := len(tasks)
+	deadline := dueDate.AddDate(0, 0, 1)
+	for i := 0; i+1 < len(bounds); i++ {
+		p := models.BurndownPoint{Period: bounds[i].Format("2006-01-02")}
+		at := bounds[i+1]
+		if hasDue && !bounds[i].After(dueDate) {
+			// The share of the time to the due date that has passed by the
+			// end of the period.
+			elapsed := float64(at.Sub(start)) / float64(deadline.Sub(start))
+			ideal := math.Round(float64(scope)*(1-math.Min(elapsed, 1))*100) / 100
+			p.Ideal = &ideal
+		}
+		if !bounds[i].After(now) {
+			if now.Before(at) {
+				at = now
+			}
+			var inScope, completed int
+			for _, t := range tasks {
+				if t.created.Before(at) {
+					inScope++
+					if !t.completed.IsZero() && t.completed.Before(at) {
+						completed++
+					}
+				}
+			}
+			remaining := inScope - completed
+			p.Scope, p.Completed, p.Remaining = &inScope, &completed, &remaining
+		}
+		burndown.Points = append(burndown.Points, p)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(burndown)
+}
+
+// analyticsPage is the data of templates/analytics.html.
+type analyticsPage struct {
+	TotalTasks, CompletedTasks, PendingTasks, HighPriorityTasks int
+	TotalProjects, ActiveProjects, TotalNotes                   int
+	CompletionRate                                              float64
+
+	TrendFrom, Today string
+	TrendChart       template.HTML // created vs completed per day
+	OpenChart        template.HTML // open and overdue per day
+
+	Flow            models.FlowMetrics
+	ThroughputChart template.HTML
+}
+
+// Analytics renders the analytics dashboard: totals, the last 30 days of
+// task trends and the last 12 weeks of flow metrics.
+func Analytics(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	var page analyticsPage
+	DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM tasks WHERE user_id = ?", userID).Scan(&page.TotalTasks)
+	DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM tasks WHERE done = 1 AND user_id = ?", userID).Scan(&page.CompletedTasks)
+	DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM tasks WHERE priority = 'high' AND user_id = ?", userID).Scan(&page.HighPriorityTasks)
+	DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM projects WHERE user_id = ?", userID).Scan(&page.TotalProjects)
+	DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM projects WHERE status = 'active' AND user_id = ?", userID).Scan(&page.ActiveProjects)
+	DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM notes WHERE user_id = ?", userID).Scan(&page.TotalNotes)
+	page.PendingTasks = page.TotalTasks - page.CompletedTasks
+	if page.TotalTasks > 0 {
+		page.CompletionRate = float64(page.CompletedTasks) / float64(page.TotalTasks) * 100
+	}
+
+	now := userToday(r.Context(), userID)
+	from, to, _ := analyticsDateRange("", "", now, 30)
+	page.TrendFrom, page.Today = from.Format("2006-01-02"), to.Format("2006-01-02")
+	tasks, err := loadAnalyticsTasks(r.Context(), userID, 0, now.Location())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Task trends error", "err", err)
+		http.Error(w, "Failed to load analytics", http.StatusInternalServerError)
+		return
+	}
+	bounds, _ := analyticsPeriods(from, to, "day")
+	points := trendPoints(tasks, bounds, now)
+	labels := make([]string, len(points))
+	created, completed := make([]float64, len(points)), make([]float64, len(points))
+	open, overdue := make([]float64, len(points)), make([]float64, len(points))
+	for i, p := range points {
+		labels[i] = p.Period[5:]
+		created[i], completed[i] = float64(p.Created), float64(p.Completed)
+		open[i], overdue[i] = float64(p.Open), float64(p.Overdue)
+	}
+	page.TrendChart = charts.Line("Tasks created and completed per day", labels, []charts.Series{
+		{Name: "Created", Values: created, Color: "#6366f1"},
+		{Name: "Completed", Values: completed, Color: "#15f9ad"},
+	})
+	page.OpenChart = charts.Line("Open and overdue tasks", labels, []charts.Series{
+		{Name: "Open", Values: open, Color: "#6366f1"},
+		{Name: "Overdue", Values: overdue, Color: "#ef4444"},
+	})
+
+	from, to, _ = analyticsDateRange("", "", now, 84)
+	if page.Flow, err = flowMetrics(r.Context(), userID, 0, from, to, now); err != nil {
+		slog.ErrorContext(r.Context(), "Flow metrics error", "err", err)
+		http.Error(w, "Failed to load analytics", http.StatusInternalServerError)
+		return
+	}
+	labels = make([]string, len(page.Flow.Throughput))
+	values := make([]float64, len(page.Flow.Throughput))
+	for i, week := range page.Flow.Throughput {
+		labels[i], values[i] = week.Week[5:], float64(week.Completed)
+	}
+	page.ThroughputChart = charts.Bar("Tasks completed per week", labels, values, "")
+
+	tmpl, err := template.ParseFiles(templatePath("analytics.html"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Analytics template error", "err", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := executeTemplate(r.Context(), tmpl, w, page); err != nil {
+		slog.ErrorContext(r.Context(), "Analytics template error", "err", err)
+	}
+}
@@ -0,0 +1,1222 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"task-manager/models"
+	"task-manager/notecrypt"
+	"time"
+)
+
+// StorageDir holds files the server writes itself: account archives and
+// the document files restored from them.
+var StorageDir = "./storage"
+
+const (
+	archiveFormat = "tasklift-account"
+
+	// archiveSchemaVersion is bumped whenever the layout of the JSON files
+	// in an archive changes. Imports accept this version and older ones.
+	// Version 2 added notebooks.json and the notes' notebook, project and
+	// pinning. Version 3 added note_key.json and encrypted notes, version 4
+	// the tasks' completion times and version 5 their start times.
+	archiveSchemaVersion = 5
+
+	maxArchiveUploadSize = 256 << 20
+
+	// maxArchiveContentSize caps what an uploaded archive may unpack to, so
+	// a small zip that inflates to gigabytes is turned down unread.
+	maxArchiveContentSize = 1 << 30
+
+	// accountArchiveLifetime is how long finished exports, and uploads whose
+	// import never finished, are kept in StorageDir before
+	// PruneAccountArchives deletes them.
+	accountArchiveLifetime = 7 * 24 * time.Hour
+)
+
+func init() {
+	jobKinds["export:account"] = runAccountExport
+	jobKinds["import:account"] = runAccountImport
+}
+
+// The archive's JSON files. IDs are those of the exporting server and are
+// only used to link records inside the archive; timestamps are RFC 3339.
+
+type archiveManifest struct {
+	Format        string         `json:"format"`
+	SchemaVersion int            `json:"schema_version"`
+	ExportedAt    string         `json:"exported_at"`
+	Username      string         `json:"username"`
+	Email         string         `json:"email"`
+	Counts        map[string]int `json:"counts"`
+	Files         []archiveFile  `json:"files"`
+}
+
+// archiveFile lets an import detect a damaged or edited archive.
+type archiveFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type archiveProject struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	Progress    int    `json:"progress"`
+	DueDate     string `json:"due_date,omitempty"`
+	TeamMembers int    `json:"team_members"`
+	ExternalID  string `json:"external_id,omitempty"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+type archiveTask struct {
+	ID          int      `json:"id"`
+	ProjectID   *int     `json:"project_id,omitempty"`
+	Description string   `json:"description"`
+	Priority    string   `json:"priority"`
+	Done        bool     `json:"done"`
+	DueDate     string   `json:"due_date,omitempty"`
+	Tags        []string `json:"tags"`
+	ExternalID  string   `json:"external_id,omitempty"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+	StartedAt   string   `json:"started_at,omitempty"`
+	CompletedAt string   `json:"completed_at,omitempty"`
+
+	// The note checklist item the task was created from.
+	SourceNoteID    *int   `json:"source_note_id,omitempty"`
+	SourceItemIndex *int   `json:"source_item_index,omitempty"`
+	SourceItemText  string `json:"source_item_text,omitempty"`
+}
+
+type archiveNotebook struct {
+	ID        int    `json:"id"`
+	ParentID  *int   `json:"parent_id,omitempty"`
+	Name      string `json:"name"`
+	SortOrder string `json:"sort_order"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type archiveNote struct {
+	ID         int    `json:"id"`
+	NotebookID *int   `json:"notebook_id,omitempty"`
+	ProjectID  *int   `json:"project_id,omitempty"`
+	Title      string `json:"title"`
+	Content    string `json:"content"`
+	Pinned     bool   `json:"pinned"`
+	Position   int    `json:"position"`
+	CreatedAt  string `json:"created_at"`
+	UpdatedAt  string `json:"updated_at"`
+
+	// Encrypted notes hold the ciphertext, readable with the key in
+	// note_key.json and the account's passphrase.
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+// archiveNoteKey is the account's wrapped private notes key, in hex. The
+// passphrase is never exported.
+type archiveNoteKey struct {
+	Salt         string `json:"salt"`
+	ArgonTime    uint32 `json:"argon_time"`
+	ArgonMemory  uint32 `json:"argon_memory"`
+	ArgonThreads uint8  `json:"argon_threads"`
+	WrappedKey   string `json:"wrapped_key"`
+}
+
+func newArchiveNoteKey(k noteKeyRecord) *archiveNoteKey {
+	return &archiveNoteKey{
+		Salt:         hex.EncodeToString(k.salt),
+		ArgonTime:    k.params.Time,
+		ArgonMemory:  k.params.Memory,
+		ArgonThreads: k.params.Threads,
+		WrappedKey:   hex.EncodeToString(k.wrapped),
+	}
+}
+
+func (a *archiveNoteKey) record() (noteKeyRecord, error) {
+	k := noteKeyRecord{params: notecrypt.Params{Time: a.ArgonTime, Memory: a.ArgonMemory, Threads: a.ArgonThreads}}
+	var err error
+	if k.salt, err = hex.DecodeString(a.Salt); err != nil {
+		return k, err
+	}
+	k.wrapped, err = hex.DecodeString(a.WrappedKey)
+	return k, err
+}
+
+// archiveDocument is a document's metadata. Blob is the file's path inside
+// the archive, or empty when the file was missing at export time.
+type archiveDocument struct {
+	ID        int    `json:"id"`
+	Title     string `json:"title"`
+	FileName  string `json:"file_name"`
+	FileType  string `json:"file_type"`
+	FileSize  int64  `json:"file_size"`
+	Blob      string `json:"blob,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+type archiveActivity struct {
+	ID          int    `json:"id"`
+	Action      string `json:"action"`
+	EntityType  string `json:"entity_type"`
+	EntityID    int    `json:"entity_id"`
+	Description string `json:"description"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type accountArchive struct {
+	Projects  []archiveProject
+	Tasks     []archiveTask
+	Notebooks []archiveNotebook
+	Notes     []archiveNote
+	Documents []archiveDocument
+	Activity  []archiveActivity
+	NoteKey   *archiveNoteKey // nil without private notes
+}
+
+func archiveTime(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.UTC().Format(time.RFC3339)
+}
+
+// parseArchiveTime reads an archive timestamp, falling back to now for
+// missing or unreadable values.
+func parseArchiveTime(s string) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// ExportAccount queues a "download my data" job. When it is done, the job's
+// result carries the download URL of the archive.
+func ExportAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	jobID, err := queueJob(r.Context(), userID, "export:account", nil)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Queue account export error", "err", err)
+		http.Error(w, "Failed to start export", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "queued", "job_id": jobID})
+}
+
+// DownloadAccountExport serves the archive produced by an export job.
+func DownloadAccountExport(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var result string
+	err = DB.QueryRowContext(r.Context(), `
+		SELECT COALESCE(result, '') FROM background_jobs
+		WHERE id = ? AND user_id = ? AND kind = 'export:account' AND status = 'done'`,
+		r.URL.Query().Get("job_id"), userID).Scan(&result)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Load account export error", "err", err)
+		http.Error(w, "Failed to retrieve export", http.StatusInternalServerError)
+		return
+	}
+	var export models.AccountExport
+	if err := json.Unmarshal([]byte(result), &export); err != nil || export.Archive == "" {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(StorageDir, "exports", filepath.Base(export.Archive)))
+	if err != nil {
+		http.Error(w, "Export no longer available", http.StatusGone)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Export no longer available", http.StatusGone)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tasklift-export-%s.zip"`, info.ModTime().UTC().Format("2006-01-02")))
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// runAccountExport writes the user's data to a zip archive in
+// StorageDir/exports.
+func runAccountExport(ctx context.Context, job *runningJob) (interface{}, error) {
+	var manifest archiveManifest
+	if err := DB.QueryRowContext(ctx, "SELECT username, COALESCE(email, '') FROM users WHERE id = ?", job.userID).
+		Scan(&manifest.Username, &manifest.Email); err != nil {
+		return nil, err
+	}
+
+	data, paths, err := loadAccountArchive(ctx, job.userID)
+	if err != nil {
+		return nil, err
+	}
+	job.setTotal(8 + len(data.Documents)) // the JSON files and the manifest
+
+	dir := filepath.Join(StorageDir, "exports")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("account-%d-%d-%s.zip", job.userID, job.id, randomHex(8))
+	tmp, err := os.CreateTemp(dir, ".export-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	now := time.Now()
+	zw := zip.NewWriter(tmp)
+	add := func(name string, r io.Reader) error {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(fw, h), r)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, archiveFile{Name: name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))})
+		return nil
+	}
+	addJSON := func(name string, v interface{}) error {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := add(name, bytes.NewReader(b)); err != nil {
+			return err
+		}
+		job.advance(1, "Writing "+name)
+		return nil
+	}
+
+	// Blobs first, so documents.json can say which files made it in.
+	for i := range data.Documents {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		doc := &data.Documents[i]
+		job.advance(1, "Copying "+doc.Title)
+		f, err := os.Open(paths[doc.ID])
+		if err != nil {
+			slog.WarnContext(ctx, "Account export: document left out", "document_id", doc.ID, "err", err)
+			continue
+		}
+		doc.Blob = fmt.Sprintf("documents/%d/%s", doc.ID, doc.FileName)
+		err = add(doc.Blob, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, file := range []struct {
+		name string
+		v    interface{}
+	}{
+		{"projects.json", data.Projects},
+		{"tasks.json", data.Tasks},
+		{"notebooks.json", data.Notebooks},
+		{"notes.json", data.Notes},
+		{"documents.json", data.Documents},
+		{"activity.json", data.Activity},
+		{"note_key.json", data.NoteKey},
+	} {
+		if err := addJSON(file.name, file.v); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest.Format = archiveFormat
+	manifest.SchemaVersion = archiveSchemaVersion
+	manifest.ExportedAt = now.UTC().Format(time.RFC3339)
+	manifest.Counts = map[string]int{
+		"projects":  len(data.Projects),
+		"tasks":     len(data.Tasks),
+		"notebooks": len(data.Notebooks),
+		"notes":     len(data.Notes),
+		"documents": len(data.Documents),
+		"activity":  len(data.Activity),
+	}
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return nil, err
+	}
+	job.advance(1, "Finished")
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	return models.AccountExport{
+		Archive:     name,
+		Size:        info.Size(),
+		DownloadURL: fmt.Sprintf("/api/account/export/download?job_id=%d", job.id),
+		ExpiresAt:   info.ModTime().Add(accountArchiveLifetime).UTC().Format(time.RFC3339),
+		Counts:      manifest.Counts,
+	}, nil
+}
+
+// PruneAccountArchives deletes export archives, and uploads whose import
+// failed or never ran, once they are older than accountArchiveLifetime.
+// Downloading an export after that answers 410 Gone.
+func PruneAccountArchives(ctx context.Context) {
+	cutoff := time.Now().Add(-accountArchiveLifetime)
+	removed := 0
+	for _, sub := range []string{"exports", "imports"} {
+		dir := filepath.Join(StorageDir, sub)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				slog.ErrorContext(ctx, "Prune account archives error", "err", err)
+			}
+			continue
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				slog.ErrorContext(ctx, "Prune account archives error", "err", err)
+				continue
+			}
+			removed++
+		}
+	}
+	if removed > 0 {
+		slog.InfoContext(ctx, "Pruned account archives", "files", removed)
+	}
+}
+
+// loadAccountArchive reads everything an archive holds. paths maps document
+// IDs to their files on disk.
+func loadAccountArchive(ctx context.Context, userID int) (*accountArchive, map[int]string, error) {
+	data := &accountArchive{
+		Projects:  make([]archiveProject, 0),
+		Tasks:     make([]archiveTask, 0),
+		Notebooks: make([]archiveNotebook, 0),
+		Notes:     make([]archiveNote, 0),
+		Documents: make([]archiveDocument, 0),
+		Activity:  make([]archiveActivity, 0),
+	}
+	paths := make(map[int]string)
+
+	rows, err := DB.QueryContext(ctx, `
+		SELECT id, name, COALESCE(description, ''), COALESCE(status, 'active'), COALESCE(progress, 0),
+		       COALESCE(due_date, ''), COALESCE(team_members, 0), COALESCE(external_id, ''), created_at, updated_at
+		FROM projects WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var p archiveProject
+		var createdAt, updatedAt sql.NullTime
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Status, &p.Progress,
+			&p.DueDate, &p.TeamMembers, &p.ExternalID, &createdAt, &updatedAt); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		p.CreatedAt, p.UpdatedAt = archiveTime(createdAt), archiveTime(updatedAt)
+		data.Projects = append(data.Projects, p)
+	}
+	rows.Close()
+
+	rows, err = DB.QueryContext(ctx, `
+		SELECT t.id, t.project_id, t.description, COALESCE(t.priority, 'medium'), t.done,
+		       COALESCE(t.due_date, ''), COALESCE(GROUP_CONCAT(tt.tag), ''), COALESCE(t.external_id, ''),
+		       t.created_at, t.updated_at, t.started_at, t.completed_at, t.source_note_id, t.source_item_index, COALESCE(t.source_item_text, '')
+		FROM tasks t
+		LEFT JOIN task_tags tt ON tt.task_id = t.id
+		WHERE t.user_id = ?
+		GROUP BY t.id
+		ORDER BY t.id`, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var t archiveTask
+		var projectID, sourceNoteID, sourceItemIndex sql.NullInt64
+		var tags string
+		var createdAt, updatedAt, startedAt, completedAt sql.NullTime
+		if err := rows.Scan(&t.ID, &projectID, &t.Description, &t.Priority, &t.Done,
+			&t.DueDate, &tags, &t.ExternalID, &createdAt, &updatedAt, &startedAt, &completedAt,
+			&sourceNoteID, &sourceItemIndex, &t.SourceItemText); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if projectID.Valid {
+			id := int(projectID.Int64)
+			t.ProjectID = &id
+		}
+		if sourceNoteID.Valid {
+			id := int(sourceNoteID.Int64)
+			t.SourceNoteID = &id
+		}
+		if sourceItemIndex.Valid {
+			index := int(sourceItemIndex.Int64)
+			t.SourceItemIndex = &index
+		}
+		t.Tags = splitTags(tags)
+		t.CreatedAt, t.UpdatedAt = archiveTime(createdAt), archiveTime(updatedAt)
+		if startedAt.Valid {
+			t.StartedAt = archiveTime(startedAt)
+		}
+		if t.Done {
+			t.CompletedAt = archiveTime(completedAt)
+		}
+		data.Tasks = append(data.Tasks, t)
+	}
+	rows.Close()
+
+	rows, err = DB.QueryContext(ctx, `
+		SELECT id, parent_id, name, sort_order, created_at, updated_at
+		FROM notebooks WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var b archiveNotebook
+		var parentID sql.NullInt64
+		var createdAt, updatedAt sql.NullTime
+		if err := rows.Scan(&b.ID, &parentID, &b.Name, &b.SortOrder, &createdAt, &updatedAt); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			b.ParentID = &id
+		}
+		b.CreatedAt, b.UpdatedAt = archiveTime(createdAt), archiveTime(updatedAt)
+		data.Notebooks = append(data.Notebooks, b)
+	}
+	rows.Close()
+
+	rows, err = DB.QueryContext(ctx, `
+		SELECT id, notebook_id, project_id, title, COALESCE(content, ''), pinned, position, created_at, updated_at, encrypted
+		FROM notes WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var n archiveNote
+		var notebookID, projectID sql.NullInt64
+		var createdAt, updatedAt sql.NullTime
+		if err := rows.Scan(&n.ID, &notebookID, &projectID, &n.Title, &n.Content, &n.Pinned, &n.Position,
+			&createdAt, &updatedAt, &n.Encrypted); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if notebookID.Valid {
+			id := int(notebookID.Int64)
+			n.NotebookID = &id
+		}
+		if projectID.Valid {
+			id := int(projectID.Int64)
+			n.ProjectID = &id
+		}
+		n.CreatedAt, n.UpdatedAt = archiveTime(createdAt), archiveTime(updatedAt)
+		data.Notes = append(data.Notes, n)
+	}
+	rows.Close()
+
+	key, err := loadNoteKeyRecord(ctx, DB, userID)
+	if err == nil {
+		data.NoteKey = newArchiveNoteKey(key)
+	} else if err != sql.ErrNoRows {
+		return nil, nil, err
+	}
+
+	rows, err = DB.QueryContext(ctx, `
+		SELECT id, title, file_path, COALESCE(file_type, ''), COALESCE(file_size, 0), created_at
+		FROM documents WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var d archiveDocument
+		var filePath string
+		var createdAt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.Title, &filePath, &d.FileType, &d.FileSize, &createdAt); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		d.FileName = archiveFileName(filePath)
+		d.CreatedAt = archiveTime(createdAt)
+		paths[d.ID] = filePath
+		data.Documents = append(data.Documents, d)
+	}
+	rows.Close()
+
+	rows, err = DB.QueryContext(ctx, `
+		SELECT id, action, entity_type, entity_id, COALESCE(description, ''), created_at
+		FROM activity_logs WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var a archiveActivity
+		var createdAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.Action, &a.EntityType, &a.EntityID, &a.Description, &createdAt); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		a.CreatedAt = archiveTime(createdAt)
+		data.Activity = append(data.Activity, a)
+	}
+	rows.Close()
+
+	return data, paths, rows.Err()
+}
+
+// archiveFileName reduces a stored path to a file name that is safe inside
+// a zip archive and on disk.
+func archiveFileName(p string) string {
+	name := filepath.Base(filepath.FromSlash(strings.ReplaceAll(p, "\\", "/")))
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+// ImportAccount accepts an archive made by ExportAccount as the multipart
+// "archive" field and queues a job that adds its contents to the current
+// account. To move to a new account, register it, log in and import there.
+func ImportAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxArchiveUploadSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "Expected a multipart upload of at most 256 MB", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	file, _, err := r.FormFile("archive")
+	if err != nil {
+		http.Error(w, "Archive is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	dir := filepath.Join(StorageDir, "imports")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		slog.ErrorContext(r.Context(), "Account import error", "err", err)
+		http.Error(w, "Failed to start import", http.StatusInternalServerError)
+		return
+	}
+	dest := filepath.Join(dir, fmt.Sprintf("account-%d-%s.zip", userID, randomHex(8)))
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Account import error", "err", err)
+		http.Error(w, "Failed to start import", http.StatusInternalServerError)
+		return
+	}
+	_, err = io.Copy(out, file)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dest)
+		slog.ErrorContext(r.Context(), "Account import error", "err", err)
+		http.Error(w, "Failed to start import", http.StatusInternalServerError)
+		return
+	}
+
+	archive, err := openAccountArchive(dest)
+	if err != nil {
+		os.Remove(dest)
+		http.Error(w, "Invalid archive: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	archive.Close()
+
+	payload, _ := json.Marshal(map[string]string{"path": dest})
+	jobID, err := queueJob(r.Context(), userID, "import:account", payload)
+	if err != nil {
+		os.Remove(dest)
+		slog.ErrorContext(r.Context(), "Queue account import error", "err", err)
+		http.Error(w, "Failed to start import", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "queued", "job_id": jobID})
+}
+
+// openedArchive is an archive whose manifest and checksums have been
+// checked. Close it when done.
+type openedArchive struct {
+	*zip.ReadCloser
+	manifest archiveManifest
+	files    map[string]*zip.File
+}
+
+func openAccountArchive(name string) (*openedArchive, error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, fmt.Errorf("not a zip file")
+	}
+	a := &openedArchive{ReadCloser: zr, files: make(map[string]*zip.File)}
+	if err := a.check(); err != nil {
+		zr.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *openedArchive) check() error {
+	var total uint64
+	for _, f := range a.File {
+		if f.UncompressedSize64 > maxArchiveContentSize-total {
+			return fmt.Errorf("archive unpacks to more than %d MB", maxArchiveContentSize>>20)
+		}
+		total += f.UncompressedSize64
+		a.files[f.Name] = f
+	}
+	if err := a.readJSON("manifest.json", &a.manifest); err != nil {
+		return err
+	}
+	if a.manifest.Format != archiveFormat {
+		return fmt.Errorf("not a TaskLift account archive")
+	}
+	if a.manifest.SchemaVersion < 1 || a.manifest.SchemaVersion > archiveSchemaVersion {
+		return fmt.Errorf("unsupported schema version %d", a.manifest.SchemaVersion)
+	}
+	// The zip headers may lie about sizes, so the bytes actually read are
+	// counted against the same cap.
+	remaining := int64(maxArchiveContentSize)
+	for _, want := range a.manifest.Files {
+		f, ok := a.files[want.Name]
+		if !ok {
+			return fmt.Errorf("%s is missing", want.Name)
+		}
+		if want.Size < 0 || want.Size > remaining {
+			return fmt.Errorf("archive unpacks to more than %d MB", maxArchiveContentSize>>20)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %v", want.Name, err)
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, io.LimitReader(rc, want.Size+1))
+		rc.Close()
+		remaining -= n
+		if err != nil || n != want.Size || hex.EncodeToString(h.Sum(nil)) != want.SHA256 {
+			return fmt.Errorf("%s is damaged", want.Name)
+		}
+	}
+	return nil
+}
+
+func (a *openedArchive) readJSON(name string, v interface{}) error {
+	f, ok := a.files[name]
+	if !ok {
+		return fmt.Errorf("%s is missing", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(io.LimitReader(rc, int64(f.UncompressedSize64))).Decode(v); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// runAccountImport restores an archive in one transaction. Every record gets
+// a new ID; project, task, note and document references are rewritten to
+// the new IDs. The uploaded archive is removed once the data is committed,
+// so a job re-run after a crash fails instead of importing twice.
+func runAccountImport(ctx context.Context, job *runningJob) (interface{}, error) {
+	var payload struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(job.payload, &payload); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(payload.Path); err != nil {
+		return nil, fmt.Errorf("archive no longer available")
+	}
+	archive, err := openAccountArchive(payload.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	var data accountArchive
+	for _, file := range []struct {
+		name string
+		v    interface{}
+	}{
+		{"projects.json", &data.Projects},
+		{"tasks.json", &data.Tasks},
+		{"notes.json", &data.Notes},
+		{"documents.json", &data.Documents},
+		{"activity.json", &data.Activity},
+	} {
+		if err := archive.readJSON(file.name, file.v); err != nil {
+			return nil, err
+		}
+	}
+	if archive.manifest.SchemaVersion >= 2 {
+		if err := archive.readJSON("notebooks.json", &data.Notebooks); err != nil {
+			return nil, err
+		}
+	}
+	if archive.manifest.SchemaVersion >= 3 {
+		if err := archive.readJSON("note_key.json", &data.NoteKey); err != nil {
+			return nil, err
+		}
+	}
+	job.setTotal(len(data.Projects) + len(data.Tasks) + len(data.Notebooks) + len(data.Notes) +
+		len(data.Documents) + len(data.Activity))
+
+	imp := &accountImport{
+		ctx:       ctx,
+		job:       job,
+		archive:   archive,
+		projects:  make(map[int]int64),
+		tasks:     make(map[int]int64),
+		notebooks: make(map[int]int64),
+		notes:     make(map[int]int64),
+		docs:      make(map[int]int64),
+		report:    models.AccountImportReport{Skipped: make([]models.SkippedItem, 0)},
+	}
+	if err := imp.run(&data); err != nil {
+		for _, name := range imp.written {
+			os.Remove(name)
+		}
+		return nil, err
+	}
+	os.Remove(payload.Path)
+
+	for _, id := range imp.projects {
+		if project, err := loadProject(ctx, job.userID, id); err == nil {
+			publish(ctx, job.userID, "project.created", project)
+		}
+	}
+	for _, id := range imp.tasks {
+		if task, err := loadTask(ctx, job.userID, id); err == nil {
+			publish(ctx, job.userID, "task.created", task)
+		}
+	}
+	for _, id := range imp.notes {
+		if note, err := loadNote(ctx, job.userID, id); err == nil {
+			publish(ctx, job.userID, "note.created", note)
+		}
+	}
+	job.advance(0, "Finished")
+	return imp.report, nil
+}
+
+type accountImport struct {
+	ctx     context.Context
+	job     *runningJob
+	archive *openedArchive
+	tx      *sql.Tx
+
+	// Archive IDs → new IDs.
+	projects  map[int]int64
+	tasks     map[int]int64
+	notebooks map[int]int64
+	notes     map[int]int64
+	docs      map[int]int64
+
+	written []string // document files created, removed again on failure
+	report  models.AccountImportReport
+}
+
+func (imp *accountImport) run(data *accountArchive) error {
+	tx, err := DB.BeginTx(imp.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	imp.tx = tx
+	userID := imp.job.userID
+
+	for _, p := range data.Projects {
+		externalID, err := imp.freeExternalID("projects", p.ExternalID)
+		if err != nil {
+			return err
+		}
+		status := p.Status
+		if !containsString([]string{"active", "completed", "paused", "cancelled"}, status) {
+			status = "active"
+		}
+		res, err := tx.ExecContext(imp.ctx, `
+			INSERT INTO projects (user_id, name, description, status, progress, due_date, team_members, external_id, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID, p.Name, p.Description, status, p.Progress, nullableString(p.DueDate), p.TeamMembers, externalID,
+			parseArchiveTime(p.CreatedAt), parseArchiveTime(p.UpdatedAt))
+		if err != nil {
+			return fmt.Errorf("project %d: %v", p.ID, err)
+		}
+		imp.projects[p.ID], _ = res.LastInsertId()
+		resolveLinksTo(imp.ctx, tx, userID, "project", imp.projects[p.ID], p.Name)
+		imp.report.Projects++
+		imp.job.advance(1, "Restoring projects")
+	}
+
+	for _, t := range data.Tasks {
+		if err := imp.ctx.Err(); err != nil {
+			return err
+		}
+		var projectID interface{}
+		if t.ProjectID != nil {
+			if id, ok := imp.projects[*t.ProjectID]; ok {
+				projectID = id
+			}
+		}
+		externalID, err := imp.freeExternalID("tasks", t.ExternalID)
+		if err != nil {
+			return err
+		}
+		priority := t.Priority
+		if priority != "high" && priority != "low" {
+			priority = "medium"
+		}
+		// Without a completion time, a done task counts as completed at its
+		// last update.
+		var startedAt, completedAt interface{}
+		if t.StartedAt != "" {
+			startedAt = parseArchiveTime(t.StartedAt)
+		}
+		if t.Done && t.CompletedAt != "" {
+			completedAt = parseArchiveTime(t.CompletedAt)
+		}
+		res, err := tx.ExecContext(imp.ctx, `
+			INSERT INTO tasks (user_id, project_id, description, priority, done, due_date, external_id,
+			                   created_at, updated_at, started_at, completed_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID, projectID, t.Description, priority, t.Done, nullableString(t.DueDate), externalID,
+			parseArchiveTime(t.CreatedAt), parseArchiveTime(t.UpdatedAt), startedAt, completedAt)
+		if err != nil {
+			return fmt.Errorf("task %d: %v", t.ID, err)
+		}
+		id, _ := res.LastInsertId()
+		if err := replaceTaskTags(imp.ctx, tx, id, normalizeTags(t.Tags)); err != nil {
+			return err
+		}
+		imp.tasks[t.ID] = id
+		imp.report.Tasks++
+		imp.job.advance(1, "Restoring tasks")
+	}
+
+	for _, b := range data.Notebooks {
+		sortOrder := b.SortOrder
+		if _, ok := noteOrders[sortOrder]; !ok {
+			sortOrder = "updated"
+		}
+		res, err := tx.ExecContext(imp.ctx, `
+			INSERT INTO notebooks (user_id, name, sort_order, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?)`,
+			userID, b.Name, sortOrder, parseArchiveTime(b.CreatedAt), parseArchiveTime(b.UpdatedAt))
+		if err != nil {
+			return fmt.Errorf("notebook %d: %v", b.ID, err)
+		}
+		imp.notebooks[b.ID], _ = res.LastInsertId()
+		imp.report.Notebooks++
+		imp.job.advance(1, "Restoring notebooks")
+	}
+	// Parents are set once every notebook exists, as a notebook may have
+	// been moved under one created after it.
+	for _, b := range data.Notebooks {
+		if b.ParentID == nil {
+			continue
+		}
+		if parentID, ok := imp.notebooks[*b.ParentID]; ok {
+			if _, err := tx.ExecContext(imp.ctx, "UPDATE notebooks SET parent_id = ? WHERE id = ?", parentID, imp.notebooks[b.ID]); err != nil {
+				return fmt.Errorf("notebook %d: %v", b.ID, err)
+			}
+		}
+	}
+
+	encryptedReason, err := imp.restoreNoteKey(data.NoteKey)
+	if err != nil {
+		return err
+	}
+	for _, n := range data.Notes {
+		if n.Encrypted && (encryptedReason != "" || !notecrypt.IsSealed(n.Content)) {
+			if encryptedReason == "" {
+				encryptedReason = "encrypted content is damaged"
+			}
+			imp.skip("note", n.Title, encryptedReason)
+			imp.job.advance(1, "Restoring notes")
+			continue
+		}
+		var notebookID, projectID interface{}
+		if n.NotebookID != nil {
+			if id, ok := imp.notebooks[*n.NotebookID]; ok {
+				notebookID = id
+			}
+		}
+		if n.ProjectID != nil {
+			if id, ok := imp.projects[*n.ProjectID]; ok {
+				projectID = id
+			}
+		}
+		res, err := tx.ExecContext(imp.ctx, `
+			INSERT INTO notes (user_id, notebook_id, project_id, title, content, pinned, position, encrypted, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID, notebookID, projectID, n.Title, n.Content, n.Pinned, n.Position, n.Encrypted,
+			parseArchiveTime(n.CreatedAt), parseArchiveTime(n.UpdatedAt))
+		if err != nil {
+			return fmt.Errorf("note %d: %v", n.ID, err)
+		}
+		imp.notes[n.ID], _ = res.LastInsertId()
+		if n.Encrypted {
+			imp.report.Notes++
+			imp.job.advance(1, "Restoring notes")
+			continue
+		}
+		if err := recordNoteRevision(imp.ctx, tx, imp.notes[n.ID], userID, n.Title, n.Content, false); err != nil {
+			return err
+		}
+		imp.report.Notes++
+		imp.job.advance(1, "Restoring notes")
+	}
+	// Tasks come before notes, so their source notes are filled in now.
+	for _, t := range data.Tasks {
+		if t.SourceNoteID == nil {
+			continue
+		}
+		noteID, ok := imp.notes[*t.SourceNoteID]
+		if !ok {
+			continue
+		}
+		var text interface{}
+		if t.SourceItemText != "" {
+			text = t.SourceItemText
+		}
+		if _, err := tx.ExecContext(imp.ctx, "UPDATE tasks SET source_note_id = ?, source_item_index = ?, source_item_text = ? WHERE id = ?",
+			noteID, t.SourceItemIndex, text, imp.tasks[t.ID]); err != nil {
+			return fmt.Errorf("task %d: %v", t.ID, err)
+		}
+	}
+
+	// Links are indexed once every note exists, so wiki links between
+	// restored notes resolve regardless of their order in the archive.
+	for _, n := range data.Notes {
+		if _, ok := imp.notes[n.ID]; !ok || n.Encrypted {
+			continue
+		}
+		if err := updateNoteLinks(imp.ctx, tx, userID, imp.notes[n.ID], n.Content); err != nil {
+			return fmt.Errorf("note %d: %v", n.ID, err)
+		}
+	}
+	// Broken links already in the account may name a restored note.
+	for _, n := range data.Notes {
+		if id, ok := imp.notes[n.ID]; ok {
+			resolveLinksTo(imp.ctx, tx, userID, "note", id, n.Title)
+		}
+	}
+
+	for _, d := range data.Documents {
+		if err := imp.restoreDocument(d); err != nil {
+			return fmt.Errorf("document %d: %v", d.ID, err)
+		}
+		imp.job.advance(1, "Restoring documents")
+	}
+
+	for _, a := range data.Activity {
+		entityID, ok := imp.entityID(a.EntityType, a.EntityID)
+		if !ok {
+			imp.skip("activity", a.Action+" "+a.EntityType, "refers to a record not in the archive")
+			imp.job.advance(1, "Restoring activity")
+			continue
+		}
+		if _, err := tx.ExecContext(imp.ctx, `
+			INSERT INTO activity_logs (user_id, action, entity_type, entity_id, description, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			userID, a.Action, a.EntityType, entityID, a.Description, parseArchiveTime(a.CreatedAt)); err != nil {
+			return fmt.Errorf("activity %d: %v", a.ID, err)
+		}
+		imp.report.Activity++
+		imp.job.advance(1, "Restoring activity")
+	}
+
+	return tx.Commit()
+}
+
+// restoreNoteKey makes the archive's encrypted notes readable in the
+// account, installing the archive's key if the account has none. It
+// returns why encrypted notes must be skipped instead, if they must.
+func (imp *accountImport) restoreNoteKey(a *archiveNoteKey) (string, error) {
+	if a == nil {
+		return "its key is not in the archive", nil
+	}
+	key, err := a.record()
+	if err != nil {
+		return "the archive's key is damaged", nil
+	}
+	current, err := loadNoteKeyRecord(imp.ctx, imp.tx, imp.job.userID)
+	switch {
+	case err == sql.ErrNoRows:
+		now := time.Now()
+		_, err := imp.tx.ExecContext(imp.ctx, `
+			INSERT INTO note_keys (user_id, salt, argon_time, argon_memory, argon_threads, wrapped_key, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			imp.job.userID, key.salt, key.params.Time, key.params.Memory, key.params.Threads, key.wrapped, now, now)
+		return "", err
+	case err != nil:
+		return "", err
+	case !sameNoteKey(current, key):
+		return "encrypted with a different private notes key", nil
+	}
+	return "", nil
+}
+
+// freeExternalID returns the external ID to store, or nil when the account
+// already uses it, e.g. when restoring an archive into the account it came
+// from.
+func (imp *accountImport) freeExternalID(table, externalID string) (interface{}, error) {
+	if externalID == "" {
+		return nil, nil
+	}
+	var taken bool
+	err := imp.tx.QueryRowContext(imp.ctx, "SELECT EXISTS(SELECT 1 FROM "+table+" WHERE user_id = ? AND external_id = ?)",
+		imp.job.userID, externalID).Scan(&taken)
+	if err != nil || taken {
+		return nil, err
+	}
+	return externalID, nil
+}
+
+func (imp *accountImport) restoreDocument(d archiveDocument) error {
+	if d.Blob == "" || imp.archive.files[d.Blob] == nil {
+		imp.skip("document", d.Title, "file was not included in the archive")
+		return nil
+	}
+	rc, err := imp.archive.files[d.Blob].Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dir := filepath.Join(StorageDir, "documents", fmt.Sprint(imp.job.userID))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	dest := filepath.Join(dir, randomHex(8)+"-"+archiveFileName(path.Base(d.Blob)))
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	imp.written = append(imp.written, dest)
+	size, err := io.Copy(out, io.LimitReader(rc, int64(imp.archive.files[d.Blob].UncompressedSize64)))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := imp.tx.ExecContext(imp.ctx, `
+		INSERT INTO documents (user_id, title, file_path, file_type, file_size, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		imp.job.userID, d.Title, dest, d.FileType, size, parseArchiveTime(d.CreatedAt))
+	if err != nil {
+		return err
+	}
+	imp.docs[d.ID], _ = res.LastInsertId()
+	imp.report.Documents++
+	return nil
+}
+
+// entityID maps an activity entry's entity to its new ID.
+func (imp *accountImport) entityID(entityType string, id int) (int64, bool) {
+	var ids map[int]int64
+	switch entityType {
+	case "task":
+		ids = imp.tasks
+	case "project":
+		ids = imp.projects
+	case "note":
+		ids = imp.notes
+	case "document":
+		ids = imp.docs
+	default:
+		return 0, false
+	}
+	newID, ok := ids[id]
+	return newID, ok
+}
+
+func (imp *accountImport) skip(kind, name, reason string) {
+	imp.report.Skipped = append(imp.report.Skipped, models.SkippedItem{Kind: kind, Name: name, Reason: reason})
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestArchive writes a zip with a manifest listing every file in files.
+func writeTestArchive(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+	manifest := archiveManifest{Format: archiveFormat, SchemaVersion: archiveSchemaVersion}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(content)
+		sum := sha256.Sum256(content)
+		manifest.Files = append(manifest.Files, archiveFile{Name: name, Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:])})
+	}
+	fw, err := zw.Create("manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewEncoder(fw).Encode(manifest)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "archive.zip")
+	if err := os.WriteFile(name, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestOpenAccountArchiveChecksFiles(t *testing.T) {
+	name := writeTestArchive(t, map[string][]byte{"tasks.json": []byte("[]\n")})
+	a, err := openAccountArchive(name)
+	if err != nil {
+		t.Fatalf("valid archive rejected: %v", err)
+	}
+	a.Close()
+}
+
+func TestOpenAccountArchiveRejectsOversizedContent(t *testing.T) {
+	// Deflate packs this into a few megabytes, but it unpacks past the cap.
+	blob := make([]byte, maxArchiveContentSize/4+1)
+	name := writeTestArchive(t, map[string][]byte{
+		"a.bin": blob, "b.bin": blob, "c.bin": blob, "d.bin": blob,
+	})
+	_, err := openAccountArchive(name)
+	if err == nil || !strings.Contains(err.Error(), "unpacks to more than") {
+		t.Fatalf("oversized archive: got %v", err)
+	}
+}
+
+func TestPruneAccountArchivesRemovesOldFiles(t *testing.T) {
+	previous := StorageDir
+	StorageDir = t.TempDir()
+	t.Cleanup(func() { StorageDir = previous })
+
+	write := func(sub, name string, age time.Duration) string {
+		dir := filepath.Join(StorageDir, sub)
+		os.MkdirAll(dir, 0o700)
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte("zip"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		modified := time.Now().Add(-age)
+		os.Chtimes(file, modified, modified)
+		return file
+	}
+	oldExport := write("exports", "account-1-1-old.zip", accountArchiveLifetime+time.Hour)
+	newExport := write("exports", "account-1-2-new.zip", time.Hour)
+	oldImport := write("imports", "account-1-old.zip", accountArchiveLifetime+time.Hour)
+
+	PruneAccountArchives(context.Background())
+
+	for _, file := range []string{oldExport, oldImport} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s was kept", filepath.Base(file))
+		}
+	}
+	if _, err := os.Stat(newExport); err != nil {
+		t.Errorf("recent export removed: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"html/template"
 	"net/http"
+	"path/filepath"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -11,13 +12,28 @@ import (
 
 var DB *sql.DB
 
+// Settings main fills in from the configuration.
+var (
+	// TemplateDir holds the HTML and email templates.
+	TemplateDir = "./templates"
+	// SessionLifetime is how long a login lasts.
+	SessionLifetime = 24 * time.Hour
+	// SecureCookies restricts cookies to HTTPS.
+	SecureCookies = false
+)
+
+// templatePath returns the path of a file in TemplateDir.
+func templatePath(name string) string {
+	return filepath.Join(TemplateDir, name)
+}
+
 func InitAuthHandler(db *sql.DB) {
 	DB = db
 }
 
 func Login(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		http.ServeFile(w, r, "./templates/login.html")
+		http.ServeFile(w, r, templatePath("login.html"))
 		return
 	}
 
@@ -31,7 +47,7 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		}
 
 		var storedHashedPassword, storedUsername string
-		err := DB.QueryRow("SELECT username, password FROM users WHERE username = ? OR email = ?",
+		err := DB.QueryRowContext(r.Context(), "SELECT username, password FROM users WHERE username = ? OR email = ?",
 			loginInput, loginInput).Scan(&storedUsername, &storedHashedPassword)
 
 		if err == sql.ErrNoRows {
@@ -55,8 +71,8 @@ func Login(w http.ResponseWriter, r *http.Request) {
 			Value:    storedUsername,
 			Path:     "/",
 			HttpOnly: true,
-			Secure:   false, // change to true if using HTTPS
-			Expires:  time.Now().Add(24 * time.Hour),
+			Secure:   SecureCookies,
+			Expires:  time.Now().Add(SessionLifetime),
 		})
 
 		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
@@ -66,7 +82,7 @@ func Login(w http.ResponseWriter, r *http.Request) {
 func Register(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		w.Header().Set("Content-Type", "text/html")
-		http.ServeFile(w, r, "./templates/register.html")
+		http.ServeFile(w, r, templatePath("register.html"))
 		return
 	}
 
@@ -95,7 +111,7 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Store the HASHED password in the database
-		stmt, err := DB.Prepare("INSERT INTO users (username, email, password) VALUES (?, ?, ?)")
+		stmt, err := DB.PrepareContext(r.Context(), "INSERT INTO users (username, email, password) VALUES (?, ?, ?)")
 		if err != nil {
 			http.Error(w, "Database error", http.StatusInternalServerError)
 			return
@@ -103,7 +119,7 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		defer stmt.Close()
 
 		// Use hashedPassword, NOT the plain password
-		_, err = stmt.Exec(username, email, string(hashedPassword))
+		_, err = stmt.ExecContext(r.Context(), username, email, string(hashedPassword))
 		if err != nil {
 			http.Error(w, "Username or email already exists", http.StatusConflict)
 			return
@@ -124,13 +140,13 @@ func Dashboard(w http.ResponseWriter, r *http.Request) {
 	username := cookie.Value
 
 	// Serve the dashboard template with the username
-	tmpl, err := template.ParseFiles("./templates/dashboard.html")
+	tmpl, err := template.ParseFiles(templatePath("dashboard.html"))
 	if err != nil {
 		http.Error(w, "Could not load dashboard", http.StatusInternalServerError)
 		return
 	}
 
-	tmpl.Execute(w, struct {
+	executeTemplate(r.Context(), tmpl, w, struct {
 		Username string
 	}{
 		Username: username,
@@ -145,7 +161,9 @@ func Logout(w http.ResponseWriter, r *http.Request) {
 		Path:     "/",
 		Expires:  time.Now().Add(-1 * time.Hour),
 		HttpOnly: true,
+		Secure:   SecureCookies,
 	})
+	lockNotes(w, r)
 
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
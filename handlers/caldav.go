@@ -0,0 +1,882 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"task-manager/caldav"
+	"task-manager/ical"
+	"task-manager/logging"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CalDAV resources are laid out as
+//
+//	/caldav/                              the user's principal
+//	/caldav/calendars/                    calendar home
+//	/caldav/calendars/inbox/              tasks without a project
+//	/caldav/calendars/project-<id>/       one collection per project
+//	/caldav/calendars/<collection>/<name> a task as a VTODO
+const (
+	caldavRoot = "/caldav/"
+	caldavHome = "/caldav/calendars/"
+
+	caldavSyncPrefix = "urn:tasklift:sync:"
+
+	// caldavSyncRetention is how long task_changes keeps a change, and so
+	// how long a client may go between syncs before it has to start over.
+	caldavSyncRetention = 30 * 24 * time.Hour
+)
+
+var calendarDataName = xml.Name{Space: caldav.NSCalDAV, Local: "calendar-data"}
+
+type davCollection struct {
+	ProjectID   int // 0 for the inbox
+	Name        string
+	Description string
+}
+
+func (c davCollection) href() string {
+	if c.ProjectID == 0 {
+		return caldavHome + "inbox/"
+	}
+	return fmt.Sprintf("%sproject-%d/", caldavHome, c.ProjectID)
+}
+
+// davTarget is what a request path refers to. Objects are identified by
+// name only; whether the task exists is up to the method.
+type davTarget struct {
+	kind       string // "principal", "home", "collection" or "object"
+	collection davCollection
+	name       string
+}
+
+// davObject is a task rendered as a calendar object resource. The ETag is
+// a hash of the rendered data without the task's update time, so it changes
+// with a published field, exactly when task_changes logs the task, and not
+// when only something a client never sees, such as a reminder, does.
+type davObject struct {
+	task calendarTask
+	cal  *ical.Component
+	data []byte
+	etag string
+}
+
+func newDAVObject(task calendarTask) davObject {
+	cal, data := renderDAVObject(task)
+	unstamped := task
+	unstamped.UpdatedAt = sql.NullTime{}
+	_, stable := renderDAVObject(unstamped)
+	sum := sha1.Sum(stable)
+	return davObject{task: task, cal: cal, data: data, etag: `"` + hex.EncodeToString(sum[:]) + `"`}
+}
+
+func renderDAVObject(task calendarTask) (*ical.Component, []byte) {
+	cal := ical.NewComponent("VCALENDAR")
+	cal.Add("VERSION", "2.0", nil)
+	cal.Add("PRODID", "-//TaskLift//CalDAV//EN", nil)
+	cal.AddChild(task.todo())
+
+	var buf bytes.Buffer
+	ical.Encode(&buf, cal)
+	return cal, buf.Bytes()
+}
+
+func (o davObject) href(c davCollection) string {
+	return c.href() + url.PathEscape(o.task.Name)
+}
+
+// caldavUser checks HTTP Basic credentials against the users table. CalDAV
+// clients cannot log in through the web form, so they send the same
+// username (or email) and password on every request.
+func caldavUser(r *http.Request) (int, string, bool) {
+	login, password, ok := r.BasicAuth()
+	if !ok || login == "" || password == "" {
+		return 0, "", false
+	}
+
+	var userID int
+	var username, hash string
+	err := DB.QueryRowContext(r.Context(), "SELECT id, username, password FROM users WHERE username = ? OR email = ?",
+		login, login).Scan(&userID, &username, &hash)
+	if err != nil {
+		return 0, "", false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return 0, "", false
+	}
+	logging.SetUserID(r.Context(), userID)
+	return userID, username, true
+}
+
+// CalDAVWellKnown redirects /.well-known/caldav (RFC 6764) to the principal.
+func CalDAVWellKnown(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, caldavRoot, http.StatusMovedPermanently)
+}
+
+// CalDAV serves the CalDAV tree under /caldav/, exposing each project (and
+// an inbox for tasks without one) as a VTODO calendar collection.
+func CalDAV(w http.ResponseWriter, r *http.Request) {
+	userID, username, ok := caldavUser(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="TaskLift CalDAV", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("DAV", "1, 3, calendar-access")
+
+	target, err := resolveDAV(r.Context(), userID, r.URL.Path)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		slog.ErrorContext(r.Context(), "CalDAV resolve error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, PROPPATCH, REPORT")
+		w.WriteHeader(http.StatusOK)
+	case "PROPFIND":
+		caldavPropfind(w, r, userID, username, target)
+	case "PROPPATCH":
+		caldavProppatch(w, r)
+	case "REPORT":
+		caldavReport(w, r, userID, target)
+	case http.MethodGet, http.MethodHead:
+		caldavGet(w, r, userID, target)
+	case http.MethodPut:
+		caldavPut(w, r, userID, target)
+	case http.MethodDelete:
+		caldavDelete(w, r, userID, target)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// resolveDAV maps a request path onto a resource, returning sql.ErrNoRows
+// for paths outside the tree and for other users' projects.
+func resolveDAV(ctx context.Context, userID int, path string) (davTarget, error) {
+	switch strings.TrimSuffix(path, "/") + "/" {
+	case caldavRoot:
+		return davTarget{kind: "principal"}, nil
+	case caldavHome:
+		return davTarget{kind: "home"}, nil
+	}
+	if !strings.HasPrefix(path, caldavHome) {
+		return davTarget{}, sql.ErrNoRows
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, caldavHome), "/")
+	if len(parts) > 2 {
+		return davTarget{}, sql.ErrNoRows
+	}
+	collection, err := loadDAVCollection(ctx, userID, parts[0])
+	if err != nil {
+		return davTarget{}, err
+	}
+	if len(parts) == 1 || parts[1] == "" {
+		return davTarget{kind: "collection", collection: collection}, nil
+	}
+	return davTarget{kind: "object", collection: collection, name: parts[1]}, nil
+}
+
+func loadDAVCollection(ctx context.Context, userID int, slug string) (davCollection, error) {
+	if slug == "inbox" {
+		return davCollection{Name: "Inbox", Description: "Tasks without a project"}, nil
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(slug, "project-"))
+	if err != nil || !strings.HasPrefix(slug, "project-") {
+		return davCollection{}, sql.ErrNoRows
+	}
+	c := davCollection{ProjectID: id}
+	err = DB.QueryRowContext(ctx, "SELECT name, COALESCE(description, '') FROM projects WHERE id = ? AND user_id = ?",
+		id, userID).Scan(&c.Name, &c.Description)
+	return c, err
+}
+
+func loadDAVCollections(ctx context.Context, userID int) ([]davCollection, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT id, name, COALESCE(description, '') FROM projects WHERE user_id = ? ORDER BY name",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []davCollection{{Name: "Inbox", Description: "Tasks without a project"}}
+	for rows.Next() {
+		var c davCollection
+		if err := rows.Scan(&c.ProjectID, &c.Name, &c.Description); err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	return collections, rows.Err()
+}
+
+// loadDAVObjects returns the tasks in a collection, or just the one called
+// name when name is set.
+func loadDAVObjects(ctx context.Context, userID, projectID int, name string) ([]davObject, error) {
+	query := calendarTaskSelect + " WHERE t.user_id = ? AND COALESCE(t.project_id, 0) = ?"
+	args := []interface{}{userID, projectID}
+	if name != "" {
+		query += " AND COALESCE(t.ical_name, 'task-' || t.id || '.ics') = ?"
+		args = append(args, name)
+	}
+	query += " ORDER BY t.id"
+
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []davObject
+	for rows.Next() {
+		task, err := scanCalendarTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, newDAVObject(task))
+	}
+	return objects, rows.Err()
+}
+
+func loadDAVObject(ctx context.Context, userID int, target davTarget) (davObject, error) {
+	objects, err := loadDAVObjects(ctx, userID, target.collection.ProjectID, target.name)
+	if err != nil {
+		return davObject{}, err
+	}
+	if len(objects) == 0 {
+		return davObject{}, sql.ErrNoRows
+	}
+	return objects[0], nil
+}
+
+// collectionSyncSeq is the latest change logged for a collection, or its
+// horizon once every change has been pruned. It is both the CTag and the
+// sync token of the collection.
+func collectionSyncSeq(ctx context.Context, userID, projectID int) (int64, error) {
+	var seq int64
+	err := DB.QueryRowContext(ctx, `
+		SELECT MAX(
+			(SELECT COALESCE(MAX(seq), 0) FROM task_changes WHERE user_id = ?1 AND project_id = ?2),
+			(SELECT COALESCE(MAX(seq), 0) FROM task_change_horizons WHERE user_id = ?1 AND project_id = ?2))`,
+		userID, projectID).Scan(&seq)
+	return seq, err
+}
+
+// collectionSyncHorizon is the newest change pruned from a collection's
+// log. Tokens before it cannot be answered with the changes since.
+func collectionSyncHorizon(ctx context.Context, userID, projectID int) (int64, error) {
+	var seq int64
+	err := DB.QueryRowContext(ctx, "SELECT COALESCE(MAX(seq), 0) FROM task_change_horizons WHERE user_id = ? AND project_id = ?",
+		userID, projectID).Scan(&seq)
+	return seq, err
+}
+
+// PruneTaskChanges drops changes older than caldavSyncRetention from the
+// CalDAV change log and moves each collection's horizon up to the newest
+// change dropped from it. Clients with older tokens get valid-sync-token
+// and sync from scratch.
+func PruneTaskChanges(ctx context.Context) {
+	cutoff := time.Now().UTC().Add(-caldavSyncRetention).Format("2006-01-02 15:04:05")
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "Prune task changes error", "err", err)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO task_change_horizons (user_id, project_id, seq)
+		SELECT user_id, project_id, MAX(seq) FROM task_changes
+		WHERE changed_at < ?
+		GROUP BY user_id, project_id
+		ON CONFLICT (user_id, project_id) DO UPDATE SET seq = MAX(seq, excluded.seq)`, cutoff)
+	var res sql.Result
+	if err == nil {
+		res, err = tx.ExecContext(ctx, "DELETE FROM task_changes WHERE changed_at < ?", cutoff)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Prune task changes error", "err", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.InfoContext(ctx, "Pruned CalDAV change log", "rows", n)
+	}
+}
+
+func principalProps(username string) []caldav.Element {
+	return []caldav.Element{
+		caldav.New(caldav.NSDAV, "resourcetype", caldav.New(caldav.NSDAV, "principal")),
+		caldav.NewText(caldav.NSDAV, "displayname", username),
+		caldav.New(caldav.NSDAV, "current-user-principal", caldav.Href(caldavRoot)),
+		caldav.New(caldav.NSDAV, "principal-URL", caldav.Href(caldavRoot)),
+		caldav.New(caldav.NSCalDAV, "calendar-home-set", caldav.Href(caldavHome)),
+	}
+}
+
+func homeProps() []caldav.Element {
+	return []caldav.Element{
+		caldav.New(caldav.NSDAV, "resourcetype", caldav.New(caldav.NSDAV, "collection")),
+		caldav.NewText(caldav.NSDAV, "displayname", "Calendars"),
+		caldav.New(caldav.NSDAV, "current-user-principal", caldav.Href(caldavRoot)),
+		caldav.New(caldav.NSDAV, "owner", caldav.Href(caldavRoot)),
+	}
+}
+
+func collectionProps(c davCollection, seq int64) []caldav.Element {
+	privilege := func(name string) caldav.Element {
+		return caldav.New(caldav.NSDAV, "privilege", caldav.New(caldav.NSDAV, name))
+	}
+	report := func(space, name string) caldav.Element {
+		return caldav.New(caldav.NSDAV, "supported-report",
+			caldav.New(caldav.NSDAV, "report", caldav.New(space, name)))
+	}
+	comp := caldav.New(caldav.NSCalDAV, "comp")
+	comp.Attrs = []xml.Attr{{Name: xml.Name{Local: "name"}, Value: "VTODO"}}
+	token := caldavSyncPrefix + strconv.FormatInt(seq, 10)
+
+	return []caldav.Element{
+		caldav.New(caldav.NSDAV, "resourcetype",
+			caldav.New(caldav.NSDAV, "collection"), caldav.New(caldav.NSCalDAV, "calendar")),
+		caldav.NewText(caldav.NSDAV, "displayname", c.Name),
+		caldav.NewText(caldav.NSCalDAV, "calendar-description", c.Description),
+		caldav.New(caldav.NSCalDAV, "supported-calendar-component-set", comp),
+		caldav.NewText(caldav.NSCalendar, "getctag", token),
+		caldav.NewText(caldav.NSDAV, "sync-token", token),
+		caldav.New(caldav.NSDAV, "current-user-principal", caldav.Href(caldavRoot)),
+		caldav.New(caldav.NSDAV, "owner", caldav.Href(caldavRoot)),
+		caldav.New(caldav.NSDAV, "current-user-privilege-set",
+			privilege("read"), privilege("write"), privilege("write-content"),
+			privilege("bind"), privilege("unbind"), privilege("read-current-user-privilege-set")),
+		caldav.New(caldav.NSDAV, "supported-report-set",
+			report(caldav.NSCalDAV, "calendar-query"),
+			report(caldav.NSCalDAV, "calendar-multiget"),
+			report(caldav.NSDAV, "sync-collection")),
+	}
+}
+
+func objectProps(o davObject) []caldav.Element {
+	return []caldav.Element{
+		caldav.New(caldav.NSDAV, "resourcetype"),
+		caldav.NewText(caldav.NSDAV, "getetag", o.etag),
+		caldav.NewText(caldav.NSDAV, "getcontenttype", "text/calendar; charset=utf-8; component=VTODO"),
+		caldav.NewText(caldav.NSDAV, "getcontentlength", strconv.Itoa(len(o.data))),
+		caldav.NewText(caldav.NSDAV, "getlastmodified", o.task.modified().UTC().Format(http.TimeFormat)),
+		caldav.NewText(caldav.NSCalDAV, "calendar-data", string(o.data)),
+	}
+}
+
+func objectResponse(c davCollection, o davObject, req caldav.PropRequest) caldav.Response {
+	return caldav.NewResponse(o.href(c), req, objectProps(o), calendarDataName)
+}
+
+func collectionResponse(ctx context.Context, userID int, c davCollection, req caldav.PropRequest) (caldav.Response, error) {
+	seq, err := collectionSyncSeq(ctx, userID, c.ProjectID)
+	if err != nil {
+		return caldav.Response{}, err
+	}
+	return caldav.NewResponse(c.href(), req, collectionProps(c, seq)), nil
+}
+
+func caldavPropfind(w http.ResponseWriter, r *http.Request, userID int, username string, target davTarget) {
+	body, err := caldav.Decode(r.Body)
+	if err != nil || (body != nil && !body.Is(caldav.NSDAV, "propfind")) {
+		http.Error(w, "Invalid PROPFIND body", http.StatusBadRequest)
+		return
+	}
+	req := caldav.ParsePropRequest(body)
+	// Depth: infinity is treated like 1; the tree is only three levels deep.
+	members := r.Header.Get("Depth") != "0"
+
+	var ms caldav.Multistatus
+	switch target.kind {
+	case "principal":
+		ms.Responses = append(ms.Responses, caldav.NewResponse(caldavRoot, req, principalProps(username)))
+		if members {
+			ms.Responses = append(ms.Responses, caldav.NewResponse(caldavHome, req, homeProps()))
+		}
+
+	case "home":
+		ms.Responses = append(ms.Responses, caldav.NewResponse(caldavHome, req, homeProps()))
+		if members {
+			collections, err := loadDAVCollections(r.Context(), userID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "CalDAV collections error", "err", err)
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+			for _, c := range collections {
+				resp, err := collectionResponse(r.Context(), userID, c, req)
+				if err != nil {
+					slog.ErrorContext(r.Context(), "CalDAV collection error", "err", err)
+					http.Error(w, "Server error", http.StatusInternalServerError)
+					return
+				}
+				ms.Responses = append(ms.Responses, resp)
+			}
+		}
+
+	case "collection":
+		resp, err := collectionResponse(r.Context(), userID, target.collection, req)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "CalDAV collection error", "err", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		ms.Responses = append(ms.Responses, resp)
+		if members {
+			objects, err := loadDAVObjects(r.Context(), userID, target.collection.ProjectID, "")
+			if err != nil {
+				slog.ErrorContext(r.Context(), "CalDAV objects error", "err", err)
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+			for _, o := range objects {
+				ms.Responses = append(ms.Responses, objectResponse(target.collection, o, req))
+			}
+		}
+
+	case "object":
+		o, err := loadDAVObject(r.Context(), userID, target)
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			slog.ErrorContext(r.Context(), "CalDAV object error", "err", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		ms.Responses = append(ms.Responses, objectResponse(target.collection, o, req))
+	}
+
+	caldav.WriteMultistatus(w, ms)
+}
+
+// caldavProppatch refuses every change: names and descriptions are edited
+// in TaskLift itself. Clients still get a well-formed answer per property.
+func caldavProppatch(w http.ResponseWriter, r *http.Request) {
+	body, err := caldav.Decode(r.Body)
+	if err != nil || body == nil || !body.Is(caldav.NSDAV, "propertyupdate") {
+		http.Error(w, "Invalid PROPPATCH body", http.StatusBadRequest)
+		return
+	}
+
+	var names []caldav.Element
+	for _, update := range body.Children {
+		if prop := update.Child(caldav.NSDAV, "prop"); prop != nil {
+			for _, p := range prop.Children {
+				names = append(names, caldav.Element{XMLName: p.XMLName})
+			}
+		}
+	}
+	caldav.WriteMultistatus(w, caldav.Multistatus{Responses: []caldav.Response{{
+		Href: r.URL.Path,
+		Propstats: []caldav.Propstat{{
+			Prop:   caldav.Prop{Values: names},
+			Status: caldav.Status(http.StatusForbidden),
+		}},
+	}}})
+}
+
+func caldavReport(w http.ResponseWriter, r *http.Request, userID int, target davTarget) {
+	body, err := caldav.Decode(r.Body)
+	if err != nil || body == nil {
+		http.Error(w, "Invalid REPORT body", http.StatusBadRequest)
+		return
+	}
+	if target.kind != "collection" {
+		caldav.WriteError(w, http.StatusForbidden, caldav.New(caldav.NSDAV, "supported-report"))
+		return
+	}
+	req := caldav.ParsePropRequest(body)
+	collection := target.collection
+
+	var ms caldav.Multistatus
+	switch {
+	case body.Is(caldav.NSCalDAV, "calendar-query"):
+		filter, err := caldav.ParseFilter(body.Child(caldav.NSCalDAV, "filter"))
+		if err != nil {
+			caldav.WriteError(w, http.StatusForbidden, caldav.New(caldav.NSCalDAV, "valid-filter"))
+			return
+		}
+		objects, err := loadDAVObjects(r.Context(), userID, collection.ProjectID, "")
+		if err != nil {
+			slog.ErrorContext(r.Context(), "CalDAV query error", "err", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		for _, o := range objects {
+			if filter.Match(o.cal) {
+				ms.Responses = append(ms.Responses, objectResponse(collection, o, req))
+			}
+		}
+
+	case body.Is(caldav.NSCalDAV, "calendar-multiget"):
+		for _, child := range body.Children {
+			if !child.Is(caldav.NSDAV, "href") {
+				continue
+			}
+			href := child.Content()
+			u, err := url.Parse(href)
+			if err != nil {
+				ms.Responses = append(ms.Responses, caldav.NewStatusResponse(href, http.StatusNotFound))
+				continue
+			}
+			t, err := resolveDAV(r.Context(), userID, u.Path)
+			if err != nil || t.kind != "object" {
+				ms.Responses = append(ms.Responses, caldav.NewStatusResponse(href, http.StatusNotFound))
+				continue
+			}
+			o, err := loadDAVObject(r.Context(), userID, t)
+			if err != nil {
+				ms.Responses = append(ms.Responses, caldav.NewStatusResponse(href, http.StatusNotFound))
+				continue
+			}
+			ms.Responses = append(ms.Responses, objectResponse(t.collection, o, req))
+		}
+
+	case body.Is(caldav.NSDAV, "sync-collection"):
+		responses, token, err := syncCollection(r.Context(), userID, collection, body, req)
+		if err == errInvalidSyncToken {
+			caldav.WriteError(w, http.StatusForbidden, caldav.New(caldav.NSDAV, "valid-sync-token"))
+			return
+		} else if err != nil {
+			slog.ErrorContext(r.Context(), "CalDAV sync error", "err", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		ms.Responses = responses
+		ms.SyncToken = token
+
+	default:
+		caldav.WriteError(w, http.StatusForbidden, caldav.New(caldav.NSDAV, "supported-report"))
+		return
+	}
+
+	caldav.WriteMultistatus(w, ms)
+}
+
+var errInvalidSyncToken = fmt.Errorf("invalid sync token")
+
+// syncCollection answers an RFC 6578 sync-collection report. Without a
+// token every member is returned; otherwise only tasks logged in
+// task_changes since the token, with 404 entries for those that left the
+// collection.
+func syncCollection(ctx context.Context, userID int, c davCollection, body *caldav.Element, req caldav.PropRequest) ([]caldav.Response, string, error) {
+	var since int64 = -1
+	if el := body.Child(caldav.NSDAV, "sync-token"); el != nil && el.Content() != "" {
+		seq, err := strconv.ParseInt(strings.TrimPrefix(el.Content(), caldavSyncPrefix), 10, 64)
+		if err != nil || !strings.HasPrefix(el.Content(), caldavSyncPrefix) {
+			return nil, "", errInvalidSyncToken
+		}
+		since = seq
+	}
+
+	// Read the new token first so changes racing with this report are
+	// reported again next time rather than lost.
+	current, err := collectionSyncSeq(ctx, userID, c.ProjectID)
+	if err != nil {
+		return nil, "", err
+	}
+	token := caldavSyncPrefix + strconv.FormatInt(current, 10)
+
+	objects, err := loadDAVObjects(ctx, userID, c.ProjectID, "")
+	if err != nil {
+		return nil, "", err
+	}
+	var responses []caldav.Response
+	if since < 0 {
+		for _, o := range objects {
+			responses = append(responses, objectResponse(c, o, req))
+		}
+		return responses, token, nil
+	}
+
+	// The AUTOINCREMENT counter is the last seq handed out, even once
+	// pruning has emptied the log.
+	var latest int64
+	if err := DB.QueryRowContext(ctx, "SELECT COALESCE(MAX(seq), 0) FROM sqlite_sequence WHERE name = 'task_changes'").Scan(&latest); err != nil {
+		return nil, "", err
+	}
+	if since > latest {
+		return nil, "", errInvalidSyncToken
+	}
+	horizon, err := collectionSyncHorizon(ctx, userID, c.ProjectID)
+	if err != nil {
+		return nil, "", err
+	}
+	if since < horizon {
+		return nil, "", errInvalidSyncToken
+	}
+
+	rows, err := DB.QueryContext(ctx, `
+		SELECT DISTINCT name FROM task_changes
+		WHERE user_id = ? AND project_id = ? AND seq > ?`, userID, c.ProjectID, since)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	byName := make(map[string]davObject, len(objects))
+	for _, o := range objects {
+		byName[o.task.Name] = o
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, "", err
+		}
+		if o, ok := byName[name]; ok {
+			responses = append(responses, objectResponse(c, o, req))
+		} else {
+			responses = append(responses, caldav.NewStatusResponse(c.href()+url.PathEscape(name), http.StatusNotFound))
+		}
+	}
+	return responses, token, rows.Err()
+}
+
+func caldavGet(w http.ResponseWriter, r *http.Request, userID int, target davTarget) {
+	if target.kind != "object" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	o, err := loadDAVObject(r.Context(), userID, target)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		slog.ErrorContext(r.Context(), "CalDAV get error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("ETag", o.etag)
+	w.Header().Set("Last-Modified", o.task.modified().UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+	if r.Method == http.MethodGet {
+		w.Write(o.data)
+	}
+}
+
+// checkPreconditions applies If-Match and If-None-Match to a possibly
+// missing object.
+func checkPreconditions(r *http.Request, o davObject, exists bool) bool {
+	if inm := r.Header.Get("If-None-Match"); inm == "*" && exists {
+		return false
+	}
+	if im := r.Header.Get("If-Match"); im != "" {
+		return exists && (im == "*" || im == o.etag)
+	}
+	return true
+}
+
+// todoFields is a VTODO mapped onto task columns. DESCRIPTION is not
+// imported: tasks have no notes, and the server only puts the project name
+// there.
+type todoFields struct {
+	description string
+	priority    string
+	dueDate     string
+	done        bool
+	tags        []string
+}
+
+func parseTodo(todo *ical.Component) todoFields {
+	f := todoFields{description: "Untitled task", priority: "medium"}
+	if p := todo.Prop("SUMMARY"); p != nil && strings.TrimSpace(p.TextValue()) != "" {
+		f.description = strings.TrimSpace(p.TextValue())
+	}
+	if p := todo.Prop("PRIORITY"); p != nil {
+		switch n, _ := strconv.Atoi(p.Value); {
+		case n >= 1 && n <= 4:
+			f.priority = "high"
+		case n >= 6 && n <= 9:
+			f.priority = "low"
+		}
+	}
+	if p := todo.Prop("DUE"); p != nil {
+		if due, _, err := p.Time(time.Local); err == nil {
+			f.dueDate = due.In(time.Local).Format("2006-01-02")
+		}
+	}
+	if p := todo.Prop("STATUS"); p != nil {
+		f.done = strings.EqualFold(p.Value, "COMPLETED")
+	} else {
+		f.done = todo.Prop("COMPLETED") != nil
+	}
+
+	var categories []string
+	for _, p := range todo.Props {
+		if p.Name == "CATEGORIES" {
+			categories = append(categories, p.TextValues()...)
+		}
+	}
+	f.tags = normalizeTags(categories)
+	return f
+}
+
+func caldavPut(w http.ResponseWriter, r *http.Request, userID int, target davTarget) {
+	if target.kind != "object" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	cal, err := ical.Decode(bytes.NewReader(data))
+	if err != nil || cal.Name != "VCALENDAR" {
+		caldav.WriteError(w, http.StatusBadRequest, caldav.New(caldav.NSCalDAV, "valid-calendar-data"))
+		return
+	}
+	todo := cal.Child("VTODO")
+	if todo == nil {
+		caldav.WriteError(w, http.StatusForbidden, caldav.New(caldav.NSCalDAV, "supported-calendar-component"))
+		return
+	}
+	uidProp := todo.Prop("UID")
+	if uidProp == nil || uidProp.Value == "" {
+		caldav.WriteError(w, http.StatusBadRequest, caldav.New(caldav.NSCalDAV, "valid-calendar-object-resource"))
+		return
+	}
+	uid := uidProp.Value
+
+	existing, err := loadDAVObject(r.Context(), userID, target)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		slog.ErrorContext(r.Context(), "CalDAV put error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if !checkPreconditions(r, existing, exists) {
+		http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	// A UID may only live at one URL.
+	var otherID, otherProject int
+	var otherName string
+	err = DB.QueryRowContext(r.Context(), `
+		SELECT id, COALESCE(project_id, 0), COALESCE(ical_name, 'task-' || id || '.ics')
+		FROM tasks
+		WHERE user_id = ? AND COALESCE(ical_uid, 'task-' || id || '@tasklift') = ?`,
+		userID, uid).Scan(&otherID, &otherProject, &otherName)
+	if err == nil && (!exists || otherID != existing.task.ID) {
+		other := davCollection{ProjectID: otherProject}
+		caldav.WriteError(w, http.StatusForbidden, caldav.New(caldav.NSCalDAV, "no-uid-conflict",
+			caldav.Href(other.href()+url.PathEscape(otherName))))
+		return
+	}
+
+	fields := parseTodo(todo)
+	now := time.Now()
+
+	if exists {
+		taskID := existing.task.ID
+		_, err := DB.ExecContext(r.Context(), `
+			UPDATE tasks
+			SET description = ?, priority = ?, due_date = ?, done = ?, ical_uid = ?, updated_at = ?
+			WHERE id = ? AND user_id = ?`,
+			fields.description, fields.priority, fields.dueDate, fields.done, uid, now, taskID, userID)
+		if err == nil {
+			err = setTaskTags(r.Context(), int64(taskID), fields.tags)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "CalDAV update task error", "err", err)
+			http.Error(w, "Failed to update task", http.StatusInternalServerError)
+			return
+		}
+
+		if task, err := loadTask(r.Context(), userID, int64(taskID)); err == nil {
+			publish(r.Context(), userID, "task.updated", task)
+			if fields.done && !existing.task.Done {
+				publish(r.Context(), userID, "task.completed", task)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var projectID interface{}
+	if target.collection.ProjectID != 0 {
+		projectID = target.collection.ProjectID
+	}
+	res, err := DB.ExecContext(r.Context(), `
+		INSERT INTO tasks (user_id, project_id, description, priority, due_date, done, ical_uid, ical_name, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, projectID, fields.description, fields.priority, fields.dueDate, fields.done,
+		uid, target.name, now, now)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "CalDAV create task error", "err", err)
+		http.Error(w, "Failed to create task", http.StatusInternalServerError)
+		return
+	}
+	taskID, _ := res.LastInsertId()
+	if err := setTaskTags(r.Context(), taskID, fields.tags); err != nil {
+		slog.ErrorContext(r.Context(), "CalDAV set tags error", "err", err)
+	}
+	publishTask(r.Context(), userID, res, "task.created")
+
+	// No ETag: the stored representation differs from what was sent, so
+	// clients must fetch it again (RFC 4791 section 5.3.4).
+	w.WriteHeader(http.StatusCreated)
+}
+
+func caldavDelete(w http.ResponseWriter, r *http.Request, userID int, target davTarget) {
+	if target.kind != "object" {
+		http.Error(w, "Collections cannot be deleted over CalDAV", http.StatusForbidden)
+		return
+	}
+
+	o, err := loadDAVObject(r.Context(), userID, target)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		slog.ErrorContext(r.Context(), "CalDAV delete error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if !checkPreconditions(r, o, true) {
+		http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	res, err := DB.ExecContext(r.Context(), "DELETE FROM tasks WHERE id = ? AND user_id = ?", o.task.ID, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "CalDAV delete task error", "err", err)
+		http.Error(w, "Failed to delete task", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		DB.ExecContext(r.Context(), "DELETE FROM task_tags WHERE task_id = ?", o.task.ID)
+		unlinkTarget(r.Context(), DB, userID, "task", int64(o.task.ID))
+	}
+	publishDeleted(r.Context(), userID, res, "task.deleted", strconv.Itoa(o.task.ID))
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"task-manager/ical"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// davClient talks to the CalDAV handler over HTTP as a logged-in user.
+type davClient struct {
+	t      *testing.T
+	server *httptest.Server
+	userID int
+}
+
+func newDAVClient(t *testing.T) *davClient {
+	t.Helper()
+	openTestDB(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := mustExec(t, "INSERT INTO users (username, email, password) VALUES ('dave', 'dave@example.com', ?)", string(hash))
+	id, _ := res.LastInsertId()
+
+	server := httptest.NewServer(http.HandlerFunc(CalDAV))
+	t.Cleanup(server.Close)
+	return &davClient{t: t, server: server, userID: int(id)}
+}
+
+// do sends a request and returns the response with its body read.
+func (c *davClient) do(method, path, body string, header map[string]string) (*http.Response, string) {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.SetBasicAuth("dave", "secret")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.server.Client().Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+// multistatus sends a PROPFIND or REPORT and decodes the 207 response.
+func (c *davClient) multistatus(method, path, depth, body string) testMultistatus {
+	c.t.Helper()
+	resp, data := c.do(method, path, body, map[string]string{"Depth": depth, "Content-Type": "application/xml"})
+	if resp.StatusCode != http.StatusMultiStatus {
+		c.t.Fatalf("%s %s: status %d, body %s", method, path, resp.StatusCode, data)
+	}
+	var ms testMultistatus
+	if err := xml.Unmarshal([]byte(data), &ms); err != nil {
+		c.t.Fatalf("%s %s: %v in %s", method, path, err, data)
+	}
+	return ms
+}
+
+type testMultistatus struct {
+	SyncToken string         `xml:"DAV: sync-token"`
+	Responses []testResponse `xml:"DAV: response"`
+}
+
+type testResponse struct {
+	Href      string `xml:"DAV: href"`
+	Status    string `xml:"DAV: status"`
+	Propstats []struct {
+		Status string `xml:"DAV: status"`
+		Prop   struct {
+			DisplayName  string `xml:"DAV: displayname"`
+			ETag         string `xml:"DAV: getetag"`
+			SyncToken    string `xml:"DAV: sync-token"`
+			CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+		} `xml:"DAV: prop"`
+	} `xml:"DAV: propstat"`
+}
+
+// found returns the properties the server reported with 200.
+func (r testResponse) found() (displayName, etag, syncToken, calendarData string) {
+	for _, ps := range r.Propstats {
+		if strings.Contains(ps.Status, " 200 ") {
+			return ps.Prop.DisplayName, ps.Prop.ETag, ps.Prop.SyncToken, ps.Prop.CalendarData
+		}
+	}
+	return "", "", "", ""
+}
+
+// hrefs lists the response hrefs in order, each with its bare status if it
+// has one.
+func (ms testMultistatus) hrefs() []string {
+	var hrefs []string
+	for _, r := range ms.Responses {
+		if r.Status != "" {
+			hrefs = append(hrefs, r.Href+" "+r.Status)
+		} else {
+			hrefs = append(hrefs, r.Href)
+		}
+	}
+	sort.Strings(hrefs)
+	return hrefs
+}
+
+func (c *davClient) createTask(projectID interface{}, description, dueDate string, done bool) int {
+	c.t.Helper()
+	res := mustExec(c.t, "INSERT INTO tasks (user_id, project_id, description, due_date, done) VALUES (?, ?, ?, ?, ?)",
+		c.userID, projectID, description, dueDate, done)
+	id, _ := res.LastInsertId()
+	return int(id)
+}
+
+const todoData = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Test//EN\r\n" +
+	"BEGIN:VTODO\r\n" +
+	"UID:%s\r\n" +
+	"SUMMARY:%s\r\n" +
+	"DUE;VALUE=DATE:20261105\r\n" +
+	"PRIORITY:1\r\n" +
+	"CATEGORIES:home,errands\r\n" +
+	"STATUS:NEEDS-ACTION\r\n" +
+	"END:VTODO\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestCalDAVPropfindListsCollections(t *testing.T) {
+	c := newDAVClient(t)
+	res := mustExec(t, "INSERT INTO projects (user_id, name) VALUES (?, 'Garden')", c.userID)
+	projectID, _ := res.LastInsertId()
+	mustExec(t, "INSERT INTO projects (user_id, name) VALUES (?, 'Not mine')", c.userID+1)
+
+	ms := c.multistatus("PROPFIND", caldavHome, "1", `<?xml version="1.0"?>
+		<d:propfind xmlns:d="DAV:"><d:prop><d:displayname/><d:sync-token/></d:prop></d:propfind>`)
+
+	names := map[string]string{}
+	for _, r := range ms.Responses {
+		name, _, _, _ := r.found()
+		names[r.Href] = name
+	}
+	want := map[string]string{
+		caldavHome:            "Calendars",
+		caldavHome + "inbox/": "Inbox",
+		fmt.Sprintf("%sproject-%d/", caldavHome, projectID): "Garden",
+	}
+	if len(names) != len(want) {
+		t.Fatalf("PROPFIND returned %v, want %v", names, want)
+	}
+	for href, name := range want {
+		if names[href] != name {
+			t.Errorf("%s: displayname %q, want %q", href, names[href], name)
+		}
+	}
+}
+
+func TestCalDAVCalendarQueryFilters(t *testing.T) {
+	c := newDAVClient(t)
+	open := c.createTask(nil, "Buy seeds", "2026-11-05", false)
+	c.createTask(nil, "Paint fence", "2026-11-06", true)
+	c.createTask(nil, "Plan spring", "2027-03-01", false)
+
+	query := `<?xml version="1.0"?>
+		<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+			<d:prop><d:getetag/></d:prop>
+			<c:filter>
+				<c:comp-filter name="VCALENDAR">
+					<c:comp-filter name="VTODO">
+						<c:time-range start="20261101T000000Z" end="20261201T000000Z"/>
+						<c:prop-filter name="STATUS">
+							<c:text-match negate-condition="yes">COMPLETED</c:text-match>
+						</c:prop-filter>
+					</c:comp-filter>
+				</c:comp-filter>
+			</c:filter>
+		</c:calendar-query>`
+	ms := c.multistatus("REPORT", caldavHome+"inbox/", "1", query)
+
+	want := []string{fmt.Sprintf("%sinbox/task-%d.ics", caldavHome, open)}
+	if got := ms.hrefs(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("calendar-query matched %q, want %q", got, want)
+	}
+
+	resp, data := c.do("REPORT", caldavHome+"inbox/", `<?xml version="1.0"?>
+		<c:calendar-query xmlns:c="urn:ietf:params:xml:ns:caldav"><c:filter/></c:calendar-query>`, nil)
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(data, "valid-filter") {
+		t.Errorf("empty filter: status %d, body %s; want 403 valid-filter", resp.StatusCode, data)
+	}
+}
+
+func TestCalDAVPutThenGetRoundTrips(t *testing.T) {
+	c := newDAVClient(t)
+	path := caldavHome + "inbox/seeds.ics"
+
+	resp, data := c.do(http.MethodPut, path, fmt.Sprintf(todoData, "seeds@test", `Buy seeds\, bulbs`),
+		map[string]string{"If-None-Match": "*", "Content-Type": "text/calendar"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT: status %d, body %s", resp.StatusCode, data)
+	}
+
+	resp, data = c.do(http.MethodGet, path, "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET: status %d, body %s", resp.StatusCode, data)
+	}
+	etag := resp.Header.Get("ETag")
+	cal, err := ical.Decode(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("GET returned invalid iCalendar: %v\n%s", err, data)
+	}
+	todo := cal.Child("VTODO")
+	if todo == nil {
+		t.Fatalf("GET returned no VTODO:\n%s", data)
+	}
+	checks := []struct{ prop, want string }{
+		{"UID", "seeds@test"},
+		{"SUMMARY", "Buy seeds, bulbs"},
+		{"DUE", "20261105"},
+		{"PRIORITY", "1"},
+		{"STATUS", "NEEDS-ACTION"},
+	}
+	for _, check := range checks {
+		p := todo.Prop(check.prop)
+		if p == nil {
+			t.Errorf("%s missing", check.prop)
+		} else if got := p.TextValue(); got != check.want {
+			t.Errorf("%s = %q, want %q", check.prop, got, check.want)
+		}
+	}
+	if p := todo.Prop("CATEGORIES"); p == nil || strings.Join(p.TextValues(), ",") != "errands,home" {
+		t.Errorf("CATEGORIES = %v, want errands,home", p)
+	}
+
+	// Updates must name the version they replace.
+	update := fmt.Sprintf(todoData, "seeds@test", "Buy more seeds")
+	resp, _ = c.do(http.MethodPut, path, update, map[string]string{"If-Match": `"stale"`})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a stale ETag: status %d, want 412", resp.StatusCode)
+	}
+	resp, data = c.do(http.MethodPut, path, update, map[string]string{"If-Match": etag})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT with the current ETag: status %d, body %s", resp.StatusCode, data)
+	}
+	_, data = c.do(http.MethodGet, path, "", nil)
+	if cal, err := ical.Decode(strings.NewReader(data)); err != nil || cal.Child("VTODO").Prop("SUMMARY").TextValue() != "Buy more seeds" {
+		t.Errorf("GET after update returned:\n%s", data)
+	}
+
+	// The UID cannot be used again at another URL.
+	resp, data = c.do(http.MethodPut, caldavHome+"inbox/copy.ics", update, nil)
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(data, "no-uid-conflict") {
+		t.Errorf("PUT of a duplicate UID: status %d, body %s; want 403 no-uid-conflict", resp.StatusCode, data)
+	}
+}
+
+func syncReport(token string) string {
+	return fmt.Sprintf(`<?xml version="1.0"?>
+		<d:sync-collection xmlns:d="DAV:">
+			<d:sync-token>%s</d:sync-token>
+			<d:sync-level>1</d:sync-level>
+			<d:prop><d:getetag/></d:prop>
+		</d:sync-collection>`, token)
+}
+
+func TestCalDAVSyncCollectionTokens(t *testing.T) {
+	c := newDAVClient(t)
+	kept := c.createTask(nil, "Buy seeds", "2026-11-05", false)
+	removed := c.createTask(nil, "Paint fence", "", false)
+	untouched := c.createTask(nil, "Sweep path", "", false)
+	inbox := caldavHome + "inbox/"
+	href := func(id int) string { return fmt.Sprintf("%stask-%d.ics", inbox, id) }
+
+	initial := c.multistatus("REPORT", inbox, "", syncReport(""))
+	if got := initial.hrefs(); len(got) != 3 {
+		t.Fatalf("initial sync returned %q, want all three tasks", got)
+	}
+	if initial.SyncToken == "" {
+		t.Fatal("initial sync returned no token")
+	}
+
+	// Changes a client never sees leave the log and the ETag alone.
+	etagBefore := syncETag(t, initial, href(untouched))
+	mustExec(t, "UPDATE tasks SET reminder_lead_minutes = 30, updated_at = CURRENT_TIMESTAMP WHERE id = ?", untouched)
+	quiet := c.multistatus("REPORT", inbox, "", syncReport(initial.SyncToken))
+	if len(quiet.Responses) != 0 || quiet.SyncToken != initial.SyncToken {
+		t.Errorf("sync after an invisible change returned %q with token %s", quiet.hrefs(), quiet.SyncToken)
+	}
+	again := c.multistatus("REPORT", inbox, "", syncReport(""))
+	if etag := syncETag(t, again, href(untouched)); etag != etagBefore {
+		t.Errorf("ETag changed from %s to %s without a visible change", etagBefore, etag)
+	}
+
+	mustExec(t, "UPDATE tasks SET done = 1 WHERE id = ?", kept)
+	resp, data := c.do(http.MethodDelete, href(removed), "", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: status %d, body %s", resp.StatusCode, data)
+	}
+
+	changes := c.multistatus("REPORT", inbox, "", syncReport(initial.SyncToken))
+	want := []string{href(kept), href(removed) + " HTTP/1.1 404 Not Found"}
+	sort.Strings(want)
+	if got := changes.hrefs(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("sync since the initial token returned %q, want %q", got, want)
+	}
+	if changes.SyncToken == initial.SyncToken {
+		t.Error("sync token did not move after changes")
+	}
+
+	caughtUp := c.multistatus("REPORT", inbox, "", syncReport(changes.SyncToken))
+	if len(caughtUp.Responses) != 0 {
+		t.Errorf("sync with the latest token returned %q, want nothing", caughtUp.hrefs())
+	}
+
+	resp, data = c.do("REPORT", inbox, syncReport(caldavSyncPrefix+"999999"), nil)
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(data, "valid-sync-token") {
+		t.Errorf("token from the future: status %d, body %s; want 403 valid-sync-token", resp.StatusCode, data)
+	}
+}
+
+func TestCalDAVSyncTokenExpiresWithPrunedChanges(t *testing.T) {
+	c := newDAVClient(t)
+	task := c.createTask(nil, "Buy seeds", "", false)
+	inbox := caldavHome + "inbox/"
+
+	old := c.multistatus("REPORT", inbox, "", syncReport(""))
+	mustExec(t, "UPDATE tasks SET description = 'Buy bulbs' WHERE id = ?", task)
+	latest := c.multistatus("REPORT", inbox, "", syncReport(""))
+
+	mustExec(t, "UPDATE task_changes SET changed_at = datetime('now', '-60 days')")
+	PruneTaskChanges(t.Context())
+	if n := queryCount(t, "SELECT COUNT(*) FROM task_changes"); n != 0 {
+		t.Fatalf("%d changes left after pruning", n)
+	}
+
+	resp, data := c.do("REPORT", inbox, syncReport(old.SyncToken), nil)
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(data, "valid-sync-token") {
+		t.Errorf("pruned token: status %d, body %s; want 403 valid-sync-token", resp.StatusCode, data)
+	}
+
+	// A client that was up to date keeps syncing, and new clients are
+	// handed a token that is still honoured.
+	current := c.multistatus("REPORT", inbox, "", syncReport(latest.SyncToken))
+	if len(current.Responses) != 0 {
+		t.Errorf("sync with the latest token returned %q, want nothing", current.hrefs())
+	}
+	fresh := c.multistatus("REPORT", inbox, "", syncReport(""))
+	if fresh.SyncToken != latest.SyncToken {
+		t.Errorf("token after pruning is %s, want %s", fresh.SyncToken, latest.SyncToken)
+	}
+	c.multistatus("REPORT", inbox, "", syncReport(fresh.SyncToken))
+}
+
+func TestCalDAVDeleteBreaksLinks(t *testing.T) {
+	c := newDAVClient(t)
+	task := c.createTask(nil, "Buy seeds", "", false)
+	res := mustExec(t, "INSERT INTO notes (user_id, title, content) VALUES (?, 'Garden', ?)", c.userID, fmt.Sprintf("See #task-%d", task))
+	noteID, _ := res.LastInsertId()
+	if err := updateNoteLinks(t.Context(), DB, c.userID, noteID, fmt.Sprintf("See #task-%d", task)); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, data := c.do(http.MethodDelete, fmt.Sprintf("%sinbox/task-%d.ics", caldavHome, task), "", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: status %d, body %s", resp.StatusCode, data)
+	}
+	if n := queryCount(t, "SELECT COUNT(*) FROM note_links WHERE note_id = ? AND target_id IS NULL", noteID); n != 1 {
+		t.Errorf("%d broken links after deleting the task, want 1", n)
+	}
+}
+
+// syncETag returns the ETag a sync report gave for href.
+func syncETag(t *testing.T, ms testMultistatus, href string) string {
+	t.Helper()
+	for _, r := range ms.Responses {
+		if r.Href == href {
+			_, etag, _, _ := r.found()
+			return etag
+		}
+	}
+	t.Fatalf("%s not in sync report", href)
+	return ""
+}
@@ -0,0 +1,473 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"task-manager/ical"
+	"task-manager/models"
+	"time"
+)
+
+func projectUID(projectID int) string {
+	return fmt.Sprintf("project-%d@tasklift", projectID)
+}
+
+// icalPriority maps TaskLift priorities onto RFC 5545 PRIORITY values.
+func icalPriority(priority string) string {
+	switch priority {
+	case "high":
+		return "1"
+	case "low":
+		return "9"
+	default:
+		return "5"
+	}
+}
+
+// feedFilterKeys are the query parameters that filter a feed.
+var feedFilterKeys = []string{"project", "tag", "open", "events"}
+
+// calendarFeedURL builds the subscription URL for a feed token, with the
+// feed's filters encoded in the query string.
+func calendarFeedURL(token string, filters url.Values) string {
+	u := BaseURL + "/calendar/" + token + ".ics"
+	if len(filters) > 0 {
+		u += "?" + filters.Encode()
+	}
+	return u
+}
+
+// feedFiltersMatch reports whether the filters in a feed request's query
+// agree with the stored ones. A query without filters always matches.
+func feedFiltersMatch(query, stored url.Values) bool {
+	given := false
+	for _, k := range feedFilterKeys {
+		given = given || query.Has(k)
+	}
+	if !given {
+		return true
+	}
+	for _, k := range feedFilterKeys {
+		if query.Has(k) != stored.Has(k) || query.Get(k) != stored.Get(k) {
+			return false
+		}
+	}
+	return true
+}
+
+// feedFilterMap flattens stored feed filters for the JSON listing.
+func feedFilterMap(filters url.Values) map[string]string {
+	if len(filters) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(filters))
+	for k := range filters {
+		m[k] = filters.Get(k)
+	}
+	return m
+}
+
+// calendarFilters picks the supported feed filters out of a form.
+func calendarFilters(r *http.Request) (url.Values, error) {
+	filters := url.Values{}
+	if v := r.FormValue("project"); v != "" {
+		if _, err := strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid project")
+		}
+		filters.Set("project", v)
+	}
+	if v := normalizeTags([]string{r.FormValue("tag")}); len(v) == 1 {
+		filters.Set("tag", v[0])
+	}
+	if v := r.FormValue("open"); v == "1" || v == "true" || v == "on" {
+		filters.Set("open", "1")
+	}
+	if v := r.FormValue("events"); v == "1" || v == "true" || v == "on" {
+		filters.Set("events", "1")
+	}
+	return filters, nil
+}
+
+// CalendarFeeds lists the current user's feeds (GET) or creates one (POST).
+// Optional form values project, tag, open and events become the feed's
+// filters.
+func CalendarFeeds(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := DB.QueryContext(r.Context(), `
+			SELECT id, COALESCE(name, ''), token, COALESCE(filters, ''), created_at, revoked_at IS NOT NULL
+			FROM calendar_feeds
+			WHERE user_id = ?
+			ORDER BY created_at DESC`, userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "List calendar feeds error", "err", err)
+			http.Error(w, "Failed to retrieve calendar feeds", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		feeds := make([]models.CalendarFeed, 0)
+		for rows.Next() {
+			var feed models.CalendarFeed
+			var token, encoded string
+			if err := rows.Scan(&feed.ID, &feed.Name, &token, &encoded, &feed.CreatedAt, &feed.Revoked); err != nil {
+				slog.ErrorContext(r.Context(), "Scan error", "err", err)
+				continue
+			}
+			filters, _ := url.ParseQuery(encoded)
+			if !feed.Revoked {
+				feed.URL = calendarFeedURL(token, filters)
+			}
+			feed.Filters = feedFilterMap(filters)
+			feeds = append(feeds, feed)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(feeds)
+
+	case http.MethodPost:
+		filters, err := calendarFilters(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := r.FormValue("name")
+		if name == "" {
+			name = "TaskLift"
+		}
+
+		token := randomHex(20)
+		now := time.Now()
+		res, err := DB.ExecContext(r.Context(), "INSERT INTO calendar_feeds (user_id, token, name, filters, created_at) VALUES (?, ?, ?, ?, ?)",
+			userID, token, name, filters.Encode(), now)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Create calendar feed error", "err", err)
+			http.Error(w, "Failed to create calendar feed", http.StatusInternalServerError)
+			return
+		}
+		id, _ := res.LastInsertId()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(models.CalendarFeed{
+			ID:        int(id),
+			Name:      name,
+			URL:       calendarFeedURL(token, filters),
+			Filters:   feedFilterMap(filters),
+			CreatedAt: now.Format(time.RFC3339Nano),
+		})
+
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// RevokeCalendarFeed permanently disables a feed URL.
+func RevokeCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := r.FormValue("id")
+	if id == "" {
+		http.Error(w, "Feed ID required", http.StatusBadRequest)
+		return
+	}
+
+	res, err := DB.ExecContext(r.Context(), "UPDATE calendar_feeds SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		time.Now(), id, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Revoke calendar feed error", "err", err)
+		http.Error(w, "Failed to revoke calendar feed", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Feed not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "revoked"})
+}
+
+// CalendarFeed serves /calendar/<token>.ics with the filters stored for the
+// feed. The secret token replaces the session cookie, since calendar apps
+// fetch the feed on their own. The feed URL repeats the filters in its query
+// string; a request whose filters differ from the stored ones gets 400
+// rather than a calendar the subscriber did not ask for, and one without
+// filters gets the stored ones. Feeds created before filters were stored
+// take them from the query string.
+func CalendarFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/calendar/"), ".ics")
+	var userID int
+	var name string
+	var filters sql.NullString
+	err := DB.QueryRowContext(r.Context(), "SELECT user_id, COALESCE(name, ''), filters FROM calendar_feeds WHERE token = ? AND revoked_at IS NULL",
+		token).Scan(&userID, &name, &filters)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	q := r.URL.Query()
+	if filters.Valid {
+		stored, _ := url.ParseQuery(filters.String)
+		if !feedFiltersMatch(q, stored) {
+			http.Error(w, "Feed filters in the URL do not match the feed", http.StatusBadRequest)
+			return
+		}
+		q = stored
+	}
+	projectFilter, _ := strconv.Atoi(q.Get("project"))
+	tagFilter := q.Get("tag")
+	openOnly := q.Get("open") == "1"
+	withEvents := q.Get("events") == "1"
+
+	cal := ical.NewComponent("VCALENDAR")
+	cal.Add("VERSION", "2.0", nil)
+	cal.Add("PRODID", "-//TaskLift//Task Feed//EN", nil)
+	cal.Add("CALSCALE", "GREGORIAN", nil)
+	cal.Add("METHOD", "PUBLISH", nil)
+	cal.AddText("X-WR-CALNAME", name)
+	cal.Add("REFRESH-INTERVAL", "PT1H", map[string]string{"VALUE": "DURATION"})
+	cal.Add("X-PUBLISHED-TTL", "PT1H", nil)
+
+	if err := addFeedTasks(r.Context(), cal, userID, projectFilter, tagFilter, openOnly, withEvents); err != nil {
+		slog.ErrorContext(r.Context(), "Calendar feed tasks error", "err", err)
+		http.Error(w, "Failed to build calendar", http.StatusInternalServerError)
+		return
+	}
+	if tagFilter == "" {
+		if err := addFeedProjects(r.Context(), cal, userID, projectFilter, openOnly); err != nil {
+			slog.ErrorContext(r.Context(), "Calendar feed projects error", "err", err)
+			http.Error(w, "Failed to build calendar", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="tasklift.ics"`)
+	ical.Encode(w, cal)
+}
+
+// feedDate returns the calendar day of a due date, ignoring any time part.
+func feedDate(s string) (time.Time, error) {
+	if len(s) > 10 {
+		s = s[:10]
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// calendarTask is a task as published over iCalendar and CalDAV.
+type calendarTask struct {
+	ID          int
+	UID         string
+	Name        string
+	Description string
+	Priority    string
+	Done        bool
+	DueDate     string
+	CreatedAt   sql.NullTime
+	UpdatedAt   sql.NullTime
+	ProjectName string
+	Tags        []string
+}
+
+// calendarTaskSelect falls back to UIDs and resource names derived from the
+// task ID for tasks that were not created by a CalDAV client.
+const calendarTaskSelect = `
+	SELECT t.id, COALESCE(t.ical_uid, 'task-' || t.id || '@tasklift'),
+	       COALESCE(t.ical_name, 'task-' || t.id || '.ics'),
+	       t.description, COALESCE(t.priority, 'medium'), t.done, COALESCE(t.due_date, ''),
+	       t.created_at, t.updated_at, COALESCE(p.name, ''),
+	       (SELECT COALESCE(GROUP_CONCAT(tag, ','), '') FROM task_tags WHERE task_id = t.id)
+	FROM tasks t
+	LEFT JOIN projects p ON p.id = t.project_id`
+
+func scanCalendarTask(row rowScanner) (calendarTask, error) {
+	var task calendarTask
+	var tags string
+	err := row.Scan(&task.ID, &task.UID, &task.Name, &task.Description, &task.Priority,
+		&task.Done, &task.DueDate, &task.CreatedAt, &task.UpdatedAt, &task.ProjectName, &tags)
+	task.Tags = splitTags(tags)
+	return task, err
+}
+
+// modified is the task's last change, which doubles as its DTSTAMP so that
+// rendering an unchanged task always yields the same bytes.
+func (t calendarTask) modified() time.Time {
+	switch {
+	case t.UpdatedAt.Valid:
+		return t.UpdatedAt.Time
+	case t.CreatedAt.Valid:
+		return t.CreatedAt.Time
+	}
+	return time.Unix(0, 0)
+}
+
+func (t calendarTask) todo() *ical.Component {
+	modified := t.modified()
+	todo := ical.NewComponent("VTODO")
+	todo.Add("UID", t.UID, nil)
+	todo.AddDateTime("DTSTAMP", modified)
+	if t.CreatedAt.Valid {
+		todo.AddDateTime("CREATED", t.CreatedAt.Time)
+	}
+	todo.AddDateTime("LAST-MODIFIED", modified)
+	todo.AddText("SUMMARY", t.Description)
+	if due, err := feedDate(t.DueDate); err == nil {
+		todo.AddDate("DUE", due)
+	}
+	todo.Add("PRIORITY", icalPriority(t.Priority), nil)
+	if t.Done {
+		todo.Add("STATUS", "COMPLETED", nil)
+		todo.Add("PERCENT-COMPLETE", "100", nil)
+	} else {
+		todo.Add("STATUS", "NEEDS-ACTION", nil)
+	}
+	if t.ProjectName != "" {
+		todo.AddText("DESCRIPTION", "Project: "+t.ProjectName)
+	}
+	if len(t.Tags) > 0 {
+		escaped := make([]string, len(t.Tags))
+		for i, tag := range t.Tags {
+			escaped[i] = ical.EscapeText(tag)
+		}
+		todo.Add("CATEGORIES", strings.Join(escaped, ","), nil)
+	}
+	return todo
+}
+
+func addFeedTasks(ctx context.Context, cal *ical.Component, userID, projectFilter int, tagFilter string, openOnly, withEvents bool) error {
+	query := calendarTaskSelect + `
+		WHERE t.user_id = ? AND t.due_date IS NOT NULL AND t.due_date != ''`
+	args := []interface{}{userID}
+	if projectFilter != 0 {
+		query += " AND t.project_id = ?"
+		args = append(args, projectFilter)
+	}
+	if tagFilter != "" {
+		query += " AND EXISTS (SELECT 1 FROM task_tags WHERE task_id = t.id AND tag = ?)"
+		args = append(args, tagFilter)
+	}
+	if openOnly {
+		query += " AND t.done = 0"
+	}
+	query += " ORDER BY t.due_date, t.id"
+
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		task, err := scanCalendarTask(rows)
+		if err != nil {
+			return err
+		}
+		due, err := feedDate(task.DueDate)
+		if err != nil {
+			continue
+		}
+		cal.AddChild(task.todo())
+
+		// Many calendar apps ignore VTODO, so an all-day event can mirror it.
+		if withEvents {
+			event := ical.NewComponent("VEVENT")
+			event.Add("UID", fmt.Sprintf("task-%d-due@tasklift", task.ID), nil)
+			event.AddDateTime("DTSTAMP", task.modified())
+			event.AddDate("DTSTART", due)
+			event.AddDate("DTEND", due.AddDate(0, 0, 1))
+			summary := task.Description
+			if task.Done {
+				summary = "✓ " + summary
+			}
+			event.AddText("SUMMARY", summary)
+			event.Add("TRANSP", "TRANSPARENT", nil)
+			cal.AddChild(event)
+		}
+	}
+	return rows.Err()
+}
+
+func addFeedProjects(ctx context.Context, cal *ical.Component, userID, projectFilter int, openOnly bool) error {
+	query := `
+		SELECT id, name, COALESCE(description, ''), COALESCE(status, 'active'), COALESCE(due_date, ''), updated_at
+		FROM projects
+		WHERE user_id = ? AND due_date IS NOT NULL AND due_date != ''`
+	args := []interface{}{userID}
+	if projectFilter != 0 {
+		query += " AND id = ?"
+		args = append(args, projectFilter)
+	}
+	if openOnly {
+		query += " AND status NOT IN ('completed', 'cancelled')"
+	}
+
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var name, description, status, dueDate string
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&id, &name, &description, &status, &dueDate, &updatedAt); err != nil {
+			return err
+		}
+
+		due, err := feedDate(dueDate)
+		if err != nil {
+			continue
+		}
+		modified := time.Now()
+		if updatedAt.Valid {
+			modified = updatedAt.Time
+		}
+
+		event := ical.NewComponent("VEVENT")
+		event.Add("UID", projectUID(id), nil)
+		event.AddDateTime("DTSTAMP", modified)
+		event.AddDateTime("LAST-MODIFIED", modified)
+		event.AddDate("DTSTART", due)
+		event.AddDate("DTEND", due.AddDate(0, 0, 1))
+		event.AddText("SUMMARY", "Project due: "+name)
+		if description != "" {
+			event.AddText("DESCRIPTION", description)
+		}
+		if status == "cancelled" {
+			event.Add("STATUS", "CANCELLED", nil)
+		}
+		event.Add("TRANSP", "TRANSPARENT", nil)
+		cal.AddChild(event)
+	}
+	return rows.Err()
+}
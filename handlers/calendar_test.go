@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCalendarFeedChecksURLFilters(t *testing.T) {
+	openTestDB(t)
+	userID := createTestUser(t, "fran", "fran@example.com")
+	mustExec(t, "INSERT INTO tasks (user_id, description, due_date) VALUES (?, 'Tagged', '2026-11-02')", userID)
+	mustExec(t, "INSERT INTO task_tags (task_id, tag) VALUES (last_insert_rowid(), 'work')")
+	mustExec(t, "INSERT INTO tasks (user_id, description, due_date) VALUES (?, 'Untagged', '2026-11-03')", userID)
+	mustExec(t, "INSERT INTO calendar_feeds (user_id, token, name, filters, created_at) VALUES (?, 'tok', 'Work', 'tag=work', CURRENT_TIMESTAMP)", userID)
+
+	feedURL := calendarFeedURL("tok", url.Values{"tag": {"work"}})
+	if !strings.HasSuffix(feedURL, "/calendar/tok.ics?tag=work") {
+		t.Fatalf("feed URL %q does not carry its filters", feedURL)
+	}
+
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{"?tag=work", http.StatusOK},
+		{"", http.StatusOK},
+		{"?tag=home", http.StatusBadRequest},
+		{"?tag=work&open=1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		CalendarFeed(rec, httptest.NewRequest(http.MethodGet, "/calendar/tok.ics"+tt.query, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%q: status %d, want %d", tt.query, rec.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		body := rec.Body.String()
+		if !strings.Contains(body, "SUMMARY:Tagged") || strings.Contains(body, "Untagged") {
+			t.Errorf("%q: feed ignores the stored tag filter:\n%s", tt.query, body)
+		}
+	}
+}
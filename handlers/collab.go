@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"task-manager/collab"
+	"task-manager/events"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// The session cookie authenticates the socket, so only accept
+	// connections opened by our own pages.
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == r.Host
+	},
+}
+
+type collabAccess struct{}
+
+func (collabAccess) CanJoin(ctx context.Context, userID, projectID int) bool {
+	var n int
+	DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM projects WHERE id = ? AND user_id = ?", projectID, userID).Scan(&n)
+	return n > 0
+}
+
+func (collabAccess) CanLock(ctx context.Context, userID int, resource string, id int) bool {
+	table := "tasks"
+	if resource == "note" {
+		table = "notes"
+	}
+	var n int
+	DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" WHERE id = ? AND user_id = ?", id, userID).Scan(&n)
+	return n > 0
+}
+
+var hub = collab.NewHub(collabAccess{})
+
+// StartCollaboration forwards change events to WebSocket rooms and starts
+// expiring editing locks.
+func StartCollaboration() {
+	Events.Subscribe(func(_ context.Context, e events.Event) { hub.Publish(e) })
+	hub.Start()
+}
+
+// StopCollaboration disconnects all WebSocket clients.
+func StopCollaboration() {
+	hub.Close()
+}
+
+// CollabSocket upgrades to the collaboration WebSocket. See collab.Hub for
+// the message protocol.
+func CollabSocket(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var username string
+	if err := DB.QueryRowContext(r.Context(), "SELECT username FROM users WHERE id = ?", userID).Scan(&username); err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "WebSocket upgrade error", "err", err)
+		return
+	}
+
+	hub.Serve(r.Context(), conn, userID, username)
+}
@@ -0,0 +1,959 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"task-manager/models"
+	"time"
+)
+
+// Exportable columns per record type, in default export order. Everything
+// except id and created_at can also be imported.
+var csvColumns = map[string][]string{
+	"tasks":    {"id", "external_id", "description", "project", "priority", "done", "due_date", "tags", "created_at"},
+	"projects": {"id", "external_id", "name", "description", "status", "progress", "due_date", "team_members", "created_at"},
+}
+
+// csvAliases are header spellings recognised when suggesting a mapping,
+// besides the field names themselves.
+var csvAliases = map[string]map[string][]string{
+	"tasks": {
+		"external_id": {"ext_id", "reference", "key"},
+		"description": {"title", "task", "task_name", "name", "summary"},
+		"project":     {"project_name", "list"},
+		"done":        {"completed", "complete", "status"},
+		"due_date":    {"due", "deadline", "due_on"},
+		"tags":        {"labels", "categories"},
+	},
+	"projects": {
+		"external_id":  {"ext_id", "reference", "key"},
+		"name":         {"project", "project_name", "title"},
+		"description":  {"notes", "summary"},
+		"due_date":     {"due", "deadline"},
+		"team_members": {"members", "team"},
+	},
+}
+
+func csvImportFields(kind string) []string {
+	var fields []string
+	for _, f := range csvColumns[kind] {
+		if f != "id" && f != "created_at" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func csvType(r *http.Request) (string, error) {
+	kind := r.FormValue("type")
+	if kind == "" {
+		kind = "tasks"
+	}
+	if _, ok := csvColumns[kind]; !ok {
+		return "", fmt.Errorf("type must be tasks or projects")
+	}
+	return kind, nil
+}
+
+// ExportCSV downloads tasks or projects as CSV. columns picks and orders the
+// columns; tasks can be filtered by project (an ID or "none"), done,
+// priority, tag, due_from and due_to, projects by status. Values that a
+// spreadsheet would run as a formula start with an apostrophe.
+func ExportCSV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	kind, err := csvType(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	columns := csvColumns[kind]
+	if v := r.FormValue("columns"); v != "" {
+		columns = nil
+		for _, c := range strings.Split(v, ",") {
+			c = strings.TrimSpace(c)
+			if !containsString(csvColumns[kind], c) {
+				http.Error(w, fmt.Sprintf("Unknown column %q", c), http.StatusBadRequest)
+				return
+			}
+			columns = append(columns, c)
+		}
+	}
+
+	var records [][]string
+	if kind == "tasks" {
+		records, err = exportTaskRecords(r, userID, columns)
+	} else {
+		records, err = exportProjectRecords(r, userID, columns)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "CSV export error", "err", err)
+		http.Error(w, "Failed to export", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.csv", kind, time.Now().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write(columns)
+	cw.WriteAll(records)
+}
+
+func exportTaskRecords(r *http.Request, userID int, columns []string) ([][]string, error) {
+	query := taskSelect + " WHERE t.user_id = ?"
+	args := []interface{}{userID}
+	switch project := r.FormValue("project"); project {
+	case "":
+	case "none":
+		query += " AND t.project_id IS NULL"
+	default:
+		query += " AND t.project_id = ?"
+		args = append(args, project)
+	}
+	if v := r.FormValue("done"); v != "" {
+		done, err := strconv.ParseBool(v)
+		if err == nil {
+			query += " AND t.done = ?"
+			args = append(args, done)
+		}
+	}
+	if v := r.FormValue("priority"); v != "" {
+		query += " AND t.priority = ?"
+		args = append(args, v)
+	}
+	if v := r.FormValue("tag"); v != "" {
+		query += " AND EXISTS (SELECT 1 FROM task_tags WHERE task_id = t.id AND tag = ?)"
+		args = append(args, strings.ToLower(v))
+	}
+	if v := r.FormValue("due_from"); v != "" {
+		query += " AND t.due_date != '' AND t.due_date >= ?"
+		args = append(args, v)
+	}
+	if v := r.FormValue("due_to"); v != "" {
+		query += " AND t.due_date != '' AND t.due_date <= ?"
+		args = append(args, v)
+	}
+	query += " ORDER BY t.id"
+
+	rows, err := DB.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records [][]string
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		record := make([]string, len(columns))
+		for i, c := range columns {
+			record[i] = escapeCSVField(taskCSVValue(task, c))
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// startsCSVFormula reports whether a spreadsheet opening the exported file
+// would read s as a formula.
+func startsCSVFormula(s string) bool {
+	return s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0]))
+}
+
+// escapeCSVField quotes a value that would start a formula with a leading
+// apostrophe, which spreadsheets hide; the import strips it again.
+func escapeCSVField(s string) string {
+	if startsCSVFormula(s) {
+		return "'" + s
+	}
+	return s
+}
+
+func taskCSVValue(t models.Task, column string) string {
+	switch column {
+	case "id":
+		return strconv.Itoa(t.ID)
+	case "external_id":
+		return t.ExternalID
+	case "description":
+		return t.Description
+	case "project":
+		return t.ProjectName
+	case "priority":
+		return t.Priority
+	case "done":
+		return strconv.FormatBool(t.Done)
+	case "due_date":
+		return t.DueDate
+	case "tags":
+		return strings.Join(t.Tags, ",")
+	case "created_at":
+		return t.CreatedAt
+	}
+	return ""
+}
+
+func exportProjectRecords(r *http.Request, userID int, columns []string) ([][]string, error) {
+	query := projectSelect + " WHERE p.user_id = ?"
+	args := []interface{}{userID}
+	if v := r.FormValue("status"); v != "" {
+		query += " AND p.status = ?"
+		args = append(args, v)
+	}
+	query += " GROUP BY p.id ORDER BY p.id"
+
+	rows, err := DB.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records [][]string
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+		record := make([]string, len(columns))
+		for i, c := range columns {
+			record[i] = escapeCSVField(projectCSVValue(project, c))
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func projectCSVValue(p models.Project, column string) string {
+	switch column {
+	case "id":
+		return strconv.Itoa(p.ID)
+	case "external_id":
+		return p.ExternalID
+	case "name":
+		return p.Name
+	case "description":
+		return p.Description
+	case "status":
+		return p.Status
+	case "progress":
+		return strconv.Itoa(p.Progress)
+	case "due_date":
+		return p.DueDate
+	case "team_members":
+		return strconv.Itoa(p.TeamMembers)
+	case "created_at":
+		return p.CreatedAt
+	}
+	return ""
+}
+
+// readCSVUpload parses the multipart "file" field into a header and rows.
+func readCSVUpload(r *http.Request) ([]string, [][]string, error) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		return nil, nil, fmt.Errorf("expected a multipart upload of at most 10 MB")
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, nil, fmt.Errorf("file is required")
+	}
+	defer file.Close()
+
+	cr := csv.NewReader(file)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("the file is empty")
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	// Spreadsheet exports often start with a UTF-8 byte order mark.
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	return header, rows, nil
+}
+
+func normalizeHeader(h string) string {
+	h = strings.ToLower(strings.TrimSpace(h))
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(h)
+}
+
+// suggestCSVMapping matches headers to fields by name or a known alias.
+func suggestCSVMapping(kind string, header []string) map[string]string {
+	mapping := make(map[string]string)
+	for _, field := range csvImportFields(kind) {
+		names := append([]string{field}, csvAliases[kind][field]...)
+	names:
+		for _, name := range names {
+			for _, h := range header {
+				if normalizeHeader(h) == name {
+					if _, taken := mapping[field]; !taken {
+						mapping[field] = h
+					}
+					break names
+				}
+			}
+		}
+	}
+	return mapping
+}
+
+// resolveCSVMapping turns a JSON object of field → header (or the suggested
+// mapping when empty) into field → column index.
+func resolveCSVMapping(kind string, header []string, mappingJSON string) (map[string]int, error) {
+	mapping := suggestCSVMapping(kind, header)
+	if mappingJSON != "" {
+		mapping = make(map[string]string)
+		if err := json.Unmarshal([]byte(mappingJSON), &mapping); err != nil {
+			return nil, fmt.Errorf("mapping must be a JSON object of field to column")
+		}
+	}
+
+	columns := make(map[string]int)
+	for field, h := range mapping {
+		if h == "" {
+			continue
+		}
+		if !containsString(csvImportFields(kind), field) {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		index := -1
+		for i, name := range header {
+			if name == h {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("column %q not found", h)
+		}
+		columns[field] = index
+	}
+
+	required := "description"
+	if kind == "projects" {
+		required = "name"
+	}
+	if _, ok := columns[required]; !ok {
+		return nil, fmt.Errorf("a column must be mapped to %s", required)
+	}
+	return columns, nil
+}
+
+// CSVColumns is the mapping step of an import: it reads an uploaded file's
+// header and first rows and suggests which columns feed which fields.
+func CSVColumns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := GetCurrentUserID(r); err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	header, rows, err := readCSVUpload(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	kind, err := csvType(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(rows) > 5 {
+		rows = rows[:5]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":    kind,
+		"headers": header,
+		"sample":  rows,
+		"fields":  csvImportFields(kind),
+		"mapping": suggestCSVMapping(kind, header),
+	})
+}
+
+// ImportCSV imports tasks or projects from the multipart "file". mapping is
+// an optional JSON object of field → column header; with dry_run=1 nothing
+// is saved and the report shows what would happen. Rows with an
+// external_id update the record imported earlier under that id, so
+// importing the same file twice leaves everything unchanged.
+func ImportCSV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	header, rows, err := readCSVUpload(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	kind, err := csvType(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	columns, err := resolveCSVMapping(kind, header, r.FormValue("mapping"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dryRun := r.FormValue("dry_run") == "1" || r.FormValue("dry_run") == "true"
+
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "CSV import error", "err", err)
+		http.Error(w, "Failed to import", http.StatusInternalServerError)
+		return
+	}
+	imp := &csvImport{
+		ctx:       r.Context(),
+		tx:        tx,
+		userID:    userID,
+		columns:   columns,
+		projects:  make(map[string]int),
+		seen:      make(map[string]int),
+		completed: make(map[int]bool),
+		report: models.ImportReport{
+			Type:            kind,
+			DryRun:          dryRun,
+			ProjectsCreated: make([]string, 0),
+			Rows:            make([]models.ImportRow, 0, len(rows)),
+		},
+	}
+	for i, row := range rows {
+		if isBlankRecord(row) {
+			continue
+		}
+		imp.importRow(kind, i+2, row)
+	}
+
+	// A dry run performs the whole import and throws it away, so the
+	// preview matches a real run exactly, auto-created projects included.
+	if dryRun {
+		tx.Rollback()
+		for i := range imp.report.Rows {
+			if imp.report.Rows[i].Action == "create" {
+				imp.report.Rows[i].ID = 0
+			}
+		}
+	} else if err := tx.Commit(); err != nil {
+		slog.ErrorContext(r.Context(), "CSV import commit error", "err", err)
+		http.Error(w, "Failed to import", http.StatusInternalServerError)
+		return
+	} else {
+		imp.publishChanges(kind)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(imp.report)
+}
+
+type csvImport struct {
+	ctx       context.Context
+	tx        *sql.Tx
+	userID    int
+	columns   map[string]int
+	projects  map[string]int // lower-cased name → ID
+	seen      map[string]int // external ID → first row using it
+	completed map[int]bool   // tasks an update marked done
+	report    models.ImportReport
+}
+
+func (imp *csvImport) value(row []string, field string) (string, bool) {
+	i, ok := imp.columns[field]
+	if !ok {
+		return "", false
+	}
+	if i >= len(row) {
+		return "", true
+	}
+	v := strings.TrimSpace(row[i])
+	if unquoted, ok := strings.CutPrefix(v, "'"); ok && startsCSVFormula(unquoted) {
+		v = strings.TrimSpace(unquoted)
+	}
+	return v, true
+}
+
+func (imp *csvImport) importRow(kind string, line int, row []string) {
+	result := models.ImportRow{Row: line}
+	result.ExternalID, _ = imp.value(row, "external_id")
+	if kind == "tasks" {
+		result.Summary, _ = imp.value(row, "description")
+	} else {
+		result.Summary, _ = imp.value(row, "name")
+	}
+	if result.ExternalID != "" {
+		if first, dup := imp.seen[result.ExternalID]; dup {
+			result.Action = "error"
+			result.Errors = []string{fmt.Sprintf("external_id already used on row %d", first)}
+			imp.record(result)
+			return
+		}
+		imp.seen[result.ExternalID] = line
+	}
+
+	if _, err := imp.tx.ExecContext(imp.ctx, "SAVEPOINT csv_row"); err != nil {
+		result.Action = "error"
+		result.Errors = []string{err.Error()}
+		imp.record(result)
+		return
+	}
+	projectsBefore := len(imp.report.ProjectsCreated)
+
+	var err error
+	if kind == "tasks" {
+		err = imp.importTask(row, &result)
+	} else {
+		err = imp.importProject(row, &result)
+	}
+
+	if err != nil || len(result.Errors) > 0 {
+		imp.tx.ExecContext(imp.ctx, "ROLLBACK TO csv_row")
+		// Forget projects created for this row; the rollback removed them.
+		for _, name := range imp.report.ProjectsCreated[projectsBefore:] {
+			delete(imp.projects, strings.ToLower(name))
+		}
+		imp.report.ProjectsCreated = imp.report.ProjectsCreated[:projectsBefore]
+		if err != nil {
+			slog.Error("CSV import row error", "line", line, "err", err)
+			result.Errors = append(result.Errors, "could not be saved")
+		}
+		result.Action = "error"
+		result.ID = 0
+	}
+	imp.tx.ExecContext(imp.ctx, "RELEASE csv_row")
+	imp.record(result)
+}
+
+func (imp *csvImport) record(result models.ImportRow) {
+	switch result.Action {
+	case "create":
+		imp.report.Created++
+	case "update":
+		imp.report.Updated++
+	case "unchanged":
+		imp.report.Unchanged++
+	default:
+		imp.report.Failed++
+	}
+	imp.report.Rows = append(imp.report.Rows, result)
+}
+
+// importTask validates and saves one task row. Validation problems are
+// added to result.Errors; the returned error is for database failures.
+// Only mapped fields are written when updating.
+func (imp *csvImport) importTask(row []string, result *models.ImportRow) error {
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		sets = append(sets, column+" = ?")
+		args = append(args, value)
+	}
+
+	description, _ := imp.value(row, "description")
+	result.Summary = description
+	if description == "" {
+		result.Errors = append(result.Errors, "description is required")
+	}
+	set("description", description)
+
+	if v, ok := imp.value(row, "priority"); ok {
+		priority := strings.ToLower(v)
+		if priority == "" {
+			priority = "medium"
+		}
+		if priority != "high" && priority != "medium" && priority != "low" {
+			result.Errors = append(result.Errors, fmt.Sprintf("invalid priority %q", v))
+		}
+		set("priority", priority)
+	}
+	var markDone bool
+	if v, ok := imp.value(row, "done"); ok {
+		done, valid := parseCSVBool(v)
+		if !valid {
+			result.Errors = append(result.Errors, fmt.Sprintf("invalid done value %q", v))
+		}
+		set("done", done)
+		markDone = done
+	}
+	if v, ok := imp.value(row, "due_date"); ok {
+		due, valid := parseCSVDate(v)
+		if !valid {
+			result.Errors = append(result.Errors, fmt.Sprintf("invalid due date %q", v))
+		}
+		set("due_date", due)
+	}
+	var tags []string
+	tagsValue, hasTags := imp.value(row, "tags")
+	if hasTags {
+		tags = normalizeTags([]string{strings.ReplaceAll(tagsValue, ";", ",")})
+	}
+	if len(result.Errors) > 0 {
+		return nil
+	}
+
+	if name, ok := imp.value(row, "project"); ok {
+		var projectID interface{}
+		if name != "" {
+			id, err := imp.projectByName(name)
+			if err != nil {
+				return err
+			}
+			projectID = id
+		}
+		set("project_id", projectID)
+	}
+
+	existingID, unchanged, err := imp.existingTask(result.ExternalID, sets, args, tags, hasTags)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	if existingID == 0 {
+		columns := []string{"user_id", "external_id", "created_at", "updated_at"}
+		var externalID interface{}
+		if result.ExternalID != "" {
+			externalID = result.ExternalID
+		}
+		values := []interface{}{imp.userID, externalID, now, now}
+		for i, s := range sets {
+			columns = append(columns, strings.TrimSuffix(s, " = ?"))
+			values = append(values, args[i])
+		}
+		if !containsString(columns, "priority") {
+			columns = append(columns, "priority")
+			values = append(values, "medium")
+		}
+
+		res, err := imp.tx.ExecContext(imp.ctx, fmt.Sprintf("INSERT INTO tasks (%s) VALUES (?%s)",
+			strings.Join(columns, ", "), strings.Repeat(", ?", len(columns)-1)), values...)
+		if err != nil {
+			return err
+		}
+		id, _ := res.LastInsertId()
+		if len(tags) > 0 {
+			if err := replaceTaskTags(imp.ctx, imp.tx, id, tags); err != nil {
+				return err
+			}
+		}
+		result.ID = int(id)
+		result.Action = "create"
+		return nil
+	}
+
+	result.ID = existingID
+	if unchanged {
+		result.Action = "unchanged"
+		return nil
+	}
+	var wasDone bool
+	if markDone {
+		if err := imp.tx.QueryRowContext(imp.ctx, "SELECT done FROM tasks WHERE id = ?", existingID).Scan(&wasDone); err != nil {
+			return err
+		}
+	}
+	set("updated_at", now)
+	args = append(args, existingID, imp.userID)
+	if _, err := imp.tx.ExecContext(imp.ctx, "UPDATE tasks SET "+strings.Join(sets, ", ")+" WHERE id = ? AND user_id = ?", args...); err != nil {
+		return err
+	}
+	if markDone && !wasDone {
+		imp.completed[existingID] = true
+	}
+	if hasTags {
+		if err := replaceTaskTags(imp.ctx, imp.tx, int64(existingID), tags); err != nil {
+			return err
+		}
+	}
+	result.Action = "update"
+	return nil
+}
+
+// existingTask finds the task previously imported under externalID and
+// reports whether the row would leave it unchanged.
+func (imp *csvImport) existingTask(externalID string, sets []string, args []interface{}, tags []string, hasTags bool) (int, bool, error) {
+	if externalID == "" {
+		return 0, false, nil
+	}
+
+	var id int
+	err := imp.tx.QueryRowContext(imp.ctx, "SELECT id FROM tasks WHERE user_id = ? AND external_id = ?",
+		imp.userID, externalID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	// Compare every mapped column in SQL, where NULLs and booleans behave.
+	conditions := make([]string, len(sets))
+	for i, s := range sets {
+		conditions[i] = strings.Replace(s, " = ?", " IS ?", 1)
+	}
+	var same bool
+	err = imp.tx.QueryRowContext(imp.ctx, "SELECT COUNT(*) > 0 FROM tasks WHERE id = ? AND "+strings.Join(conditions, " AND "),
+		append([]interface{}{id}, args...)...).Scan(&same)
+	if err != nil {
+		return 0, false, err
+	}
+	if same && hasTags {
+		var current string
+		err = imp.tx.QueryRowContext(imp.ctx, "SELECT COALESCE(GROUP_CONCAT(tag, ','), '') FROM task_tags WHERE task_id = ?", id).Scan(&current)
+		if err != nil {
+			return 0, false, err
+		}
+		// GROUP_CONCAT order is undefined, but splitTags sorts the tags as
+		// normalizeTags sorted the file's.
+		same = strings.Join(splitTags(current), ",") == strings.Join(tags, ",")
+	}
+	return id, same, nil
+}
+
+// projectByName finds a project by name, ignoring case, or creates it.
+func (imp *csvImport) projectByName(name string) (int, error) {
+	key := strings.ToLower(name)
+	if id, ok := imp.projects[key]; ok {
+		return id, nil
+	}
+
+	var id int
+	err := imp.tx.QueryRowContext(imp.ctx, "SELECT id FROM projects WHERE user_id = ? AND LOWER(name) = ? ORDER BY id LIMIT 1",
+		imp.userID, key).Scan(&id)
+	if err == sql.ErrNoRows {
+		res, err := imp.tx.ExecContext(imp.ctx, "INSERT INTO projects (user_id, name, description, status, created_at) VALUES (?, ?, '', 'active', ?)",
+			imp.userID, name, time.Now())
+		if err != nil {
+			return 0, err
+		}
+		newID, _ := res.LastInsertId()
+		id = int(newID)
+		resolveLinksTo(imp.ctx, imp.tx, imp.userID, "project", newID, name)
+		imp.report.ProjectsCreated = append(imp.report.ProjectsCreated, name)
+	} else if err != nil {
+		return 0, err
+	}
+	imp.projects[key] = id
+	return id, nil
+}
+
+func (imp *csvImport) importProject(row []string, result *models.ImportRow) error {
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		sets = append(sets, column+" = ?")
+		args = append(args, value)
+	}
+
+	name, _ := imp.value(row, "name")
+	result.Summary = name
+	if name == "" {
+		result.Errors = append(result.Errors, "name is required")
+	}
+	set("name", name)
+
+	if v, ok := imp.value(row, "description"); ok {
+		set("description", v)
+	}
+	if v, ok := imp.value(row, "status"); ok {
+		status := strings.ToLower(v)
+		if status == "" {
+			status = "active"
+		}
+		if status != "active" && status != "completed" && status != "paused" && status != "cancelled" {
+			result.Errors = append(result.Errors, fmt.Sprintf("invalid status %q", v))
+		}
+		set("status", status)
+	}
+	for _, field := range []string{"progress", "team_members"} {
+		v, ok := imp.value(row, field)
+		if !ok {
+			continue
+		}
+		n := 0
+		if v != "" {
+			var err error
+			n, err = strconv.Atoi(strings.TrimSuffix(v, "%"))
+			if err != nil || n < 0 || (field == "progress" && n > 100) {
+				result.Errors = append(result.Errors, fmt.Sprintf("invalid %s %q", field, v))
+			}
+		}
+		set(field, n)
+	}
+	if v, ok := imp.value(row, "due_date"); ok {
+		due, valid := parseCSVDate(v)
+		if !valid {
+			result.Errors = append(result.Errors, fmt.Sprintf("invalid due date %q", v))
+		}
+		set("due_date", due)
+	}
+	if len(result.Errors) > 0 {
+		return nil
+	}
+
+	var existingID int
+	if result.ExternalID != "" {
+		err := imp.tx.QueryRowContext(imp.ctx, "SELECT id FROM projects WHERE user_id = ? AND external_id = ?",
+			imp.userID, result.ExternalID).Scan(&existingID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+	now := time.Now()
+
+	if existingID == 0 {
+		columns := []string{"user_id", "external_id", "created_at", "updated_at"}
+		var externalID interface{}
+		if result.ExternalID != "" {
+			externalID = result.ExternalID
+		}
+		values := []interface{}{imp.userID, externalID, now, now}
+		for i, s := range sets {
+			columns = append(columns, strings.TrimSuffix(s, " = ?"))
+			values = append(values, args[i])
+		}
+		res, err := imp.tx.ExecContext(imp.ctx, fmt.Sprintf("INSERT INTO projects (%s) VALUES (?%s)",
+			strings.Join(columns, ", "), strings.Repeat(", ?", len(columns)-1)), values...)
+		if err != nil {
+			return err
+		}
+		id, _ := res.LastInsertId()
+		resolveLinksTo(imp.ctx, imp.tx, imp.userID, "project", id, name)
+		imp.projects[strings.ToLower(name)] = int(id)
+		result.ID = int(id)
+		result.Action = "create"
+		return nil
+	}
+
+	result.ID = existingID
+	conditions := make([]string, len(sets))
+	for i, s := range sets {
+		conditions[i] = strings.Replace(s, " = ?", " IS ?", 1)
+	}
+	var same bool
+	err := imp.tx.QueryRowContext(imp.ctx, "SELECT COUNT(*) > 0 FROM projects WHERE id = ? AND "+strings.Join(conditions, " AND "),
+		append([]interface{}{existingID}, args...)...).Scan(&same)
+	if err != nil {
+		return err
+	}
+	if same {
+		result.Action = "unchanged"
+		return nil
+	}
+
+	set("updated_at", now)
+	args = append(args, existingID, imp.userID)
+	if _, err := imp.tx.ExecContext(imp.ctx, "UPDATE projects SET "+strings.Join(sets, ", ")+" WHERE id = ? AND user_id = ?", args...); err != nil {
+		return err
+	}
+	// The name may have changed to one that broken links point at.
+	resolveLinksTo(imp.ctx, imp.tx, imp.userID, "project", int64(existingID), name)
+	result.Action = "update"
+	return nil
+}
+
+func (imp *csvImport) publishChanges(kind string) {
+	for _, name := range imp.report.ProjectsCreated {
+		if project, err := loadProject(imp.ctx, imp.userID, int64(imp.projects[strings.ToLower(name)])); err == nil {
+			publish(imp.ctx, imp.userID, "project.created", project)
+		}
+	}
+	for _, row := range imp.report.Rows {
+		if row.Action != "create" && row.Action != "update" {
+			continue
+		}
+		if kind == "tasks" {
+			if task, err := loadTask(imp.ctx, imp.userID, int64(row.ID)); err == nil {
+				publish(imp.ctx, imp.userID, "task."+row.Action+"d", task)
+				if row.Action == "update" && imp.completed[row.ID] {
+					publish(imp.ctx, imp.userID, "task.completed", task)
+				}
+			}
+		} else if project, err := loadProject(imp.ctx, imp.userID, int64(row.ID)); err == nil {
+			publish(imp.ctx, imp.userID, "project."+row.Action+"d", project)
+		}
+	}
+}
+
+func parseCSVBool(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "", "false", "no", "n", "0", "open", "todo":
+		return false, true
+	case "true", "yes", "y", "1", "x", "done", "complete", "completed":
+		return true, true
+	}
+	return false, false
+}
+
+// parseCSVDate accepts ISO dates and common spreadsheet formats (US order
+// for slashes) and returns the date as YYYY-MM-DD.
+func parseCSVDate(s string) (string, bool) {
+	if s == "" {
+		return "", true
+	}
+	for _, layout := range []string{"2006-01-02", "2006/01/02", "1/2/2006", "02.01.2006", time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("2006-01-02"), true
+		}
+	}
+	return "", false
+}
+
+func isBlankRecord(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"task-manager/models"
+	"testing"
+)
+
+// importTestCSV posts a CSV file to ImportCSV as the given user.
+func importTestCSV(t *testing.T, username, kind, content string) models.ImportReport {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("type", kind)
+	fw, _ := mw.CreateFormFile("file", "import.csv")
+	fw.Write([]byte(content))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/import/csv", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.AddCookie(&http.Cookie{Name: "session_user", Value: username})
+	rec := httptest.NewRecorder()
+	ImportCSV(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var report models.ImportReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestImportCSVResolvesLinksToNewProjects(t *testing.T) {
+	openTestDB(t)
+	userID := createTestUser(t, "hal", "hal@example.com")
+	res := mustExec(t, "INSERT INTO notes (user_id, title, content) VALUES (?, 'Plans', '[[Garden]] and [[Kitchen]]')", userID)
+	noteID, _ := res.LastInsertId()
+	if err := updateNoteLinks(context.Background(), DB, userID, noteID, "[[Garden]] and [[Kitchen]]"); err != nil {
+		t.Fatal(err)
+	}
+
+	importTestCSV(t, "hal", "projects", "name\nGarden\n")
+	importTestCSV(t, "hal", "tasks", "description,project\nPaint walls,Kitchen\n")
+
+	if n := queryCount(t, "SELECT COUNT(*) FROM note_links WHERE note_id = ? AND target_type = 'project' AND target_id IS NOT NULL", noteID); n != 2 {
+		t.Errorf("%d of 2 links resolved to the imported projects", n)
+	}
+}
+
+func TestCSVEscapesFormulas(t *testing.T) {
+	openTestDB(t)
+	userID := createTestUser(t, "ida", "ida@example.com")
+	createTestUser(t, "jo", "jo@example.com")
+	mustExec(t, "INSERT INTO tasks (user_id, description) VALUES (?, '=HYPERLINK(\"http://evil.example\")')", userID)
+	mustExec(t, "INSERT INTO task_tags (task_id, tag) VALUES (last_insert_rowid(), '@home')")
+	mustExec(t, "INSERT INTO tasks (user_id, description) VALUES (?, '''Tis the season')", userID)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/export/csv?columns=description,tags", nil)
+	req.AddCookie(&http.Cookie{Name: "session_user", Value: "ida"})
+	rec := httptest.NewRecorder()
+	ExportCSV(rec, req)
+	want := "description,tags\n\"'=HYPERLINK(\"\"http://evil.example\"\")\",'@home\n'Tis the season,\n"
+	if got := rec.Body.String(); got != want {
+		t.Fatalf("export:\n%s\nwant\n%s", got, want)
+	}
+
+	importTestCSV(t, "jo", "tasks", rec.Body.String())
+	rows, err := DB.Query(`
+		SELECT t.description, COALESCE(GROUP_CONCAT(tt.tag), '') FROM tasks t
+		LEFT JOIN task_tags tt ON tt.task_id = t.id
+		JOIN users u ON u.id = t.user_id WHERE u.username = 'jo'
+		GROUP BY t.id ORDER BY t.id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var description, tags string
+		rows.Scan(&description, &tags)
+		got = append(got, description+"|"+tags)
+	}
+	if len(got) != 2 || got[0] != `=HYPERLINK("http://evil.example")|@home` || got[1] != "'Tis the season|" {
+		t.Errorf("imported %q", got)
+	}
+}
+
+func TestImportCSVTwiceLeavesTagsUnchanged(t *testing.T) {
+	openTestDB(t)
+	createTestUser(t, "kit", "kit@example.com")
+	file := "external_id,description,tags\nT-1,Water plants,\"home,garden,weekly\"\n"
+
+	importTestCSV(t, "kit", "tasks", file)
+	report := importTestCSV(t, "kit", "tasks", file)
+	if len(report.Rows) != 1 || report.Rows[0].Action != "unchanged" {
+		t.Errorf("second import: %+v", report.Rows)
+	}
+}
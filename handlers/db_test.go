@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"database/sql"
+	"path/filepath"
+	"task-manager/schema"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openTestDB points DB at a new database with the full schema for the
+// length of the test.
+func openTestDB(t *testing.T) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := schema.Apply(db); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	previous := DB
+	DB = db
+	t.Cleanup(func() {
+		DB = previous
+		db.Close()
+	})
+}
+
+// mustExec runs a statement the test cannot go on without.
+func mustExec(t *testing.T, query string, args ...interface{}) sql.Result {
+	t.Helper()
+	res, err := DB.Exec(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return res
+}
+
+// createTestUser adds a user and returns their ID.
+func createTestUser(t *testing.T, username, email string) int {
+	t.Helper()
+	res := mustExec(t, "INSERT INTO users (username, email, password) VALUES (?, ?, 'x')", username, email)
+	id, _ := res.LastInsertId()
+	return int(id)
+}
+
+// queryCount returns the result of a SELECT COUNT(*) query.
+func queryCount(t *testing.T, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := DB.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
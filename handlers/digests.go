@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	htmltemplate "html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"task-manager/models"
+	"task-manager/notify"
+	texttemplate "text/template"
+	"time"
+)
+
+// BaseURL is the externally visible address of the server, used for links
+// in emails.
+var BaseURL = "http://localhost:5050"
+
+type digestTask struct {
+	Description string
+	ProjectName string
+	Priority    string
+	DueDate     string
+}
+
+type digestProject struct {
+	Name     string
+	Previous int
+	Progress int
+}
+
+type digestData struct {
+	Username       string
+	Date           string
+	Frequency      string
+	DueToday       []digestTask
+	Overdue        []digestTask
+	CompletedSince []digestTask
+	SinceLabel     string
+	Projects       []digestProject
+	UnsubscribeURL string
+	DashboardURL   string
+}
+
+func (d *digestData) Empty() bool {
+	return len(d.DueToday) == 0 && len(d.Overdue) == 0 && len(d.CompletedSince) == 0 && len(d.Projects) == 0
+}
+
+func defaultDigestSettings() models.DigestSettings {
+	return models.DigestSettings{Frequency: "off", Hour: 8, Weekday: 1, Timezone: "UTC"}
+}
+
+func loadDigestSettings(ctx context.Context, userID int) (models.DigestSettings, error) {
+	settings := defaultDigestSettings()
+	err := DB.QueryRowContext(ctx, `
+		SELECT COALESCE(digest_frequency, 'off'), COALESCE(digest_hour, 8),
+		       COALESCE(digest_weekday, 1), COALESCE(timezone, 'UTC')
+		FROM user_settings WHERE user_id = ?`, userID).
+		Scan(&settings.Frequency, &settings.Hour, &settings.Weekday, &settings.Timezone)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	return settings, err
+}
+
+// DigestSettings reads or updates when the current user receives digests.
+func DigestSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		settings, err := loadDigestSettings(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Digest settings error", "err", err)
+			http.Error(w, "Failed to load digest settings", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+
+	case http.MethodPost:
+		// Only the fields that were sent change; the rest keep their
+		// stored values.
+		settings, err := loadDigestSettings(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Digest settings error", "err", err)
+			http.Error(w, "Failed to load digest settings", http.StatusInternalServerError)
+			return
+		}
+		if v := r.FormValue("digest_frequency"); v != "" {
+			if v != "off" && v != "daily" && v != "weekly" {
+				http.Error(w, "Digest frequency must be off, daily or weekly", http.StatusBadRequest)
+				return
+			}
+			settings.Frequency = v
+		}
+		if v := r.FormValue("digest_hour"); v != "" {
+			hour, err := strconv.Atoi(v)
+			if err != nil || hour < 0 || hour > 23 {
+				http.Error(w, "Digest hour must be between 0 and 23", http.StatusBadRequest)
+				return
+			}
+			settings.Hour = hour
+		}
+		if v := r.FormValue("digest_weekday"); v != "" {
+			weekday, err := strconv.Atoi(v)
+			if err != nil || weekday < 0 || weekday > 6 {
+				http.Error(w, "Digest weekday must be between 0 (Sunday) and 6", http.StatusBadRequest)
+				return
+			}
+			settings.Weekday = weekday
+		}
+		if v := r.FormValue("timezone"); v != "" {
+			if _, err := time.LoadLocation(v); err != nil {
+				http.Error(w, "Unknown timezone", http.StatusBadRequest)
+				return
+			}
+			settings.Timezone = v
+		}
+
+		_, err = DB.ExecContext(r.Context(), `
+			INSERT INTO user_settings (user_id, digest_frequency, digest_hour, digest_weekday, timezone)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(user_id) DO UPDATE SET
+				digest_frequency = excluded.digest_frequency,
+				digest_hour = excluded.digest_hour,
+				digest_weekday = excluded.digest_weekday,
+				timezone = excluded.timezone`,
+			userID, settings.Frequency, settings.Hour, settings.Weekday, settings.Timezone)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Update digest settings error", "err", err)
+			http.Error(w, "Failed to update digest settings", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// DigestPreview renders the current user's digest as it would be sent now.
+// Use ?format=text for the plain-text version.
+func DigestPreview(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := loadDigestSettings(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to load digest settings", http.StatusInternalServerError)
+		return
+	}
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	if settings.Frequency == "off" {
+		settings.Frequency = "daily"
+	}
+
+	data, err := buildDigest(r.Context(), userID, settings.Frequency, time.Now().In(loc))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Build digest error", "err", err)
+		http.Error(w, "Failed to build digest", http.StatusInternalServerError)
+		return
+	}
+
+	htmlBody, textBody, err := renderDigest(r.Context(), data)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Render digest error", "err", err)
+		http.Error(w, "Failed to render digest", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(textBody))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(htmlBody))
+}
+
+// DigestUnsubscribe turns digests off for the user named in a signed token.
+// It needs no session so it works straight from the email.
+func DigestUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	userID, err := verifyToken("digest-unsubscribe", r.FormValue("token"))
+	if err != nil {
+		http.Error(w, "Invalid unsubscribe link", http.StatusBadRequest)
+		return
+	}
+
+	_, err = DB.ExecContext(r.Context(), `
+		INSERT INTO user_settings (user_id, digest_frequency) VALUES (?, 'off')
+		ON CONFLICT(user_id) DO UPDATE SET digest_frequency = 'off'`, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Digest unsubscribe error", "err", err)
+		http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(`<!DOCTYPE html><html><head><title>Unsubscribed</title></head><body>
+		<h1>You have been unsubscribed</h1>
+		<p>You will no longer receive TaskLift digest emails. You can turn them back on from your settings.</p>
+		</body></html>`))
+}
+
+// SendDigests delivers digests to every user whose scheduled time has passed
+// in their own timezone. A delivery is claimed per user and period before
+// sending so each digest goes out at most once.
+func SendDigests(ctx context.Context, n notify.Notifier) {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT u.id, COALESCE(u.email, ''), s.digest_frequency, COALESCE(s.digest_hour, 8),
+		       COALESCE(s.digest_weekday, 1), COALESCE(s.timezone, 'UTC')
+		FROM user_settings s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.digest_frequency IN ('daily', 'weekly')`)
+	if err != nil {
+		slog.ErrorContext(ctx, "Digest query error", "err", err)
+		return
+	}
+
+	type recipient struct {
+		userID   int
+		email    string
+		settings models.DigestSettings
+	}
+	var recipients []recipient
+	for rows.Next() {
+		var rc recipient
+		if err := rows.Scan(&rc.userID, &rc.email, &rc.settings.Frequency, &rc.settings.Hour,
+			&rc.settings.Weekday, &rc.settings.Timezone); err != nil {
+			slog.ErrorContext(ctx, "Digest scan error", "err", err)
+			continue
+		}
+		recipients = append(recipients, rc)
+	}
+	rows.Close()
+
+	for _, rc := range recipients {
+		if ctx.Err() != nil {
+			return
+		}
+		if rc.email == "" {
+			continue
+		}
+
+		loc, err := time.LoadLocation(rc.settings.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		now := time.Now().In(loc)
+		if now.Hour() < rc.settings.Hour {
+			continue
+		}
+		if rc.settings.Frequency == "weekly" && int(now.Weekday()) != rc.settings.Weekday {
+			continue
+		}
+
+		if err := sendDigest(ctx, n, rc.userID, rc.email, rc.settings.Frequency, now); err != nil {
+			slog.ErrorContext(ctx, "Digest failed", "user_id", rc.userID, "err", err)
+		}
+	}
+}
+
+func sendDigest(ctx context.Context, n notify.Notifier, userID int, email, frequency string, now time.Time) error {
+	period := frequency + ":" + now.Format("2006-01-02")
+	res, err := DB.ExecContext(ctx, "INSERT OR IGNORE INTO digest_deliveries (user_id, period, sent_at) VALUES (?, ?, ?)",
+		userID, period, time.Now())
+	if err != nil {
+		return err
+	}
+	if claimed, _ := res.RowsAffected(); claimed == 0 {
+		return nil
+	}
+	release := func() {
+		DB.ExecContext(ctx, "DELETE FROM digest_deliveries WHERE user_id = ? AND period = ?", userID, period)
+	}
+
+	data, err := buildDigest(ctx, userID, frequency, now)
+	if err != nil {
+		release()
+		return err
+	}
+	htmlBody, textBody, err := renderDigest(ctx, data)
+	if err != nil {
+		release()
+		return err
+	}
+
+	title := "Your TaskLift daily digest"
+	if frequency == "weekly" {
+		title = "Your TaskLift weekly digest"
+	}
+	err = n.Notify(ctx, notify.Message{
+		To:      []string{email},
+		Subject: title + " for " + data.Date,
+		Text:    textBody,
+		HTML:    htmlBody,
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + data.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+	if err != nil {
+		release()
+		return err
+	}
+
+	return saveProgressSnapshots(ctx, userID, data.Projects)
+}
+
+// buildDigest collects the digest contents for a user. now must be in the
+// user's timezone; "today" and the completed window follow it.
+func buildDigest(ctx context.Context, userID int, frequency string, now time.Time) (*digestData, error) {
+	data := &digestData{
+		Date:           now.Format("Monday, January 2, 2006"),
+		Frequency:      frequency,
+		UnsubscribeURL: BaseURL + "/digest/unsubscribe?token=" + url.QueryEscape(signToken("digest-unsubscribe", userID)),
+		DashboardURL:   BaseURL + "/dashboard",
+	}
+	if err := DB.QueryRowContext(ctx, "SELECT username FROM users WHERE id = ?", userID).Scan(&data.Username); err != nil {
+		return nil, err
+	}
+
+	today := now.Format("2006-01-02")
+	startOfToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	since := startOfToday.AddDate(0, 0, -1)
+	data.SinceLabel = "yesterday"
+	if frequency == "weekly" {
+		since = startOfToday.AddDate(0, 0, -7)
+		data.SinceLabel = "in the last week"
+	}
+
+	rows, err := DB.QueryContext(ctx, `
+		SELECT t.description, COALESCE(p.name, ''), COALESCE(t.priority, 'medium'),
+		       COALESCE(t.due_date, ''), t.done, t.completed_at
+		FROM tasks t
+		LEFT JOIN projects p ON p.id = t.project_id
+		WHERE t.user_id = ?
+		ORDER BY t.due_date, t.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var task digestTask
+		var done bool
+		var completedAt sql.NullTime
+		if err := rows.Scan(&task.Description, &task.ProjectName, &task.Priority, &task.DueDate,
+			&done, &completedAt); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case done:
+			if completedAt.Valid && !completedAt.Time.Before(since) && completedAt.Time.Before(startOfToday) {
+				data.CompletedSince = append(data.CompletedSince, task)
+			}
+		case task.DueDate == today:
+			data.DueToday = append(data.DueToday, task)
+		case task.DueDate != "" && task.DueDate < today:
+			data.Overdue = append(data.Overdue, task)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	projects, err := projectProgressChanges(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	data.Projects = projects
+
+	return data, nil
+}
+
+// projectProgressChanges compares each project's progress with the value
+// recorded when the last digest was sent.
+func projectProgressChanges(ctx context.Context, userID int) ([]digestProject, error) {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT p.id, p.name,
+		       COUNT(t.id), COUNT(CASE WHEN t.done = 1 THEN 1 END),
+		       s.progress
+		FROM projects p
+		LEFT JOIN tasks t ON t.project_id = p.id
+		LEFT JOIN project_progress_snapshots s ON s.project_id = p.id AND s.user_id = p.user_id
+		WHERE p.user_id = ?
+		GROUP BY p.id, p.name, s.progress
+		ORDER BY p.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []digestProject
+	for rows.Next() {
+		var id, taskCount, completed int
+		var previous sql.NullInt64
+		var project digestProject
+		if err := rows.Scan(&id, &project.Name, &taskCount, &completed, &previous); err != nil {
+			return nil, err
+		}
+		if taskCount > 0 {
+			project.Progress = completed * 100 / taskCount
+		}
+		if previous.Valid {
+			project.Previous = int(previous.Int64)
+		}
+		if project.Progress != project.Previous {
+			projects = append(projects, project)
+		}
+	}
+	return projects, rows.Err()
+}
+
+func saveProgressSnapshots(ctx context.Context, userID int, changed []digestProject) error {
+	if len(changed) == 0 {
+		return nil
+	}
+	_, err := DB.ExecContext(ctx, `
+		INSERT INTO project_progress_snapshots (user_id, project_id, progress, recorded_at)
+		SELECT p.user_id, p.id,
+		       CASE WHEN COUNT(t.id) > 0 THEN COUNT(CASE WHEN t.done = 1 THEN 1 END) * 100 / COUNT(t.id) ELSE 0 END,
+		       ?
+		FROM projects p
+		LEFT JOIN tasks t ON t.project_id = p.id
+		WHERE p.user_id = ?
+		GROUP BY p.id
+		ON CONFLICT(user_id, project_id) DO UPDATE SET
+			progress = excluded.progress,
+			recorded_at = excluded.recorded_at`, time.Now(), userID)
+	return err
+}
+
+func renderDigest(ctx context.Context, data *digestData) (string, string, error) {
+	htmlTmpl, err := htmltemplate.ParseFiles(templatePath("digest.html"))
+	if err != nil {
+		return "", "", err
+	}
+	textTmpl, err := texttemplate.ParseFiles(templatePath("digest.txt"))
+	if err != nil {
+		return "", "", err
+	}
+
+	var htmlBuf, textBuf bytes.Buffer
+	if err := executeTemplate(ctx, htmlTmpl, &htmlBuf, data); err != nil {
+		return "", "", err
+	}
+	if err := executeTemplate(ctx, textTmpl, &textBuf, data); err != nil {
+		return "", "", err
+	}
+	return htmlBuf.String(), textBuf.String(), nil
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBuildDigestUsesCompletionTime(t *testing.T) {
+	openTestDB(t)
+	userID := createTestUser(t, "gus", "gus@example.com")
+
+	now := time.Now().UTC()
+	startOfToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	yesterday := startOfToday.Add(-12 * time.Hour).Format("2006-01-02 15:04:05")
+	lastMonth := startOfToday.AddDate(0, -1, 0).Format("2006-01-02 15:04:05")
+
+	mustExec(t, "INSERT INTO tasks (user_id, description, done, completed_at, updated_at) VALUES (?, 'Finished yesterday', 1, ?, ?)",
+		userID, yesterday, yesterday)
+	// Done long ago, but its title was edited yesterday.
+	mustExec(t, "INSERT INTO tasks (user_id, description, done, completed_at, updated_at) VALUES (?, 'Finished last month', 1, ?, ?)",
+		userID, lastMonth, yesterday)
+
+	data, err := buildDigest(context.Background(), userID, "daily", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.CompletedSince) != 1 || data.CompletedSince[0].Description != "Finished yesterday" {
+		t.Errorf("completed since yesterday: %+v", data.CompletedSince)
+	}
+}
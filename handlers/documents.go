@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// saveDocument stores data as a new document of the user's, in
+// StorageDir/documents/<user ID>, and returns its ID.
+func saveDocument(ctx context.Context, userID int, title, fileType string, data []byte) (int64, error) {
+	dir := filepath.Join(StorageDir, "documents", fmt.Sprint(userID))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return 0, err
+	}
+	dest := filepath.Join(dir, randomHex(8)+"-"+archiveFileName(title))
+	if err := os.WriteFile(dest, data, 0o600); err != nil {
+		return 0, err
+	}
+
+	res, err := DB.ExecContext(ctx, `
+		INSERT INTO documents (user_id, title, file_path, file_type, file_size, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, title, dest, fileType, len(data), time.Now())
+	if err != nil {
+		os.Remove(dest)
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// deleteDocument removes a document and its file.
+func deleteDocument(ctx context.Context, userID int, id int64) {
+	var path string
+	if err := DB.QueryRowContext(ctx, "SELECT file_path FROM documents WHERE id = ? AND user_id = ?", id, userID).Scan(&path); err != nil {
+		return
+	}
+	if _, err := DB.ExecContext(ctx, "DELETE FROM documents WHERE id = ? AND user_id = ?", id, userID); err == nil {
+		os.Remove(path)
+	}
+}
+
+// DownloadDocument serves the file of document ?id=.
+func DownloadDocument(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Document ID required", http.StatusBadRequest)
+		return
+	}
+	var title, path, fileType string
+	err = DB.QueryRowContext(r.Context(), "SELECT title, file_path, COALESCE(file_type, '') FROM documents WHERE id = ? AND user_id = ?", id, userID).
+		Scan(&title, &path, &fileType)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Load document error", "err", err)
+		http.Error(w, "Failed to retrieve document", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "Document file no longer available", http.StatusGone)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Document file no longer available", http.StatusGone)
+		return
+	}
+
+	if fileType == "" {
+		fileType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", fileType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": archiveFileName(title)}))
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"task-manager/events"
+)
+
+// Events carries change notifications from the handlers to webhooks and any
+// other in-process listeners.
+var Events = events.NewBus()
+
+func publish(ctx context.Context, userID int, eventType string, data interface{}) {
+	Events.Publish(ctx, userID, eventType, data)
+}
+
+// publishTask publishes eventType with the task just inserted by res.
+func publishTask(ctx context.Context, userID int, res sql.Result, eventType string) {
+	id, err := res.LastInsertId()
+	if err != nil {
+		return
+	}
+	if task, err := loadTask(ctx, userID, id); err == nil {
+		publish(ctx, userID, eventType, task)
+	}
+}
+
+// publishDeleted publishes a deletion event if res actually removed a row.
+func publishDeleted(ctx context.Context, userID int, res sql.Result, eventType string, id string) {
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return
+	}
+	entityID, err := strconv.Atoi(id)
+	if err != nil {
+		return
+	}
+	publish(ctx, userID, eventType, map[string]int{"id": entityID})
+}
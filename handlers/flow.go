@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"task-manager/models"
+	"time"
+)
+
+// A task's lifecycle (created, started, completed, reopened) is written to
+// task_lifecycle by triggers, so every way of changing a task is recorded.
+// Flow metrics come from the tasks' timestamps, and the reopen counts from
+// that history.
+
+// StartTask marks task "id" as in progress, or with started=false as not
+// started yet. Starting a task that already started keeps its start time.
+func StartTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Task ID required", http.StatusBadRequest)
+		return
+	}
+	started := true
+	if v := r.FormValue("started"); v != "" {
+		if started, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "started must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var res sql.Result
+	if started {
+		res, err = DB.ExecContext(r.Context(), "UPDATE tasks SET started_at = COALESCE(started_at, ?), updated_at = ? WHERE id = ? AND user_id = ?",
+			time.Now(), time.Now(), id, userID)
+	} else {
+		res, err = DB.ExecContext(r.Context(), "UPDATE tasks SET started_at = NULL, updated_at = ? WHERE id = ? AND user_id = ? AND done = 0",
+			time.Now(), id, userID)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Start task error", "err", err)
+		http.Error(w, "Failed to update task", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Task not found, or already done", http.StatusNotFound)
+		return
+	}
+
+	task, err := loadTask(r.Context(), userID, id)
+	if err != nil {
+		http.Error(w, "Failed to update task", http.StatusInternalServerError)
+		return
+	}
+	publish(r.Context(), userID, "task.updated", task)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// flowMetrics measures the tasks completed in [from, to], days in the
+// user's time zone. projectID 0 means every project.
+func flowMetrics(ctx context.Context, userID int, projectID int64, from, to, now time.Time) (models.FlowMetrics, error) {
+	loc := now.Location()
+	end := to.AddDate(0, 0, 1)
+	metrics := models.FlowMetrics{
+		From:       from.Format("2006-01-02"),
+		To:         to.Format("2006-01-02"),
+		Throughput: make([]models.ThroughputWeek, 0),
+		WIP:        make([]models.ProjectWIP, 0),
+	}
+
+	args := []interface{}{userID}
+	projectFilter := func(column string) string { return "" }
+	if projectID > 0 {
+		args = append(args, projectID)
+		projectFilter = func(column string) string { return " AND " + column + " = ?" }
+	}
+
+	weeks, ok := analyticsPeriods(from, to, "week")
+	if !ok {
+		return metrics, errAnalyticsRange
+	}
+	perWeek := make([]int, len(weeks)-1)
+
+	rows, err := DB.QueryContext(ctx, `
+		SELECT created_at, started_at, completed_at FROM tasks
+		WHERE user_id = ? AND done = 1 AND completed_at IS NOT NULL`+projectFilter("project_id"), args...)
+	if err != nil {
+		return metrics, err
+	}
+	var lead, cycle []float64
+	for rows.Next() {
+		var created, started, completed sql.NullTime
+		if err := rows.Scan(&created, &started, &completed); err != nil {
+			rows.Close()
+			return metrics, err
+		}
+		at := completed.Time.In(loc)
+		if at.Before(from) || !at.Before(end) {
+			continue
+		}
+		metrics.Completed++
+		for i := 0; i+1 < len(weeks); i++ {
+			if !at.Before(weeks[i]) && at.Before(weeks[i+1]) {
+				perWeek[i]++
+			}
+		}
+		lead = append(lead, math.Max(at.Sub(created.Time).Hours(), 0))
+		if started.Valid {
+			cycle = append(cycle, math.Max(at.Sub(started.Time).Hours(), 0))
+		}
+	}
+	rows.Close()
+	metrics.LeadTime, metrics.CycleTime = summarizeDurations(lead), summarizeDurations(cycle)
+	for i, n := range perWeek {
+		metrics.Throughput = append(metrics.Throughput, models.ThroughputWeek{Week: weeks[i].Format("2006-01-02"), Completed: n})
+	}
+
+	rows, err = DB.QueryContext(ctx, `
+		SELECT l.at FROM task_lifecycle l JOIN tasks t ON t.id = l.task_id
+		WHERE l.user_id = ? AND l.event = 'reopened'`+projectFilter("t.project_id"), args...)
+	if err != nil {
+		return metrics, err
+	}
+	for rows.Next() {
+		var at time.Time
+		if err := rows.Scan(&at); err != nil {
+			rows.Close()
+			return metrics, err
+		}
+		if at = at.In(loc); !at.Before(from) && at.Before(end) {
+			metrics.Reopened++
+		}
+	}
+	rows.Close()
+
+	rows, err = DB.QueryContext(ctx, `
+		SELECT t.project_id, COALESCE(p.name, ''),
+		       COUNT(CASE WHEN t.started_at IS NOT NULL THEN 1 END),
+		       COUNT(CASE WHEN t.started_at IS NULL THEN 1 END)
+		FROM tasks t LEFT JOIN projects p ON p.id = t.project_id
+		WHERE t.user_id = ? AND t.done = 0`+projectFilter("t.project_id")+`
+		GROUP BY t.project_id
+		ORDER BY 3 DESC, 2`, args...)
+	if err != nil {
+		return metrics, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var wip models.ProjectWIP
+		var id sql.NullInt64
+		if err := rows.Scan(&id, &wip.Name, &wip.InProgress, &wip.ToDo); err != nil {
+			return metrics, err
+		}
+		if id.Valid {
+			projectID := int(id.Int64)
+			wip.ProjectID = &projectID
+		}
+		metrics.WIP = append(metrics.WIP, wip)
+	}
+	return metrics, rows.Err()
+}
+
+// summarizeDurations returns the mean and nearest-rank percentiles of a
+// set of durations in hours, rounded to a tenth of an hour.
+func summarizeDurations(hours []float64) models.Durations {
+	d := models.Durations{Count: len(hours)}
+	if len(hours) == 0 {
+		return d
+	}
+	sort.Float64s(hours)
+	var sum float64
+	for _, h := range hours {
+		sum += h
+	}
+	percentile := func(p float64) float64 {
+		return round1(hours[int(math.Ceil(p*float64(len(hours))))-1])
+	}
+	d.Mean = round1(sum / float64(len(hours)))
+	d.P50, d.P85, d.P95 = percentile(0.50), percentile(0.85), percentile(0.95)
+	return d
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// FlowMetrics reports lead time, cycle time, throughput per week and
+// reopened tasks for the tasks completed between ?from= and ?to= (default
+// the last 12 weeks), and the work in progress per project right now.
+// ?project_id= limits it to one project.
+func FlowMetrics(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	now := userToday(r.Context(), userID)
+	from, to, err := analyticsDateRange(q.Get("from"), q.Get("to"), now, 84)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var projectID int64
+	if v := q.Get("project_id"); v != "" {
+		if projectID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "Invalid project ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	metrics, err := flowMetrics(r.Context(), userID, projectID, from, to, now)
+	if err == errAnalyticsRange {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Flow metrics error", "err", err)
+		http.Error(w, "Failed to retrieve flow metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"task-manager/models"
+	"time"
+)
+
+// Projects are forecast with a Monte Carlo simulation: each trial draws
+// weeks at random from the project's recent weekly throughput until the
+// open tasks are used up. The spread of the trials gives the date range.
+const (
+	forecastHistoryWeeks = 12
+	forecastTrials       = 2000
+	forecastMaxWeeks     = 260 // trials that take longer count as this long
+)
+
+// projectThroughput returns, for each of the user's projects, the number of
+// tasks completed in each of the last forecastHistoryWeeks weeks, this one
+// included and the most recent first, or since the project's first week if
+// it is younger.
+func projectThroughput(ctx context.Context, userID int, projects []models.Project, now time.Time) (map[int][]int, error) {
+	loc := now.Location()
+	const week = 7 * 24 * time.Hour
+	end := startOfWeek(now).AddDate(0, 0, 7)
+	first := end.AddDate(0, 0, -7*forecastHistoryWeeks)
+
+	history := make(map[int][]int)
+	for _, p := range projects {
+		start := first
+		if created, err := time.Parse(time.RFC3339Nano, p.CreatedAt); err == nil {
+			if w := startOfWeek(created.In(loc)); w.After(start) && w.Before(end) {
+				start = w
+			}
+		}
+		history[p.ID] = make([]int, int(end.Sub(start).Round(week)/week))
+	}
+
+	rows, err := DB.QueryContext(ctx, `
+		SELECT project_id, completed_at FROM tasks
+		WHERE user_id = ? AND project_id IS NOT NULL AND done = 1 AND completed_at IS NOT NULL`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var projectID int
+		var completed time.Time
+		if err := rows.Scan(&projectID, &completed); err != nil {
+			return nil, err
+		}
+		weeks, ok := history[projectID]
+		if ago := int(end.Sub(completed) / week); ok && completed.Before(end) && ago < len(weeks) {
+			weeks[ago]++
+		}
+	}
+	return history, rows.Err()
+}
+
+// forecastProject simulates when the open tasks of p will be done.
+func forecastProject(p models.Project, weekly []int, now time.Time) *models.ProjectForecast {
+	f := &models.ProjectForecast{
+		Remaining:    p.TaskCount - p.CompletedTasks,
+		HistoryWeeks: len(weekly),
+	}
+	total := 0
+	for _, n := range weekly {
+		total += n
+	}
+	if len(weekly) > 0 {
+		f.WeeklyAverage = round1(float64(total) / float64(len(weekly)))
+	}
+	due, hasDue := parseAnalyticsDate(p.DueDate, now.Location())
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch {
+	case f.Remaining == 0:
+		f.Risk, f.RiskExplained = "done", "No open tasks."
+		return f
+	case total == 0:
+		f.Risk, f.RiskExplained = "unknown", "No tasks completed recently to forecast from."
+		return f
+	}
+
+	// Seeded by project and day, so the forecast is stable between
+	// requests until something changes.
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%d/%s", p.ID, f.Remaining, today.Format("2006-01-02"))
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	results := make([]int, forecastTrials)
+	for i := range results {
+		weeks, done := 0, 0
+		for done < f.Remaining && weeks < forecastMaxWeeks {
+			done += weekly[rng.Intn(len(weekly))]
+			weeks++
+		}
+		results[i] = weeks
+	}
+	sort.Ints(results)
+	date := func(pct float64) time.Time {
+		return today.AddDate(0, 0, 7*results[int(pct*float64(len(results)))-1])
+	}
+	p50, p85, p95 := date(0.50), date(0.85), date(0.95)
+	f.P50, f.P85, f.P95 = p50.Format("2006-01-02"), p85.Format("2006-01-02"), p95.Format("2006-01-02")
+
+	if !hasDue {
+		f.Risk, f.RiskExplained = "no_due_date", "The project has no due date."
+		return f
+	}
+	onTime := 0
+	for _, weeks := range results {
+		if !today.AddDate(0, 0, 7*weeks).After(due) {
+			onTime++
+		}
+	}
+	chance := onTime * 100 / len(results)
+	f.OnTimeChance = &chance
+	switch {
+	case due.Before(today):
+		f.Risk, f.RiskExplained = "late", "The due date has passed with tasks still open."
+	case !p85.After(due):
+		f.Risk, f.RiskExplained = "on_track", fmt.Sprintf("%d%% chance of finishing by %s.", chance, p.DueDate)
+	case !p50.After(due):
+		f.Risk, f.RiskExplained = "at_risk", fmt.Sprintf("Only %d%% chance of finishing by %s.", chance, p.DueDate)
+	default:
+		f.Risk, f.RiskExplained = "late", fmt.Sprintf("Likely done by %s, after the due date; %d%% chance of finishing on time.", f.P50, chance)
+	}
+	return f
+}
+
+// attachForecasts fills in the forecast of each project.
+func attachForecasts(ctx context.Context, userID int, projects []models.Project) error {
+	now := userToday(ctx, userID)
+	history, err := projectThroughput(ctx, userID, projects, now)
+	if err != nil {
+		return err
+	}
+	for i := range projects {
+		projects[i].Forecast = forecastProject(projects[i], history[projects[i].ID], now)
+	}
+	return nil
+}
+
+// ProjectForecasts lists the user's projects with their forecasts, or
+// project ?id= alone. ?at_risk=true keeps only the projects at risk of
+// missing or past their due date.
+func ProjectForecasts(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	where, args := "p.user_id = ?", []interface{}{userID}
+	if v := r.URL.Query().Get("id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid project ID", http.StatusBadRequest)
+			return
+		}
+		where, args = where+" AND p.id = ?", append(args, id)
+	}
+	rows, err := DB.QueryContext(r.Context(), projectSelect+`
+		WHERE `+where+`
+		GROUP BY p.id
+		ORDER BY p.created_at DESC`, args...)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Project forecast error", "err", err)
+		http.Error(w, "Failed to forecast projects", http.StatusInternalServerError)
+		return
+	}
+	projects := make([]models.Project, 0)
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Project scan error", "err", err)
+			continue
+		}
+		projects = append(projects, project)
+	}
+	rows.Close()
+	if r.URL.Query().Get("id") != "" && len(projects) == 0 {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	if err := attachForecasts(r.Context(), userID, projects); err != nil {
+		slog.ErrorContext(r.Context(), "Project forecast error", "err", err)
+		http.Error(w, "Failed to forecast projects", http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("at_risk") == "true" {
+		atRisk := make([]models.Project, 0)
+		for _, p := range projects {
+			if p.Forecast.Risk == "at_risk" || p.Forecast.Risk == "late" {
+				atRisk = append(atRisk, p)
+			}
+		}
+		projects = atRisk
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(projects)
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"task-manager/importers"
+	"task-manager/models"
+	"time"
+)
+
+// maxExternalImportSize bounds uploaded exports. Todoist backups and large
+// Trello boards are considerably bigger than typical CSV files.
+const maxExternalImportSize = 32 << 20
+
+func init() {
+	for _, source := range importers.Sources {
+		jobKinds["import:"+source] = runExternalImport
+	}
+}
+
+// ImportExternal accepts a Trello board, Todoist backup or Asana project
+// export as the multipart "file" field, with "source" naming the tool. The
+// file is checked and queued as a background job; poll /api/jobs?id= for
+// progress and the final report.
+func ImportExternal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxExternalImportSize); err != nil {
+		http.Error(w, "Expected a multipart upload of at most 32 MB", http.StatusBadRequest)
+		return
+	}
+	source := strings.ToLower(strings.TrimSpace(r.FormValue("source")))
+	if !containsString(importers.Sources, source) {
+		http.Error(w, "Source must be one of: "+strings.Join(importers.Sources, ", "), http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "File is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxExternalImportSize+1))
+	if err != nil || len(data) > maxExternalImportSize {
+		http.Error(w, "Expected a multipart upload of at most 32 MB", http.StatusBadRequest)
+		return
+	}
+
+	// Reject files that are not an export of the named tool now rather than
+	// as a failed job.
+	if _, err := importers.Parse(source, bytes.NewReader(data)); err != nil {
+		http.Error(w, "Invalid file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jobID, err := queueJob(r.Context(), userID, "import:"+source, data)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Queue import job error", "err", err)
+		http.Error(w, "Failed to start import", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "queued", "job_id": jobID})
+}
+
+// runExternalImport saves an export, one transaction per project. Items
+// carry their source ID as external_id: projects imported before are reused
+// and tasks imported before are skipped, so re-running an import (also after
+// a restart interrupted it) only adds what is new.
+func runExternalImport(ctx context.Context, job *runningJob) (interface{}, error) {
+	source := strings.TrimPrefix(job.kind, "import:")
+	export, err := importers.Parse(source, bytes.NewReader(job.payload))
+	if err != nil {
+		return nil, err
+	}
+	job.setTotal(export.Count())
+
+	report := &models.ExternalImportReport{Source: source, Skipped: make([]models.SkippedItem, 0)}
+	for _, s := range export.Skipped {
+		report.Skipped = append(report.Skipped, models.SkippedItem{Kind: s.Kind, Name: s.Name, Reason: s.Reason})
+	}
+
+	for _, project := range export.Projects {
+		if err := importExternalProject(ctx, job, source, project, report); err != nil {
+			return report, err
+		}
+		job.save(ctx)
+	}
+	job.advance(0, "Finished")
+	return report, nil
+}
+
+// externalImportChanges collects what a project's transaction created, to be
+// published once it commits.
+type externalImportChanges struct {
+	projectID      int64
+	projectCreated bool
+	tasks          []int64
+	notes          []int64
+}
+
+func importExternalProject(ctx context.Context, job *runningJob, source string, project importers.Project, report *models.ExternalImportReport) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var changes externalImportChanges
+	err = tx.QueryRowContext(ctx, "SELECT id FROM projects WHERE user_id = ? AND external_id = ?",
+		job.userID, project.ExternalID).Scan(&changes.projectID)
+	if err == sql.ErrNoRows {
+		now := time.Now()
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO projects (user_id, name, description, status, external_id, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			job.userID, project.Name, project.Description, project.Status, project.ExternalID, now, now)
+		if err != nil {
+			return fmt.Errorf("project %q: %v", project.Name, err)
+		}
+		changes.projectID, _ = res.LastInsertId()
+		changes.projectCreated = true
+		resolveLinksTo(ctx, tx, job.userID, "project", changes.projectID, project.Name)
+		report.ProjectsCreated++
+	} else if err != nil {
+		return err
+	} else {
+		report.ProjectsReused++
+	}
+	job.advance(1, "Importing "+project.Name)
+
+	for _, task := range project.Tasks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM tasks WHERE user_id = ? AND external_id = ?)",
+			job.userID, task.ExternalID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			report.Skipped = append(report.Skipped, models.SkippedItem{Kind: "task", Name: task.Title, Reason: "already imported"})
+			job.advance(1, "Importing "+project.Name)
+			continue
+		}
+
+		taskID, noteID, err := importExternalTask(ctx, tx, job.userID, source, changes.projectID, project.Name, task)
+		if err != nil {
+			return fmt.Errorf("task %q: %v", task.Title, err)
+		}
+		changes.tasks = append(changes.tasks, taskID)
+		report.TasksCreated++
+		if noteID != 0 {
+			changes.notes = append(changes.notes, noteID)
+			report.NotesCreated++
+		}
+		job.advance(1, "Importing "+project.Name)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	publishExternalImport(ctx, job.userID, changes)
+	return nil
+}
+
+// importExternalTask inserts a task with its tags and, when the export had
+// more to say about it, a note. It returns the new IDs; noteID is 0 when no
+// note was needed.
+func importExternalTask(ctx context.Context, tx *sql.Tx, userID int, source string, projectID int64, projectName string, task importers.Task) (int64, int64, error) {
+	var dueDate interface{}
+	if task.DueDate != "" {
+		dueDate = task.DueDate
+	}
+	now := time.Now()
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO tasks (user_id, project_id, description, priority, done, due_date, external_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, projectID, task.Title, task.Priority, task.Done, dueDate, task.ExternalID, now, now)
+	if err != nil {
+		return 0, 0, err
+	}
+	taskID, _ := res.LastInsertId()
+	if tags := normalizeTags(task.Tags); len(tags) > 0 {
+		if err := replaceTaskTags(ctx, tx, taskID, tags); err != nil {
+			return 0, 0, err
+		}
+	}
+	if task.Note == "" {
+		return taskID, 0, nil
+	}
+
+	content := fmt.Sprintf("%s\n\n---\nImported from %s%s: %s / %s",
+		task.Note, strings.ToUpper(source[:1]), source[1:], projectName, task.Title)
+	res, err = tx.ExecContext(ctx, `
+		INSERT INTO notes (user_id, title, content, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		userID, task.Title, content, now, now)
+	if err != nil {
+		return 0, 0, err
+	}
+	noteID, _ := res.LastInsertId()
+	if err := recordNoteRevision(ctx, tx, noteID, userID, task.Title, content, false); err != nil {
+		return 0, 0, err
+	}
+	if err := updateNoteLinks(ctx, tx, userID, noteID, content); err != nil {
+		return 0, 0, err
+	}
+	resolveLinksTo(ctx, tx, userID, "note", noteID, task.Title)
+	return taskID, noteID, nil
+}
+
+func publishExternalImport(ctx context.Context, userID int, changes externalImportChanges) {
+	if changes.projectCreated {
+		if project, err := loadProject(ctx, userID, changes.projectID); err == nil {
+			publish(ctx, userID, "project.created", project)
+		}
+	}
+	for _, id := range changes.tasks {
+		if task, err := loadTask(ctx, userID, id); err == nil {
+			publish(ctx, userID, "task.created", task)
+		}
+	}
+	for _, id := range changes.notes {
+		if note, err := loadNote(ctx, userID, id); err == nil {
+			publish(ctx, userID, "note.created", note)
+		}
+	}
+}
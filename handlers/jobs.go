@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"task-manager/models"
+	"task-manager/tracing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// jobFunc does the work of one background job. It reports progress through
+// the job and returns the value stored as the job's JSON result.
+type jobFunc func(ctx context.Context, job *runningJob) (interface{}, error)
+
+// jobKinds maps a job kind to the function that runs it.
+var jobKinds = map[string]jobFunc{}
+
+// runningJob is a job being worked on. Progress is kept in memory while the
+// job runs, because the work usually holds a write transaction, and is
+// written to the database between transactions and when the job finishes.
+type runningJob struct {
+	id      int
+	userID  int
+	kind    string
+	payload []byte
+
+	mu       sync.Mutex
+	progress int
+	total    int
+	message  string
+}
+
+// setTotal sets the number of steps the job expects to take.
+func (j *runningJob) setTotal(total int) {
+	j.mu.Lock()
+	j.total = total
+	j.mu.Unlock()
+}
+
+// advance adds n completed steps and replaces the status message.
+func (j *runningJob) advance(n int, message string) {
+	j.mu.Lock()
+	j.progress += n
+	j.message = message
+	j.mu.Unlock()
+}
+
+// save writes the current progress to the database. Call it outside any
+// transaction the job has open.
+func (j *runningJob) save(ctx context.Context) {
+	j.mu.Lock()
+	progress, total, message := j.progress, j.total, j.message
+	j.mu.Unlock()
+	if _, err := DB.ExecContext(ctx, "UPDATE background_jobs SET progress = ?, total = ?, message = ? WHERE id = ?",
+		progress, total, message, j.id); err != nil {
+		slog.ErrorContext(ctx, "Job progress error", "err", err)
+	}
+}
+
+// queueJob stores a job for the runner and returns its ID.
+func queueJob(ctx context.Context, userID int, kind string, payload []byte) (int64, error) {
+	if _, ok := jobKinds[kind]; !ok {
+		return 0, fmt.Errorf("unknown job kind %q", kind)
+	}
+	res, err := DB.ExecContext(ctx, `
+		INSERT INTO background_jobs (user_id, kind, status, payload, created_at)
+		VALUES (?, ?, 'queued', ?, ?)`,
+		userID, kind, payload, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	jobRunner.nudge()
+	return res.LastInsertId()
+}
+
+// backgroundJobs runs queued jobs one at a time per worker. Jobs live in the
+// database, so a job interrupted by a restart is picked up again.
+type backgroundJobs struct {
+	workers int
+	wake    chan struct{}
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mu      sync.Mutex
+	running map[int]*runningJob
+}
+
+var jobRunner = &backgroundJobs{
+	wake:    make(chan struct{}, 1),
+	running: make(map[int]*runningJob),
+}
+
+// StartBackgroundJobs starts workers that run queued jobs.
+func StartBackgroundJobs(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	jobRunner.workers = workers
+
+	// Anything left "running" was interrupted by a shutdown or crash. Job
+	// functions must be safe to run again from the start.
+	DB.ExecContext(context.Background(), "UPDATE background_jobs SET status = 'queued', progress = 0 WHERE status = 'running'")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	jobRunner.cancel = cancel
+	jobRunner.done = make(chan struct{})
+	go jobRunner.run(ctx)
+}
+
+// StopBackgroundJobs stops the workers, waiting for running jobs to notice.
+func StopBackgroundJobs() {
+	if jobRunner.cancel == nil {
+		return
+	}
+	jobRunner.cancel()
+	<-jobRunner.done
+}
+
+func (b *backgroundJobs) nudge() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (b *backgroundJobs) run(ctx context.Context) {
+	defer close(b.done)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for i := 0; i < b.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(10 * time.Second)
+			defer ticker.Stop()
+			for {
+				for ctx.Err() == nil && b.runNext(ctx) {
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-b.wake:
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+}
+
+// runNext claims the oldest queued job and runs it. It reports whether a
+// job was found.
+func (b *backgroundJobs) runNext(ctx context.Context) bool {
+	job := &runningJob{}
+	err := DB.QueryRowContext(ctx, `
+		SELECT id, user_id, kind, payload FROM background_jobs
+		WHERE status = 'queued' ORDER BY id LIMIT 1`).Scan(&job.id, &job.userID, &job.kind, &job.payload)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Job queue error", "err", err)
+		}
+		return false
+	}
+
+	// Another worker may have claimed the same job in the meantime.
+	res, err := DB.ExecContext(ctx, `
+		UPDATE background_jobs SET status = 'running', started_at = ?, progress = 0, message = ''
+		WHERE id = ? AND status = 'queued'`, time.Now().UTC(), job.id)
+	if err != nil {
+		slog.ErrorContext(ctx, "Job claim error", "err", err)
+		return false
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	b.running[job.id] = job
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.running, job.id)
+		b.mu.Unlock()
+	}()
+
+	spanCtx, span := tracing.Start(ctx, "job "+job.kind, attribute.Int("job.id", job.id))
+	result, err := b.execute(spanCtx, job)
+	tracing.End(span, err)
+	if ctx.Err() != nil {
+		// Shutting down: leave the job "running" so the next start re-queues it.
+		return false
+	}
+
+	job.mu.Lock()
+	progress, total, message := job.progress, job.total, job.message
+	job.mu.Unlock()
+	status, errText, resultJSON := "done", "", []byte(nil)
+	if err != nil {
+		status, errText = "failed", err.Error()
+		slog.ErrorContext(ctx, "Job failed", "job_id", job.id, "kind", job.kind, "err", err)
+	}
+	if result != nil {
+		resultJSON, _ = json.Marshal(result)
+	}
+	if _, err := DB.ExecContext(ctx, `
+		UPDATE background_jobs
+		SET status = ?, progress = ?, total = ?, message = ?, result = ?, error = ?, finished_at = ?, payload = NULL
+		WHERE id = ?`,
+		status, progress, total, message, nullableString(string(resultJSON)), nullableString(errText),
+		time.Now().UTC(), job.id); err != nil {
+		slog.ErrorContext(ctx, "Job finish error", "err", err)
+	}
+	return true
+}
+
+// execute runs the job's function, turning a panic into a failed job.
+func (b *backgroundJobs) execute(ctx context.Context, job *runningJob) (result interface{}, err error) {
+	fn, ok := jobKinds[job.kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown job kind %q", job.kind)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return fn(ctx, job)
+}
+
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+const jobSelect = `
+	SELECT id, kind, status, progress, total, message, COALESCE(result, ''), COALESCE(error, ''),
+	       created_at, started_at, finished_at
+	FROM background_jobs`
+
+func scanJob(row rowScanner) (models.Job, error) {
+	var job models.Job
+	var result string
+	var createdAt time.Time
+	var startedAt, finishedAt sql.NullTime
+	err := row.Scan(&job.ID, &job.Kind, &job.Status, &job.Progress, &job.Total, &job.Message,
+		&result, &job.Error, &createdAt, &startedAt, &finishedAt)
+	if err != nil {
+		return job, err
+	}
+	if result != "" {
+		job.Result = json.RawMessage(result)
+	}
+	job.CreatedAt = createdAt.Format(time.RFC3339)
+	if startedAt.Valid {
+		job.StartedAt = startedAt.Time.Format(time.RFC3339)
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = finishedAt.Time.Format(time.RFC3339)
+	}
+
+	// A running job's latest progress is only in memory.
+	jobRunner.mu.Lock()
+	running := jobRunner.running[job.ID]
+	jobRunner.mu.Unlock()
+	if running != nil && job.Status == "running" {
+		running.mu.Lock()
+		job.Progress, job.Total, job.Message = running.progress, running.total, running.message
+		running.mu.Unlock()
+	}
+	return job, nil
+}
+
+// Jobs returns the current user's most recent background jobs, or with
+// ?id= a single job including its result.
+func Jobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		job, err := scanJob(DB.QueryRowContext(r.Context(), jobSelect+" WHERE id = ? AND user_id = ?", id, userID))
+		if err == sql.ErrNoRows {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Load job error", "err", err)
+			http.Error(w, "Failed to retrieve job", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(job)
+		return
+	}
+
+	rows, err := DB.QueryContext(r.Context(), jobSelect+" WHERE user_id = ? ORDER BY id DESC LIMIT 50", userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List jobs error", "err", err)
+		http.Error(w, "Failed to retrieve jobs", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	jobs := make([]models.Job, 0)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Scan error", "err", err)
+			continue
+		}
+		// The list stays small; fetch a single job for its result.
+		job.Result = nil
+		jobs = append(jobs, job)
+	}
+	json.NewEncoder(w).Encode(jobs)
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"task-manager/markdown"
+	"task-manager/models"
+)
+
+// Wiki links resolve by title to a note, or failing that a project. Once
+// resolved, a link stays attached to its target by ID, so renaming the
+// target does not break links whose text still uses the old title. Broken
+// links are kept and resolve as soon as something with their title exists.
+
+// linkTarget is what a link points to.
+type linkTarget struct {
+	typ string
+	id  int64
+}
+
+// wikiTitles maps the title keys of the user's notes and projects to the
+// record a [[Title]] link with that key resolves to: the most recently
+// updated note, else the oldest project. Titles are compared in Go because
+// SQLite's LOWER only folds ASCII.
+func wikiTitles(ctx context.Context, db execQuerier, userID int) (map[string]linkTarget, error) {
+	titles := make(map[string]linkTarget)
+	for _, q := range []struct{ typ, query string }{
+		{"note", "SELECT id, title FROM notes WHERE user_id = ? ORDER BY updated_at DESC"},
+		{"project", "SELECT id, name FROM projects WHERE user_id = ? ORDER BY id"},
+	} {
+		rows, err := db.QueryContext(ctx, q.query, userID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			var title string
+			if err := rows.Scan(&id, &title); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if key := markdown.TitleKey(title); key != "" {
+				if _, taken := titles[key]; !taken {
+					titles[key] = linkTarget{q.typ, id}
+				}
+			}
+		}
+		rows.Close()
+	}
+	return titles, nil
+}
+
+// targetExists reports whether the user owns the given note, task or project.
+func targetExists(ctx context.Context, db execQuerier, userID int, targetType string, id int64) bool {
+	table := map[string]string{"note": "notes", "task": "tasks", "project": "projects"}[targetType]
+	if table == "" {
+		return false
+	}
+	var exists bool
+	db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM "+table+" WHERE id = ? AND user_id = ?)", id, userID).Scan(&exists)
+	return exists
+}
+
+// updateNoteLinks re-parses a note's content and replaces its outgoing
+// links.
+func updateNoteLinks(ctx context.Context, db execQuerier, userID int, noteID int64, content string) error {
+	previous := make(map[string]linkTarget)
+	rows, err := db.QueryContext(ctx, `
+		SELECT target_key, target_type, target_id FROM note_links
+		WHERE note_id = ? AND kind = 'wiki' AND target_id IS NOT NULL`, noteID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var key string
+		var t linkTarget
+		if err := rows.Scan(&key, &t.typ, &t.id); err != nil {
+			rows.Close()
+			return err
+		}
+		previous[key] = t
+	}
+	rows.Close()
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM note_links WHERE note_id = ?", noteID); err != nil {
+		return err
+	}
+
+	var titles map[string]linkTarget
+	for i, ref := range markdown.References(content) {
+		kind, key := "ref", fmt.Sprintf("%s-%d", ref.Type, ref.ID)
+		var targetType, targetID interface{}
+		if ref.Title != "" {
+			kind, key = "wiki", markdown.TitleKey(ref.Title)
+			t, ok := previous[key]
+			if !ok || !targetExists(ctx, db, userID, t.typ, t.id) {
+				if titles == nil {
+					if titles, err = wikiTitles(ctx, db, userID); err != nil {
+						return err
+					}
+				}
+				t, ok = titles[key]
+			}
+			if ok {
+				targetType, targetID = t.typ, t.id
+			}
+		} else {
+			targetType = ref.Type
+			if targetExists(ctx, db, userID, ref.Type, int64(ref.ID)) {
+				targetID = ref.ID
+			}
+		}
+		if _, err := db.ExecContext(ctx, `
+			INSERT INTO note_links (user_id, note_id, position, kind, text, target_key, target_type, target_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID, noteID, i, kind, ref.Text, key, targetType, targetID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveLinksTo attaches broken links to a note, task or project that was
+// just created or renamed: wiki links with its title, and for new records
+// "#type-id" references to it.
+func resolveLinksTo(ctx context.Context, db execQuerier, userID int, targetType string, id int64, title string) {
+	if _, err := db.ExecContext(ctx, `
+		UPDATE note_links SET target_type = ?, target_id = ?
+		WHERE user_id = ? AND target_id IS NULL
+		  AND ((kind = 'wiki' AND target_key = ? AND ? != 'task') OR (kind = 'ref' AND target_key = ?))`,
+		targetType, id, userID, markdown.TitleKey(title), targetType, fmt.Sprintf("%s-%d", targetType, id)); err != nil {
+		slog.Error("Resolve links error", "err", err)
+	}
+}
+
+// unlinkTarget breaks the links to a deleted note, task or project. Wiki
+// links move to another note or project with the same title if there is
+// one.
+func unlinkTarget(ctx context.Context, db execQuerier, userID int, targetType string, id int64) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT target_key FROM note_links
+		WHERE user_id = ? AND target_type = ? AND target_id = ? AND kind = 'wiki'`, userID, targetType, id)
+	if err != nil {
+		slog.Error("Unlink target error", "err", err)
+		return
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if rows.Scan(&key) == nil {
+			keys = append(keys, key)
+		}
+	}
+	rows.Close()
+
+	if _, err := db.ExecContext(ctx, `
+		UPDATE note_links SET target_id = NULL,
+		       target_type = CASE kind WHEN 'wiki' THEN NULL ELSE target_type END
+		WHERE user_id = ? AND target_type = ? AND target_id = ?`, userID, targetType, id); err != nil {
+		slog.Error("Unlink target error", "err", err)
+		return
+	}
+	if len(keys) == 0 {
+		return
+	}
+	titles, err := wikiTitles(ctx, db, userID)
+	if err != nil {
+		slog.Error("Unlink target error", "err", err)
+		return
+	}
+	for _, key := range keys {
+		if t, ok := titles[key]; ok {
+			db.ExecContext(ctx, `
+				UPDATE note_links SET target_type = ?, target_id = ?
+				WHERE user_id = ? AND kind = 'wiki' AND target_key = ? AND target_id IS NULL`,
+				t.typ, t.id, userID, key)
+		}
+	}
+}
+
+const linkSelect = `
+	SELECT l.note_id, COALESCE(sn.title, ''), l.kind, l.text, COALESCE(l.target_type, ''), l.target_id,
+	       COALESCE(tn.title, tt.description, tp.name, '')
+	FROM note_links l
+	JOIN notes sn ON sn.id = l.note_id
+	LEFT JOIN notes tn ON l.target_type = 'note' AND tn.id = l.target_id
+	LEFT JOIN tasks tt ON l.target_type = 'task' AND tt.id = l.target_id
+	LEFT JOIN projects tp ON l.target_type = 'project' AND tp.id = l.target_id`
+
+func queryLinks(ctx context.Context, query string, args ...interface{}) ([]models.Link, error) {
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]models.Link, 0)
+	for rows.Next() {
+		l := models.Link{SourceType: "note"}
+		var targetID sql.NullInt64
+		if err := rows.Scan(&l.SourceID, &l.SourceTitle, &l.Kind, &l.Text, &l.TargetType, &targetID, &l.TargetTitle); err != nil {
+			return nil, err
+		}
+		if targetID.Valid {
+			id := int(targetID.Int64)
+			l.TargetID = &id
+		} else {
+			l.Broken = true
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// Links returns the outgoing links of a note and the backlinks of a note,
+// task or project, given as ?type=note|task|project&id=.
+func Links(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	targetType := r.URL.Query().Get("type")
+	var id int64
+	if _, err := fmt.Sscan(r.URL.Query().Get("id"), &id); err != nil {
+		http.Error(w, "ID required", http.StatusBadRequest)
+		return
+	}
+	if targetType != "note" && targetType != "task" && targetType != "project" {
+		http.Error(w, "type must be note, task or project", http.StatusBadRequest)
+		return
+	}
+	if !targetExists(r.Context(), DB, userID, targetType, id) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	outgoing := make([]models.Link, 0)
+	if targetType == "note" {
+		outgoing, err = queryLinks(r.Context(), linkSelect+" WHERE l.note_id = ? AND l.user_id = ? ORDER BY l.position", id, userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "List links error", "err", err)
+			http.Error(w, "Failed to retrieve links", http.StatusInternalServerError)
+			return
+		}
+	}
+	backlinks, err := queryLinks(r.Context(), linkSelect+`
+		WHERE l.user_id = ? AND l.target_type = ? AND l.target_id = ?
+		ORDER BY sn.updated_at DESC, l.position`, userID, targetType, id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List backlinks error", "err", err)
+		http.Error(w, "Failed to retrieve links", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"outgoing":  outgoing,
+		"backlinks": backlinks,
+	})
+}
+
+// BrokenLinks lists every link in the user's notes whose target does not
+// exist.
+func BrokenLinks(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	links, err := queryLinks(r.Context(), linkSelect+" WHERE l.user_id = ? AND l.target_id IS NULL ORDER BY sn.title, l.position", userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List broken links error", "err", err)
+		http.Error(w, "Failed to retrieve links", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"task-manager/markdown"
+	"time"
+)
+
+// PreviewNote renders the "content" form value the way a saved note would
+// be rendered, for live previews while editing.
+func PreviewNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, err := GetCurrentUserID(r); err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"content_html": markdown.Render(r.FormValue("content"))})
+}
+
+// ToggleNoteTask checks or unchecks a GFM task list item ("- [ ] ...") in a
+// note by rewriting its source. "index" counts the note's task items from 0
+// in the order their checkboxes appear in content_html; "checked" is "true"
+// or "false", and when omitted the item is flipped. A task created from the
+// item is completed or reopened to match. The updated note is returned.
+func ToggleNoteTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Note ID required", http.StatusBadRequest)
+		return
+	}
+	index, err := strconv.Atoi(r.FormValue("index"))
+	if err != nil {
+		http.Error(w, "Task index required", http.StatusBadRequest)
+		return
+	}
+
+	note, err := loadNote(r.Context(), userID, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Load note error", "err", err)
+		http.Error(w, "Failed to update note", http.StatusInternalServerError)
+		return
+	}
+	if note.Encrypted {
+		http.Error(w, "Checklists in private notes are edited in the note itself", http.StatusConflict)
+		return
+	}
+
+	var checked bool
+	if v := r.FormValue("checked"); v != "" {
+		if checked, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "checked must be true or false", http.StatusBadRequest)
+			return
+		}
+	} else {
+		current, err := markdown.TaskChecked(note.Content, index)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		checked = !current
+	}
+
+	content, err := markdown.SetTask(note.Content, index, checked)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only write if nobody changed the note since it was read, so a toggle
+	// never overwrites a concurrent edit.
+	res, err := DB.ExecContext(r.Context(), `
+		UPDATE notes SET content = ?, updated_at = ?
+		WHERE id = ? AND user_id = ? AND COALESCE(content, '') = ?`,
+		content, time.Now(), id, userID, note.Content)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Toggle note task error", "err", err)
+		http.Error(w, "Failed to update note", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "The note was changed by someone else; reload and try again", http.StatusConflict)
+		return
+	}
+
+	note, err = loadNote(r.Context(), userID, id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Load note error", "err", err)
+		http.Error(w, "Failed to update note", http.StatusInternalServerError)
+		return
+	}
+	if err := recordNoteRevision(r.Context(), DB, id, userID, note.Title, note.Content, true); err != nil {
+		slog.ErrorContext(r.Context(), "Record note revision error", "err", err)
+	}
+	publish(r.Context(), userID, "note.updated", note)
+	syncItemTasks(r.Context(), userID, id, note.Content)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(note)
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"task-manager/events"
+	"task-manager/metrics"
+)
+
+// The server's own metrics. Counters follow the events bus, so they count
+// changes made through any route, import or sync; gauges are read from the
+// database when scraped.
+
+var (
+	tasksCreated = metrics.Default.NewCounterVec("tasklift_tasks_created_total",
+		"Tasks created since the server started.")
+	tasksCompleted = metrics.Default.NewCounterVec("tasklift_tasks_completed_total",
+		"Tasks marked done since the server started.")
+	eventsPublished = metrics.Default.NewCounterVec("tasklift_events_published_total",
+		"Change events published to webhooks, streams and other listeners, by type.", "type")
+)
+
+func init() {
+	Events.Subscribe(func(_ context.Context, e events.Event) {
+		eventsPublished.Inc(e.Type)
+		switch e.Type {
+		case "task.created":
+			tasksCreated.Inc()
+		case "task.completed":
+			tasksCompleted.Inc()
+		}
+	})
+
+	metrics.Default.NewGaugeFunc("tasklift_background_jobs",
+		"Background jobs waiting or running, by kind and status.", []string{"kind", "status"},
+		func(ctx context.Context, emit func(float64, ...string)) {
+			countRows(ctx, `SELECT kind, status, COUNT(*) FROM background_jobs
+				WHERE status IN ('queued', 'running') GROUP BY kind, status`, emit)
+		})
+	metrics.Default.NewGaugeFunc("tasklift_webhook_deliveries",
+		"Webhook deliveries waiting to be sent or being sent, by status.", []string{"status"},
+		func(ctx context.Context, emit func(float64, ...string)) {
+			countRows(ctx, `SELECT status, COUNT(*) FROM webhook_deliveries
+				WHERE status IN ('pending', 'sending') GROUP BY status`, emit)
+		})
+	metrics.Default.NewGaugeFunc("tasklift_tasks",
+		"Tasks stored, by state.", []string{"state"},
+		func(ctx context.Context, emit func(float64, ...string)) {
+			countRows(ctx, `SELECT CASE WHEN done THEN 'done' ELSE 'open' END, COUNT(*) FROM tasks GROUP BY 1`, emit)
+		})
+	metrics.Default.NewGaugeFunc("tasklift_users",
+		"Registered users.", nil,
+		func(ctx context.Context, emit func(float64, ...string)) {
+			countRows(ctx, "SELECT COUNT(*) FROM users", emit)
+		})
+}
+
+// countRows emits a row's last column as the value and the others as the
+// label values, for every row query returns.
+func countRows(ctx context.Context, query string, emit func(float64, ...string)) {
+	rows, err := DB.QueryContext(ctx, query)
+	if err != nil {
+		slog.Error("Metrics query error", "err", err)
+		return
+	}
+	defer rows.Close()
+	columns, _ := rows.Columns()
+	for rows.Next() {
+		labels := make([]string, len(columns)-1)
+		var value float64
+		dest := make([]interface{}, len(columns))
+		for i := range labels {
+			dest[i] = &labels[i]
+		}
+		dest[len(labels)] = &value
+		if err := rows.Scan(dest...); err != nil {
+			slog.Error("Metrics query error", "err", err)
+			return
+		}
+		emit(value, labels...)
+	}
+}
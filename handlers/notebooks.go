@@ -0,0 +1,704 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"task-manager/models"
+	"time"
+)
+
+// noteOrders maps a notebook's sort order to the ORDER BY clause listing its
+// notes. Pinned notes always come first.
+var noteOrders = map[string]string{
+	"updated": "updated_at DESC, id DESC",
+	"created": "created_at DESC, id DESC",
+	"title":   "title COLLATE NOCASE, id",
+	"manual":  "position, id",
+}
+
+func notebookSortOrder(ctx context.Context, db execQuerier, userID int, id int64) (string, error) {
+	var sortOrder string
+	err := db.QueryRowContext(ctx, "SELECT sort_order FROM notebooks WHERE id = ? AND user_id = ?", id, userID).Scan(&sortOrder)
+	return sortOrder, err
+}
+
+// nextNotePosition is the position that puts a note last in a notebook
+// sorted manually.
+func nextNotePosition(ctx context.Context, db execQuerier, userID int, notebookID interface{}) int {
+	var position int
+	db.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(position) + 1, 0) FROM notes
+		WHERE user_id = ? AND notebook_id IS ?`, userID, notebookID).Scan(&position)
+	return position
+}
+
+// noteNotebookFormValue checks a notebook_id form value. It returns nil for
+// no notebook, and the position that adds a note at the end of the notebook.
+func noteNotebookFormValue(ctx context.Context, userID int, value string) (interface{}, int, error) {
+	if value == "" || value == "0" {
+		return nil, nextNotePosition(ctx, DB, userID, nil), nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Invalid notebook ID")
+	}
+	if _, err := notebookSortOrder(ctx, DB, userID, id); err != nil {
+		return nil, 0, fmt.Errorf("Notebook not found")
+	}
+	return id, nextNotePosition(ctx, DB, userID, id), nil
+}
+
+// noteProjectFormValue checks a project_id form value; nil means no project.
+func noteProjectFormValue(ctx context.Context, userID int, value string) (interface{}, error) {
+	if value == "" || value == "0" {
+		return nil, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid project ID")
+	}
+	if !targetExists(ctx, DB, userID, "project", id) {
+		return nil, fmt.Errorf("Project not found")
+	}
+	return id, nil
+}
+
+// deleteNoteData removes what belongs to a deleted note: its revisions and
+// links, and links to it from other notes become broken. Tasks created from
+// the note stay.
+func deleteNoteData(ctx context.Context, db execQuerier, userID int, noteID int64) {
+	db.ExecContext(ctx, "DELETE FROM note_revisions WHERE note_id = ?", noteID)
+	db.ExecContext(ctx, "DELETE FROM note_links WHERE note_id = ?", noteID)
+	db.ExecContext(ctx, `UPDATE tasks SET source_note_id = NULL, source_item_index = NULL, source_item_text = NULL
+		WHERE source_note_id = ? AND user_id = ?`, noteID, userID)
+	unlinkTarget(ctx, db, userID, "note", noteID)
+}
+
+// renumberNotes stores a notebook's current order as note positions, so
+// switching it to manual order starts from what the user saw.
+func renumberNotes(ctx context.Context, db execQuerier, userID int, notebookID int64, order string) error {
+	rows, err := db.QueryContext(ctx, "SELECT id FROM notes WHERE user_id = ? AND notebook_id = ? ORDER BY pinned DESC, "+order,
+		userID, notebookID)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for i, id := range ids {
+		if _, err := db.ExecContext(ctx, "UPDATE notes SET position = ? WHERE id = ?", i, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListNotebooks returns the user's notebooks as a tree, each with the number
+// of notes directly in it. Notebooks are sorted by name.
+func ListNotebooks(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := DB.QueryContext(r.Context(), `
+		SELECT b.id, b.parent_id, b.name, b.sort_order, b.created_at, b.updated_at,
+		       (SELECT COUNT(*) FROM notes n WHERE n.notebook_id = b.id)
+		FROM notebooks b
+		WHERE b.user_id = ?`, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List notebooks error", "err", err)
+		http.Error(w, "Failed to retrieve notebooks", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var all []models.Notebook
+	for rows.Next() {
+		var b models.Notebook
+		var parentID sql.NullInt64
+		var createdAt, updatedAt time.Time
+		if err := rows.Scan(&b.ID, &parentID, &b.Name, &b.SortOrder, &createdAt, &updatedAt, &b.NoteCount); err != nil {
+			slog.ErrorContext(r.Context(), "Scan error", "err", err)
+			continue
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			b.ParentID = &id
+		}
+		b.CreatedAt = createdAt.Format(time.RFC3339)
+		b.UpdatedAt = updatedAt.Format(time.RFC3339)
+		all = append(all, b)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notebookTree(all))
+}
+
+// notebookTree nests notebooks under their parents. A notebook whose parent
+// is missing is listed at the top level.
+func notebookTree(all []models.Notebook) []models.Notebook {
+	children := make(map[int][]models.Notebook)
+	known := make(map[int]bool)
+	for _, b := range all {
+		known[b.ID] = true
+	}
+	for _, b := range all {
+		parent := 0
+		if b.ParentID != nil && known[*b.ParentID] {
+			parent = *b.ParentID
+		}
+		children[parent] = append(children[parent], b)
+	}
+
+	var build func(parent int) []models.Notebook
+	build = func(parent int) []models.Notebook {
+		list := children[parent]
+		sort.Slice(list, func(i, j int) bool {
+			return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+		})
+		out := make([]models.Notebook, 0, len(list))
+		for _, b := range list {
+			b.Children = build(b.ID)
+			out = append(out, b)
+		}
+		return out
+	}
+	return build(0)
+}
+
+// notebookParentFormValue checks a parent_id form value for notebook id (0
+// for a new notebook): the parent must exist and must not be the notebook
+// itself or one of its descendants.
+func notebookParentFormValue(ctx context.Context, userID int, id int64, value string) (interface{}, error) {
+	if value == "" || value == "0" {
+		return nil, nil
+	}
+	parentID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid parent ID")
+	}
+	for ancestor := parentID; ; {
+		if ancestor == id {
+			return nil, fmt.Errorf("A notebook cannot be moved into itself")
+		}
+		var next sql.NullInt64
+		err := DB.QueryRowContext(ctx, "SELECT parent_id FROM notebooks WHERE id = ? AND user_id = ?", ancestor, userID).Scan(&next)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("Parent notebook not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !next.Valid {
+			return parentID, nil
+		}
+		ancestor = next.Int64
+	}
+}
+
+// CreateNotebook creates a notebook from "name", with optional "parent_id"
+// and "sort_order" (updated, created, title or manual; default updated).
+func CreateNotebook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Notebook name required", http.StatusBadRequest)
+		return
+	}
+	sortOrder := r.FormValue("sort_order")
+	if sortOrder == "" {
+		sortOrder = "updated"
+	}
+	if _, ok := noteOrders[sortOrder]; !ok {
+		http.Error(w, "sort_order must be updated, created, title or manual", http.StatusBadRequest)
+		return
+	}
+	parentID, err := notebookParentFormValue(r.Context(), userID, 0, r.FormValue("parent_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	res, err := DB.ExecContext(r.Context(), `
+		INSERT INTO notebooks (user_id, parent_id, name, sort_order, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, parentID, name, sortOrder, now, now)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Create notebook error", "err", err)
+		http.Error(w, "Failed to create notebook", http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "created", "id": id})
+}
+
+// UpdateNotebook renames a notebook, moves it under another ("parent_id", 0
+// for the top level) or changes its sort order. Fields left out of the form
+// are kept.
+func UpdateNotebook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Notebook ID required", http.StatusBadRequest)
+		return
+	}
+	current, err := notebookSortOrder(r.Context(), DB, userID, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Notebook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Load notebook error", "err", err)
+		http.Error(w, "Failed to update notebook", http.StatusInternalServerError)
+		return
+	}
+
+	sets, args := []string{"updated_at = ?"}, []interface{}{time.Now()}
+	if _, ok := r.Form["name"]; ok {
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			http.Error(w, "Notebook name required", http.StatusBadRequest)
+			return
+		}
+		sets, args = append(sets, "name = ?"), append(args, name)
+	}
+	if _, ok := r.Form["parent_id"]; ok {
+		parentID, err := notebookParentFormValue(r.Context(), userID, id, r.FormValue("parent_id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sets, args = append(sets, "parent_id = ?"), append(args, parentID)
+	}
+	sortOrder := r.FormValue("sort_order")
+	if sortOrder != "" {
+		if _, ok := noteOrders[sortOrder]; !ok {
+			http.Error(w, "sort_order must be updated, created, title or manual", http.StatusBadRequest)
+			return
+		}
+		sets, args = append(sets, "sort_order = ?"), append(args, sortOrder)
+	}
+
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Failed to update notebook", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if sortOrder == "manual" && current != "manual" {
+		if err := renumberNotes(r.Context(), tx, userID, id, noteOrders[current]); err != nil {
+			slog.ErrorContext(r.Context(), "Update notebook error", "err", err)
+			http.Error(w, "Failed to update notebook", http.StatusInternalServerError)
+			return
+		}
+	}
+	if _, err := tx.ExecContext(r.Context(), "UPDATE notebooks SET "+strings.Join(sets, ", ")+" WHERE id = ? AND user_id = ?",
+		append(args, id, userID)...); err != nil {
+		slog.ErrorContext(r.Context(), "Update notebook error", "err", err)
+		http.Error(w, "Failed to update notebook", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update notebook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
+
+// DeleteNotebook deletes a notebook. Its sub-notebooks move up to its
+// parent. A notebook that still holds notes is only deleted when the request
+// says what happens to them: "move_to" names the notebook they move to (0
+// for no notebook), or delete_notes=true deletes them. Otherwise the
+// response is 409 with the number of notes, so the client can ask the user.
+func DeleteNotebook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Notebook ID required", http.StatusBadRequest)
+		return
+	}
+	var parentID sql.NullInt64
+	err = DB.QueryRowContext(r.Context(), "SELECT parent_id FROM notebooks WHERE id = ? AND user_id = ?", id, userID).Scan(&parentID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Notebook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Load notebook error", "err", err)
+		http.Error(w, "Failed to delete notebook", http.StatusInternalServerError)
+		return
+	}
+
+	var noteIDs []int64
+	rows, err := DB.QueryContext(r.Context(), "SELECT id FROM notes WHERE notebook_id = ? AND user_id = ? ORDER BY position, id", id, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Delete notebook error", "err", err)
+		http.Error(w, "Failed to delete notebook", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var noteID int64
+		if rows.Scan(&noteID) == nil {
+			noteIDs = append(noteIDs, noteID)
+		}
+	}
+	rows.Close()
+
+	moveTo, hasMoveTo := r.Form["move_to"]
+	deleteNotes := r.FormValue("delete_notes") == "true"
+	var target interface{}
+	if len(noteIDs) > 0 {
+		switch {
+		case deleteNotes:
+		case hasMoveTo:
+			if moveTo[0] == strconv.FormatInt(id, 10) {
+				http.Error(w, "Notes cannot be moved into the notebook being deleted", http.StatusBadRequest)
+				return
+			}
+			if target, _, err = noteNotebookFormValue(r.Context(), userID, moveTo[0]); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "Notebook is not empty; give move_to or delete_notes=true",
+				"notes": len(noteIDs),
+			})
+			return
+		}
+	}
+
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Failed to delete notebook", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(r.Context(), "UPDATE notebooks SET parent_id = ? WHERE parent_id = ? AND user_id = ?",
+		parentID, id, userID); err != nil {
+		slog.ErrorContext(r.Context(), "Delete notebook error", "err", err)
+		http.Error(w, "Failed to delete notebook", http.StatusInternalServerError)
+		return
+	}
+	for _, noteID := range noteIDs {
+		if deleteNotes {
+			if _, err = tx.ExecContext(r.Context(), "DELETE FROM notes WHERE id = ?", noteID); err == nil {
+				deleteNoteData(r.Context(), tx, userID, noteID)
+			}
+		} else {
+			_, err = tx.ExecContext(r.Context(), "UPDATE notes SET notebook_id = ?, position = ? WHERE id = ?",
+				target, nextNotePosition(r.Context(), tx, userID, target), noteID)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Delete notebook error", "err", err)
+			http.Error(w, "Failed to delete notebook", http.StatusInternalServerError)
+			return
+		}
+	}
+	if _, err := tx.ExecContext(r.Context(), "DELETE FROM notebooks WHERE id = ? AND user_id = ?", id, userID); err != nil {
+		slog.ErrorContext(r.Context(), "Delete notebook error", "err", err)
+		http.Error(w, "Failed to delete notebook", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to delete notebook", http.StatusInternalServerError)
+		return
+	}
+
+	for _, noteID := range noteIDs {
+		if deleteNotes {
+			publish(r.Context(), userID, "note.deleted", map[string]int64{"id": noteID})
+		} else if note, err := loadNote(r.Context(), userID, noteID); err == nil {
+			publish(r.Context(), userID, "note.updated", note)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "deleted", "notes": len(noteIDs)})
+}
+
+// ReorderNotebook sets the manual order of a notebook's notes from
+// "note_ids", a comma-separated list; notes not in the list keep their
+// relative order after those that are. The notebook switches to manual
+// order.
+func ReorderNotebook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Notebook ID required", http.StatusBadRequest)
+		return
+	}
+	current, err := notebookSortOrder(r.Context(), DB, userID, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Notebook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Load notebook error", "err", err)
+		http.Error(w, "Failed to reorder notes", http.StatusInternalServerError)
+		return
+	}
+	var order []int64
+	for _, s := range strings.Split(r.FormValue("note_ids"), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		noteID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			http.Error(w, "note_ids must be a comma-separated list of note IDs", http.StatusBadRequest)
+			return
+		}
+		order = append(order, noteID)
+	}
+
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Failed to reorder notes", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Start from the order the notebook is shown in, then move the listed
+	// notes to the front.
+	if err := renumberNotes(r.Context(), tx, userID, id, noteOrders[current]); err != nil {
+		slog.ErrorContext(r.Context(), "Reorder notes error", "err", err)
+		http.Error(w, "Failed to reorder notes", http.StatusInternalServerError)
+		return
+	}
+	for i, noteID := range order {
+		if _, err := tx.ExecContext(r.Context(), "UPDATE notes SET position = ? WHERE id = ? AND notebook_id = ? AND user_id = ?",
+			i-len(order), noteID, id, userID); err != nil {
+			slog.ErrorContext(r.Context(), "Reorder notes error", "err", err)
+			http.Error(w, "Failed to reorder notes", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := renumberNotes(r.Context(), tx, userID, id, noteOrders["manual"]); err != nil {
+		slog.ErrorContext(r.Context(), "Reorder notes error", "err", err)
+		http.Error(w, "Failed to reorder notes", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(), "UPDATE notebooks SET sort_order = 'manual', updated_at = ? WHERE id = ?", time.Now(), id); err != nil {
+		slog.ErrorContext(r.Context(), "Reorder notes error", "err", err)
+		http.Error(w, "Failed to reorder notes", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to reorder notes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "reordered"})
+}
+
+// noteIDsFormValue reads one or more note IDs from "id", which may be
+// repeated or comma-separated.
+func noteIDsFormValue(r *http.Request) ([]int64, error) {
+	r.ParseForm()
+	var ids []int64
+	for _, v := range r.Form["id"] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no note IDs")
+	}
+	return ids, nil
+}
+
+// updateNotesField sets one organising field on the given notes and
+// publishes the updated notes. It does not touch updated_at: filing or
+// pinning a note does not change it.
+func updateNotesField(ctx context.Context, w http.ResponseWriter, userID int, ids []int64, set string, args ...interface{}) {
+	updated := 0
+	for _, id := range ids {
+		res, err := DB.ExecContext(ctx, "UPDATE notes SET "+set+" WHERE id = ? AND user_id = ?", append(args, id, userID)...)
+		if err != nil {
+			slog.Error("Update note error", "err", err)
+			http.Error(w, "Failed to update note", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			updated++
+			if note, err := loadNote(ctx, userID, id); err == nil {
+				publish(ctx, userID, "note.updated", note)
+			}
+		}
+	}
+	if updated == 0 {
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "updated", "notes": updated})
+}
+
+// MoveNotes files the notes in "id" into "notebook_id" (0 for no notebook),
+// at the end of its manual order.
+func MoveNotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ids, err := noteIDsFormValue(r)
+	if err != nil {
+		http.Error(w, "Note ID required", http.StatusBadRequest)
+		return
+	}
+	notebookID, position, err := noteNotebookFormValue(r.Context(), userID, r.FormValue("notebook_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	updated := 0
+	for _, id := range ids {
+		res, err := DB.ExecContext(r.Context(), "UPDATE notes SET notebook_id = ?, position = ? WHERE id = ? AND user_id = ? AND notebook_id IS NOT ?",
+			notebookID, position+updated, id, userID, notebookID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Move note error", "err", err)
+			http.Error(w, "Failed to move notes", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			updated++
+			if note, err := loadNote(r.Context(), userID, id); err == nil {
+				publish(r.Context(), userID, "note.updated", note)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "moved", "notes": updated})
+}
+
+// PinNote pins the notes in "id" to the top of their lists, or unpins them
+// with pinned=false.
+func PinNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ids, err := noteIDsFormValue(r)
+	if err != nil {
+		http.Error(w, "Note ID required", http.StatusBadRequest)
+		return
+	}
+	pinned := r.FormValue("pinned") != "false"
+	updateNotesField(r.Context(), w, userID, ids, "pinned = ?", pinned)
+}
+
+// SetNoteProject attaches the notes in "id" to "project_id", or detaches
+// them with project_id=0. A project's notes are listed by
+// /api/notes?project_id=.
+func SetNoteProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ids, err := noteIDsFormValue(r)
+	if err != nil {
+		http.Error(w, "Note ID required", http.StatusBadRequest)
+		return
+	}
+	projectID, err := noteProjectFormValue(r.Context(), userID, r.FormValue("project_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	updateNotesField(r.Context(), w, userID, ids, "project_id = ?", projectID)
+}
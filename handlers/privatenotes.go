@@ -0,0 +1,515 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"task-manager/markdown"
+	"task-manager/models"
+	"task-manager/notecrypt"
+	"time"
+)
+
+// Private notes are encrypted at rest with the user's data key (see package
+// notecrypt). Unlocking with the passphrase keeps the data key in memory for
+// the browser session that unlocked, named by the note_unlock cookie, until
+// it is locked, the user logs out or noteUnlockIdle passes without use. The
+// key is never written to disk unwrapped.
+//
+// Encrypted notes keep no revisions and no links, and their content is left
+// out of events and webhooks. Titles stay readable so that lists and
+// [[links]] to the note still work.
+
+const (
+	noteUnlockCookie      = "note_unlock"
+	noteUnlockIdle        = 15 * time.Minute
+	minNotePassphraseSize = 10
+)
+
+var errNotesLocked = errors.New("private notes are locked")
+
+type unlockedNotes struct {
+	userID  int
+	key     []byte
+	expires time.Time
+}
+
+var noteSessions = struct {
+	sync.Mutex
+	m map[string]*unlockedNotes
+}{m: make(map[string]*unlockedNotes)}
+
+// noteKeyDerivations bounds concurrent Argon2id runs, which take 64 MiB
+// each.
+var noteKeyDerivations = make(chan struct{}, 2)
+
+func deriveNoteKey(passphrase string, salt []byte, p notecrypt.Params) []byte {
+	noteKeyDerivations <- struct{}{}
+	defer func() { <-noteKeyDerivations }()
+	return notecrypt.DeriveKey(passphrase, salt, p)
+}
+
+// noteKeyRecord is a row of note_keys.
+type noteKeyRecord struct {
+	salt, wrapped []byte
+	params        notecrypt.Params
+}
+
+func loadNoteKeyRecord(ctx context.Context, db execQuerier, userID int) (noteKeyRecord, error) {
+	var k noteKeyRecord
+	err := db.QueryRowContext(ctx, `
+		SELECT salt, argon_time, argon_memory, argon_threads, wrapped_key
+		FROM note_keys WHERE user_id = ?`, userID).
+		Scan(&k.salt, &k.params.Time, &k.params.Memory, &k.params.Threads, &k.wrapped)
+	return k, err
+}
+
+// unlockedNoteKey returns a copy of the user's data key if this session has
+// unlocked it, extending the session, or nil.
+func unlockedNoteKey(r *http.Request, userID int) []byte {
+	cookie, err := r.Cookie(noteUnlockCookie)
+	if err != nil {
+		return nil
+	}
+	noteSessions.Lock()
+	defer noteSessions.Unlock()
+
+	now := time.Now()
+	for token, s := range noteSessions.m {
+		if now.After(s.expires) {
+			notecrypt.Zero(s.key)
+			delete(noteSessions.m, token)
+		}
+	}
+	s, ok := noteSessions.m[cookie.Value]
+	if !ok || s.userID != userID {
+		return nil
+	}
+	s.expires = now.Add(noteUnlockIdle)
+	return append([]byte(nil), s.key...)
+}
+
+func unlockNotes(w http.ResponseWriter, r *http.Request, userID int, key []byte) time.Time {
+	expires := time.Now().Add(noteUnlockIdle)
+	token := randomHex(32)
+
+	noteSessions.Lock()
+	noteSessions.m[token] = &unlockedNotes{userID: userID, key: key, expires: expires}
+	noteSessions.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     noteUnlockCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   SecureCookies || r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return expires
+}
+
+// lockNotes forgets the key unlocked by this session.
+func lockNotes(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(noteUnlockCookie); err == nil {
+		noteSessions.Lock()
+		if s, ok := noteSessions.m[cookie.Value]; ok {
+			notecrypt.Zero(s.key)
+			delete(noteSessions.m, cookie.Value)
+		}
+		noteSessions.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     noteUnlockCookie,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Now().Add(-1 * time.Hour),
+		HttpOnly: true,
+		Secure:   SecureCookies || r.TLS != nil,
+	})
+}
+
+// lockUserNotes forgets every session's key of a user.
+func lockUserNotes(userID int) {
+	noteSessions.Lock()
+	defer noteSessions.Unlock()
+	for token, s := range noteSessions.m {
+		if s.userID == userID {
+			notecrypt.Zero(s.key)
+			delete(noteSessions.m, token)
+		}
+	}
+}
+
+// revealNote decrypts an encrypted note loaded by loadNote.
+func revealNote(note *models.Note, key []byte) error {
+	content, err := notecrypt.Open(key, note.Ciphertext)
+	if err != nil {
+		return err
+	}
+	note.Content, note.Locked = content, false
+	note.ContentHTML = markdown.Render(content)
+	return nil
+}
+
+// sealNote encrypts content for an encrypted note, or fails with
+// errNotesLocked.
+func sealNote(r *http.Request, userID int, content string) (string, error) {
+	key := unlockedNoteKey(r, userID)
+	if key == nil {
+		return "", errNotesLocked
+	}
+	defer notecrypt.Zero(key)
+	return notecrypt.Seal(key, content)
+}
+
+// NoteEncryption reports whether the user has set up private notes and
+// whether this session has unlocked them.
+func NoteEncryption(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var status models.NoteEncryption
+	_, err = loadNoteKeyRecord(r.Context(), DB, userID)
+	if err != nil && err != sql.ErrNoRows {
+		slog.ErrorContext(r.Context(), "Load note key error", "err", err)
+		http.Error(w, "Failed to load encryption status", http.StatusInternalServerError)
+		return
+	}
+	status.Enabled = err == nil
+	if key := unlockedNoteKey(r, userID); key != nil {
+		notecrypt.Zero(key)
+		status.Unlocked = true
+		status.ExpiresAt = time.Now().Add(noteUnlockIdle).UTC().Format(time.RFC3339)
+	}
+	DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM notes WHERE user_id = ? AND encrypted = 1", userID).Scan(&status.Notes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// SetupNoteEncryption creates the user's data key, protected by
+// "passphrase", and unlocks it for this session. The passphrase cannot be
+// recovered: notes encrypted under a forgotten passphrase are lost.
+func SetupNoteEncryption(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	passphrase := r.FormValue("passphrase")
+	if len(passphrase) < minNotePassphraseSize {
+		http.Error(w, "Passphrase must be at least "+strconv.Itoa(minNotePassphraseSize)+" characters", http.StatusBadRequest)
+		return
+	}
+	if _, err := loadNoteKeyRecord(r.Context(), DB, userID); err != sql.ErrNoRows {
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Load note key error", "err", err)
+			http.Error(w, "Failed to set up private notes", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Private notes are already set up", http.StatusConflict)
+		return
+	}
+
+	key, err := notecrypt.NewKey()
+	if err != nil {
+		http.Error(w, "Failed to set up private notes", http.StatusInternalServerError)
+		return
+	}
+	salt, err := notecrypt.NewSalt()
+	if err != nil {
+		http.Error(w, "Failed to set up private notes", http.StatusInternalServerError)
+		return
+	}
+	params := notecrypt.DefaultParams
+	kek := deriveNoteKey(passphrase, salt, params)
+	wrapped, err := notecrypt.Wrap(kek, key)
+	notecrypt.Zero(kek)
+	if err != nil {
+		http.Error(w, "Failed to set up private notes", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	if _, err := DB.ExecContext(r.Context(), `
+		INSERT INTO note_keys (user_id, salt, argon_time, argon_memory, argon_threads, wrapped_key, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, salt, params.Time, params.Memory, params.Threads, wrapped, now, now); err != nil {
+		slog.ErrorContext(r.Context(), "Set up note encryption error", "err", err)
+		http.Error(w, "Failed to set up private notes", http.StatusInternalServerError)
+		return
+	}
+	expires := unlockNotes(w, r, userID, key)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.NoteEncryption{
+		Enabled: true, Unlocked: true, ExpiresAt: expires.UTC().Format(time.RFC3339),
+	})
+}
+
+// unwrapNoteKey checks a passphrase and returns the user's data key.
+func unwrapNoteKey(ctx context.Context, w http.ResponseWriter, userID int, passphrase string) ([]byte, noteKeyRecord, bool) {
+	record, err := loadNoteKeyRecord(ctx, DB, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Private notes are not set up", http.StatusNotFound)
+		return nil, record, false
+	}
+	if err != nil {
+		slog.Error("Load note key error", "err", err)
+		http.Error(w, "Failed to unlock private notes", http.StatusInternalServerError)
+		return nil, record, false
+	}
+	kek := deriveNoteKey(passphrase, record.salt, record.params)
+	defer notecrypt.Zero(kek)
+	key, err := notecrypt.Unwrap(kek, record.wrapped)
+	if err != nil {
+		http.Error(w, "Wrong passphrase", http.StatusForbidden)
+		return nil, record, false
+	}
+	return key, record, true
+}
+
+// UnlockNotes unlocks private notes for this session with "passphrase".
+func UnlockNotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	key, _, ok := unwrapNoteKey(r.Context(), w, userID, r.FormValue("passphrase"))
+	if !ok {
+		return
+	}
+	lockNotes(w, r)
+	expires := unlockNotes(w, r, userID, key)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.NoteEncryption{
+		Enabled: true, Unlocked: true, ExpiresAt: expires.UTC().Format(time.RFC3339),
+	})
+}
+
+// LockNotes locks private notes for this session.
+func LockNotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, err := GetCurrentUserID(r); err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	lockNotes(w, r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "locked"})
+}
+
+// ChangeNotePassphrase replaces "passphrase" with "new_passphrase". Only the
+// wrapped data key is rewritten; the notes stay as they are. Other sessions
+// are locked.
+func ChangeNotePassphrase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	newPassphrase := r.FormValue("new_passphrase")
+	if len(newPassphrase) < minNotePassphraseSize {
+		http.Error(w, "Passphrase must be at least "+strconv.Itoa(minNotePassphraseSize)+" characters", http.StatusBadRequest)
+		return
+	}
+	key, record, ok := unwrapNoteKey(r.Context(), w, userID, r.FormValue("passphrase"))
+	if !ok {
+		return
+	}
+
+	salt, err := notecrypt.NewSalt()
+	if err != nil {
+		notecrypt.Zero(key)
+		http.Error(w, "Failed to change passphrase", http.StatusInternalServerError)
+		return
+	}
+	params := notecrypt.DefaultParams
+	kek := deriveNoteKey(newPassphrase, salt, params)
+	wrapped, err := notecrypt.Wrap(kek, key)
+	notecrypt.Zero(kek)
+	if err != nil {
+		notecrypt.Zero(key)
+		http.Error(w, "Failed to change passphrase", http.StatusInternalServerError)
+		return
+	}
+
+	// Only replace the key that was unwrapped, in case of a concurrent change.
+	res, err := DB.ExecContext(r.Context(), `
+		UPDATE note_keys SET salt = ?, argon_time = ?, argon_memory = ?, argon_threads = ?, wrapped_key = ?, updated_at = ?
+		WHERE user_id = ? AND wrapped_key = ?`,
+		salt, params.Time, params.Memory, params.Threads, wrapped, time.Now(), userID, record.wrapped)
+	if err != nil {
+		notecrypt.Zero(key)
+		slog.ErrorContext(r.Context(), "Change note passphrase error", "err", err)
+		http.Error(w, "Failed to change passphrase", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		notecrypt.Zero(key)
+		http.Error(w, "The passphrase was changed concurrently; try again", http.StatusConflict)
+		return
+	}
+	lockUserNotes(userID)
+	expires := unlockNotes(w, r, userID, key)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.NoteEncryption{
+		Enabled: true, Unlocked: true, ExpiresAt: expires.UTC().Format(time.RFC3339),
+	})
+}
+
+// EncryptNote turns note "id" into a private note. Its revision history and
+// links are deleted, since they hold its plain text.
+func EncryptNote(w http.ResponseWriter, r *http.Request) {
+	setNoteEncrypted(w, r, true)
+}
+
+// DecryptNote turns private note "id" back into an ordinary note.
+func DecryptNote(w http.ResponseWriter, r *http.Request) {
+	setNoteEncrypted(w, r, false)
+}
+
+func setNoteEncrypted(w http.ResponseWriter, r *http.Request, encrypt bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Note ID required", http.StatusBadRequest)
+		return
+	}
+	key := unlockedNoteKey(r, userID)
+	if key == nil {
+		http.Error(w, "Unlock private notes first", http.StatusLocked)
+		return
+	}
+	defer notecrypt.Zero(key)
+
+	note, err := loadNote(r.Context(), userID, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Load note error", "err", err)
+		http.Error(w, "Failed to update note", http.StatusInternalServerError)
+		return
+	}
+	if note.Encrypted == encrypt {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "unchanged"})
+		return
+	}
+
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Failed to update note", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var stored string
+	if encrypt {
+		if stored, err = notecrypt.Seal(key, note.Content); err == nil {
+			tx.ExecContext(r.Context(), "DELETE FROM note_revisions WHERE note_id = ?", id)
+			tx.ExecContext(r.Context(), "DELETE FROM note_links WHERE note_id = ?", id)
+		}
+	} else {
+		if err = revealNote(&note, key); err == nil {
+			stored = note.Content
+			if err = recordNoteRevision(r.Context(), tx, id, userID, note.Title, stored, false); err == nil {
+				err = updateNoteLinks(r.Context(), tx, userID, id, stored)
+			}
+		}
+	}
+	if err == nil {
+		// Compare with what was read, so a concurrent edit is not lost.
+		var res sql.Result
+		res, err = tx.ExecContext(r.Context(), `
+			UPDATE notes SET content = ?, encrypted = ?
+			WHERE id = ? AND user_id = ? AND COALESCE(content, '') = ?`,
+			stored, encrypt, id, userID, noteStoredContent(note))
+		if err == nil {
+			if n, _ := res.RowsAffected(); n == 0 {
+				http.Error(w, "The note was changed by someone else; reload and try again", http.StatusConflict)
+				return
+			}
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Update note encryption error", "err", err)
+		http.Error(w, "Failed to update note", http.StatusInternalServerError)
+		return
+	}
+
+	if note, err := loadNote(r.Context(), userID, id); err == nil {
+		publish(r.Context(), userID, "note.updated", note)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if encrypt {
+		json.NewEncoder(w).Encode(map[string]string{"status": "encrypted"})
+	} else {
+		json.NewEncoder(w).Encode(map[string]string{"status": "decrypted"})
+	}
+}
+
+// noteStoredContent is what notes.content holds for a loaded note.
+func noteStoredContent(note models.Note) string {
+	if note.Encrypted {
+		return note.Ciphertext
+	}
+	return note.Content
+}
+
+// sameNoteKey reports whether two wrapped keys are byte for byte the same
+// record, so that notes encrypted under one can be read with the other.
+func sameNoteKey(a, b noteKeyRecord) bool {
+	return subtle.ConstantTimeCompare(a.salt, b.salt) == 1 && subtle.ConstantTimeCompare(a.wrapped, b.wrapped) == 1
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"task-manager/models"
+	"task-manager/notify"
+	"time"
+)
+
+// DefaultReminderLead is used for users who have not chosen their own lead time.
+var DefaultReminderLead = 24 * time.Hour
+
+// parseDueDate parses a task or project due date. Dates without a time are
+// due at the end of that day in the server's local time zone.
+func parseDueDate(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t.AddDate(0, 0, 1), true
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// formatDueDate shows a due date in emails: dates as they are, times in
+// the server's local time zone.
+func formatDueDate(s string) string {
+	if _, err := time.Parse("2006-01-02", s); err == nil {
+		return s
+	}
+	if t, ok := parseDueDate(s); ok {
+		return t.In(time.Local).Format("2006-01-02 15:04")
+	}
+	return s
+}
+
+// parseLeadMinutes parses an optional lead time form value. An empty value
+// means "inherit" and is returned as nil.
+func parseLeadMinutes(s string) (*int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	lead, err := strconv.Atoi(s)
+	if err != nil || lead < 0 {
+		return nil, fmt.Errorf("invalid lead time")
+	}
+	return &lead, nil
+}
+
+// ReminderSettings reads or updates the current user's global reminder settings.
+func ReminderSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		settings := models.ReminderSettings{
+			RemindersEnabled:   true,
+			DefaultLeadMinutes: int(DefaultReminderLead.Minutes()),
+		}
+		var lead sql.NullInt64
+		err := DB.QueryRowContext(r.Context(), "SELECT reminders_enabled, reminder_lead_minutes FROM user_settings WHERE user_id = ?",
+			userID).Scan(&settings.RemindersEnabled, &lead)
+		if err != nil && err != sql.ErrNoRows {
+			slog.ErrorContext(r.Context(), "Reminder settings error", "err", err)
+			http.Error(w, "Failed to load reminder settings", http.StatusInternalServerError)
+			return
+		}
+		if lead.Valid {
+			minutes := int(lead.Int64)
+			settings.ReminderLeadMinutes = &minutes
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+
+	case http.MethodPost:
+		enabled := r.FormValue("reminders_enabled") != "off" && r.FormValue("reminders_enabled") != "false"
+		lead, err := parseLeadMinutes(r.FormValue("reminder_lead_minutes"))
+		if err != nil {
+			http.Error(w, "Invalid value for reminder lead minutes", http.StatusBadRequest)
+			return
+		}
+
+		_, err = DB.ExecContext(r.Context(), `
+			INSERT INTO user_settings (user_id, reminders_enabled, reminder_lead_minutes)
+			VALUES (?, ?, ?)
+			ON CONFLICT(user_id) DO UPDATE SET
+				reminders_enabled = excluded.reminders_enabled,
+				reminder_lead_minutes = excluded.reminder_lead_minutes`,
+			userID, enabled, lead)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Update reminder settings error", "err", err)
+			http.Error(w, "Failed to update reminder settings", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// SetTaskReminder sets the lead time for a single task. An empty
+// lead_minutes clears the override so the user's setting applies again.
+func SetTaskReminder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := r.FormValue("id")
+	if id == "" {
+		http.Error(w, "Task ID required", http.StatusBadRequest)
+		return
+	}
+
+	lead, err := parseLeadMinutes(r.FormValue("lead_minutes"))
+	if err != nil {
+		http.Error(w, "Invalid value for lead minutes", http.StatusBadRequest)
+		return
+	}
+
+	res, err := DB.ExecContext(r.Context(), "UPDATE tasks SET reminder_lead_minutes = ?, updated_at = ? WHERE id = ? AND user_id = ?",
+		lead, time.Now(), id, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Set task reminder error", "err", err)
+		http.Error(w, "Failed to update task reminder", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if taskID, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if task, err := loadTask(r.Context(), userID, taskID); err == nil {
+			publish(r.Context(), userID, "task.updated", task)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
+
+type dueTask struct {
+	id          int
+	description string
+	dueDate     string // as stored, so the driver does not turn it into a UTC timestamp
+	projectName string
+	email       string
+	username    string
+	lead        time.Duration
+}
+
+// SendDueReminders notifies users about open tasks that are due soon or
+// overdue. Each (task, kind, due date) reminder is claimed in task_reminders
+// before it is sent, so restarts and overlapping runs never send it twice;
+// changing a task's due date makes it eligible for new reminders.
+func SendDueReminders(ctx context.Context, n notify.Notifier) {
+	rows, err := DB.QueryContext(ctx, `
+		SELECT t.id, t.description, COALESCE(t.due_date, ''), COALESCE(p.name, ''),
+		       COALESCE(u.email, ''), u.username,
+		       t.reminder_lead_minutes, s.reminder_lead_minutes
+		FROM tasks t
+		JOIN users u ON u.id = t.user_id
+		LEFT JOIN projects p ON p.id = t.project_id
+		LEFT JOIN user_settings s ON s.user_id = t.user_id
+		WHERE t.done = 0 AND t.due_date IS NOT NULL AND t.due_date != ''
+		  AND COALESCE(s.reminders_enabled, 1) = 1`)
+	if err != nil {
+		slog.ErrorContext(ctx, "Reminder query error", "err", err)
+		return
+	}
+
+	var tasks []dueTask
+	for rows.Next() {
+		var task dueTask
+		var taskLead, userLead sql.NullInt64
+		if err := rows.Scan(&task.id, &task.description, &task.dueDate, &task.projectName,
+			&task.email, &task.username, &taskLead, &userLead); err != nil {
+			slog.ErrorContext(ctx, "Reminder scan error", "err", err)
+			continue
+		}
+
+		task.lead = DefaultReminderLead
+		if userLead.Valid {
+			task.lead = time.Duration(userLead.Int64) * time.Minute
+		}
+		if taskLead.Valid {
+			task.lead = time.Duration(taskLead.Int64) * time.Minute
+		}
+		tasks = append(tasks, task)
+	}
+	rows.Close()
+
+	now := time.Now()
+	for _, task := range tasks {
+		if ctx.Err() != nil {
+			return
+		}
+		if task.email == "" {
+			continue
+		}
+
+		dueAt, ok := parseDueDate(task.dueDate)
+		if !ok {
+			continue
+		}
+
+		kind := ""
+		switch {
+		case !now.Before(dueAt):
+			kind = "overdue"
+		case task.lead > 0 && !now.Before(dueAt.Add(-task.lead)):
+			kind = "due_soon"
+		default:
+			continue
+		}
+
+		if err := sendReminder(ctx, n, task, kind); err != nil {
+			slog.ErrorContext(ctx, "Reminder failed", "task_id", task.id, "err", err)
+		}
+	}
+}
+
+func sendReminder(ctx context.Context, n notify.Notifier, task dueTask, kind string) error {
+	res, err := DB.ExecContext(ctx, `
+		INSERT OR IGNORE INTO task_reminders (task_id, kind, due_date, sent_at)
+		VALUES (?, ?, ?, ?)`, task.id, kind, task.dueDate, time.Now())
+	if err != nil {
+		return err
+	}
+	if claimed, _ := res.RowsAffected(); claimed == 0 {
+		return nil
+	}
+
+	if err := n.Notify(ctx, reminderMessage(task, kind)); err != nil {
+		// Release the claim so the next run retries.
+		DB.ExecContext(ctx, "DELETE FROM task_reminders WHERE task_id = ? AND kind = ? AND due_date = ?",
+			task.id, kind, task.dueDate)
+		return err
+	}
+	return nil
+}
+
+func reminderMessage(task dueTask, kind string) notify.Message {
+	subject := "Task due soon: " + task.description
+	status := "is due on " + formatDueDate(task.dueDate)
+	if kind == "overdue" {
+		subject = "Task overdue: " + task.description
+		status = "was due on " + formatDueDate(task.dueDate) + " and is not done yet"
+	}
+
+	text := fmt.Sprintf("Hi %s,\n\nYour task %q %s.\n", task.username, task.description, status)
+	if task.projectName != "" {
+		text += fmt.Sprintf("Project: %s\n", task.projectName)
+	}
+	text += "\n- TaskLift\n"
+
+	return notify.Message{
+		To:      []string{task.email},
+		Subject: subject,
+		Text:    text,
+	}
+}
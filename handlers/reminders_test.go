@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"task-manager/notify"
+	"testing"
+	"time"
+)
+
+// smtpSink is an SMTP server on a local port that keeps the messages it
+// accepts, or turns every message down while rejecting is set.
+type smtpSink struct {
+	ln net.Listener
+
+	mu        sync.Mutex
+	messages  []*mail.Message
+	rejecting bool
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpSink{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// notifier returns an SMTPNotifier that sends to the sink.
+func (s *smtpSink) notifier() *notify.SMTPNotifier {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return &notify.SMTPNotifier{Host: "127.0.0.1", Port: addr.Port, From: "TaskLift <no-reply@tasklift.test>"}
+}
+
+func (s *smtpSink) setRejecting(rejecting bool) {
+	s.mu.Lock()
+	s.rejecting = rejecting
+	s.mu.Unlock()
+}
+
+// subjects returns the decoded subjects of the messages received so far.
+func (s *smtpSink) subjects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var dec mime.WordDecoder
+	subjects := make([]string, len(s.messages))
+	for i, msg := range s.messages {
+		subjects[i], _ = dec.DecodeHeader(msg.Header.Get("Subject"))
+	}
+	return subjects
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 sink ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 sink")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 Go ahead")
+			data, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			rejecting := s.rejecting
+			if !rejecting {
+				if msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(string(data)))); err == nil {
+					s.messages = append(s.messages, msg)
+				}
+			}
+			s.mu.Unlock()
+			if rejecting {
+				tp.PrintfLine("451 Try again later")
+			} else {
+				tp.PrintfLine("250 Queued")
+			}
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+// createDueTask adds an open task for userID due on dueDate.
+func createDueTask(t *testing.T, userID int, description, dueDate string) int {
+	t.Helper()
+	res := mustExec(t, "INSERT INTO tasks (user_id, description, due_date) VALUES (?, ?, ?)", userID, description, dueDate)
+	id, _ := res.LastInsertId()
+	return int(id)
+}
+
+func TestSendDueRemindersSendsEachReminderOnce(t *testing.T) {
+	openTestDB(t)
+	sink := newSMTPSink(t)
+
+	userID := createTestUser(t, "alice", "alice@example.com")
+	createDueTask(t, userID, "Overdue report", time.Now().AddDate(0, 0, -2).Format("2006-01-02"))
+	createDueTask(t, userID, "Call back", time.Now().Add(2*time.Hour).Format("2006-01-02T15:04"))
+	createDueTask(t, userID, "Plan next quarter", time.Now().AddDate(0, 1, 0).Format("2006-01-02"))
+	done := createDueTask(t, userID, "Already done", time.Now().AddDate(0, 0, -2).Format("2006-01-02"))
+	mustExec(t, "UPDATE tasks SET done = 1 WHERE id = ?", done)
+
+	SendDueReminders(context.Background(), sink.notifier())
+
+	got := sink.subjects()
+	want := map[string]bool{"Task overdue: Overdue report": true, "Task due soon: Call back": true}
+	if len(got) != len(want) {
+		t.Fatalf("sent %q, want %d reminders", got, len(want))
+	}
+	for _, subject := range got {
+		if !want[subject] {
+			t.Errorf("unexpected reminder %q", subject)
+		}
+	}
+	if n := queryCount(t, "SELECT COUNT(*) FROM task_reminders"); n != 2 {
+		t.Errorf("task_reminders has %d rows, want 2", n)
+	}
+
+	// The next tick finds every reminder claimed.
+	SendDueReminders(context.Background(), sink.notifier())
+	if got := sink.subjects(); len(got) != 2 {
+		t.Errorf("second tick sent again: %q", got)
+	}
+}
+
+func TestSendDueRemindersReleasesClaimOnFailure(t *testing.T) {
+	openTestDB(t)
+	sink := newSMTPSink(t)
+
+	userID := createTestUser(t, "bob", "bob@example.com")
+	taskID := createDueTask(t, userID, "Renew passport", time.Now().AddDate(0, 0, -1).Format("2006-01-02"))
+
+	sink.setRejecting(true)
+	SendDueReminders(context.Background(), sink.notifier())
+	if got := sink.subjects(); len(got) != 0 {
+		t.Fatalf("rejected reminder was recorded: %q", got)
+	}
+	if n := queryCount(t, "SELECT COUNT(*) FROM task_reminders WHERE task_id = ?", taskID); n != 0 {
+		t.Fatalf("claim kept after failed send: %d rows", n)
+	}
+
+	sink.setRejecting(false)
+	SendDueReminders(context.Background(), sink.notifier())
+	if got := sink.subjects(); len(got) != 1 || got[0] != "Task overdue: Renew passport" {
+		t.Fatalf("retry sent %q, want the overdue reminder", got)
+	}
+	if n := queryCount(t, "SELECT COUNT(*) FROM task_reminders WHERE task_id = ? AND kind = 'overdue'", taskID); n != 1 {
+		t.Errorf("task_reminders has %d overdue rows after retry, want 1", n)
+	}
+}
+
+func TestSendDueRemindersNewDueDateIsEligibleAgain(t *testing.T) {
+	openTestDB(t)
+	sink := newSMTPSink(t)
+
+	userID := createTestUser(t, "carol", "carol@example.com")
+	taskID := createDueTask(t, userID, "Water plants", time.Now().AddDate(0, 0, -3).Format("2006-01-02"))
+
+	SendDueReminders(context.Background(), sink.notifier())
+	mustExec(t, "UPDATE tasks SET due_date = ? WHERE id = ?", time.Now().AddDate(0, 0, -1).Format("2006-01-02"), taskID)
+	SendDueReminders(context.Background(), sink.notifier())
+
+	if got := sink.subjects(); len(got) != 2 {
+		t.Errorf("sent %q, want one reminder per due date", got)
+	}
+}
+
+func TestSendDueRemindersDateOnlyDueTodayIsNotOverdue(t *testing.T) {
+	openTestDB(t)
+	sink := newSMTPSink(t)
+
+	userID := createTestUser(t, "dana", "dana@example.com")
+	createDueTask(t, userID, "Pay rent", time.Now().Format("2006-01-02"))
+
+	SendDueReminders(context.Background(), sink.notifier())
+
+	// The task is due at the end of today, within the default lead time.
+	if got := sink.subjects(); len(got) != 1 || got[0] != "Task due soon: Pay rent" {
+		t.Errorf("sent %q, want a due-soon reminder", got)
+	}
+}
+
+func TestSendDueRemindersReadsTimesInLocalZone(t *testing.T) {
+	previous := time.Local
+	time.Local = time.FixedZone("UTC+10", 10*60*60)
+	t.Cleanup(func() { time.Local = previous })
+
+	openTestDB(t)
+	sink := newSMTPSink(t)
+
+	userID := createTestUser(t, "erin", "erin@example.com")
+	later := createDueTask(t, userID, "Board meeting", time.Now().Add(2*time.Hour).Format("2006-01-02T15:04"))
+	mustExec(t, "UPDATE tasks SET reminder_lead_minutes = 60 WHERE id = ?", later)
+	soon := createDueTask(t, userID, "Dentist", time.Now().Add(30*time.Minute).Format("2006-01-02T15:04"))
+	mustExec(t, "UPDATE tasks SET reminder_lead_minutes = 60 WHERE id = ?", soon)
+
+	SendDueReminders(context.Background(), sink.notifier())
+
+	// Read as UTC, both times would lie ten hours in the past.
+	if got := sink.subjects(); len(got) != 1 || got[0] != "Task due soon: Dentist" {
+		t.Errorf("sent %q, want only the due-soon reminder for Dentist", got)
+	}
+}
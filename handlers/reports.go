@@ -0,0 +1,913 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"task-manager/charts"
+	"task-manager/models"
+	"task-manager/notify"
+	"time"
+)
+
+// Reports export the analytics as documents: a CSV of the numbers and a
+// printable HTML document with the charts, an overview page followed by a
+// page per project. A report covers the RangeDays days up to yesterday in
+// the user's time zone, so every day in it is complete. Scheduled reports
+// are claimed per period like digests; "run now" queues a background job.
+
+var (
+	reportMetrics = []string{"summary", "trends", "flow", "forecast"}
+	reportFormats = []string{"csv", "html"}
+)
+
+const maxReportRecipients = 10
+
+// ReportNotifier emails reports run on demand. Scheduled reports use the
+// notifier GenerateReports is given.
+var ReportNotifier notify.Notifier = notify.LogNotifier{}
+
+func init() {
+	jobKinds["report:run"] = runReportJob
+}
+
+const reportSelect = `
+	SELECT id, user_id, name, metrics, project_ids, range_days, granularity, formats,
+	       frequency, weekday, hour, email, recipients, created_at, updated_at
+	FROM report_definitions`
+
+func scanReport(row rowScanner) (models.ReportDefinition, error) {
+	var d models.ReportDefinition
+	var metrics, projectIDs, formats, recipients string
+	err := row.Scan(&d.ID, &d.UserID, &d.Name, &metrics, &projectIDs, &d.RangeDays, &d.Granularity, &formats,
+		&d.Frequency, &d.Weekday, &d.Hour, &d.Email, &recipients, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return d, err
+	}
+	d.Metrics, d.Formats, d.Recipients = splitList(metrics), splitList(formats), splitList(recipients)
+	d.ProjectIDs = make([]int, 0)
+	for _, v := range splitList(projectIDs) {
+		if id, err := strconv.Atoi(v); err == nil {
+			d.ProjectIDs = append(d.ProjectIDs, id)
+		}
+	}
+	return d, nil
+}
+
+func loadReport(ctx context.Context, userID int, id int64) (models.ReportDefinition, error) {
+	return scanReport(DB.QueryRowContext(ctx, reportSelect+" WHERE id = ? AND user_id = ?", id, userID))
+}
+
+// splitList splits a stored comma-separated list; the empty string is the
+// empty list.
+func splitList(s string) []string {
+	if s == "" {
+		return make([]string, 0)
+	}
+	return strings.Split(s, ",")
+}
+
+// formList collects a form field given comma-separated, repeated or both.
+func formList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// parseReportForm reads a report definition from a create or update
+// request. Fields left out get their defaults.
+func parseReportForm(r *http.Request, userID int) (models.ReportDefinition, error) {
+	r.ParseForm()
+	d := models.ReportDefinition{
+		UserID:     userID,
+		Name:       strings.TrimSpace(r.FormValue("name")),
+		Metrics:    formList(r.Form["metrics"]),
+		ProjectIDs: make([]int, 0),
+		RangeDays:  7,
+		Formats:    formList(r.Form["formats"]),
+		Frequency:  "off",
+		Weekday:    1,
+		Hour:       8,
+		Recipients: make([]string, 0),
+	}
+	if d.Name == "" {
+		return d, errors.New("Report name required")
+	}
+
+	if len(d.Metrics) == 0 {
+		d.Metrics = reportMetrics
+	}
+	for _, m := range d.Metrics {
+		if !containsString(reportMetrics, m) {
+			return d, fmt.Errorf("Unknown metric %q, use summary, trends, flow or forecast", m)
+		}
+	}
+	if len(d.Formats) == 0 {
+		d.Formats = reportFormats
+	}
+	for _, f := range d.Formats {
+		if !containsString(reportFormats, f) {
+			return d, fmt.Errorf("Unknown format %q, use csv or html", f)
+		}
+	}
+
+	for _, v := range formList(r.Form["project_ids"]) {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return d, errors.New("Invalid project ID")
+		}
+		d.ProjectIDs = append(d.ProjectIDs, id)
+	}
+	for _, id := range d.ProjectIDs {
+		var exists bool
+		DB.QueryRowContext(r.Context(), "SELECT EXISTS(SELECT 1 FROM projects WHERE id = ? AND user_id = ?)", id, userID).Scan(&exists)
+		if !exists {
+			return d, fmt.Errorf("Project %d not found", id)
+		}
+	}
+
+	if v := r.FormValue("range_days"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 1 || days > 366 {
+			return d, errors.New("range_days must be between 1 and 366")
+		}
+		d.RangeDays = days
+	}
+	d.Granularity = r.FormValue("granularity")
+	if d.Granularity == "" {
+		d.Granularity = "day"
+		if d.RangeDays > 31 {
+			d.Granularity = "week"
+		}
+	}
+	if d.Granularity != "day" && d.Granularity != "week" && d.Granularity != "month" {
+		return d, errors.New("granularity must be day, week or month")
+	}
+
+	if v := r.FormValue("frequency"); v != "" {
+		d.Frequency = v
+	}
+	if d.Frequency != "off" && d.Frequency != "daily" && d.Frequency != "weekly" {
+		return d, errors.New("Report frequency must be off, daily or weekly")
+	}
+	if v := r.FormValue("weekday"); v != "" {
+		weekday, err := strconv.Atoi(v)
+		if err != nil || weekday < 0 || weekday > 6 {
+			return d, errors.New("Report weekday must be between 0 (Sunday) and 6")
+		}
+		d.Weekday = weekday
+	}
+	if v := r.FormValue("hour"); v != "" {
+		hour, err := strconv.Atoi(v)
+		if err != nil || hour < 0 || hour > 23 {
+			return d, errors.New("Report hour must be between 0 and 23")
+		}
+		d.Hour = hour
+	}
+
+	if v := r.FormValue("email"); v != "" {
+		email, err := strconv.ParseBool(v)
+		if err != nil && v != "on" {
+			return d, errors.New("email must be true or false")
+		}
+		d.Email = email || v == "on"
+	}
+	for _, v := range formList(r.Form["recipients"]) {
+		addr, err := mail.ParseAddress(v)
+		if err != nil {
+			return d, fmt.Errorf("Invalid recipient %q", v)
+		}
+		if !containsString(d.Recipients, addr.Address) {
+			d.Recipients = append(d.Recipients, addr.Address)
+		}
+	}
+	if len(d.Recipients) > maxReportRecipients {
+		return d, fmt.Errorf("At most %d recipients", maxReportRecipients)
+	}
+	return d, nil
+}
+
+func joinInts(ids []int) string {
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		list[i] = strconv.Itoa(id)
+	}
+	return strings.Join(list, ",")
+}
+
+// Reports lists the current user's report definitions (GET) or creates one
+// (POST) from name, metrics, project_ids, range_days, granularity, formats,
+// frequency, weekday, hour, email and recipients.
+func Reports(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := DB.QueryContext(r.Context(), reportSelect+" WHERE user_id = ? ORDER BY name", userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "List reports error", "err", err)
+			http.Error(w, "Failed to retrieve reports", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		reports := make([]models.ReportDefinition, 0)
+		for rows.Next() {
+			report, err := scanReport(rows)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Scan error", "err", err)
+				continue
+			}
+			reports = append(reports, report)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+
+	case http.MethodPost:
+		d, err := parseReportForm(r, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		res, err := DB.ExecContext(r.Context(), `
+			INSERT INTO report_definitions (user_id, name, metrics, project_ids, range_days, granularity, formats,
+			                                frequency, weekday, hour, email, recipients, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID, d.Name, strings.Join(d.Metrics, ","), joinInts(d.ProjectIDs), d.RangeDays, d.Granularity,
+			strings.Join(d.Formats, ","), d.Frequency, d.Weekday, d.Hour, d.Email, strings.Join(d.Recipients, ","), now, now)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Create report error", "err", err)
+			http.Error(w, "Failed to create report", http.StatusInternalServerError)
+			return
+		}
+		id, _ := res.LastInsertId()
+		report, err := loadReport(r.Context(), userID, id)
+		if err != nil {
+			http.Error(w, "Failed to create report", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(report)
+
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// UpdateReport replaces report "id"'s definition with the fields given, as
+// for creating one.
+func UpdateReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Report ID required", http.StatusBadRequest)
+		return
+	}
+	d, err := parseReportForm(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := DB.ExecContext(r.Context(), `
+		UPDATE report_definitions
+		SET name = ?, metrics = ?, project_ids = ?, range_days = ?, granularity = ?, formats = ?,
+		    frequency = ?, weekday = ?, hour = ?, email = ?, recipients = ?, updated_at = ?
+		WHERE id = ? AND user_id = ?`,
+		d.Name, strings.Join(d.Metrics, ","), joinInts(d.ProjectIDs), d.RangeDays, d.Granularity,
+		strings.Join(d.Formats, ","), d.Frequency, d.Weekday, d.Hour, d.Email, strings.Join(d.Recipients, ","),
+		time.Now(), id, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Update report error", "err", err)
+		http.Error(w, "Failed to update report", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	}
+	report, err := loadReport(r.Context(), userID, id)
+	if err != nil {
+		http.Error(w, "Failed to update report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// DeleteReport deletes report "id" and its run history. The documents it
+// produced are kept.
+func DeleteReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := r.FormValue("id")
+	if id == "" {
+		http.Error(w, "Report ID required", http.StatusBadRequest)
+		return
+	}
+
+	res, err := DB.ExecContext(r.Context(), "DELETE FROM report_definitions WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Delete report error", "err", err)
+		http.Error(w, "Failed to delete report", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		DB.ExecContext(r.Context(), "DELETE FROM report_runs WHERE report_id = ?", id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// RunReport queues a job that generates report "id" now. email=false skips
+// emailing a report that is normally emailed. The job's result is the run.
+func RunReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Report ID required", http.StatusBadRequest)
+		return
+	}
+	if _, err := loadReport(r.Context(), userID, id); err == sql.ErrNoRows {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.ErrorContext(r.Context(), "Load report error", "err", err)
+		http.Error(w, "Failed to start report", http.StatusInternalServerError)
+		return
+	}
+	email := true
+	if v := r.FormValue("email"); v != "" {
+		if email, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "email must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	payload, _ := json.Marshal(reportJobPayload{ReportID: id, Email: email})
+	jobID, err := queueJob(r.Context(), userID, "report:run", payload)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Queue report error", "err", err)
+		http.Error(w, "Failed to start report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "queued", "job_id": jobID})
+}
+
+type reportJobPayload struct {
+	ReportID int64 `json:"report_id"`
+	Email    bool  `json:"email"`
+}
+
+func runReportJob(ctx context.Context, job *runningJob) (interface{}, error) {
+	var payload reportJobPayload
+	if err := json.Unmarshal(job.payload, &payload); err != nil {
+		return nil, err
+	}
+	report, err := loadReport(ctx, job.userID, payload.ReportID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("report no longer exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.Email = report.Email && payload.Email
+
+	job.setTotal(1)
+	run, err := runReport(ctx, ReportNotifier, report, fmt.Sprintf("manual:%d", job.id), userToday(ctx, job.userID))
+	if err != nil {
+		return nil, err
+	}
+	job.advance(1, "Finished")
+	return run, nil
+}
+
+const reportRunSelect = `
+	SELECT id, report_id, period, from_date, to_date, csv_document_id, html_document_id,
+	       emailed_to, created_at, finished_at
+	FROM report_runs`
+
+func scanReportRun(row rowScanner) (models.ReportRun, error) {
+	var run models.ReportRun
+	var csvID, htmlID sql.NullInt64
+	var emailedTo string
+	var finished sql.NullTime
+	err := row.Scan(&run.ID, &run.ReportID, &run.Period, &run.From, &run.To, &csvID, &htmlID,
+		&emailedTo, &run.CreatedAt, &finished)
+	if err != nil {
+		return run, err
+	}
+	run.Status = "running"
+	if finished.Valid {
+		run.Status, run.FinishedAt = "done", finished.Time.Format(time.RFC3339Nano)
+	}
+	if csvID.Valid {
+		id := int(csvID.Int64)
+		run.CSVDocumentID = &id
+	}
+	if htmlID.Valid {
+		id := int(htmlID.Int64)
+		run.HTMLDocumentID = &id
+	}
+	run.EmailedTo = splitList(emailedTo)
+	return run, nil
+}
+
+// ReportRuns lists the last 50 runs of report ?id=, newest first.
+func ReportRuns(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Report ID required", http.StatusBadRequest)
+		return
+	}
+	rows, err := DB.QueryContext(r.Context(), reportRunSelect+" WHERE report_id = ? AND user_id = ? ORDER BY id DESC LIMIT 50", id, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List report runs error", "err", err)
+		http.Error(w, "Failed to retrieve report runs", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	runs := make([]models.ReportRun, 0)
+	for rows.Next() {
+		run, err := scanReportRun(rows)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Scan error", "err", err)
+			continue
+		}
+		runs = append(runs, run)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+// GenerateReports runs every scheduled report whose time has passed today in
+// its owner's time zone.
+func GenerateReports(ctx context.Context, n notify.Notifier) {
+	rows, err := DB.QueryContext(ctx, reportSelect+" WHERE frequency IN ('daily', 'weekly')")
+	if err != nil {
+		slog.ErrorContext(ctx, "Report query error", "err", err)
+		return
+	}
+	var reports []models.ReportDefinition
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			slog.ErrorContext(ctx, "Report scan error", "err", err)
+			continue
+		}
+		reports = append(reports, report)
+	}
+	rows.Close()
+
+	for _, report := range reports {
+		if ctx.Err() != nil {
+			return
+		}
+		now := userToday(ctx, report.UserID)
+		if now.Hour() < report.Hour {
+			continue
+		}
+		if report.Frequency == "weekly" && int(now.Weekday()) != report.Weekday {
+			continue
+		}
+		if _, err := runReport(ctx, n, report, report.Frequency+":"+now.Format("2006-01-02"), now); err != nil {
+			slog.ErrorContext(ctx, "Report failed", "report_id", report.ID, "user_id", report.UserID, "err", err)
+		}
+	}
+}
+
+// reportRange returns the first and last day a report run at now covers.
+func reportRange(report models.ReportDefinition, now time.Time) (time.Time, time.Time) {
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -1)
+	return to.AddDate(0, 0, 1-report.RangeDays), to
+}
+
+// runReport claims the run of a report for a period, generates and stores
+// its documents and emails them if the report asks for it. Anything that
+// fails undoes the run, so a scheduled report is tried again on the next
+// tick. A period that was already claimed returns the existing run.
+func runReport(ctx context.Context, n notify.Notifier, report models.ReportDefinition, period string, now time.Time) (models.ReportRun, error) {
+	from, to := reportRange(report, now)
+	res, err := DB.ExecContext(ctx, `
+		INSERT OR IGNORE INTO report_runs (report_id, user_id, period, from_date, to_date, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		report.ID, report.UserID, period, from.Format("2006-01-02"), to.Format("2006-01-02"), time.Now())
+	if err != nil {
+		return models.ReportRun{}, err
+	}
+	if claimed, _ := res.RowsAffected(); claimed == 0 {
+		return scanReportRun(DB.QueryRowContext(ctx, reportRunSelect+" WHERE report_id = ? AND period = ?", report.ID, period))
+	}
+	runID, _ := res.LastInsertId()
+
+	var saved []int64
+	fail := func(err error) (models.ReportRun, error) {
+		for _, id := range saved {
+			deleteDocument(ctx, report.UserID, id)
+		}
+		DB.ExecContext(ctx, "DELETE FROM report_runs WHERE id = ?", runID)
+		return models.ReportRun{}, err
+	}
+
+	doc, err := buildReport(ctx, report, now)
+	if err != nil {
+		return fail(err)
+	}
+
+	var csvID, htmlID interface{}
+	var attachments []notify.Attachment
+	for _, format := range report.Formats {
+		var data []byte
+		var contentType string
+		switch format {
+		case "csv":
+			data, err = renderReportCSV(doc)
+			contentType = "text/csv"
+		case "html":
+			data, err = renderReportHTML(ctx, doc)
+			contentType = "text/html"
+		default:
+			continue
+		}
+		if err != nil {
+			return fail(err)
+		}
+		title := fmt.Sprintf("%s %s to %s.%s", report.Name, doc.From, doc.To, format)
+		id, err := saveDocument(ctx, report.UserID, title, contentType, data)
+		if err != nil {
+			return fail(err)
+		}
+		saved = append(saved, id)
+		if format == "csv" {
+			csvID = id
+		} else {
+			htmlID = id
+		}
+		attachments = append(attachments, notify.Attachment{Name: archiveFileName(title), ContentType: contentType, Data: data})
+	}
+
+	var emailedTo []string
+	if report.Email {
+		var email string
+		DB.QueryRowContext(ctx, "SELECT COALESCE(email, '') FROM users WHERE id = ?", report.UserID).Scan(&email)
+		if email != "" {
+			emailedTo = append(emailedTo, email)
+		}
+		for _, addr := range report.Recipients {
+			if !strings.EqualFold(addr, email) {
+				emailedTo = append(emailedTo, addr)
+			}
+		}
+	}
+	if len(emailedTo) > 0 {
+		err := n.Notify(ctx, notify.Message{
+			To:          emailedTo,
+			Subject:     fmt.Sprintf("TaskLift report: %s, %s to %s", report.Name, doc.From, doc.To),
+			Text:        reportEmailText(doc),
+			Attachments: attachments,
+		})
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	if _, err := DB.ExecContext(ctx, `
+		UPDATE report_runs SET csv_document_id = ?, html_document_id = ?, emailed_to = ?, finished_at = ?
+		WHERE id = ?`, csvID, htmlID, strings.Join(emailedTo, ","), time.Now(), runID); err != nil {
+		return fail(err)
+	}
+	return scanReportRun(DB.QueryRowContext(ctx, reportRunSelect+" WHERE id = ?", runID))
+}
+
+// reportSection is the part of a report about one project, or about all of
+// them.
+type reportSection struct {
+	Title     string
+	ProjectID int64
+	Page      int
+
+	Created, Completed, Open, Overdue int // summary; open and overdue at the end
+
+	Trends     []models.TrendPoint
+	TrendChart template.HTML
+	OpenChart  template.HTML
+
+	Flow            *models.FlowMetrics
+	ThroughputChart template.HTML
+}
+
+// reportDocument is the data of a generated report and of
+// templates/report.html.
+type reportDocument struct {
+	Report    models.ReportDefinition
+	Username  string
+	From, To  string
+	Generated string
+	Timezone  string
+	Has       map[string]bool
+
+	Sections  []reportSection
+	Forecasts []models.Project
+	Missing   int // projects of the report that no longer exist
+	Pages     int
+}
+
+// buildReport works out the metrics of a report for the days before now.
+func buildReport(ctx context.Context, report models.ReportDefinition, now time.Time) (*reportDocument, error) {
+	loc := now.Location()
+	from, to := reportRange(report, now)
+	doc := &reportDocument{
+		Report:    report,
+		From:      from.Format("2006-01-02"),
+		To:        to.Format("2006-01-02"),
+		Generated: now.Format("2006-01-02 15:04 MST"),
+		Timezone:  loc.String(),
+		Has:       make(map[string]bool),
+		Forecasts: make([]models.Project, 0),
+	}
+	for _, m := range report.Metrics {
+		doc.Has[m] = true
+	}
+	if err := DB.QueryRowContext(ctx, "SELECT username FROM users WHERE id = ?", report.UserID).Scan(&doc.Username); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.QueryContext(ctx, projectSelect+`
+		WHERE p.user_id = ?
+		GROUP BY p.id
+		ORDER BY p.name`, report.UserID)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]models.Project)
+	var projects []models.Project
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			slog.Error("Project scan error", "err", err)
+			continue
+		}
+		byID[project.ID] = project
+		projects = append(projects, project)
+	}
+	rows.Close()
+
+	if len(report.ProjectIDs) == 0 {
+		doc.Sections = []reportSection{{Title: "All projects"}}
+	} else {
+		projects = nil
+		for _, id := range report.ProjectIDs {
+			project, ok := byID[id]
+			if !ok {
+				doc.Missing++
+				continue
+			}
+			projects = append(projects, project)
+			doc.Sections = append(doc.Sections, reportSection{Title: project.Name, ProjectID: int64(id)})
+		}
+	}
+
+	bounds, ok := analyticsPeriods(from, to, report.Granularity)
+	if !ok {
+		return nil, errAnalyticsRange
+	}
+	end := to.AddDate(0, 0, 1)
+	doc.Pages = 1
+	for i := range doc.Sections {
+		s := &doc.Sections[i]
+		if doc.Has["trends"] || doc.Has["flow"] {
+			doc.Pages++
+			s.Page = doc.Pages
+		}
+
+		if doc.Has["summary"] || doc.Has["trends"] {
+			tasks, err := loadAnalyticsTasks(ctx, report.UserID, s.ProjectID, loc)
+			if err != nil {
+				return nil, err
+			}
+			for _, t := range tasks {
+				if !t.created.Before(from) && t.created.Before(end) {
+					s.Created++
+				}
+				if !t.completed.IsZero() && !t.completed.Before(from) && t.completed.Before(end) {
+					s.Completed++
+				}
+				if t.openAt(end) {
+					s.Open++
+				}
+				if t.overdueAt(end) {
+					s.Overdue++
+				}
+			}
+			if doc.Has["trends"] {
+				s.Trends = trendPoints(tasks, bounds, end)
+				s.TrendChart, s.OpenChart = trendCharts(s.Trends, report.Granularity)
+			}
+		}
+
+		if doc.Has["flow"] {
+			flow, err := flowMetrics(ctx, report.UserID, s.ProjectID, from, to, now)
+			if err != nil {
+				return nil, err
+			}
+			s.Flow = &flow
+			labels := make([]string, len(flow.Throughput))
+			values := make([]float64, len(flow.Throughput))
+			for i, week := range flow.Throughput {
+				labels[i], values[i] = week.Week[5:], float64(week.Completed)
+			}
+			s.ThroughputChart = charts.Bar("Tasks completed per week", labels, values, "")
+		}
+	}
+
+	if doc.Has["forecast"] && len(projects) > 0 {
+		if err := attachForecasts(ctx, report.UserID, projects); err != nil {
+			return nil, err
+		}
+		doc.Forecasts = projects
+	}
+	return doc, nil
+}
+
+// trendCharts draws the created and completed, and the open and overdue,
+// tasks per period.
+func trendCharts(points []models.TrendPoint, granularity string) (template.HTML, template.HTML) {
+	labels := make([]string, len(points))
+	created, completed := make([]float64, len(points)), make([]float64, len(points))
+	open, overdue := make([]float64, len(points)), make([]float64, len(points))
+	for i, p := range points {
+		labels[i] = p.Period[5:]
+		if granularity == "month" {
+			labels[i] = p.Period[:7]
+		}
+		created[i], completed[i] = float64(p.Created), float64(p.Completed)
+		open[i], overdue[i] = float64(p.Open), float64(p.Overdue)
+	}
+	trend := charts.Line("Tasks created and completed per "+granularity, labels, []charts.Series{
+		{Name: "Created", Values: created, Color: "#6366f1"},
+		{Name: "Completed", Values: completed, Color: "#15f9ad"},
+	})
+	openChart := charts.Line("Open and overdue tasks", labels, []charts.Series{
+		{Name: "Open", Values: open, Color: "#6366f1"},
+		{Name: "Overdue", Values: overdue, Color: "#ef4444"},
+	})
+	return trend, openChart
+}
+
+// renderReportCSV writes a report in long form, one value per row, which
+// spreadsheets can pivot: section, project, period, metric, value.
+func renderReportCSV(doc *reportDocument) ([]byte, error) {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	write := func(section, project, period, metric string, value interface{}) {
+		cw.Write([]string{section, project, period, metric, fmt.Sprint(value)})
+	}
+	cw.Write([]string{"section", "project", "period", "metric", "value"})
+
+	period := doc.From + "/" + doc.To
+	for _, s := range doc.Sections {
+		if doc.Has["summary"] {
+			write("summary", s.Title, period, "created", s.Created)
+			write("summary", s.Title, period, "completed", s.Completed)
+			write("summary", s.Title, period, "open", s.Open)
+			write("summary", s.Title, period, "overdue", s.Overdue)
+		}
+		for _, p := range s.Trends {
+			write("trends", s.Title, p.Period, "created", p.Created)
+			write("trends", s.Title, p.Period, "completed", p.Completed)
+			write("trends", s.Title, p.Period, "open", p.Open)
+			write("trends", s.Title, p.Period, "overdue", p.Overdue)
+		}
+		if f := s.Flow; f != nil {
+			write("flow", s.Title, period, "completed", f.Completed)
+			write("flow", s.Title, period, "reopened", f.Reopened)
+			for _, d := range []struct {
+				name string
+				d    models.Durations
+			}{{"lead_time", f.LeadTime}, {"cycle_time", f.CycleTime}} {
+				write("flow", s.Title, period, d.name+"_count", d.d.Count)
+				write("flow", s.Title, period, d.name+"_mean_hours", d.d.Mean)
+				write("flow", s.Title, period, d.name+"_p50_hours", d.d.P50)
+				write("flow", s.Title, period, d.name+"_p85_hours", d.d.P85)
+				write("flow", s.Title, period, d.name+"_p95_hours", d.d.P95)
+			}
+			for _, week := range f.Throughput {
+				write("throughput", s.Title, week.Week, "completed", week.Completed)
+			}
+			for _, wip := range f.WIP {
+				name := wip.Name
+				if wip.ProjectID == nil {
+					name = "No project"
+				}
+				write("wip", name, "", "in_progress", wip.InProgress)
+				write("wip", name, "", "to_do", wip.ToDo)
+			}
+		}
+	}
+	for _, p := range doc.Forecasts {
+		f := p.Forecast
+		write("forecast", p.Name, "", "remaining", f.Remaining)
+		write("forecast", p.Name, "", "weekly_average", f.WeeklyAverage)
+		write("forecast", p.Name, "", "p50", f.P50)
+		write("forecast", p.Name, "", "p85", f.P85)
+		write("forecast", p.Name, "", "p95", f.P95)
+		if f.OnTimeChance != nil {
+			write("forecast", p.Name, "", "on_time_chance", *f.OnTimeChance)
+		}
+		write("forecast", p.Name, "", "risk", f.Risk)
+	}
+
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+func renderReportHTML(ctx context.Context, doc *reportDocument) ([]byte, error) {
+	tmpl, err := template.ParseFiles(templatePath("report.html"))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := executeTemplate(ctx, tmpl, &buf, doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func reportEmailText(doc *reportDocument) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi,\n\n%s's TaskLift report %q for %s to %s is attached.\n", doc.Username, doc.Report.Name, doc.From, doc.To)
+	if doc.Has["summary"] && len(doc.Sections) > 0 {
+		b.WriteString("\n")
+		for _, s := range doc.Sections {
+			fmt.Fprintf(&b, "%s: %d created, %d completed, %d open, %d overdue.\n", s.Title, s.Created, s.Completed, s.Open, s.Overdue)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"task-manager/diff"
+	"task-manager/models"
+	"time"
+)
+
+// Saves by the same author in quick succession, typically autosaves,
+// update the latest revision instead of adding one, as long as that
+// revision is younger than noteRevisionMaxSpan. Each note keeps at most
+// noteRevisionLimit revisions; the oldest are dropped first.
+const (
+	noteRevisionWindow  = 2 * time.Minute
+	noteRevisionMaxSpan = 15 * time.Minute
+	noteRevisionLimit   = 200
+)
+
+// execQuerier is satisfied by both *sql.DB and *sql.Tx.
+type execQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// recordNoteRevision saves a note's new title and content as a revision.
+// With coalesce, as for ordinary edits, it may fold the save into the latest
+// revision instead, see noteRevisionWindow. Revisions recorded without
+// coalesce, such as a note's creation or a restore, are never folded into,
+// so they stay available as they were. Saving an unchanged note records
+// nothing.
+func recordNoteRevision(ctx context.Context, db execQuerier, noteID int64, authorID int, title, content string, coalesce bool) error {
+	now := time.Now().UTC()
+
+	var last struct {
+		id, authorID         int
+		title, content       string
+		coalescable          bool
+		createdAt, updatedAt time.Time
+	}
+	err := db.QueryRowContext(ctx, `
+		SELECT id, author_id, title, content, coalescable, created_at, updated_at
+		FROM note_revisions WHERE note_id = ? ORDER BY id DESC LIMIT 1`, noteID).
+		Scan(&last.id, &last.authorID, &last.title, &last.content, &last.coalescable, &last.createdAt, &last.updatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil {
+		if last.title == title && last.content == content {
+			return nil
+		}
+		if coalesce && last.coalescable && last.authorID == authorID &&
+			now.Sub(last.updatedAt) < noteRevisionWindow && now.Sub(last.createdAt) < noteRevisionMaxSpan {
+			_, err := db.ExecContext(ctx, "UPDATE note_revisions SET title = ?, content = ?, updated_at = ? WHERE id = ?",
+				title, content, now, last.id)
+			return err
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO note_revisions (note_id, author_id, title, content, coalescable, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		noteID, authorID, title, content, coalesce, now, now); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		DELETE FROM note_revisions
+		WHERE note_id = ? AND id NOT IN (
+			SELECT id FROM note_revisions WHERE note_id = ? ORDER BY id DESC LIMIT ?)`,
+		noteID, noteID, noteRevisionLimit)
+	return err
+}
+
+const noteRevisionSelect = `
+	SELECT r.id, r.note_id, r.title, r.content, r.author_id, COALESCE(u.username, ''), r.created_at, r.updated_at
+	FROM note_revisions r
+	JOIN notes n ON n.id = r.note_id
+	LEFT JOIN users u ON u.id = r.author_id`
+
+func scanNoteRevision(row rowScanner) (models.NoteRevision, error) {
+	var rev models.NoteRevision
+	var createdAt, updatedAt time.Time
+	err := row.Scan(&rev.ID, &rev.NoteID, &rev.Title, &rev.Content, &rev.AuthorID, &rev.Author, &createdAt, &updatedAt)
+	rev.Size = len(rev.Content)
+	rev.CreatedAt = createdAt.Format(time.RFC3339)
+	rev.UpdatedAt = updatedAt.Format(time.RFC3339)
+	return rev, err
+}
+
+// loadNoteRevision returns a revision of one of the user's notes.
+func loadNoteRevision(ctx context.Context, userID int, id string) (models.NoteRevision, error) {
+	return scanNoteRevision(DB.QueryRowContext(ctx, noteRevisionSelect+" WHERE r.id = ? AND n.user_id = ?", id, userID))
+}
+
+// NoteRevisions lists a note's revisions, newest first, with ?note_id=, or
+// returns a single revision including its content with ?id=.
+func NoteRevisions(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		rev, err := loadNoteRevision(r.Context(), userID, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Load note revision error", "err", err)
+			http.Error(w, "Failed to retrieve revision", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(rev)
+		return
+	}
+
+	noteID := r.URL.Query().Get("note_id")
+	if noteID == "" {
+		http.Error(w, "Note ID required", http.StatusBadRequest)
+		return
+	}
+	rows, err := DB.QueryContext(r.Context(), noteRevisionSelect+" WHERE r.note_id = ? AND n.user_id = ? ORDER BY r.id DESC", noteID, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List note revisions error", "err", err)
+		http.Error(w, "Failed to retrieve revisions", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	revisions := make([]models.NoteRevision, 0)
+	for rows.Next() {
+		rev, err := scanNoteRevision(rows)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Scan error", "err", err)
+			continue
+		}
+		rev.Content = ""
+		revisions = append(revisions, rev)
+	}
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// NoteRevisionDiff compares two revisions of the same note. "from" and "to"
+// are revision IDs; without "to" the note's latest revision is used.
+// mode=unified (the default) returns a unified line diff, mode=words a list
+// of equal/insert/delete runs for inline highlighting. Revisions too long
+// or too different to compare get 422.
+func NoteRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	mode := q.Get("mode")
+	if mode == "" {
+		mode = "unified"
+	}
+	if mode != "unified" && mode != "words" {
+		http.Error(w, "mode must be unified or words", http.StatusBadRequest)
+		return
+	}
+
+	from, err := loadNoteRevision(r.Context(), userID, q.Get("from"))
+	if err != nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	var to models.NoteRevision
+	if toID := q.Get("to"); toID != "" {
+		to, err = loadNoteRevision(r.Context(), userID, toID)
+	} else {
+		to, err = scanNoteRevision(DB.QueryRowContext(r.Context(), noteRevisionSelect+" WHERE r.note_id = ? AND n.user_id = ? ORDER BY r.id DESC LIMIT 1",
+			from.NoteID, userID))
+	}
+	if err != nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if from.NoteID != to.NoteID {
+		http.Error(w, "Revisions belong to different notes", http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"note_id": from.NoteID,
+		"from":    from.ID,
+		"to":      to.ID,
+		"mode":    mode,
+	}
+	if from.Title != to.Title {
+		response["title"] = map[string]string{"from": from.Title, "to": to.Title}
+	}
+	if mode == "words" {
+		var changes []diff.Op
+		changes, err = diff.Words(from.Content, to.Content)
+		if changes == nil {
+			changes = make([]diff.Op, 0)
+		}
+		response["changes"] = changes
+	} else {
+		var unified string
+		unified, err = diff.Unified(from.Content, to.Content,
+			fmt.Sprintf("revision %d (%s)", from.ID, from.UpdatedAt),
+			fmt.Sprintf("revision %d (%s)", to.ID, to.UpdatedAt))
+		response["unified"] = unified
+	}
+	if err != nil {
+		http.Error(w, "Revisions are too different to diff", http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RestoreNoteRevision sets a note back to the title and content of revision
+// "id". The restore is itself recorded as a new revision, so it can be
+// undone the same way.
+func RestoreNoteRevision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rev, err := loadNoteRevision(r.Context(), userID, r.FormValue("id"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Load note revision error", "err", err)
+		http.Error(w, "Failed to restore revision", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := DB.ExecContext(r.Context(), "UPDATE notes SET title = ?, content = ?, updated_at = ? WHERE id = ? AND user_id = ?",
+		rev.Title, rev.Content, time.Now(), rev.NoteID, userID); err != nil {
+		slog.ErrorContext(r.Context(), "Restore note revision error", "err", err)
+		http.Error(w, "Failed to restore revision", http.StatusInternalServerError)
+		return
+	}
+	if err := recordNoteRevision(r.Context(), DB, int64(rev.NoteID), userID, rev.Title, rev.Content, false); err != nil {
+		slog.ErrorContext(r.Context(), "Record note revision error", "err", err)
+	}
+	if err := updateNoteLinks(r.Context(), DB, userID, int64(rev.NoteID), rev.Content); err != nil {
+		slog.ErrorContext(r.Context(), "Update note links error", "err", err)
+	}
+	resolveLinksTo(r.Context(), DB, userID, "note", int64(rev.NoteID), rev.Title)
+
+	note, err := loadNote(r.Context(), userID, int64(rev.NoteID))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Load note error", "err", err)
+		http.Error(w, "Failed to restore revision", http.StatusInternalServerError)
+		return
+	}
+	publish(r.Context(), userID, "note.updated", note)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(note)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func splitTags(s string) []string {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(s, ",") {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// normalizeTags lowercases tags, drops a leading "#" and removes blanks and
+// duplicates. Commas separate tags, so they can never be part of one.
+func normalizeTags(values []string) []string {
+	seen := make(map[string]bool)
+	tags := make([]string, 0)
+	for _, v := range values {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(tag), "#")))
+			if tag == "" || seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// setTaskTags replaces a task's tags. The caller must have checked ownership.
+func setTaskTags(ctx context.Context, taskID int64, tags []string) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := replaceTaskTags(ctx, tx, taskID, tags); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// replaceTaskTags is setTaskTags within an existing transaction.
+func replaceTaskTags(ctx context.Context, tx *sql.Tx, taskID int64, tags []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM task_tags WHERE task_id = ?", taskID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO task_tags (task_id, tag) VALUES (?, ?)", taskID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetTaskTags replaces the tags of a task with the comma-separated "tags" value.
+func SetTaskTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	r.ParseForm()
+	taskID, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Task ID required", http.StatusBadRequest)
+		return
+	}
+
+	res, err := DB.ExecContext(r.Context(), "UPDATE tasks SET updated_at = ? WHERE id = ? AND user_id = ?", time.Now(), taskID, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Set task tags error", "err", err)
+		http.Error(w, "Failed to update tags", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	if err := setTaskTags(r.Context(), taskID, normalizeTags(r.Form["tags"])); err != nil {
+		slog.ErrorContext(r.Context(), "Set task tags error", "err", err)
+		http.Error(w, "Failed to update tags", http.StatusInternalServerError)
+		return
+	}
+
+	task, err := loadTask(r.Context(), userID, taskID)
+	if err != nil {
+		http.Error(w, "Failed to load task", http.StatusInternalServerError)
+		return
+	}
+	publish(r.Context(), userID, "task.updated", task)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"task-manager/logging"
+	"task-manager/markdown"
 	"task-manager/models"
+	"task-manager/notecrypt"
 	"time"
 )
 
@@ -19,14 +23,79 @@ func GetCurrentUserID(r *http.Request) (int, error) {
 	}
 
 	var userID int
-	err = DB.QueryRow("SELECT id FROM users WHERE username = ?", cookie.Value).Scan(&userID)
+	err = DB.QueryRowContext(r.Context(), "SELECT id FROM users WHERE username = ?", cookie.Value).Scan(&userID)
 	if err != nil {
 		return 0, err
 	}
 
+	logging.SetUserID(r.Context(), userID)
 	return userID, nil
 }
 
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+const taskSelect = `
+	SELECT t.id, t.user_id, t.project_id, t.description, t.priority, t.done, 
+	       COALESCE(t.due_date, ''), t.created_at, COALESCE(p.name, ''),
+	       t.reminder_lead_minutes,
+	       (SELECT COALESCE(GROUP_CONCAT(tag, ','), '') FROM task_tags WHERE task_id = t.id),
+	       COALESCE(t.external_id, ''), t.source_note_id, t.started_at, t.completed_at
+	FROM tasks t 
+	LEFT JOIN projects p ON t.project_id = p.id`
+
+func scanTask(row rowScanner) (models.Task, error) {
+	var task models.Task
+	var projectID, reminderLead, sourceNoteID sql.NullInt64
+	var dueDate, createdAt, startedAt, completedAt sql.NullString
+	var tags string
+
+	err := row.Scan(&task.ID, &task.UserID, &projectID, &task.Description,
+		&task.Priority, &task.Done, &dueDate, &createdAt, &task.ProjectName,
+		&reminderLead, &tags, &task.ExternalID, &sourceNoteID, &startedAt, &completedAt)
+	if err != nil {
+		return task, err
+	}
+
+	task.Tags = splitTags(tags)
+
+	if projectID.Valid {
+		pid := int(projectID.Int64)
+		task.ProjectID = &pid
+	}
+	if dueDate.Valid {
+		task.DueDate = dueDate.String
+	}
+	if createdAt.Valid {
+		task.CreatedAt = createdAt.String
+	}
+	if startedAt.Valid {
+		task.StartedAt = startedAt.String
+	}
+	if completedAt.Valid {
+		task.CompletedAt = completedAt.String
+	}
+	if reminderLead.Valid {
+		lead := int(reminderLead.Int64)
+		task.ReminderLeadMinutes = &lead
+	}
+	if sourceNoteID.Valid {
+		noteID := int(sourceNoteID.Int64)
+		task.SourceNoteID = &noteID
+	}
+
+	if task.Priority == "" {
+		task.Priority = "medium"
+	}
+
+	return task, nil
+}
+
+func loadTask(ctx context.Context, userID int, id int64) (models.Task, error) {
+	return scanTask(DB.QueryRowContext(ctx, taskSelect+" WHERE t.id = ? AND t.user_id = ?", id, userID))
+}
+
 // Task Handlers
 func ListTasks(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -41,17 +110,11 @@ func ListTasks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `
-		SELECT t.id, t.user_id, t.project_id, t.description, t.priority, t.done, 
-		       COALESCE(t.due_date, ''), t.created_at, COALESCE(p.name, '')
-		FROM tasks t 
-		LEFT JOIN projects p ON t.project_id = p.id 
+	rows, err := DB.QueryContext(r.Context(), taskSelect+`
 		WHERE t.user_id = ?
-		ORDER BY t.created_at DESC`
-
-	rows, err := DB.Query(query, userID)
+		ORDER BY t.created_at DESC`, userID)
 	if err != nil {
-		log.Printf("Query error: %v", err)
+		slog.ErrorContext(r.Context(), "Query error", "err", err)
 		http.Error(w, "Failed to retrieve tasks", http.StatusInternalServerError)
 		return
 	}
@@ -59,33 +122,11 @@ func ListTasks(w http.ResponseWriter, r *http.Request) {
 
 	tasks := make([]models.Task, 0)
 	for rows.Next() {
-		var task models.Task
-		var projectID sql.NullInt64
-		var dueDate, createdAt sql.NullString
-
-		err := rows.Scan(&task.ID, &task.UserID, &projectID, &task.Description,
-			&task.Priority, &task.Done, &dueDate, &createdAt, &task.ProjectName)
-
+		task, err := scanTask(rows)
 		if err != nil {
-			log.Printf("Scan error: %v", err)
+			slog.ErrorContext(r.Context(), "Scan error", "err", err)
 			continue
 		}
-
-		if projectID.Valid {
-			pid := int(projectID.Int64)
-			task.ProjectID = &pid
-		}
-		if dueDate.Valid {
-			task.DueDate = dueDate.String
-		}
-		if createdAt.Valid {
-			task.CreatedAt = createdAt.String
-		}
-
-		if task.Priority == "" {
-			task.Priority = "medium"
-		}
-
 		tasks = append(tasks, task)
 	}
 
@@ -95,18 +136,18 @@ func ListTasks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tmpl, err := template.ParseFiles("templates/list_tasks.html")
+	tmpl, err := template.ParseFiles(templatePath("list_tasks.html"))
 	if err != nil {
 		http.Error(w, "Template error", http.StatusInternalServerError)
 		return
 	}
 
-	tmpl.Execute(w, tasks)
+	executeTemplate(r.Context(), tmpl, w, tasks)
 }
 
 func CreateTask(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		http.ServeFile(w, r, "templates/create_task.html")
+		http.ServeFile(w, r, templatePath("create_task.html"))
 		return
 	}
 
@@ -139,16 +180,17 @@ func CreateTask(w http.ResponseWriter, r *http.Request) {
 			projectID = nil
 		}
 
-		_, err = DB.Exec(`
+		res, err := DB.ExecContext(r.Context(), `
 			INSERT INTO tasks (user_id, project_id, description, priority, due_date, done, created_at, updated_at) 
 			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
 			userID, projectID, description, priority, dueDate, false, time.Now(), time.Now())
 
 		if err != nil {
-			log.Printf("Create task error: %v", err)
+			slog.ErrorContext(r.Context(), "Create task error", "err", err)
 			http.Error(w, "Failed to create task", http.StatusInternalServerError)
 			return
 		}
+		publishTask(r.Context(), userID, res, "task.created")
 
 		if r.Header.Get("X-Requested-With") == "XMLHttpRequest" || r.URL.Path == "/api/tasks" {
 			w.Header().Set("Content-Type", "application/json")
@@ -162,7 +204,7 @@ func CreateTask(w http.ResponseWriter, r *http.Request) {
 
 func UpdateTask(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		http.ServeFile(w, r, "templates/update_task.html")
+		http.ServeFile(w, r, templatePath("update_task.html"))
 		return
 	}
 
@@ -188,18 +230,30 @@ func UpdateTask(w http.ResponseWriter, r *http.Request) {
 			priority = "medium"
 		}
 
-		_, err = DB.Exec(`
+		var wasDone bool
+		DB.QueryRowContext(r.Context(), "SELECT done FROM tasks WHERE id = ? AND user_id = ?", id, userID).Scan(&wasDone)
+
+		_, err = DB.ExecContext(r.Context(), `
 			UPDATE tasks 
 			SET description = ?, priority = ?, due_date = ?, done = ?, updated_at = ?
 			WHERE id = ? AND user_id = ?`,
 			description, priority, dueDate, done, time.Now(), id, userID)
 
 		if err != nil {
-			log.Printf("Update task error: %v", err)
+			slog.ErrorContext(r.Context(), "Update task error", "err", err)
 			http.Error(w, "Failed to update task", http.StatusInternalServerError)
 			return
 		}
 
+		if taskID, err := strconv.ParseInt(id, 10, 64); err == nil {
+			if task, err := loadTask(r.Context(), userID, taskID); err == nil {
+				publish(r.Context(), userID, "task.updated", task)
+				if done && !wasDone {
+					publish(r.Context(), userID, "task.completed", task)
+				}
+			}
+		}
+
 		if r.Header.Get("X-Requested-With") == "XMLHttpRequest" || r.Referer() == "" {
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
@@ -224,12 +278,19 @@ func DeleteTask(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		_, err = DB.Exec("DELETE FROM tasks WHERE id = ? AND user_id = ?", id, userID)
+		res, err := DB.ExecContext(r.Context(), "DELETE FROM tasks WHERE id = ? AND user_id = ?", id, userID)
 		if err != nil {
-			log.Printf("Delete task error: %v", err)
+			slog.ErrorContext(r.Context(), "Delete task error", "err", err)
 			http.Error(w, "Failed to delete task", http.StatusInternalServerError)
 			return
 		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			DB.ExecContext(r.Context(), "DELETE FROM task_tags WHERE task_id = ?", id)
+			if taskID, err := strconv.ParseInt(id, 10, 64); err == nil {
+				unlinkTarget(r.Context(), DB, userID, "task", taskID)
+			}
+		}
+		publishDeleted(r.Context(), userID, res, "task.deleted", id)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
@@ -261,16 +322,24 @@ func APITasks(w http.ResponseWriter, r *http.Request) {
 			task.Priority = "medium"
 		}
 
-		_, err := DB.Exec(`
-			INSERT INTO tasks (user_id, project_id, description, priority, due_date, done, created_at, updated_at) 
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-			userID, task.ProjectID, task.Description, task.Priority, task.DueDate, task.Done, time.Now(), time.Now())
+		res, err := DB.ExecContext(r.Context(), `
+			INSERT INTO tasks (user_id, project_id, description, priority, due_date, done, reminder_lead_minutes, created_at, updated_at) 
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID, task.ProjectID, task.Description, task.Priority, task.DueDate, task.Done, task.ReminderLeadMinutes, time.Now(), time.Now())
 
 		if err != nil {
-			log.Printf("API create task error: %v", err)
+			slog.ErrorContext(r.Context(), "API create task error", "err", err)
 			http.Error(w, "Failed to create task", http.StatusInternalServerError)
 			return
 		}
+		if len(task.Tags) > 0 {
+			if id, err := res.LastInsertId(); err == nil {
+				if err := setTaskTags(r.Context(), id, normalizeTags(task.Tags)); err != nil {
+					slog.ErrorContext(r.Context(), "API create task tags error", "err", err)
+				}
+			}
+		}
+		publishTask(r.Context(), userID, res, "task.created")
 
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(map[string]string{"status": "created"})
@@ -286,12 +355,13 @@ func APIAnalytics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var totalTasks, completedTasks, highPriorityTasks, totalProjects int
+	var totalTasks, completedTasks, highPriorityTasks, totalProjects, activeProjects int
 
-	DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE user_id = ?", userID).Scan(&totalTasks)
-	DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE done = 1 AND user_id = ?", userID).Scan(&completedTasks)
-	DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE priority = 'high' AND user_id = ?", userID).Scan(&highPriorityTasks)
-	DB.QueryRow("SELECT COUNT(*) FROM projects WHERE user_id = ?", userID).Scan(&totalProjects)
+	DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM tasks WHERE user_id = ?", userID).Scan(&totalTasks)
+	DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM tasks WHERE done = 1 AND user_id = ?", userID).Scan(&completedTasks)
+	DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM tasks WHERE priority = 'high' AND user_id = ?", userID).Scan(&highPriorityTasks)
+	DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM projects WHERE user_id = ?", userID).Scan(&totalProjects)
+	DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM projects WHERE status = 'active' AND user_id = ?", userID).Scan(&activeProjects)
 
 	completionRate := 0.0
 	if totalTasks > 0 {
@@ -305,7 +375,7 @@ func APIAnalytics(w http.ResponseWriter, r *http.Request) {
 		"high_priority_tasks": highPriorityTasks,
 		"completion_rate":     completionRate,
 		"total_projects":      totalProjects,
-		"active_projects":     0,
+		"active_projects":     activeProjects,
 	}
 
 	json.NewEncoder(w).Encode(analytics)
@@ -349,23 +419,77 @@ func CreateProject(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	_, err = DB.Exec(`
+	res, err := DB.ExecContext(r.Context(), `
 		INSERT INTO projects (user_id, name, description, status, due_date, team_members, created_at) 
 		VALUES (?, ?, ?, ?, ?, ?, ?)`,
 		userID, name, description, status, dueDate, tm, time.Now())
 
 	if err != nil {
-		log.Println("Failed to create project:", err)
+		slog.ErrorContext(r.Context(), "Failed to create project", "err", err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create project"})
 		return
 	}
+	if projectID, err := res.LastInsertId(); err == nil {
+		resolveLinksTo(r.Context(), DB, userID, "project", projectID, name)
+		if project, err := loadProject(r.Context(), userID, projectID); err == nil {
+			publish(r.Context(), userID, "project.created", project)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "created"})
 }
 
+const projectSelect = `
+	SELECT p.id, p.user_id, p.name, p.description, p.status, p.progress, 
+	       COALESCE(p.due_date, ''), p.created_at,
+	       COUNT(t.id) as task_count,
+	       COUNT(CASE WHEN t.done = 1 THEN 1 END) as completed_tasks,
+	       p.team_members, COALESCE(p.external_id, '')
+	FROM projects p 
+	LEFT JOIN tasks t ON p.id = t.project_id`
+
+func scanProject(row rowScanner) (models.Project, error) {
+	var project models.Project
+	var description, dueDate sql.NullString
+	var teamMembers sql.NullInt64
+
+	err := row.Scan(
+		&project.ID, &project.UserID, &project.Name, &description,
+		&project.Status, &project.Progress, &dueDate, &project.CreatedAt,
+		&project.TaskCount, &project.CompletedTasks, &teamMembers,
+		&project.ExternalID,
+	)
+	if err != nil {
+		return project, err
+	}
+
+	project.Description = description.String
+	if dueDate.Valid {
+		project.DueDate = dueDate.String
+	}
+
+	if teamMembers.Valid {
+		project.TeamMembers = int(teamMembers.Int64)
+	} else {
+		project.TeamMembers = 0
+	}
+
+	if project.TaskCount > 0 {
+		project.Progress = (project.CompletedTasks * 100) / project.TaskCount
+	}
+
+	return project, nil
+}
+
+func loadProject(ctx context.Context, userID int, id int64) (models.Project, error) {
+	return scanProject(DB.QueryRowContext(ctx, projectSelect+`
+		WHERE p.id = ? AND p.user_id = ?
+		GROUP BY p.id`, id, userID))
+}
+
 func ListProjects(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -375,19 +499,12 @@ func ListProjects(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := DB.Query(`
-		SELECT p.id, p.name, p.description, p.status, p.progress, 
-		       COALESCE(p.due_date, ''), p.created_at,
-		       COUNT(t.id) as task_count,
-		       COUNT(CASE WHEN t.done = 1 THEN 1 END) as completed_tasks,
-		       p.team_members
-		FROM projects p 
-		LEFT JOIN tasks t ON p.id = t.project_id 
+	rows, err := DB.QueryContext(r.Context(), projectSelect+`
 		WHERE p.user_id = ? 
 		GROUP BY p.id, p.name, p.description, p.status, p.progress, p.due_date, p.created_at, p.team_members
 		ORDER BY p.created_at DESC`, userID)
 	if err != nil {
-		log.Println("DB query error:", err)
+		slog.ErrorContext(r.Context(), "DB query error", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to retrieve projects"})
 		return
@@ -396,36 +513,16 @@ func ListProjects(w http.ResponseWriter, r *http.Request) {
 
 	projects := make([]models.Project, 0)
 	for rows.Next() {
-		var project models.Project
-		var dueDate sql.NullString
-		var teamMembers sql.NullInt64
-
-		err := rows.Scan(
-			&project.ID, &project.Name, &project.Description,
-			&project.Status, &project.Progress, &dueDate, &project.CreatedAt,
-			&project.TaskCount, &project.CompletedTasks, &teamMembers,
-		)
+		project, err := scanProject(rows)
 		if err != nil {
-			log.Println("Row scan error:", err)
+			slog.ErrorContext(r.Context(), "Row scan error", "err", err)
 			continue
 		}
-
-		if dueDate.Valid {
-			project.DueDate = dueDate.String
-		}
-
-		if teamMembers.Valid {
-			project.TeamMembers = int(teamMembers.Int64)
-		} else {
-			project.TeamMembers = 0
-		}
-
-		if project.TaskCount > 0 {
-			project.Progress = (project.CompletedTasks * 100) / project.TaskCount
-		}
-
 		projects = append(projects, project)
 	}
+	if err := attachForecasts(r.Context(), userID, projects); err != nil {
+		slog.ErrorContext(r.Context(), "Project forecast error", "err", err)
+	}
 
 	json.NewEncoder(w).Encode(projects)
 }
@@ -461,17 +558,23 @@ func UpdateProject(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	_, err = DB.Exec(`
+	_, err = DB.ExecContext(r.Context(), `
 		UPDATE projects 
 		SET name = ?, description = ?, status = ?, due_date = ?, team_members = ?, updated_at = ? 
 		WHERE id = ? AND user_id = ?`,
 		name, description, status, dueDate, tm, time.Now(), id, userID)
 
 	if err != nil {
-		log.Println("Failed to update project:", err)
+		slog.ErrorContext(r.Context(), "Failed to update project", "err", err)
 		http.Error(w, "Failed to update project", http.StatusInternalServerError)
 		return
 	}
+	if projectID, err := strconv.ParseInt(id, 10, 64); err == nil {
+		if project, err := loadProject(r.Context(), userID, projectID); err == nil {
+			resolveLinksTo(r.Context(), DB, userID, "project", projectID, project.Name)
+			publish(r.Context(), userID, "project.updated", project)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
@@ -491,17 +594,28 @@ func DeleteProject(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		_, err = DB.Exec("UPDATE tasks SET project_id = NULL WHERE project_id = ?", id)
+		_, err = DB.ExecContext(r.Context(), "UPDATE tasks SET project_id = NULL WHERE project_id = ?", id)
 		if err != nil {
 			http.Error(w, "Failed to unlink tasks", http.StatusInternalServerError)
 			return
 		}
+		_, err = DB.ExecContext(r.Context(), "UPDATE notes SET project_id = NULL WHERE project_id = ? AND user_id = ?", id, userID)
+		if err != nil {
+			http.Error(w, "Failed to unlink notes", http.StatusInternalServerError)
+			return
+		}
 
-		_, err = DB.Exec("DELETE FROM projects WHERE id = ? AND user_id = ?", id, userID)
+		res, err := DB.ExecContext(r.Context(), "DELETE FROM projects WHERE id = ? AND user_id = ?", id, userID)
 		if err != nil {
 			http.Error(w, "Failed to delete project", http.StatusInternalServerError)
 			return
 		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			if projectID, err := strconv.ParseInt(id, 10, 64); err == nil {
+				unlinkTarget(r.Context(), DB, userID, "project", projectID)
+			}
+		}
+		publishDeleted(r.Context(), userID, res, "project.deleted", id)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
@@ -525,21 +639,91 @@ func CreateNote(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		_, err = DB.Exec(`
-			INSERT INTO notes (user_id, title, content, created_at, updated_at) 
-			VALUES (?, ?, ?, ?, ?)`,
-			userID, title, content, time.Now(), time.Now())
+		notebookID, position, err := noteNotebookFormValue(r.Context(), userID, r.FormValue("notebook_id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		projectID, err := noteProjectFormValue(r.Context(), userID, r.FormValue("project_id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		encrypted := r.FormValue("encrypted") == "true"
+		stored := content
+		if encrypted {
+			if stored, err = sealNote(r, userID, content); err == errNotesLocked {
+				http.Error(w, "Unlock private notes first", http.StatusLocked)
+				return
+			} else if err != nil {
+				http.Error(w, "Failed to create note", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		res, err := DB.ExecContext(r.Context(), `
+			INSERT INTO notes (user_id, title, content, notebook_id, project_id, position, encrypted, created_at, updated_at) 
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID, title, stored, notebookID, projectID, position, encrypted, time.Now(), time.Now())
 
 		if err != nil {
 			http.Error(w, "Failed to create note", http.StatusInternalServerError)
 			return
 		}
+		if noteID, err := res.LastInsertId(); err == nil {
+			if !encrypted {
+				if err := recordNoteRevision(r.Context(), DB, noteID, userID, title, content, false); err != nil {
+					slog.ErrorContext(r.Context(), "Record note revision error", "err", err)
+				}
+				if err := updateNoteLinks(r.Context(), DB, userID, noteID, content); err != nil {
+					slog.ErrorContext(r.Context(), "Update note links error", "err", err)
+				}
+			}
+			resolveLinksTo(r.Context(), DB, userID, "note", noteID, title)
+			if note, err := loadNote(r.Context(), userID, noteID); err == nil {
+				publish(r.Context(), userID, "note.created", note)
+			}
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "created"})
 	}
 }
 
+const noteSelect = `
+	SELECT id, user_id, title, COALESCE(content, ''), notebook_id, project_id, pinned, position,
+	       created_at, updated_at, encrypted 
+	FROM notes`
+
+func scanNote(row rowScanner) (models.Note, error) {
+	var note models.Note
+	var notebookID, projectID sql.NullInt64
+	err := row.Scan(&note.ID, &note.UserID, &note.Title, &note.Content, &notebookID, &projectID,
+		&note.Pinned, &note.Position, &note.CreatedAt, &note.UpdatedAt, &note.Encrypted)
+	if notebookID.Valid {
+		id := int(notebookID.Int64)
+		note.NotebookID = &id
+	}
+	if projectID.Valid {
+		id := int(projectID.Int64)
+		note.ProjectID = &id
+	}
+	if note.Encrypted {
+		note.Ciphertext, note.Content, note.Locked = note.Content, "", true
+		return note, err
+	}
+	note.ContentHTML = markdown.Render(note.Content)
+	return note, err
+}
+
+func loadNote(ctx context.Context, userID int, id int64) (models.Note, error) {
+	return scanNote(DB.QueryRowContext(ctx, noteSelect+" WHERE id = ? AND user_id = ?", id, userID))
+}
+
+// ListNotes lists the user's notes, pinned notes first. ?notebook_id=
+// limits the list to one notebook, sorted the way that notebook is set up
+// (0 lists the notes in no notebook), and ?project_id= to the notes attached
+// to a project.
 func ListNotes(w http.ResponseWriter, r *http.Request) {
 	userID, err := GetCurrentUserID(r)
 	if err != nil {
@@ -547,11 +731,39 @@ func ListNotes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := DB.Query(`
-		SELECT id, title, content, created_at, updated_at 
-		FROM notes 
-		WHERE user_id = ? 
-		ORDER BY updated_at DESC`, userID)
+	where, args, order := "user_id = ?", []interface{}{userID}, "updated_at DESC"
+	if id := r.URL.Query().Get("notebook_id"); id != "" {
+		notebookID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid notebook ID", http.StatusBadRequest)
+			return
+		}
+		if notebookID == 0 {
+			where += " AND notebook_id IS NULL"
+		} else {
+			sortOrder, err := notebookSortOrder(r.Context(), DB, userID, notebookID)
+			if err == sql.ErrNoRows {
+				http.Error(w, "Notebook not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Load notebook error", "err", err)
+				http.Error(w, "Failed to retrieve notes", http.StatusInternalServerError)
+				return
+			}
+			where += " AND notebook_id = ?"
+			args = append(args, notebookID)
+			order = noteOrders[sortOrder]
+		}
+	}
+	if id := r.URL.Query().Get("project_id"); id != "" {
+		where += " AND project_id = ?"
+		args = append(args, id)
+	}
+
+	rows, err := DB.QueryContext(r.Context(), noteSelect+`
+		WHERE `+where+` 
+		ORDER BY pinned DESC, `+order, args...)
 
 	if err != nil {
 		http.Error(w, "Failed to retrieve notes", http.StatusInternalServerError)
@@ -559,13 +771,21 @@ func ListNotes(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
+	key := unlockedNoteKey(r, userID)
+	defer notecrypt.Zero(key)
+
 	notes := make([]models.Note, 0)
 	for rows.Next() {
-		var note models.Note
-		err := rows.Scan(&note.ID, &note.Title, &note.Content, &note.CreatedAt, &note.UpdatedAt)
+		note, err := scanNote(rows)
 		if err != nil {
+			slog.ErrorContext(r.Context(), "Note scan error", "err", err)
 			continue
 		}
+		if note.Encrypted && key != nil {
+			if err := revealNote(&note, key); err != nil {
+				slog.ErrorContext(r.Context(), "Decrypt note error", "note_id", note.ID, "err", err)
+			}
+		}
 		notes = append(notes, note)
 	}
 
@@ -590,16 +810,43 @@ func UpdateNote(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		_, err = DB.Exec(`
+		// Private notes are sealed again with the unlocked key.
+		var encrypted bool
+		DB.QueryRowContext(r.Context(), "SELECT encrypted FROM notes WHERE id = ? AND user_id = ?", id, userID).Scan(&encrypted)
+		if encrypted {
+			if content, err = sealNote(r, userID, content); err == errNotesLocked {
+				http.Error(w, "Unlock private notes first", http.StatusLocked)
+				return
+			} else if err != nil {
+				http.Error(w, "Failed to update note", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		_, err = DB.ExecContext(r.Context(), `
 			UPDATE notes 
 			SET title = ?, content = ?, updated_at = ? 
-			WHERE id = ? AND user_id = ?`,
-			title, content, time.Now(), id, userID)
+			WHERE id = ? AND user_id = ? AND encrypted = ?`,
+			title, content, time.Now(), id, userID, encrypted)
 
 		if err != nil {
 			http.Error(w, "Failed to update note", http.StatusInternalServerError)
 			return
 		}
+		if noteID, err := strconv.ParseInt(id, 10, 64); err == nil {
+			if note, err := loadNote(r.Context(), userID, noteID); err == nil {
+				if !note.Encrypted {
+					if err := recordNoteRevision(r.Context(), DB, noteID, userID, note.Title, note.Content, true); err != nil {
+						slog.ErrorContext(r.Context(), "Record note revision error", "err", err)
+					}
+					if err := updateNoteLinks(r.Context(), DB, userID, noteID, note.Content); err != nil {
+						slog.ErrorContext(r.Context(), "Update note links error", "err", err)
+					}
+				}
+				resolveLinksTo(r.Context(), DB, userID, "note", noteID, note.Title)
+				publish(r.Context(), userID, "note.updated", note)
+			}
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
@@ -620,11 +867,17 @@ func DeleteNote(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		_, err = DB.Exec("DELETE FROM notes WHERE id = ? AND user_id = ?", id, userID)
+		res, err := DB.ExecContext(r.Context(), "DELETE FROM notes WHERE id = ? AND user_id = ?", id, userID)
 		if err != nil {
 			http.Error(w, "Failed to delete note", http.StatusInternalServerError)
 			return
 		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			if noteID, err := strconv.ParseInt(id, 10, 64); err == nil {
+				deleteNoteData(r.Context(), DB, userID, noteID)
+			}
+		}
+		publishDeleted(r.Context(), userID, res, "note.deleted", id)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
@@ -640,7 +893,7 @@ func Documents(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		rows, err := DB.Query(`
+		rows, err := DB.QueryContext(r.Context(), `
 			SELECT id, title, file_type, file_size, created_at 
 			FROM documents 
 			WHERE user_id = ? 
@@ -657,6 +910,7 @@ func Documents(w http.ResponseWriter, r *http.Request) {
 			var doc models.Document
 			err := rows.Scan(&doc.ID, &doc.Title, &doc.FileType, &doc.FileSize, &doc.CreatedAt)
 			if err != nil {
+				slog.ErrorContext(r.Context(), "Document scan error", "err", err)
 				continue
 			}
 			documents = append(documents, doc)
@@ -680,34 +934,3 @@ func Documents(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Document upload not yet implemented", http.StatusNotImplemented)
 	}
 }
-
-func Analytics(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetCurrentUserID(r)
-	if err != nil {
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
-		return
-	}
-
-	var totalTasks, completedTasks, highPriorityTasks, totalProjects, activeProjects, totalNotes int
-
-	DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE user_id = ?", userID).Scan(&totalTasks)
-	DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE done = 1 AND user_id = ?", userID).Scan(&completedTasks)
-	DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE priority = 'high' AND user_id = ?", userID).Scan(&highPriorityTasks)
-	DB.QueryRow("SELECT COUNT(*) FROM projects WHERE user_id = ?", userID).Scan(&totalProjects)
-	DB.QueryRow("SELECT COUNT(*) FROM projects WHERE status = 'active' AND user_id = ?", userID).Scan(&activeProjects)
-	DB.QueryRow("SELECT COUNT(*) FROM notes WHERE user_id = ?", userID).Scan(&totalNotes)
-
-	completionRate := 0.0
-	if totalTasks > 0 {
-		completionRate = float64(completedTasks) / float64(totalTasks) * 100
-	}
-
-	w.Header().Set("Content-Type", "text/html")
-	html := `<!DOCTYPE html><html><head><title>Analytics</title></head><body>
-		<h1>Analytics Dashboard</h1>
-		<p>Total Tasks: ` + strconv.Itoa(totalTasks) + `</p>
-		<p>Completed: ` + strconv.Itoa(completedTasks) + `</p>
-		<p>Completion Rate: ` + strconv.FormatFloat(completionRate, 'f', 1, 64) + `%</p>
-		</body></html>`
-	w.Write([]byte(html))
-}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var signingKey []byte
+
+var errInvalidToken = errors.New("invalid token")
+
+// InitSigningKey sets the key used to sign links sent outside the app, such
+// as unsubscribe links. Without a configured secret a random key is created
+// once and kept in app_settings so links survive restarts.
+func InitSigningKey(secret string) error {
+	if secret != "" {
+		signingKey = []byte(secret)
+		return nil
+	}
+
+	var stored string
+	err := DB.QueryRowContext(context.Background(), "SELECT value FROM app_settings WHERE key = 'signing_key'").Scan(&stored)
+	if err == sql.ErrNoRows {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		stored = hex.EncodeToString(b)
+		if _, err := DB.ExecContext(context.Background(), "INSERT OR IGNORE INTO app_settings (key, value) VALUES ('signing_key', ?)", stored); err != nil {
+			return err
+		}
+		// Another instance may have won the race; use whatever is stored.
+		err = DB.QueryRowContext(context.Background(), "SELECT value FROM app_settings WHERE key = 'signing_key'").Scan(&stored)
+	}
+	if err != nil {
+		return err
+	}
+
+	signingKey = []byte(stored)
+	return nil
+}
+
+// signToken returns a token binding userID to purpose, e.g.
+// "digest-unsubscribe". Tokens do not expire.
+func signToken(purpose string, userID int) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(purpose + ":" + strconv.Itoa(userID)))
+	return payload + "." + tokenMAC(payload)
+}
+
+// verifyToken checks a token made by signToken and returns its user ID.
+func verifyToken(purpose, token string) (int, error) {
+	payload, mac, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(tokenMAC(payload))) {
+		return 0, errInvalidToken
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return 0, errInvalidToken
+	}
+	gotPurpose, id, ok := strings.Cut(string(raw), ":")
+	if !ok || gotPurpose != purpose {
+		return 0, errInvalidToken
+	}
+
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, errInvalidToken
+	}
+	return userID, nil
+}
+
+func tokenMAC(payload string) string {
+	h := hmac.New(sha256.New, signingKey)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
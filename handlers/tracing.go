@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"task-manager/tracing"
+)
+
+// executableTemplate is what html/template and text/template templates
+// have in common.
+type executableTemplate interface {
+	Name() string
+	Execute(w io.Writer, data interface{}) error
+}
+
+// executeTemplate renders tmpl to w inside a span of its own.
+func executeTemplate(ctx context.Context, tmpl executableTemplate, w io.Writer, data interface{}) error {
+	_, span := tracing.Start(ctx, "template "+tmpl.Name())
+	err := tmpl.Execute(w, data)
+	tracing.End(span, err)
+	return err
+}
@@ -0,0 +1,760 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"task-manager/events"
+	"task-manager/models"
+	"task-manager/tracing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// webhookEventTypes lists the events a webhook can subscribe to. A
+// subscription may also use "*" or a prefix wildcard such as "task.*".
+var webhookEventTypes = []string{
+	"task.created", "task.updated", "task.completed", "task.deleted",
+	"project.created", "project.updated", "project.deleted",
+	"note.created", "note.updated", "note.deleted",
+}
+
+const (
+	webhookMaxAttempts = 8
+	webhookBaseBackoff = 10 * time.Second
+	webhookMaxBackoff  = time.Hour
+)
+
+// parseWebhookEvents accepts comma-separated or repeated event values.
+func parseWebhookEvents(values []string) ([]string, error) {
+	var patterns []string
+	for _, v := range values {
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			if !validWebhookPattern(p) {
+				return nil, fmt.Errorf("unknown event type %q", p)
+			}
+			patterns = append(patterns, p)
+		}
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("at least one event type is required")
+	}
+	return patterns, nil
+}
+
+func validWebhookPattern(p string) bool {
+	if p == "*" {
+		return true
+	}
+	for _, t := range webhookEventTypes {
+		if t == p || (strings.HasSuffix(p, ".*") && strings.HasPrefix(t, strings.TrimSuffix(p, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func webhookMatches(patterns []string, eventType string) bool {
+	for _, p := range patterns {
+		if p == "*" || p == eventType {
+			return true
+		}
+		if strings.HasSuffix(p, ".*") && strings.HasPrefix(eventType, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// errBlockedWebhookAddress is why a webhook may not be sent to an address
+// on the server's own network.
+var errBlockedWebhookAddress = errors.New("webhook URLs must not point at loopback, private or link-local addresses")
+
+// blockedWebhookPrefixes are the special-purpose ranges netip has no
+// predicate for.
+var blockedWebhookPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// blockedWebhookIP reports whether ip is on the server's own network:
+// loopback, private (RFC 1918 and unique local) and link-local addresses,
+// which include cloud metadata services such as 169.254.169.254.
+func blockedWebhookIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return true
+	}
+	for _, p := range blockedWebhookPrefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWebhookURL rejects anything but an http(s) URL whose host resolves
+// only to public addresses. The dispatcher checks the address again when it
+// connects, since DNS may answer differently by then.
+func checkWebhookURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("a valid http(s) URL is required")
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s", u.Hostname())
+	}
+	for _, ip := range ips {
+		if blockedWebhookIP(ip) {
+			return errBlockedWebhookAddress
+		}
+	}
+	return nil
+}
+
+// webhookDialControl refuses connections to blocked addresses. It runs
+// after name resolution, for every connection including redirects, so a
+// receiver cannot get past checkWebhookURL by changing its DNS records.
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	addr, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if blockedWebhookIP(addr.Addr()) {
+		return errBlockedWebhookAddress
+	}
+	return nil
+}
+
+// newWebhookClient returns the client deliveries are sent with. It ignores
+// proxy settings, since the dial check would then only see the proxy.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   webhookDialControl,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Webhooks lists the current user's webhooks (GET) or creates one (POST).
+// The secret is only returned when the webhook is created.
+func Webhooks(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := DB.QueryContext(r.Context(), `
+			SELECT id, user_id, url, events, active, created_at
+			FROM webhooks
+			WHERE user_id = ?
+			ORDER BY created_at DESC`, userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "List webhooks error", "err", err)
+			http.Error(w, "Failed to retrieve webhooks", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		hooks := make([]models.Webhook, 0)
+		for rows.Next() {
+			var hook models.Webhook
+			var eventList string
+			if err := rows.Scan(&hook.ID, &hook.UserID, &hook.URL, &eventList, &hook.Active, &hook.CreatedAt); err != nil {
+				slog.ErrorContext(r.Context(), "Scan error", "err", err)
+				continue
+			}
+			hook.Events = strings.Split(eventList, ",")
+			hooks = append(hooks, hook)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hooks)
+
+	case http.MethodPost:
+		r.ParseForm()
+		hookURL := r.FormValue("url")
+		if err := checkWebhookURL(r.Context(), hookURL); err != nil {
+			http.Error(w, "Invalid webhook URL: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		patterns, err := parseWebhookEvents(r.Form["events"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		secret := r.FormValue("secret")
+		if secret == "" {
+			secret = "whsec_" + randomHex(24)
+		}
+
+		now := time.Now()
+		res, err := DB.ExecContext(r.Context(), `
+			INSERT INTO webhooks (user_id, url, secret, events, active, created_at, updated_at)
+			VALUES (?, ?, ?, ?, 1, ?, ?)`,
+			userID, hookURL, secret, strings.Join(patterns, ","), now, now)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Create webhook error", "err", err)
+			http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+			return
+		}
+		id, _ := res.LastInsertId()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(models.Webhook{
+			ID:        int(id),
+			UserID:    userID,
+			URL:       hookURL,
+			Secret:    secret,
+			Events:    patterns,
+			Active:    true,
+			CreatedAt: now.Format(time.RFC3339Nano),
+		})
+
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+func UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	r.ParseForm()
+	id := r.FormValue("id")
+	hookURL := r.FormValue("url")
+	if id == "" {
+		http.Error(w, "Webhook ID required", http.StatusBadRequest)
+		return
+	}
+	if err := checkWebhookURL(r.Context(), hookURL); err != nil {
+		http.Error(w, "Invalid webhook URL: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	patterns, err := parseWebhookEvents(r.Form["events"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	active := r.FormValue("active") != "off" && r.FormValue("active") != "false"
+
+	res, err := DB.ExecContext(r.Context(), `
+		UPDATE webhooks
+		SET url = ?, events = ?, active = ?, updated_at = ?
+		WHERE id = ? AND user_id = ?`,
+		hookURL, strings.Join(patterns, ","), active, time.Now(), id, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Update webhook error", "err", err)
+		http.Error(w, "Failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
+
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := r.FormValue("id")
+	if id == "" {
+		http.Error(w, "Webhook ID required", http.StatusBadRequest)
+		return
+	}
+
+	res, err := DB.ExecContext(r.Context(), "DELETE FROM webhooks WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Delete webhook error", "err", err)
+		http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		DB.ExecContext(r.Context(), `DELETE FROM webhook_delivery_attempts WHERE delivery_id IN
+			(SELECT id FROM webhook_deliveries WHERE webhook_id = ?)`, id)
+		DB.ExecContext(r.Context(), "DELETE FROM webhook_deliveries WHERE webhook_id = ?", id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// WebhookDeliveries returns the most recent deliveries of a webhook together
+// with every attempt's response code.
+func WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	webhookID := r.URL.Query().Get("webhook_id")
+	if webhookID == "" {
+		http.Error(w, "Webhook ID required", http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 500 {
+		limit = v
+	}
+
+	rows, err := DB.QueryContext(r.Context(), `
+		SELECT d.id, d.webhook_id, d.event_id, d.event_type, d.status, d.attempts,
+		       d.response_code, COALESCE(d.error, ''), COALESCE(d.next_attempt_at, ''), d.created_at
+		FROM webhook_deliveries d
+		JOIN webhooks h ON h.id = d.webhook_id
+		WHERE d.webhook_id = ? AND h.user_id = ?
+		ORDER BY d.id DESC
+		LIMIT ?`, webhookID, userID, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List webhook deliveries error", "err", err)
+		http.Error(w, "Failed to retrieve deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		var d models.WebhookDelivery
+		var code sql.NullInt64
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Status, &d.Attempts,
+			&code, &d.Error, &d.NextAttemptAt, &d.CreatedAt); err != nil {
+			slog.ErrorContext(r.Context(), "Scan error", "err", err)
+			continue
+		}
+		if code.Valid {
+			c := int(code.Int64)
+			d.ResponseCode = &c
+		}
+		d.AttemptLog = make([]models.WebhookDeliveryAttempt, 0)
+		deliveries = append(deliveries, d)
+	}
+	rows.Close()
+
+	for i := range deliveries {
+		attempts, err := DB.QueryContext(r.Context(), `
+			SELECT attempt, response_code, COALESCE(error, ''),
+			       COALESCE(duration_ms, 0), created_at
+			FROM webhook_delivery_attempts
+			WHERE delivery_id = ?
+			ORDER BY attempt`, deliveries[i].ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "List webhook delivery attempts error", "err", err)
+			continue
+		}
+		for attempts.Next() {
+			var a models.WebhookDeliveryAttempt
+			var code sql.NullInt64
+			if err := attempts.Scan(&a.Attempt, &code, &a.Error, &a.DurationMS, &a.CreatedAt); err != nil {
+				slog.ErrorContext(r.Context(), "Webhook delivery attempt scan error", "err", err)
+				continue
+			}
+			if code.Valid {
+				c := int(code.Int64)
+				a.ResponseCode = &c
+			}
+			deliveries[i].AttemptLog = append(deliveries[i].AttemptLog, a)
+		}
+		attempts.Close()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// TestWebhook queues a "ping" event for one webhook regardless of the event
+// types it subscribes to.
+func TestWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Webhook ID required", http.StatusBadRequest)
+		return
+	}
+	var exists int
+	if err := DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM webhooks WHERE id = ? AND user_id = ?", id, userID).Scan(&exists); err != nil || exists == 0 {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	eventID, payload, err := webhookPayload("ping", time.Now().UTC(), map[string]interface{}{
+		"webhook_id": id,
+		"message":    "This is a test event from TaskLift.",
+	})
+	if err == nil {
+		err = queueWebhookDelivery(r.Context(), id, eventID, "ping", payload)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Queue test webhook error", "err", err)
+		http.Error(w, "Failed to queue test event", http.StatusInternalServerError)
+		return
+	}
+	webhooks.nudge()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "queued", "event_id": eventID})
+}
+
+func webhookPayload(eventType string, at time.Time, data interface{}) (string, []byte, error) {
+	eventID := "evt_" + randomHex(12)
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":         eventID,
+		"type":       eventType,
+		"created_at": at,
+		"data":       data,
+	})
+	return eventID, payload, err
+}
+
+func queueWebhookDelivery(ctx context.Context, webhookID int, eventID, eventType string, payload []byte) error {
+	now := time.Now().UTC()
+	_, err := DB.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, status, next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, 'pending', ?, ?, ?)`,
+		webhookID, eventID, eventType, string(payload), now, now, now)
+	return err
+}
+
+// enqueueWebhooks stores a pending delivery for every matching webhook. It
+// runs on the publishing handler's goroutine, so it only writes to the
+// database; the HTTP calls happen on the dispatcher's workers.
+func enqueueWebhooks(ctx context.Context, e events.Event) {
+	rows, err := DB.QueryContext(ctx, "SELECT id, events FROM webhooks WHERE user_id = ? AND active = 1", e.UserID)
+	if err != nil {
+		slog.Error("Webhook lookup error", "err", err)
+		return
+	}
+	var targets []int
+	for rows.Next() {
+		var id int
+		var eventList string
+		if err := rows.Scan(&id, &eventList); err != nil {
+			slog.Error("Webhook scan error", "err", err)
+			continue
+		}
+		if webhookMatches(strings.Split(eventList, ","), e.Type) {
+			targets = append(targets, id)
+		}
+	}
+	rows.Close()
+	if len(targets) == 0 {
+		return
+	}
+
+	eventID, payload, err := webhookPayload(e.Type, e.Time, e.Data)
+	if err != nil {
+		slog.Error("Webhook payload error", "err", err)
+		return
+	}
+	for _, id := range targets {
+		if err := queueWebhookDelivery(ctx, id, eventID, e.Type, payload); err != nil {
+			slog.Error("Queue webhook delivery error", "err", err)
+		}
+	}
+	webhooks.nudge()
+}
+
+// webhookDispatcher sends pending deliveries in the background. Deliveries
+// live in the database, so retries survive restarts.
+type webhookDispatcher struct {
+	client  *http.Client
+	workers int
+	wake    chan struct{}
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+var webhooks = &webhookDispatcher{
+	client: newWebhookClient(),
+	wake:   make(chan struct{}, 1),
+}
+
+// StartWebhookDelivery subscribes webhooks to Events and starts workers
+// that deliver queued events.
+func StartWebhookDelivery(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	webhooks.workers = workers
+
+	// Anything left "sending" was interrupted by a shutdown or crash.
+	DB.ExecContext(context.Background(), "UPDATE webhook_deliveries SET status = 'pending' WHERE status = 'sending'")
+
+	Events.Subscribe(enqueueWebhooks)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	webhooks.cancel = cancel
+	webhooks.done = make(chan struct{})
+	go webhooks.run(ctx)
+}
+
+// StopWebhookDelivery stops the workers, waiting for in-flight requests.
+func StopWebhookDelivery() {
+	if webhooks.cancel == nil {
+		return
+	}
+	webhooks.cancel()
+	<-webhooks.done
+}
+
+func (d *webhookDispatcher) nudge() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *webhookDispatcher) run(ctx context.Context) {
+	defer close(d.done)
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+type pendingDelivery struct {
+	id        int
+	webhookID int
+	eventID   string
+	eventType string
+	payload   string
+	attempts  int
+	url       string
+	secret    string
+}
+
+func (d *webhookDispatcher) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		rows, err := DB.QueryContext(ctx, `
+			SELECT d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.attempts, h.url, h.secret
+			FROM webhook_deliveries d
+			JOIN webhooks h ON h.id = d.webhook_id
+			WHERE d.status = 'pending' AND d.next_attempt_at <= ?
+			ORDER BY d.next_attempt_at
+			LIMIT ?`, time.Now().UTC(), d.workers*4)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.ErrorContext(ctx, "Webhook queue error", "err", err)
+			}
+			return
+		}
+
+		var batch []pendingDelivery
+		for rows.Next() {
+			var p pendingDelivery
+			if err := rows.Scan(&p.id, &p.webhookID, &p.eventID, &p.eventType, &p.payload, &p.attempts, &p.url, &p.secret); err != nil {
+				slog.ErrorContext(ctx, "Webhook queue scan error", "err", err)
+				continue
+			}
+			batch = append(batch, p)
+		}
+		rows.Close()
+		if len(batch) == 0 {
+			return
+		}
+
+		for _, p := range batch {
+			DB.ExecContext(ctx, "UPDATE webhook_deliveries SET status = 'sending', updated_at = ? WHERE id = ?", time.Now().UTC(), p.id)
+		}
+
+		sem := make(chan struct{}, d.workers)
+		var wg sync.WaitGroup
+		for _, p := range batch {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(p pendingDelivery) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				d.deliver(ctx, p)
+			}(p)
+		}
+		wg.Wait()
+	}
+}
+
+// deliver POSTs the payload once and records the outcome. Receivers can
+// verify X-TaskLift-Signature, which is "sha256=" followed by the hex
+// HMAC-SHA256 of "<X-TaskLift-Timestamp>.<body>" keyed with the webhook secret.
+func (d *webhookDispatcher) deliver(ctx context.Context, p pendingDelivery) {
+	attempt := p.attempts + 1
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(p.secret))
+	mac.Write([]byte(timestamp + "." + p.payload))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	var code int
+	var deliveryErr error
+
+	// The span leaves out the URL, which often carries the receiver's secret.
+	reqCtx, span := tracing.StartClient(ctx, "webhook "+p.eventType,
+		attribute.Int("webhook.id", p.webhookID),
+		attribute.Int("webhook.delivery_id", p.id),
+		attribute.Int("webhook.attempt", attempt))
+	if u, err := url.Parse(p.url); err == nil {
+		span.SetAttributes(attribute.String("server.address", u.Hostname()))
+	}
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, p.url, bytes.NewReader([]byte(p.payload)))
+	if err != nil {
+		deliveryErr = err
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "TaskLift-Webhooks/1.0")
+		req.Header.Set("X-TaskLift-Event", p.eventType)
+		req.Header.Set("X-TaskLift-Delivery", strconv.Itoa(p.id))
+		req.Header.Set("X-TaskLift-Timestamp", timestamp)
+		req.Header.Set("X-TaskLift-Signature", signature)
+		tracing.Inject(reqCtx, req.Header)
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			deliveryErr = err
+		} else {
+			// Only the status is kept: the body is the receiver's business,
+			// and storing it would let a webhook read internal services.
+			code = resp.StatusCode
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			if code < 200 || code > 299 {
+				deliveryErr = fmt.Errorf("receiver responded with %d", code)
+			}
+		}
+	}
+	duration := time.Since(start)
+	if code != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", code))
+	}
+	tracing.End(span, deliveryErr)
+
+	if ctx.Err() != nil {
+		// Shutting down: leave the delivery for the next start without
+		// counting the interrupted attempt.
+		DB.ExecContext(ctx, "UPDATE webhook_deliveries SET status = 'pending' WHERE id = ?", p.id)
+		return
+	}
+
+	var respCode interface{}
+	if code != 0 {
+		respCode = code
+	}
+	errText := ""
+	if deliveryErr != nil {
+		errText = deliveryErr.Error()
+	}
+
+	DB.ExecContext(ctx, `
+		INSERT INTO webhook_delivery_attempts (delivery_id, attempt, response_code, error, duration_ms, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		p.id, attempt, respCode, errText, duration.Milliseconds(), time.Now().UTC())
+
+	now := time.Now().UTC()
+	switch {
+	case deliveryErr == nil:
+		DB.ExecContext(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'delivered', attempts = ?, response_code = ?, error = NULL, next_attempt_at = NULL, updated_at = ?
+			WHERE id = ?`, attempt, respCode, now, p.id)
+	case attempt >= webhookMaxAttempts:
+		DB.ExecContext(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'failed', attempts = ?, response_code = ?, error = ?, next_attempt_at = NULL, updated_at = ?
+			WHERE id = ?`, attempt, respCode, errText, now, p.id)
+	default:
+		DB.ExecContext(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'pending', attempts = ?, response_code = ?, error = ?, next_attempt_at = ?, updated_at = ?
+			WHERE id = ?`, attempt, respCode, errText, now.Add(webhookBackoff(attempt)), now, p.id)
+	}
+}
+
+// webhookBackoff doubles the wait after every failed attempt:
+// 10s, 20s, 40s, ... capped at an hour.
+func webhookBackoff(attempt int) time.Duration {
+	backoff := webhookBaseBackoff << (attempt - 1)
+	if backoff <= 0 || backoff > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,116 @@
+// Package ical reads and writes iCalendar (RFC 5545) data.
+package ical
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Property is a single content line such as "DUE;VALUE=DATE:20250301".
+// Value is stored exactly as it appears on the wire; use AddText and
+// TextValue for TEXT properties that need escaping.
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// Component is a BEGIN/END block such as VCALENDAR or VTODO.
+type Component struct {
+	Name       string
+	Props      []Property
+	Components []*Component
+}
+
+func NewComponent(name string) *Component {
+	return &Component{Name: name}
+}
+
+// Add appends a raw property.
+func (c *Component) Add(name, value string, params map[string]string) {
+	c.Props = append(c.Props, Property{Name: name, Params: params, Value: value})
+}
+
+// AddText appends a TEXT property, escaping the value.
+func (c *Component) AddText(name, value string) {
+	c.Add(name, EscapeText(value), nil)
+}
+
+// AddDateTime appends a UTC date-time property.
+func (c *Component) AddDateTime(name string, t time.Time) {
+	c.Add(name, t.UTC().Format("20060102T150405Z"), nil)
+}
+
+// AddDate appends an all-day DATE property.
+func (c *Component) AddDate(name string, t time.Time) {
+	c.Add(name, t.Format("20060102"), map[string]string{"VALUE": "DATE"})
+}
+
+func (c *Component) AddChild(child *Component) {
+	c.Components = append(c.Components, child)
+}
+
+// EscapeText escapes a TEXT value (RFC 5545 section 3.3.11).
+func EscapeText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// Encode writes c with CRLF line endings, folding lines at 75 octets.
+func Encode(w io.Writer, c *Component) error {
+	bw := bufio.NewWriter(w)
+	encodeComponent(bw, c)
+	return bw.Flush()
+}
+
+func encodeComponent(w *bufio.Writer, c *Component) {
+	writeLine(w, "BEGIN:"+c.Name)
+	for _, p := range c.Props {
+		writeLine(w, p.String())
+	}
+	for _, child := range c.Components {
+		encodeComponent(w, child)
+	}
+	writeLine(w, "END:"+c.Name)
+}
+
+// String renders the property as an unfolded content line.
+func (p Property) String() string {
+	var b strings.Builder
+	b.WriteString(p.Name)
+
+	keys := make([]string, 0, len(p.Params))
+	for k := range p.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := p.Params[k]
+		if strings.ContainsAny(v, ";:,") {
+			v = `"` + v + `"`
+		}
+		b.WriteString(";" + k + "=" + v)
+	}
+
+	b.WriteString(":" + p.Value)
+	return b.String()
+}
+
+func writeLine(w *bufio.Writer, line string) {
+	// 75 octets per line; continuation lines spend one on the leading space.
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		// Never split a UTF-8 sequence.
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	w.WriteString(line + "\r\n")
+}
@@ -0,0 +1,129 @@
+package importers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type asanaTask struct {
+	GID       string `json:"gid"`
+	Name      string `json:"name"`
+	Notes     string `json:"notes"`
+	Completed bool   `json:"completed"`
+	DueOn     string `json:"due_on"`
+	DueAt     string `json:"due_at"`
+	Tags      []struct {
+		Name string `json:"name"`
+	} `json:"tags"`
+	Projects []struct {
+		GID  string `json:"gid"`
+		Name string `json:"name"`
+	} `json:"projects"`
+	Memberships []struct {
+		Project struct {
+			GID  string `json:"gid"`
+			Name string `json:"name"`
+		} `json:"project"`
+		Section struct {
+			Name string `json:"name"`
+		} `json:"section"`
+	} `json:"memberships"`
+	CustomFields []struct {
+		Name      string `json:"name"`
+		EnumValue *struct {
+			Name string `json:"name"`
+		} `json:"enum_value"`
+	} `json:"custom_fields"`
+	Subtasks []asanaTask `json:"subtasks"`
+}
+
+// parseAsana reads a project's "Export → JSON" file, {"data": [tasks]}.
+// Tasks are grouped into projects by their project membership; sections
+// and tags become tags and a "Priority" custom field sets the priority.
+// Sub-tasks are imported as tasks tagged "subtask".
+func parseAsana(r io.Reader) (*Export, error) {
+	var file struct {
+		Data []asanaTask `json:"data"`
+	}
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+	if file.Data == nil {
+		return nil, fmt.Errorf(`expected a "data" array of tasks`)
+	}
+
+	export := &Export{}
+	index := make(map[string]int)
+	projectFor := func(gid, name string) int {
+		if gid == "" {
+			gid, name = "unassigned", "Asana import"
+		}
+		if i, ok := index[gid]; ok {
+			return i
+		}
+		index[gid] = len(export.Projects)
+		export.Projects = append(export.Projects, Project{
+			ExternalID: "asana:" + gid,
+			Name:       name,
+			Status:     "active",
+		})
+		return index[gid]
+	}
+
+	var add func(t asanaTask, project int, extra ...string)
+	add = func(t asanaTask, project int, extra ...string) {
+		if strings.TrimSpace(t.Name) == "" {
+			export.skip("task", t.GID, "no title")
+			return
+		}
+		// Asana exports section headings as tasks ending in a colon.
+		if strings.HasSuffix(t.Name, ":") && t.Notes == "" && len(t.Subtasks) == 0 && t.DueOn == "" {
+			export.skip("task", t.Name, "section heading")
+			return
+		}
+
+		names := append([]string{}, extra...)
+		for _, tag := range t.Tags {
+			names = append(names, tag.Name)
+		}
+		priority := "medium"
+		for _, m := range t.Memberships {
+			names = append(names, m.Section.Name)
+		}
+		for _, f := range t.CustomFields {
+			if strings.EqualFold(f.Name, "priority") && f.EnumValue != nil {
+				priority = priorityFromName(f.EnumValue.Name)
+			}
+		}
+		due := dueDate(t.DueOn)
+		if due == "" {
+			due = dueDate(t.DueAt)
+		}
+
+		export.Projects[project].Tasks = append(export.Projects[project].Tasks, Task{
+			ExternalID: "asana:" + t.GID,
+			Title:      t.Name,
+			Done:       t.Completed,
+			DueDate:    due,
+			Priority:   priority,
+			Tags:       tags(names...),
+			Note:       noteSections(t.Notes),
+		})
+		for _, sub := range t.Subtasks {
+			add(sub, project, "subtask")
+		}
+	}
+
+	for _, t := range file.Data {
+		gid, name := "", ""
+		if len(t.Memberships) > 0 && t.Memberships[0].Project.GID != "" {
+			gid, name = t.Memberships[0].Project.GID, t.Memberships[0].Project.Name
+		} else if len(t.Projects) > 0 {
+			gid, name = t.Projects[0].GID, t.Projects[0].Name
+		}
+		add(t, projectFor(gid, name))
+	}
+	return export, nil
+}
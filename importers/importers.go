@@ -0,0 +1,141 @@
+// Package importers reads the JSON exports of other task tools into a
+// neutral form that the handlers save as projects, tasks, tags and notes.
+package importers
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Export is everything read from one export file.
+type Export struct {
+	Source   string
+	Projects []Project
+	Skipped  []Skipped
+}
+
+// Project becomes a TaskLift project. ExternalID is prefixed with the
+// source, e.g. "trello:5f1c...", so repeated imports can be recognised.
+type Project struct {
+	ExternalID  string
+	Name        string
+	Description string
+	Status      string // active or completed
+	Tasks       []Task
+}
+
+type Task struct {
+	ExternalID string
+	Title      string
+	Done       bool
+	DueDate    string // YYYY-MM-DD or empty
+	Priority   string // high, medium or low
+	Tags       []string
+
+	// Note holds what has no place on a task, such as descriptions,
+	// checklists and comments. Empty means no note is created.
+	Note string
+}
+
+// Skipped records an item that was deliberately not imported.
+type Skipped struct {
+	Kind   string // project, list, card or task
+	Name   string
+	Reason string
+}
+
+// Sources lists the supported export formats.
+var Sources = []string{"trello", "todoist", "asana"}
+
+// Parse reads an export of the given source.
+func Parse(source string, r io.Reader) (*Export, error) {
+	var (
+		export *Export
+		err    error
+	)
+	switch source {
+	case "trello":
+		export, err = parseTrello(r)
+	case "todoist":
+		export, err = parseTodoist(r)
+	case "asana":
+		export, err = parseAsana(r)
+	default:
+		return nil, fmt.Errorf("unknown source %q", source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s export: %v", source, err)
+	}
+	export.Source = source
+	return export, nil
+}
+
+// Count returns the number of projects and tasks in the export.
+func (e *Export) Count() int {
+	n := len(e.Projects)
+	for _, p := range e.Projects {
+		n += len(p.Tasks)
+	}
+	return n
+}
+
+func (e *Export) skip(kind, name, reason string) {
+	e.Skipped = append(e.Skipped, Skipped{Kind: kind, Name: name, Reason: reason})
+}
+
+// dueDate reduces the date or date-time formats used by the exports to a
+// calendar date.
+func dueDate(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+	return ""
+}
+
+// tags de-duplicates names and drops empty ones. Tags are normalised again
+// when they are saved.
+func tags(names ...string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		key := strings.ToLower(name)
+		if name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, strings.ReplaceAll(name, ",", " "))
+	}
+	sort.Strings(out)
+	return out
+}
+
+func priorityFromName(name string) string {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "high", "urgent", "critical", "p1":
+		return "high"
+	case "low", "p4":
+		return "low"
+	}
+	return "medium"
+}
+
+// noteSections joins the non-empty parts of a note with blank lines.
+func noteSections(parts ...string) string {
+	var kept []string
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, "\n\n")
+}
@@ -0,0 +1,145 @@
+package importers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// todoistID accepts both the numeric IDs of older backups and the string
+// IDs used since API v9.
+type todoistID string
+
+func (id *todoistID) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*id = ""
+		return nil
+	}
+	*id = todoistID(strings.Trim(string(data), `"`))
+	return nil
+}
+
+// todoistBackup is the subset of a Todoist JSON backup (the Sync API
+// format) that is imported.
+type todoistBackup struct {
+	Projects []struct {
+		ID         todoistID `json:"id"`
+		Name       string    `json:"name"`
+		IsArchived bool      `json:"is_archived"`
+		IsDeleted  bool      `json:"is_deleted"`
+	} `json:"projects"`
+	Sections []struct {
+		ID   todoistID `json:"id"`
+		Name string    `json:"name"`
+	} `json:"sections"`
+	Items []struct {
+		ID          todoistID `json:"id"`
+		ProjectID   todoistID `json:"project_id"`
+		SectionID   todoistID `json:"section_id"`
+		ParentID    todoistID `json:"parent_id"`
+		Content     string    `json:"content"`
+		Description string    `json:"description"`
+		Checked     bool      `json:"checked"`
+		IsDeleted   bool      `json:"is_deleted"`
+		Priority    int       `json:"priority"`
+		Labels      []string  `json:"labels"`
+		Due         *struct {
+			Date string `json:"date"`
+		} `json:"due"`
+	} `json:"items"`
+	Notes []struct {
+		ItemID    todoistID `json:"item_id"`
+		Content   string    `json:"content"`
+		IsDeleted bool      `json:"is_deleted"`
+	} `json:"notes"`
+}
+
+// parseTodoist maps projects onto projects and items onto tasks. Sections
+// and labels become tags, sub-tasks are imported as tasks tagged with
+// "subtask", and descriptions and comments go into a note.
+func parseTodoist(r io.Reader) (*Export, error) {
+	var backup todoistBackup
+	if err := json.NewDecoder(r).Decode(&backup); err != nil {
+		return nil, err
+	}
+	if len(backup.Projects) == 0 && len(backup.Items) == 0 {
+		return nil, fmt.Errorf("no projects or items found")
+	}
+
+	export := &Export{}
+	sections := make(map[todoistID]string)
+	for _, s := range backup.Sections {
+		sections[s.ID] = s.Name
+	}
+	comments := make(map[todoistID][]string)
+	for _, n := range backup.Notes {
+		if !n.IsDeleted && strings.TrimSpace(n.Content) != "" {
+			comments[n.ItemID] = append(comments[n.ItemID], n.Content)
+		}
+	}
+
+	index := make(map[todoistID]int)
+	for _, p := range backup.Projects {
+		if p.IsDeleted {
+			export.skip("project", p.Name, "deleted")
+			continue
+		}
+		project := Project{ExternalID: "todoist:" + string(p.ID), Name: p.Name, Status: "active"}
+		if p.IsArchived {
+			project.Status = "completed"
+		}
+		index[p.ID] = len(export.Projects)
+		export.Projects = append(export.Projects, project)
+	}
+
+	for _, item := range backup.Items {
+		i, ok := index[item.ProjectID]
+		switch {
+		case item.IsDeleted:
+			export.skip("task", item.Content, "deleted")
+			continue
+		case !ok:
+			export.skip("task", item.Content, "project missing from export")
+			continue
+		case strings.TrimSpace(item.Content) == "":
+			export.skip("task", string(item.ID), "no title")
+			continue
+		}
+
+		names := append([]string{sections[item.SectionID]}, item.Labels...)
+		if item.ParentID != "" {
+			names = append(names, "subtask")
+		}
+
+		task := Task{
+			ExternalID: "todoist:" + string(item.ID),
+			Title:      item.Content,
+			Done:       item.Checked,
+			Priority:   todoistPriority(item.Priority),
+			Tags:       tags(names...),
+		}
+		if item.Due != nil {
+			task.DueDate = dueDate(item.Due.Date)
+		}
+		var notes []string
+		if len(comments[item.ID]) > 0 {
+			notes = append(notes, "### Comments\n"+strings.Join(comments[item.ID], "\n\n"))
+		}
+		task.Note = noteSections(append([]string{item.Description}, notes...)...)
+
+		export.Projects[i].Tasks = append(export.Projects[i].Tasks, task)
+	}
+	return export, nil
+}
+
+// todoistPriority maps the API's 4 (shown as p1) … 1 (p4, the default).
+func todoistPriority(p int) string {
+	switch p {
+	case 4:
+		return "high"
+	case 3, 2:
+		return "medium"
+	}
+	return "low"
+}
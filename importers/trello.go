@@ -0,0 +1,131 @@
+package importers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// trelloBoard is the subset of a Trello board export ("Export as JSON")
+// that is imported.
+type trelloBoard struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Desc   string `json:"desc"`
+	Closed bool   `json:"closed"`
+	Lists  []struct {
+		ID     string `json:"id"`
+		Name   string `json:"name"`
+		Closed bool   `json:"closed"`
+	} `json:"lists"`
+	Cards []struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		Desc        string `json:"desc"`
+		Closed      bool   `json:"closed"`
+		IDList      string `json:"idList"`
+		Due         string `json:"due"`
+		DueComplete bool   `json:"dueComplete"`
+		Labels      []struct {
+			Name  string `json:"name"`
+			Color string `json:"color"`
+		} `json:"labels"`
+	} `json:"cards"`
+	Checklists []struct {
+		IDCard     string `json:"idCard"`
+		Name       string `json:"name"`
+		CheckItems []struct {
+			Name  string  `json:"name"`
+			State string  `json:"state"`
+			Pos   float64 `json:"pos"`
+		} `json:"checkItems"`
+	} `json:"checklists"`
+}
+
+// parseTrello maps a board onto a project and its open cards onto tasks.
+// The card's list becomes a tag, and a card counts as done when its due date
+// is marked complete or it sits in a list called "Done". Descriptions and
+// checklists go into a note.
+func parseTrello(r io.Reader) (*Export, error) {
+	var board trelloBoard
+	if err := json.NewDecoder(r).Decode(&board); err != nil {
+		return nil, err
+	}
+	if board.ID == "" || board.Name == "" {
+		return nil, fmt.Errorf("not a Trello board export")
+	}
+
+	export := &Export{}
+	project := Project{
+		ExternalID:  "trello:" + board.ID,
+		Name:        board.Name,
+		Description: board.Desc,
+		Status:      "active",
+	}
+	if board.Closed {
+		project.Status = "completed"
+	}
+
+	lists := make(map[string]string)
+	closedLists := make(map[string]bool)
+	for _, l := range board.Lists {
+		lists[l.ID] = l.Name
+		if l.Closed {
+			closedLists[l.ID] = true
+			export.skip("list", l.Name, "archived list")
+		}
+	}
+
+	checklists := make(map[string][]string)
+	for _, cl := range board.Checklists {
+		var b strings.Builder
+		b.WriteString("### " + cl.Name + "\n")
+		for _, item := range cl.CheckItems {
+			mark := " "
+			if item.State == "complete" {
+				mark = "x"
+			}
+			b.WriteString("- [" + mark + "] " + item.Name + "\n")
+		}
+		checklists[cl.IDCard] = append(checklists[cl.IDCard], b.String())
+	}
+
+	for _, card := range board.Cards {
+		switch {
+		case card.Closed:
+			export.skip("card", card.Name, "archived card")
+			continue
+		case closedLists[card.IDList]:
+			export.skip("card", card.Name, "in an archived list")
+			continue
+		case strings.TrimSpace(card.Name) == "":
+			export.skip("card", card.ID, "no title")
+			continue
+		}
+
+		listName := lists[card.IDList]
+		names := []string{listName}
+		for _, label := range card.Labels {
+			if label.Name != "" {
+				names = append(names, label.Name)
+			} else {
+				names = append(names, label.Color)
+			}
+		}
+
+		task := Task{
+			ExternalID: "trello:" + card.ID,
+			Title:      card.Name,
+			Done:       card.DueComplete || strings.EqualFold(strings.TrimSpace(listName), "done"),
+			DueDate:    dueDate(card.Due),
+			Priority:   "medium",
+			Tags:       tags(names...),
+			Note:       noteSections(append([]string{card.Desc}, checklists[card.ID]...)...),
+		}
+		project.Tasks = append(project.Tasks, task)
+	}
+
+	export.Projects = []Project{project}
+	return export, nil
+}
@@ -0,0 +1,118 @@
+// Package logging sets up structured logging with log/slog. Records logged
+// with the context of a request carry its request ID, route, user and
+// trace, so every line a request causes can be found together.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"task-manager/tracing"
+)
+
+// Setup makes slog's default logger write records at level and above to w,
+// as JSON or, with format "text", as key=value pairs. Output of the log
+// package goes through the same logger at level INFO.
+func Setup(w io.Writer, level slog.Level, format string) error {
+	options := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch format {
+	case "", "json":
+		h = slog.NewJSONHandler(w, options)
+	case "text":
+		h = slog.NewTextHandler(w, options)
+	default:
+		return fmt.Errorf("unknown log format %q, want json or text", format)
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+	return nil
+}
+
+// ParseLevel reads debug, info, warn or error; "" means info.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, want debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// requestInfo is what records learn about the request they were logged
+// for. The request is the one the mux is handed, which it fills in with the
+// matched route pattern before calling the route's handler.
+type requestInfo struct {
+	id      string
+	request *http.Request
+	userID  atomic.Int64
+}
+
+type requestKey struct{}
+
+// WithRequestID returns a copy of r whose context makes records carry id
+// and, once they are known, r's route pattern and user.
+func WithRequestID(r *http.Request, id string) *http.Request {
+	info := &requestInfo{id: id}
+	r = r.WithContext(context.WithValue(r.Context(), requestKey{}, info))
+	info.request = r
+	return r
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "".
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// SetUserID records who made the request ctx belongs to, once they have
+// been authenticated.
+func SetUserID(ctx context.Context, userID int) {
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		info.userID.Store(int64(userID))
+	}
+}
+
+// UserID returns the user SetUserID recorded for the request ctx belongs
+// to, or 0.
+func UserID(ctx context.Context) int {
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		return int(info.userID.Load())
+	}
+	return 0
+}
+
+// contextHandler adds the request and trace of a record's context to it.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		record.AddAttrs(slog.String("request_id", info.id))
+		if info.request.Pattern != "" {
+			record.AddAttrs(slog.String("route", info.request.Pattern))
+		}
+		if userID := info.userID.Load(); userID != 0 {
+			record.AddAttrs(slog.Int64("user_id", userID))
+		}
+	}
+	if traceID := tracing.TraceID(ctx); traceID != "" {
+		record.AddAttrs(slog.String("trace_id", traceID))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -1,96 +1,146 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"task-manager/config"
 	"task-manager/handlers"
+	"task-manager/logging"
+	"task-manager/metrics"
 	"task-manager/middleware"
+	"task-manager/notify"
+	"task-manager/scheduler"
+	"task-manager/tracing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	_ "time/tzdata" // digest timezones must resolve in minimal containers
 )
 
-func createBasicSchema() {
-	// Fallback basic schema if schema.sql is not found
-	basicSchema := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT UNIQUE NOT NULL,
-		email TEXT UNIQUE,
-		password TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS projects (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER NOT NULL,
-		name TEXT NOT NULL,
-		description TEXT,
-		status TEXT DEFAULT 'active',
-		progress INTEGER DEFAULT 0,
-		due_date DATE,
-		team_members INTEGER DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id)
-	);
-
-	CREATE TABLE IF NOT EXISTS tasks (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER NOT NULL,
-		project_id INTEGER,
-		description TEXT NOT NULL,
-		priority TEXT DEFAULT 'medium',
-		done BOOLEAN DEFAULT 0,
-		due_date DATE,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id),
-		FOREIGN KEY (project_id) REFERENCES projects(id)
-	);
-
-	CREATE TABLE IF NOT EXISTS documents (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER NOT NULL,
-		title TEXT NOT NULL,
-		file_path TEXT NOT NULL,
-		file_type TEXT,
-		file_size INTEGER DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id)
-	);
-
-	CREATE TABLE IF NOT EXISTS notes (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER NOT NULL,
-		title TEXT NOT NULL,
-		content TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id)
-	);
-
-	-- Insert test user
-	INSERT OR IGNORE INTO users (id, username, email, password) VALUES 
-	(1, 'testuser', 'test@example.com', 'test_password');
-	`
-
-	if _, err := DB.Exec(basicSchema); err != nil {
-		log.Fatal("Failed to create basic schema:", err)
+// newNotifier returns an SMTP notifier when an SMTP host is configured,
+// otherwise one that only logs.
+func newNotifier(cfg config.SMTP) notify.Notifier {
+	if cfg.Host == "" {
+		slog.Info("smtp.host not set, notifications will only be logged")
+		return notify.LogNotifier{}
 	}
+	return &notify.SMTPNotifier{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		From:     cfg.From,
+	}
+}
+
+// fatal logs an error the server cannot start or keep running with, and
+// exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func main() {
+	// "tasklift config print [flags]" shows the configuration the same
+	// flags would start the server with.
+	args := os.Args[1:]
+	printConfig := len(args) >= 2 && args[0] == "config" && args[1] == "print"
+	if printConfig {
+		args = args[2:]
+	}
+	cfg, err := config.Load(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("Invalid configuration", "err", err)
+	}
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fatal("Failed to print configuration", "err", err)
+		}
+		return
+	}
+
+	// Logging comes first, then tracing, so everything after them is
+	// logged and traced.
+	level, _ := logging.ParseLevel(cfg.Log.Level)
+	if err := logging.Setup(os.Stderr, level, cfg.Log.Format); err != nil {
+		fatal("Invalid log format", "err", err)
+	}
+
+	shutdownTracing, exporting, err := tracing.Setup(context.Background())
+	if err != nil {
+		fatal("Failed to set up tracing", "err", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownTracing(ctx)
+	}()
+	if !exporting {
+		slog.Info("OTEL_EXPORTER_OTLP_ENDPOINT not set, traces are not exported")
+	}
+
 	// Initialize DB and schema
-	InitDB()
+	InitDB(cfg.Database.Path)
+	defer DB.Close()
 
 	// Give the DB to the handlers package
 	handlers.InitAuthHandler(DB)
+	if err := handlers.InitSigningKey(cfg.Session.Secret); err != nil {
+		fatal("Failed to initialize signing key", "err", err)
+	}
+
+	handlers.BaseURL = cfg.Server.BaseURL
+	handlers.StorageDir = cfg.Storage.Dir
+	handlers.TemplateDir = cfg.Server.TemplateDir
+	handlers.SessionLifetime = cfg.Session.Lifetime
+	handlers.SecureCookies = cfg.Session.SecureCookies
+
+	// Background jobs
+	notifier := newNotifier(cfg.SMTP)
+	handlers.DefaultReminderLead = cfg.Reminders.Lead
+	handlers.ReportNotifier = notifier
+
+	jobs := scheduler.New()
+	jobs.Every("due-reminders", cfg.Reminders.Interval, func(ctx context.Context) {
+		handlers.SendDueReminders(ctx, notifier)
+	})
+	jobs.Every("digests", cfg.Digests.Interval, func(ctx context.Context) {
+		handlers.SendDigests(ctx, notifier)
+	})
+	jobs.Every("reports", cfg.Reports.Interval, func(ctx context.Context) {
+		handlers.GenerateReports(ctx, notifier)
+	})
+	jobs.Every("caldav-changes", time.Hour, handlers.PruneTaskChanges)
+	jobs.Every("account-archives", time.Hour, handlers.PruneAccountArchives)
+	jobs.Start()
+	defer jobs.Stop()
+
+	handlers.StartWebhookDelivery(cfg.Webhooks.Workers)
+	defer handlers.StopWebhookDelivery()
+
+	handlers.StartEventStream()
+	handlers.StartCollaboration()
+
+	handlers.StartNoteTaskSync()
+
+	handlers.StartBackgroundJobs(cfg.Jobs.Workers)
+	defer handlers.StopBackgroundJobs()
 
 	mux := http.NewServeMux()
 
 	// Static files
-	fileServer := http.FileServer(http.Dir("./static"))
+	fileServer := http.FileServer(http.Dir(cfg.Server.StaticDir))
 	mux.Handle("/static/", http.StripPrefix("/static", fileServer))
 
 	// Serve landing page at root
@@ -99,7 +149,7 @@ func main() {
 			http.NotFound(w, r)
 			return
 		}
-		http.ServeFile(w, r, "templates/homepage.html")
+		http.ServeFile(w, r, filepath.Join(cfg.Server.TemplateDir, "homepage.html"))
 	})
 
 	// Auth routes
@@ -117,46 +167,219 @@ func main() {
 	mux.HandleFunc("/createtasks", handlers.CreateTask)
 	mux.HandleFunc("/updatetasks", handlers.UpdateTask)
 	mux.HandleFunc("/deletetasks", handlers.DeleteTask)
+	mux.HandleFunc("/api/tasks/reminder", handlers.SetTaskReminder)
+	mux.HandleFunc("/api/tasks/tags", handlers.SetTaskTags)
+	mux.HandleFunc("/api/tasks/start", handlers.StartTask)
+
+	// Reminder routes
+	mux.HandleFunc("/api/reminders/settings", handlers.ReminderSettings)
+
+	// Digest routes
+	mux.HandleFunc("/api/digest/settings", handlers.DigestSettings)
+	mux.HandleFunc("/api/digest/preview", handlers.DigestPreview)
+	mux.HandleFunc("/digest/unsubscribe", handlers.DigestUnsubscribe)
+
+	// CSV import and export
+	mux.HandleFunc("/api/export/csv", handlers.ExportCSV)
+	mux.HandleFunc("/api/import/csv", handlers.ImportCSV)
+	mux.HandleFunc("/api/import/csv/columns", handlers.CSVColumns)
+
+	// Trello, Todoist and Asana imports run as background jobs
+	mux.HandleFunc("/api/import/external", handlers.ImportExternal)
+	mux.HandleFunc("/api/jobs", handlers.Jobs)
+
+	// Account archive ("download my data") and restore
+	mux.HandleFunc("/api/account/export", handlers.ExportAccount)
+	mux.HandleFunc("/api/account/export/download", handlers.DownloadAccountExport)
+	mux.HandleFunc("/api/account/import", handlers.ImportAccount)
+
+	// Calendar feed routes
+	mux.HandleFunc("/api/calendar/feeds", handlers.CalendarFeeds)
+	mux.HandleFunc("/api/calendar/feeds/revoke", handlers.RevokeCalendarFeed)
+	mux.HandleFunc("/calendar/", handlers.CalendarFeed)
+
+	// CalDAV sync
+	mux.HandleFunc("/caldav/", handlers.CalDAV)
+	mux.HandleFunc("/.well-known/caldav", handlers.CalDAVWellKnown)
+
+	// Live update stream
+	mux.HandleFunc("/api/events", handlers.EventStream)
+	mux.HandleFunc("/ws/collab", handlers.CollabSocket)
+
+	// Webhook routes
+	mux.HandleFunc("/api/webhooks", handlers.Webhooks)
+	mux.HandleFunc("/api/webhooks/update", handlers.UpdateWebhook)
+	mux.HandleFunc("/api/webhooks/delete", handlers.DeleteWebhook)
+	mux.HandleFunc("/api/webhooks/deliveries", handlers.WebhookDeliveries)
+	mux.HandleFunc("/api/webhooks/test", handlers.TestWebhook)
 
 	// Project management routes
 	mux.HandleFunc("/api/projects", handlers.ListProjects)
 	mux.HandleFunc("/api/projects/create", handlers.CreateProject)
 	mux.HandleFunc("/api/projects/update", handlers.UpdateProject)
 	mux.HandleFunc("/api/projects/delete", handlers.DeleteProject)
+	mux.HandleFunc("/api/projects/forecast", handlers.ProjectForecasts)
 
 	// Note management routes
 	mux.HandleFunc("/api/notes", handlers.ListNotes)
 	mux.HandleFunc("/api/notes/create", handlers.CreateNote)
 	mux.HandleFunc("/api/notes/update", handlers.UpdateNote)
 	mux.HandleFunc("/api/notes/delete", handlers.DeleteNote)
+	mux.HandleFunc("/api/notes/preview", handlers.PreviewNote)
+	mux.HandleFunc("/api/notes/tasks/toggle", handlers.ToggleNoteTask)
+	mux.HandleFunc("/api/notes/revisions", handlers.NoteRevisions)
+	mux.HandleFunc("/api/notes/revisions/diff", handlers.NoteRevisionDiff)
+	mux.HandleFunc("/api/notes/revisions/restore", handlers.RestoreNoteRevision)
+	mux.HandleFunc("/api/links", handlers.Links)
+	mux.HandleFunc("/api/links/broken", handlers.BrokenLinks)
+	mux.HandleFunc("/api/notes/move", handlers.MoveNotes)
+	mux.HandleFunc("/api/notes/pin", handlers.PinNote)
+	mux.HandleFunc("/api/notes/project", handlers.SetNoteProject)
+	mux.HandleFunc("/api/notes/action-items", handlers.NoteActionItems)
+	mux.HandleFunc("/api/notes/to-tasks", handlers.ConvertNoteToTasks)
+	mux.HandleFunc("/api/notes/encryption", handlers.NoteEncryption)
+	mux.HandleFunc("/api/notes/encryption/setup", handlers.SetupNoteEncryption)
+	mux.HandleFunc("/api/notes/encryption/unlock", handlers.UnlockNotes)
+	mux.HandleFunc("/api/notes/encryption/lock", handlers.LockNotes)
+	mux.HandleFunc("/api/notes/encryption/passphrase", handlers.ChangeNotePassphrase)
+	mux.HandleFunc("/api/notes/encrypt", handlers.EncryptNote)
+	mux.HandleFunc("/api/notes/decrypt", handlers.DecryptNote)
+	mux.HandleFunc("/api/notebooks", handlers.ListNotebooks)
+	mux.HandleFunc("/api/notebooks/create", handlers.CreateNotebook)
+	mux.HandleFunc("/api/notebooks/update", handlers.UpdateNotebook)
+	mux.HandleFunc("/api/notebooks/delete", handlers.DeleteNotebook)
+	mux.HandleFunc("/api/notebooks/reorder", handlers.ReorderNotebook)
 
 	// Document management routes (placeholder for now)
 	mux.HandleFunc("/api/analytics", handlers.APIAnalytics)
+	mux.HandleFunc("/api/analytics/trends", handlers.TaskTrends)
+	mux.HandleFunc("/api/analytics/burndown", handlers.ProjectBurndown)
+	mux.HandleFunc("/api/analytics/flow", handlers.FlowMetrics)
 	mux.HandleFunc("/analytics", handlers.Analytics)
+	mux.HandleFunc("/api/reports", handlers.Reports)
+	mux.HandleFunc("/api/reports/update", handlers.UpdateReport)
+	mux.HandleFunc("/api/reports/delete", handlers.DeleteReport)
+	mux.HandleFunc("/api/reports/run", handlers.RunReport)
+	mux.HandleFunc("/api/reports/runs", handlers.ReportRuns)
 
 	// Document routes (protected)
 	mux.HandleFunc("/documents", handlers.Documents)
+	mux.HandleFunc("/api/documents/download", handlers.DownloadDocument)
 
 	// Quick action routes (aliases for convenience)
 	mux.HandleFunc("/create-task", handlers.CreateTask)
 	mux.HandleFunc("/view-tasks", handlers.ListTasks)
 
-	// Get port from environment variable (required by Render)
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "5050" // fallback for local development
+	// Metrics are served on metrics.addr, an address only operators can
+	// reach such as 127.0.0.1:9090, or else on the main port when
+	// metrics.token is set, to scrapers sending it as a bearer token.
+	metricsHandler := metrics.Default.Handler()
+	if token := cfg.Metrics.Token; token != "" {
+		metricsHandler = middleware.RequireBearerToken(token, metricsHandler)
+	}
+	var adminServer *http.Server
+	if addr := cfg.Metrics.Addr; addr != "" {
+		admin := http.NewServeMux()
+		admin.Handle("/metrics", metricsHandler)
+		adminServer = &http.Server{
+			Addr:              addr,
+			Handler:           admin,
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+		}
+		go func() {
+			slog.Info("Serving metrics", "addr", addr)
+			if err := adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("Metrics server failed to start", "err", err)
+			}
+		}()
+	} else if cfg.Metrics.Token != "" {
+		mux.Handle("/metrics", metricsHandler)
+	} else {
+		slog.Info("metrics.addr and metrics.token not set, metrics are not served")
+	}
+
+	slog.Info("Starting TaskLift server", "port", cfg.Server.Port, "features", []string{
+		"Task Management (CRUD operations)",
+		"Project Management (CRUD operations, completion forecasts)",
+		"Note Management (CRUD operations, Markdown, revision history, wiki links, notebooks, private notes)",
+		"Analytics Dashboard (trends, burn-down, flow metrics, scheduled CSV/HTML reports)",
+		"Due-date reminders",
+		"Daily/weekly digest emails",
+		"Outgoing webhooks",
+		"CSV import and export",
+		"Trello, Todoist and Asana import (background jobs)",
+		"Account export and import archives",
+		"iCalendar feeds",
+		"CalDAV task sync",
+		"Live updates (Server-Sent Events)",
+		"Collaboration channel with presence (WebSocket)",
+		"Client-side routing",
+		"Prometheus metrics",
+		"OpenTelemetry tracing",
+		"Structured logging with request IDs",
+		"Configuration from file, environment and flags",
+		"Graceful shutdown, health and readiness probes",
+	})
+
+	// The probes sit in front of the middleware, so orchestrators polling
+	// them every few seconds do not fill the access log and request metrics.
+	root := http.NewServeMux()
+	root.HandleFunc("/healthz", healthz)
+	root.HandleFunc("/readyz", readyz)
+	root.Handle("/", middleware.Tracing(middleware.Logging(middleware.Metrics(mux))))
+
+	// There is no write timeout: event streams, WebSockets and large
+	// downloads legitimately keep writing for a long time.
+	srv := &http.Server{
+		Addr:              ":" + strconv.Itoa(cfg.Server.Port),
+		Handler:           root,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
 	}
+	// Shutdown does not wait for hijacked WebSocket connections and would
+	// wait out the whole drain period for event streams, so both are told
+	// to hang up as soon as it starts.
+	srv.RegisterOnShutdown(handlers.StopEventStream)
+	srv.RegisterOnShutdown(handlers.StopCollaboration)
 
-	log.Printf("Starting TaskLift server on port %s\n", port)
-	log.Println("Features available:")
-	log.Println("  - Task Management (CRUD operations)")
-	log.Println("  - Project Management (CRUD operations)")
-	log.Println("  - Note Management (CRUD operations)")
-	log.Println("  - Analytics Dashboard")
-	log.Println("  - Client-side routing")
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	err := http.ListenAndServe(":"+port, mux)
-	if err != nil {
-		log.Fatal("Server failed to start:", err)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-serveErr:
+		fatal("Server failed to start", "err", err)
+	case <-ctx.Done():
+	}
+	// A second signal kills the process without waiting for the drain.
+	stop()
+
+	// /readyz fails first and the server keeps serving for
+	// server.drain_delay, so the orchestrator stops routing here before
+	// connections are refused.
+	draining.Store(true)
+	slog.Info("Draining", "delay", cfg.Server.DrainDelay.String())
+	time.Sleep(cfg.Server.DrainDelay)
+
+	// New connections are refused from here on; requests in flight get
+	// server.shutdown_timeout to finish before their connections are cut.
+	// The deferred calls then stop the background workers, which finish
+	// the job or delivery they are on, close the database and flush traces.
+	slog.Info("Shutting down", "drain", cfg.Server.ShutdownTimeout.String())
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		slog.Warn("Requests still running after the drain period, closing their connections", "err", err)
+		srv.Close()
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(drainCtx); err != nil {
+			adminServer.Close()
+		}
 	}
+	slog.Info("Server stopped, stopping background workers")
 }
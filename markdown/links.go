@@ -0,0 +1,103 @@
+package markdown
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// Reference is a link written in a note: either a wiki link to a title,
+// "[[Q2 Planning]]" or "[[Q2 Planning|the plan]]", or a reference by ID,
+// "#task-42", "#project-3" or "#note-7".
+type Reference struct {
+	Text  string // as written
+	Title string // wiki links only
+	Type  string // ID references only: task, project or note
+	ID    int    // ID references only
+}
+
+var (
+	wikiLinkPattern = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|[^\[\]\n]*)?\]\]`)
+	idRefPattern    = regexp.MustCompile(`(?:^|[^\w&/#-])#(task|project|note)-(\d+)\b`)
+)
+
+// References returns the wiki links and ID references in source, in order
+// of appearance. Code spans and code blocks are ignored.
+func References(source string) []Reference {
+	src := []byte(source)
+	doc := md.Parser().Parse(text.NewReader(src))
+
+	// Gather the text of every block, separating blocks with newlines and
+	// replacing code spans with a character no pattern matches across.
+	var b strings.Builder
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch n := n.(type) {
+		case *ast.CodeBlock, *ast.FencedCodeBlock, *ast.HTMLBlock, *ast.RawHTML:
+			return ast.WalkSkipChildren, nil
+		case *ast.CodeSpan:
+			if entering {
+				b.WriteByte(0)
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			if entering {
+				b.Write(n.Segment.Value(src))
+				if n.SoftLineBreak() || n.HardLineBreak() {
+					b.WriteByte('\n')
+				}
+			}
+		case *ast.String:
+			if entering {
+				b.Write(n.Value)
+			}
+		default:
+			if n.Type() == ast.TypeBlock && !entering {
+				b.WriteByte('\n')
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	plain := b.String()
+
+	type found struct {
+		at  int
+		ref Reference
+	}
+	var refs []found
+	for _, m := range wikiLinkPattern.FindAllStringSubmatchIndex(plain, -1) {
+		title := strings.Join(strings.Fields(plain[m[2]:m[3]]), " ")
+		if title == "" {
+			continue
+		}
+		refs = append(refs, found{m[0], Reference{Text: plain[m[0]:m[1]], Title: title}})
+	}
+	for _, m := range idRefPattern.FindAllStringSubmatchIndex(plain, -1) {
+		id, err := strconv.Atoi(plain[m[4]:m[5]])
+		if err != nil {
+			continue
+		}
+		start := m[2] - 1 // the "#"
+		refs = append(refs, found{start, Reference{Text: plain[start:m[1]], Type: plain[m[2]:m[3]], ID: id}})
+	}
+
+	// Merge the two lists by position.
+	for i := 1; i < len(refs); i++ {
+		for j := i; j > 0 && refs[j].at < refs[j-1].at; j-- {
+			refs[j], refs[j-1] = refs[j-1], refs[j]
+		}
+	}
+	out := make([]Reference, len(refs))
+	for i, r := range refs {
+		out[i] = r.ref
+	}
+	return out
+}
+
+// TitleKey is the form titles are compared in: case-insensitive, with runs
+// of white space collapsed.
+func TitleKey(title string) string {
+	return strings.ToLower(strings.Join(strings.Fields(title), " "))
+}
@@ -0,0 +1,133 @@
+// Package markdown renders note content, written in CommonMark with the
+// GitHub extensions, to sanitized HTML and edits GFM task lists in place.
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// Raw HTML in the source is dropped by the renderer (goldmark is not run
+// with html.WithUnsafe), and the output is sanitized again so that nothing
+// but the markup Markdown itself produces reaches the browser.
+var (
+	md = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+	policy = func() *bluemonday.Policy {
+		p := bluemonday.UGCPolicy()
+		// Task list items render as disabled checkboxes.
+		p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+		p.AllowAttrs("checked", "disabled").OnElements("input")
+		p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+-]+$`)).OnElements("code")
+		p.RequireNoFollowOnLinks(true)
+		p.AddTargetBlankToFullyQualifiedLinks(true)
+		return p
+	}()
+)
+
+// Render converts Markdown to sanitized HTML.
+func Render(source string) string {
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(source), &buf); err != nil {
+		// Convert only fails when writing to buf fails, which it cannot.
+		return policy.Sanitize(source)
+	}
+	return policy.Sanitize(buf.String())
+}
+
+// TaskCount returns the number of task list items in source.
+func TaskCount(source string) int {
+	return len(taskMarkers([]byte(source)))
+}
+
+// SetTask checks or unchecks the index-th task list item (counting from 0
+// in document order, the order the checkboxes appear in Render's output)
+// and returns the rewritten source. Only the marker character changes, so
+// the rest of the source is preserved byte for byte.
+func SetTask(source string, index int, checked bool) (string, error) {
+	src := []byte(source)
+	markers := taskMarkers(src)
+	if index < 0 || index >= len(markers) {
+		return "", fmt.Errorf("task %d not found; the note has %d tasks", index, len(markers))
+	}
+	if checked {
+		src[markers[index]] = 'x'
+	} else {
+		src[markers[index]] = ' '
+	}
+	return string(src), nil
+}
+
+// TaskChecked reports whether the index-th task list item is checked.
+func TaskChecked(source string, index int) (bool, error) {
+	markers := taskMarkers([]byte(source))
+	if index < 0 || index >= len(markers) {
+		return false, fmt.Errorf("task %d not found; the note has %d tasks", index, len(markers))
+	}
+	return source[markers[index]] != ' ', nil
+}
+
+// TaskItem is a task list item: its index as SetTask counts them, whether
+// it is checked, and the source text of its line after the checkbox.
+type TaskItem struct {
+	Index   int
+	Checked bool
+	Text    string
+	Line    int // 1-based
+}
+
+// TaskItems returns the task list items in source, in document order.
+func TaskItems(source string) []TaskItem {
+	src := []byte(source)
+	markers := taskMarkers(src)
+	items := make([]TaskItem, len(markers))
+	for i, m := range markers {
+		end := bytes.IndexByte(src[m:], '\n')
+		if end < 0 {
+			end = len(src) - m
+		}
+		items[i] = TaskItem{
+			Index:   i,
+			Checked: src[m] != ' ',
+			Line:    bytes.Count(src[:m], []byte("\n")) + 1,
+		}
+		if end > 2 {
+			items[i].Text = strings.TrimSpace(string(src[m+2 : m+end]))
+		}
+	}
+	return items
+}
+
+// taskMarkers returns the offset of the character between the brackets of
+// every task list item. Using the parser rather than a regular expression
+// means "[ ]" inside code blocks or ordinary text is left alone.
+func taskMarkers(src []byte) []int {
+	doc := md.Parser().Parse(text.NewReader(src))
+	var markers []int
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if _, ok := n.(*extast.TaskCheckBox); !ok {
+			return ast.WalkContinue, nil
+		}
+		// The checkbox opens the first line of its paragraph.
+		if lines := n.Parent().Lines(); lines.Len() > 0 {
+			start := lines.At(0).Start
+			if start+2 < len(src) && src[start] == '[' && src[start+2] == ']' {
+				markers = append(markers, start+1)
+			}
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	return markers
+}
@@ -0,0 +1,257 @@
+package metrics
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// QueryObserver is told when an instrumented driver starts a statement: the
+// context it runs in, its operation (select, insert, update, delete, begin,
+// commit, rollback or other) and its text, which is empty for begin, commit
+// and rollback. The function it returns is called with the error the
+// statement ended with once it is done. A query is done when its rows are
+// closed, so reading the rows counts.
+type QueryObserver func(ctx context.Context, operation, query string) (done func(err error))
+
+// WrapDriver returns a driver that runs statements through d and reports
+// them to observe. Register it with sql.Register under a name of its own.
+func WrapDriver(d driver.Driver, observe QueryObserver) driver.Driver {
+	return &instrumentedDriver{d, observe}
+}
+
+type instrumentedDriver struct {
+	driver.Driver
+	observe QueryObserver
+}
+
+func (d *instrumentedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{c, d.observe}, nil
+}
+
+// operation classifies a statement by its first keyword.
+func operation(query string) string {
+	query = strings.TrimSpace(query)
+	end := strings.IndexFunc(query, func(r rune) bool { return r == ' ' || r == '\n' || r == '\t' || r == '(' })
+	if end < 0 {
+		end = len(query)
+	}
+	switch word := strings.ToLower(query[:end]); word {
+	case "select", "with":
+		return "select"
+	case "insert", "update", "delete":
+		return word
+	}
+	return "other"
+}
+
+type instrumentedConn struct {
+	driver.Conn
+	observe QueryObserver
+}
+
+func (c *instrumentedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var s driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		s, err = p.PrepareContext(ctx, query)
+	} else {
+		s, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedStmt{s, query, c.observe}, nil
+}
+
+func (c *instrumentedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	done := c.observe(ctx, "begin", "")
+	var tx driver.Tx
+	var err error
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedTx{tx, ctx, c.observe}, nil
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	done := c.observe(ctx, operation(query), query)
+	res, err := e.ExecContext(ctx, query, args)
+	done(err)
+	return res, err
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	done := c.observe(ctx, operation(query), query)
+	rows, err := q.QueryContext(ctx, query, args)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	return &instrumentedRows{Rows: rows, done: done}, nil
+}
+
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+type instrumentedStmt struct {
+	driver.Stmt
+	query   string
+	observe QueryObserver
+}
+
+func (s *instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	var values []driver.Value
+	e, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		var err error
+		if values, err = namedValues(args); err != nil {
+			return nil, err
+		}
+	}
+	done := s.observe(ctx, operation(s.query), s.query)
+	var res driver.Result
+	var err error
+	if ok {
+		res, err = e.ExecContext(ctx, args)
+	} else {
+		res, err = s.Stmt.Exec(values)
+	}
+	done(err)
+	return res, err
+}
+
+func (s *instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	var values []driver.Value
+	q, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		var err error
+		if values, err = namedValues(args); err != nil {
+			return nil, err
+		}
+	}
+	done := s.observe(ctx, operation(s.query), s.query)
+	var rows driver.Rows
+	var err error
+	if ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(values)
+	}
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	return &instrumentedRows{Rows: rows, done: done}, nil
+}
+
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		if a.Name != "" {
+			return nil, driver.ErrSkip
+		}
+		values[i] = a.Value
+	}
+	return values, nil
+}
+
+// instrumentedTx keeps the context the transaction began in for its
+// commit or rollback.
+type instrumentedTx struct {
+	driver.Tx
+	ctx     context.Context
+	observe QueryObserver
+}
+
+func (t *instrumentedTx) Commit() error {
+	done := t.observe(t.ctx, "commit", "")
+	err := t.Tx.Commit()
+	done(err)
+	return err
+}
+
+func (t *instrumentedTx) Rollback() error {
+	done := t.observe(t.ctx, "rollback", "")
+	err := t.Tx.Rollback()
+	done(err)
+	return err
+}
+
+// instrumentedRows reports its query when closed, with the first error
+// reading the rows ran into.
+type instrumentedRows struct {
+	driver.Rows
+	done func(error)
+	err  error
+}
+
+func (r *instrumentedRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+func (r *instrumentedRows) Close() error {
+	err := r.Rows.Close()
+	if r.err == nil {
+		r.err = err
+	}
+	r.done(r.err)
+	return err
+}
+
+func (r *instrumentedRows) ColumnTypeDatabaseTypeName(i int) string {
+	if c, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return c.ColumnTypeDatabaseTypeName(i)
+	}
+	return ""
+}
+
+func (r *instrumentedRows) ColumnTypeScanType(i int) reflect.Type {
+	if c, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return c.ColumnTypeScanType(i)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+func (r *instrumentedRows) ColumnTypeNullable(i int) (nullable, ok bool) {
+	if c, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return c.ColumnTypeNullable(i)
+	}
+	return false, false
+}
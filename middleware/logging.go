@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"task-manager/logging"
+	"time"
+)
+
+// Logging gives every request an ID, which is sent back in the X-Request-ID
+// header and carried by every record logged for the request. A client or
+// proxy may pick the ID by sending the header itself. Each request is
+// logged when it is done, with its status and duration: server errors at
+// ERROR, the rest at INFO.
+func Logging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		inner := logging.WithRequestID(r, id)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		serveCopy(next, rec, r, inner)
+
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(inner.Context(), level, "Request served",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"remote_addr", r.RemoteAddr)
+	})
+}
+
+// validRequestID accepts up to 64 letters, digits, dots, dashes and
+// underscores, so a client's ID cannot break up a log line.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
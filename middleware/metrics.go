@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"task-manager/metrics"
+	"time"
+)
+
+var (
+	httpRequests = metrics.Default.NewCounterVec("tasklift_http_requests_total",
+		"HTTP requests served, by route pattern, method and status code.", "route", "method", "code")
+	httpDuration = metrics.Default.NewHistogramVec("tasklift_http_request_duration_seconds",
+		"Time to serve HTTP requests, by route pattern and method. Streams and WebSockets count until they close.",
+		metrics.DefBuckets, "route", "method")
+)
+
+// Metrics counts and times the requests mux serves, labelled by the
+// pattern that matched them so the number of routes stays bounded.
+func Metrics(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(rec, r)
+
+		// The mux fills in the pattern of the request it was given.
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		method := r.Method
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+			http.MethodDelete, http.MethodOptions, "PROPFIND", "PROPPATCH", "REPORT", "MKCALENDAR":
+		default:
+			method = "other"
+		}
+		httpRequests.Inc(route, method, strconv.Itoa(rec.status))
+		httpDuration.Observe(time.Since(start).Seconds(), route, method)
+	})
+}
+
+// serveCopy serves inner, a copy of r with a different context, and then
+// hands the pattern the mux matched back to r, so middleware further out
+// can still read it.
+func serveCopy(next http.Handler, w http.ResponseWriter, r, inner *http.Request) {
+	next.ServeHTTP(w, inner)
+	r.Pattern = inner.Pattern
+}
+
+// statusRecorder remembers the status code of a response. It passes
+// flushing and hijacking through for event streams and WebSockets.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = code, true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		r.wroteHeader = true
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	r.status, r.wroteHeader = http.StatusSwitchingProtocols, true
+	return h.Hijack()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// RequireBearerToken only lets through requests with the header
+// "Authorization: Bearer <token>".
+func RequireBearerToken(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"task-manager/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Tracing starts a span for every request next serves, continuing the
+// trace of the client's traceparent header if it sent one. The span is
+// named after the route pattern next matched. The trace ID is sent back in
+// the X-Trace-Id header and added to plain-text error responses, so a
+// failure a user reports can be found in the collector.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.StartServer(r.Context(), r.Header, r.Method,
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("user_agent.original", r.UserAgent()))
+		defer span.End()
+
+		traceID := tracing.TraceID(ctx)
+		w.Header().Set("X-Trace-Id", traceID)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		serveCopy(next, rec, r, r.WithContext(ctx))
+
+		// serveCopy brought back the pattern the mux matched.
+		if r.Pattern != "" {
+			span.SetName(r.Method + " " + r.Pattern)
+			span.SetAttributes(attribute.String("http.route", r.Pattern))
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+
+		// http.Error's responses are plain text marked nosniff; the trace
+		// ID goes on a line after the message.
+		h := rec.Header()
+		if rec.status >= 400 && r.Method != http.MethodHead &&
+			strings.HasPrefix(h.Get("Content-Type"), "text/plain") && h.Get("X-Content-Type-Options") == "nosniff" {
+			fmt.Fprintf(rec, "Trace ID: %s\n", traceID)
+		}
+	})
+}
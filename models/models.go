@@ -1,5 +1,7 @@
 package models
 
+import "encoding/json"
+
 // Enhanced structs
 type Task struct {
 	ID          int    `json:"id"`
@@ -11,6 +13,22 @@ type Task struct {
 	DueDate     string `json:"due_date,omitempty"`
 	CreatedAt   string `json:"created_at"`
 	ProjectName string `json:"project_name,omitempty"`
+
+	// ReminderLeadMinutes overrides the user's reminder lead time for this task.
+	ReminderLeadMinutes *int `json:"reminder_lead_minutes,omitempty"`
+
+	Tags []string `json:"tags"`
+
+	// ExternalID identifies the task in the system it was imported from.
+	ExternalID string `json:"external_id,omitempty"`
+
+	// SourceNoteID is the note the task was created from, if any.
+	SourceNoteID *int `json:"source_note_id,omitempty"`
+
+	// StartedAt is when work on the task started, and CompletedAt when it
+	// was last completed, while it is done.
+	StartedAt   string `json:"started_at,omitempty"`
+	CompletedAt string `json:"completed_at,omitempty"`
 }
 
 type Project struct {
@@ -25,6 +43,60 @@ type Project struct {
 	TaskCount      int    `json:"task_count"`
 	CompletedTasks int    `json:"completed_tasks"`
 	TeamMembers    int    `json:"team_members"`
+	ExternalID     string `json:"external_id,omitempty"`
+
+	Forecast *ProjectForecast `json:"forecast,omitempty"`
+}
+
+// ProjectForecast projects when a project's open tasks will be done from
+// its past weekly throughput. The dates are the 50th, 85th and 95th
+// percentiles of the simulated completion dates.
+type ProjectForecast struct {
+	Remaining     int     `json:"remaining"`
+	HistoryWeeks  int     `json:"history_weeks"`
+	WeeklyAverage float64 `json:"weekly_average"`
+	P50           string  `json:"p50,omitempty"`
+	P85           string  `json:"p85,omitempty"`
+	P95           string  `json:"p95,omitempty"`
+	OnTimeChance  *int    `json:"on_time_chance,omitempty"` // percent, with a due date
+	Risk          string  `json:"risk"`                     // done, on_track, at_risk, late, no_due_date or unknown
+	RiskExplained string  `json:"risk_explained"`
+}
+
+// ReportDefinition describes an analytics report: which metrics, for which
+// projects (all of them when ProjectIDs is empty) and over how many days up
+// to yesterday. Frequency off means it only runs on demand.
+type ReportDefinition struct {
+	ID          int      `json:"id"`
+	UserID      int      `json:"user_id"`
+	Name        string   `json:"name"`
+	Metrics     []string `json:"metrics"` // summary, trends, flow, forecast
+	ProjectIDs  []int    `json:"project_ids"`
+	RangeDays   int      `json:"range_days"`
+	Granularity string   `json:"granularity"` // of the trends: day, week or month
+	Formats     []string `json:"formats"`     // csv, html
+	Frequency   string   `json:"frequency"`   // off, daily or weekly
+	Weekday     int      `json:"weekday"`     // 0 (Sunday) to 6, for weekly reports
+	Hour        int      `json:"hour"`
+	Email       bool     `json:"email"`
+	Recipients  []string `json:"recipients"` // besides the owner
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+}
+
+// ReportRun is one generation of a report and the documents it produced.
+type ReportRun struct {
+	ID             int      `json:"id"`
+	ReportID       int      `json:"report_id"`
+	Period         string   `json:"period"`
+	From           string   `json:"from"`
+	To             string   `json:"to"`
+	Status         string   `json:"status"` // running or done
+	CSVDocumentID  *int     `json:"csv_document_id,omitempty"`
+	HTMLDocumentID *int     `json:"html_document_id,omitempty"`
+	EmailedTo      []string `json:"emailed_to"`
+	CreatedAt      string   `json:"created_at"`
+	FinishedAt     string   `json:"finished_at,omitempty"`
 }
 
 type Document struct {
@@ -38,10 +110,281 @@ type Document struct {
 }
 
 type Note struct {
+	ID          int    `json:"id"`
+	UserID      int    `json:"user_id"`
+	Title       string `json:"title"`
+	Content     string `json:"content"`      // Markdown source
+	ContentHTML string `json:"content_html"` // rendered and sanitized
+	NotebookID  *int   `json:"notebook_id,omitempty"`
+	ProjectID   *int   `json:"project_id,omitempty"`
+	Pinned      bool   `json:"pinned"`
+	Position    int    `json:"position"` // order in a notebook sorted manually
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+
+	// Encrypted notes come with empty Content and Locked set unless the
+	// user has unlocked their private notes. Ciphertext is the stored
+	// content and never leaves the server.
+	Encrypted  bool   `json:"encrypted"`
+	Locked     bool   `json:"locked,omitempty"`
+	Ciphertext string `json:"-"`
+}
+
+// NoteEncryption is the state of a user's private notes.
+type NoteEncryption struct {
+	Enabled   bool   `json:"enabled"`
+	Unlocked  bool   `json:"unlocked"`
+	ExpiresAt string `json:"expires_at,omitempty"` // when an unlocked session locks again
+	Notes     int    `json:"notes"`                // number of encrypted notes
+}
+
+// Notebook is a folder of notes. Notebooks nest; SortOrder is how the notes
+// in it are listed: updated, created, title or manual.
+type Notebook struct {
+	ID        int        `json:"id"`
+	ParentID  *int       `json:"parent_id,omitempty"`
+	Name      string     `json:"name"`
+	SortOrder string     `json:"sort_order"`
+	NoteCount int        `json:"note_count"`
+	Children  []Notebook `json:"children"`
+	CreatedAt string     `json:"created_at"`
+	UpdatedAt string     `json:"updated_at"`
+}
+
+type ReminderSettings struct {
+	RemindersEnabled    bool `json:"reminders_enabled"`
+	ReminderLeadMinutes *int `json:"reminder_lead_minutes,omitempty"`
+	DefaultLeadMinutes  int  `json:"default_lead_minutes"`
+}
+
+type DigestSettings struct {
+	Frequency string `json:"digest_frequency"`
+	Hour      int    `json:"digest_hour"`
+	Weekday   int    `json:"digest_weekday"`
+	Timezone  string `json:"timezone"`
+}
+
+type Webhook struct {
+	ID        int      `json:"id"`
+	UserID    int      `json:"user_id"`
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"`
+	Events    []string `json:"events"`
+	Active    bool     `json:"active"`
+	CreatedAt string   `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID            int                      `json:"id"`
+	WebhookID     int                      `json:"webhook_id"`
+	EventID       string                   `json:"event_id"`
+	EventType     string                   `json:"event_type"`
+	Status        string                   `json:"status"`
+	Attempts      int                      `json:"attempts"`
+	ResponseCode  *int                     `json:"response_code,omitempty"`
+	Error         string                   `json:"error,omitempty"`
+	NextAttemptAt string                   `json:"next_attempt_at,omitempty"`
+	CreatedAt     string                   `json:"created_at"`
+	AttemptLog    []WebhookDeliveryAttempt `json:"attempt_log"`
+}
+
+type WebhookDeliveryAttempt struct {
+	Attempt      int    `json:"attempt"`
+	ResponseCode *int   `json:"response_code,omitempty"`
+	Error        string `json:"error,omitempty"`
+	DurationMS   int    `json:"duration_ms"`
+	CreatedAt    string `json:"created_at"`
+}
+
+type CalendarFeed struct {
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	URL       string            `json:"url"`
+	Filters   map[string]string `json:"filters,omitempty"`
+	CreatedAt string            `json:"created_at"`
+	Revoked   bool              `json:"revoked"`
+}
+
+// ImportReport describes the outcome of a CSV import, or on a dry run what
+// the import would do.
+type ImportReport struct {
+	Type            string      `json:"type"`
+	DryRun          bool        `json:"dry_run"`
+	Created         int         `json:"created"`
+	Updated         int         `json:"updated"`
+	Unchanged       int         `json:"unchanged"`
+	Failed          int         `json:"failed"`
+	ProjectsCreated []string    `json:"projects_created"`
+	Rows            []ImportRow `json:"rows"`
+}
+
+// ImportRow is the result for one CSV line. Row counts the header as line 1.
+type ImportRow struct {
+	Row        int      `json:"row"`
+	Action     string   `json:"action"` // create, update, unchanged or error
+	ID         int      `json:"id,omitempty"`
+	ExternalID string   `json:"external_id,omitempty"`
+	Summary    string   `json:"summary"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+// Job is a long-running request processed in the background. Result holds
+// the kind-specific outcome once the job is done.
+type Job struct {
+	ID         int             `json:"id"`
+	Kind       string          `json:"kind"`
+	Status     string          `json:"status"` // queued, running, done or failed
+	Progress   int             `json:"progress"`
+	Total      int             `json:"total"`
+	Message    string          `json:"message,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  string          `json:"created_at"`
+	StartedAt  string          `json:"started_at,omitempty"`
+	FinishedAt string          `json:"finished_at,omitempty"`
+}
+
+// ExternalImportReport is the result of importing a Trello, Todoist or
+// Asana export.
+type ExternalImportReport struct {
+	Source          string        `json:"source"`
+	ProjectsCreated int           `json:"projects_created"`
+	ProjectsReused  int           `json:"projects_reused"`
+	TasksCreated    int           `json:"tasks_created"`
+	NotesCreated    int           `json:"notes_created"`
+	Skipped         []SkippedItem `json:"skipped"`
+}
+
+// SkippedItem is something in an export that was not imported, and why.
+type SkippedItem struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// AccountExport is the result of a "download my data" job.
+type AccountExport struct {
+	Archive     string         `json:"archive"`
+	Size        int64          `json:"size"`
+	DownloadURL string         `json:"download_url"`
+	ExpiresAt   string         `json:"expires_at"`
+	Counts      map[string]int `json:"counts"`
+}
+
+// AccountImportReport counts what an account archive import restored.
+type AccountImportReport struct {
+	Projects  int           `json:"projects"`
+	Tasks     int           `json:"tasks"`
+	Notebooks int           `json:"notebooks"`
+	Notes     int           `json:"notes"`
+	Documents int           `json:"documents"`
+	Activity  int           `json:"activity"`
+	Skipped   []SkippedItem `json:"skipped"`
+}
+
+// NoteRevision is a saved version of a note. Content is left out of
+// revision lists.
+type NoteRevision struct {
 	ID        int    `json:"id"`
-	UserID    int    `json:"user_id"`
+	NoteID    int    `json:"note_id"`
 	Title     string `json:"title"`
-	Content   string `json:"content"`
+	Content   string `json:"content,omitempty"`
+	Size      int    `json:"size"`
+	AuthorID  int    `json:"author_id"`
+	Author    string `json:"author"`
 	CreatedAt string `json:"created_at"`
 	UpdatedAt string `json:"updated_at"`
 }
+
+// Link is a reference from a note to a note, task or project, written as
+// [[Title]] or #type-id. TargetID is nil while the link is broken.
+type Link struct {
+	SourceType  string `json:"source_type"`
+	SourceID    int    `json:"source_id"`
+	SourceTitle string `json:"source_title"`
+	Kind        string `json:"kind"` // wiki or ref
+	Text        string `json:"text"`
+	TargetType  string `json:"target_type,omitempty"`
+	TargetID    *int   `json:"target_id,omitempty"`
+	TargetTitle string `json:"target_title,omitempty"`
+	Broken      bool   `json:"broken"`
+}
+
+// ActionItem is a checklist item found in a note, proposed as a task.
+// Title is the item's text without the due date phrase, if it had one.
+type ActionItem struct {
+	Index   int    `json:"index"`
+	Line    int    `json:"line"`
+	Text    string `json:"text"`
+	Title   string `json:"title"`
+	DueDate string `json:"due_date,omitempty"`
+	Checked bool   `json:"checked"`
+	TaskID  *int   `json:"task_id,omitempty"` // set once the item has become a task
+}
+
+// TrendPoint is one period of the task trends. Open and Overdue are counted
+// at the end of the period, or now for the current one.
+type TrendPoint struct {
+	Period    string `json:"period"` // first day, YYYY-MM-DD
+	Created   int    `json:"created"`
+	Completed int    `json:"completed"`
+	Open      int    `json:"open"`
+	Overdue   int    `json:"overdue"`
+}
+
+// Burndown tracks a project's tasks from its creation to its due date.
+// Counts are nil for periods still to come; Ideal is the straight line from
+// the project's current scope down to zero on the due date.
+type Burndown struct {
+	ProjectID   int             `json:"project_id"`
+	Name        string          `json:"name"`
+	Start       string          `json:"start"`
+	DueDate     string          `json:"due_date,omitempty"`
+	Granularity string          `json:"granularity"`
+	Points      []BurndownPoint `json:"points"`
+}
+
+type BurndownPoint struct {
+	Period    string   `json:"period"`
+	Scope     *int     `json:"scope"`     // tasks in the project
+	Completed *int     `json:"completed"` // burn-up
+	Remaining *int     `json:"remaining"` // burn-down
+	Ideal     *float64 `json:"ideal,omitempty"`
+}
+
+// FlowMetrics describe how work flows through the tasks completed between
+// From and To. Lead time runs from creation to completion, cycle time from
+// start to completion; tasks never started have no cycle time.
+type FlowMetrics struct {
+	From       string           `json:"from"`
+	To         string           `json:"to"`
+	Completed  int              `json:"completed"`
+	Reopened   int              `json:"reopened"`
+	LeadTime   Durations        `json:"lead_time"`
+	CycleTime  Durations        `json:"cycle_time"`
+	Throughput []ThroughputWeek `json:"throughput"`
+	WIP        []ProjectWIP     `json:"wip"`
+}
+
+// Durations summarizes a set of durations, in hours.
+type Durations struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean_hours"`
+	P50   float64 `json:"p50_hours"`
+	P85   float64 `json:"p85_hours"`
+	P95   float64 `json:"p95_hours"`
+}
+
+type ThroughputWeek struct {
+	Week      string `json:"week"` // the Monday, YYYY-MM-DD
+	Completed int    `json:"completed"`
+}
+
+// ProjectWIP is the open work in a project right now. ProjectID is nil for
+// tasks without a project.
+type ProjectWIP struct {
+	ProjectID  *int   `json:"project_id"`
+	Name       string `json:"name"`
+	InProgress int    `json:"in_progress"`
+	ToDo       int    `json:"to_do"`
+}
@@ -0,0 +1,159 @@
+// Package notecrypt encrypts private notes.
+//
+// Each user has a random 256-bit data key that encrypts their notes with
+// AES-256-GCM. The data key is stored wrapped (itself encrypted with
+// AES-256-GCM) under a key derived from the user's passphrase with
+// Argon2id, so changing the passphrase only re-wraps the data key.
+package notecrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Params are the Argon2id cost parameters. They are stored with each
+// wrapped key so that they can be raised later without breaking old keys.
+type Params struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+}
+
+// DefaultParams follow the RFC 9106 recommendation for memory-constrained
+// environments, with a lower memory cost suited to a shared server.
+var DefaultParams = Params{Time: 3, Memory: 64 * 1024, Threads: 2}
+
+const (
+	keySize  = 32
+	saltSize = 16
+
+	// prefix marks encrypted note content and its format version.
+	prefix = "enc:v1:"
+)
+
+var (
+	// ErrWrongPassphrase is returned by Unwrap when the passphrase does
+	// not match.
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+
+	// ErrCorrupt is returned by Open for content that was not sealed with
+	// the key or has been altered.
+	ErrCorrupt = errors.New("encrypted content is damaged or uses another key")
+
+	wrapAD = []byte("tasklift note key")
+	sealAD = []byte("tasklift note")
+)
+
+// NewKey returns a random data key.
+func NewKey() ([]byte, error) {
+	return random(keySize)
+}
+
+// NewSalt returns a random salt for DeriveKey.
+func NewSalt() ([]byte, error) {
+	return random(saltSize)
+}
+
+// DeriveKey derives the key-encryption key from a passphrase.
+func DeriveKey(passphrase string, salt []byte, p Params) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, p.Time, p.Memory, p.Threads, keySize)
+}
+
+// Wrap encrypts a data key with a key from DeriveKey.
+func Wrap(kek, dataKey []byte) ([]byte, error) {
+	return seal(kek, dataKey, wrapAD)
+}
+
+// Unwrap decrypts a data key wrapped by Wrap.
+func Unwrap(kek, wrapped []byte) ([]byte, error) {
+	key, err := open(kek, wrapped, wrapAD)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return key, nil
+}
+
+// Seal encrypts note content with a data key. The result is printable and
+// starts with a version prefix, see IsSealed.
+func Seal(key []byte, plaintext string) (string, error) {
+	b, err := seal(key, []byte(plaintext), sealAD)
+	if err != nil {
+		return "", err
+	}
+	return prefix + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// Open decrypts content encrypted by Seal.
+func Open(key []byte, sealed string) (string, error) {
+	if !IsSealed(sealed) {
+		return "", ErrCorrupt
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, prefix))
+	if err != nil {
+		return "", ErrCorrupt
+	}
+	plaintext, err := open(key, b, sealAD)
+	if err != nil {
+		return "", ErrCorrupt
+	}
+	return string(plaintext), nil
+}
+
+// IsSealed reports whether s looks like the output of Seal.
+func IsSealed(s string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+// Zero overwrites a key that is no longer needed.
+func Zero(key []byte) {
+	for i := range key {
+		key[i] = 0
+	}
+}
+
+// seal returns nonce || ciphertext.
+func seal(key, plaintext, ad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := random(aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+func open(key, sealed, ad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrCorrupt
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, ad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func random(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
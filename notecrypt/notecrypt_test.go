@@ -0,0 +1,97 @@
+package notecrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testParams keep key derivation fast; the tests don't rely on its cost.
+var testParams = Params{Time: 1, Memory: 64, Threads: 1}
+
+func TestWrapRoundTrip(t *testing.T) {
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := Wrap(DeriveKey("correct horse", salt, testParams), dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Unwrap(DeriveKey("correct horse", salt, testParams), wrapped)
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("Unwrap = %x, %v; want %x", got, err, dataKey)
+	}
+	if _, err := Unwrap(DeriveKey("wrong horse", salt, testParams), wrapped); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("wrong passphrase: got %v", err)
+	}
+	otherSalt, _ := NewSalt()
+	if _, err := Unwrap(DeriveKey("correct horse", otherSalt, testParams), wrapped); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("wrong salt: got %v", err)
+	}
+
+	tampered := bytes.Clone(wrapped)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := Unwrap(DeriveKey("correct horse", salt, testParams), tampered); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("tampered key: got %v", err)
+	}
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	key, _ := NewKey()
+	for _, plaintext := range []string{"", "Buy milk", strings.Repeat("ünïcødé ", 1000)} {
+		sealed, err := Seal(key, plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsSealed(sealed) || strings.Contains(sealed, "milk") {
+			t.Errorf("Seal(%.20q) = %.40q", plaintext, sealed)
+		}
+		got, err := Open(key, sealed)
+		if err != nil || got != plaintext {
+			t.Errorf("Open(Seal(%.20q)) = %.20q, %v", plaintext, got, err)
+		}
+	}
+
+	// The same content seals differently each time.
+	a, _ := Seal(key, "Buy milk")
+	b, _ := Seal(key, "Buy milk")
+	if a == b {
+		t.Error("Seal reuses its nonce")
+	}
+}
+
+func TestOpenRejectsDamagedContent(t *testing.T) {
+	key, _ := NewKey()
+	otherKey, _ := NewKey()
+	sealed, _ := Seal(key, "Buy milk")
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, prefix))
+
+	flipped := bytes.Clone(raw)
+	flipped[len(flipped)/2] ^= 1
+	wrapped, _ := Wrap(key, otherKey)
+
+	tests := map[string]string{
+		"flipped bit":          prefix + base64.StdEncoding.EncodeToString(flipped),
+		"truncated":            prefix + base64.StdEncoding.EncodeToString(raw[:len(raw)-1]),
+		"shorter than a nonce": prefix + base64.StdEncoding.EncodeToString(raw[:4]),
+		"not base64":           prefix + "!!!",
+		"no prefix":            strings.TrimPrefix(sealed, prefix),
+		"wrapped key":          prefix + base64.StdEncoding.EncodeToString(wrapped),
+	}
+	for name, s := range tests {
+		if _, err := Open(key, s); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: got %v, want ErrCorrupt", name, err)
+		}
+	}
+	if _, err := Open(otherKey, sealed); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("other key: got %v, want ErrCorrupt", err)
+	}
+}
@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// Message is an outgoing notification. HTML is optional; when set the
+// message is sent as multipart/alternative together with Text. Headers are
+// extra mail headers such as List-Unsubscribe. Attachments, if any, turn the
+// message into multipart/mixed.
+type Message struct {
+	To          []string
+	Subject     string
+	Text        string
+	HTML        string
+	Headers     map[string]string
+	Attachments []Attachment
+}
+
+// Attachment is a file sent along with a message.
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Notifier delivers messages to users.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// LogNotifier writes messages to the server log instead of sending them.
+// It is used when no SMTP server is configured.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, msg Message) error {
+	if len(msg.Attachments) > 0 {
+		names := make([]string, len(msg.Attachments))
+		for i, a := range msg.Attachments {
+			names[i] = a.Name
+		}
+		slog.InfoContext(ctx, "Notification", "to", strings.Join(msg.To, ", "), "subject", msg.Subject, "attached", strings.Join(names, ", "))
+		return nil
+	}
+	slog.InfoContext(ctx, "Notification", "to", strings.Join(msg.To, ", "), "subject", msg.Subject)
+	return nil
+}
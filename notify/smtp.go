@@ -0,0 +1,271 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"task-manager/tracing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SMTPNotifier sends messages through an SMTP server. STARTTLS is used when
+// the server offers it; authentication is only attempted when Username is set,
+// so a local sink such as MailHog on port 1025 works with just Host and Port.
+type SMTPNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, msg Message) (err error) {
+	if len(msg.To) == 0 {
+		return fmt.Errorf("no recipients")
+	}
+
+	ctx, span := tracing.StartClient(ctx, "smtp send",
+		attribute.String("server.address", n.Host),
+		attribute.Int("server.port", n.Port),
+		attribute.Int("email.recipients", len(msg.To)))
+	defer func() { tracing.End(span, err) }()
+
+	body, err := n.buildMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+	dialer := net.Dialer{Timeout: 15 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("connect to %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(time.Minute))
+	}
+
+	c, err := smtp.NewClient(conn, n.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: n.Host}); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+
+	if n.Username != "" {
+		auth := smtp.PlainAuth("", n.Username, n.Password, n.Host)
+		if err := c.Auth(auth); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	if err := c.Mail(addressOnly(n.From)); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err := c.Rcpt(addressOnly(to)); err != nil {
+			return fmt.Errorf("rcpt %s: %w", to, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+func (n *SMTPNotifier) buildMessage(msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+
+	header := [][2]string{
+		{"From", n.From},
+		{"To", strings.Join(msg.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageID(n.From)},
+		{"MIME-Version", "1.0"},
+	}
+	for _, key := range sortedKeys(msg.Headers) {
+		header = append(header, [2]string{key, msg.Headers[key]})
+	}
+
+	if len(msg.Attachments) == 0 {
+		bodyHeader, body, err := buildBody(msg)
+		if err != nil {
+			return nil, err
+		}
+		writeHeader(&buf, append(header, bodyHeader...))
+		buf.Write(body)
+		return buf.Bytes(), nil
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	header = append(header, [2]string{"Content-Type", "multipart/mixed; boundary=" + mixed.Boundary()})
+	writeHeader(&buf, header)
+
+	bodyHeader, body, err := buildBody(msg)
+	if err != nil {
+		return nil, err
+	}
+	partHeader := textproto.MIMEHeader{}
+	for _, h := range bodyHeader {
+		partHeader.Set(h[0], h[1])
+	}
+	pw, err := mixed.CreatePart(partHeader)
+	if err != nil {
+		return nil, err
+	}
+	pw.Write(body)
+
+	for _, a := range msg.Attachments {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		pw, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": a.Name})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(pw, a.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// buildBody returns the content headers and body of the message's text: a
+// plain-text body, or multipart/alternative when there is HTML.
+func buildBody(msg Message) ([][2]string, []byte, error) {
+	var buf bytes.Buffer
+	if msg.HTML == "" {
+		if err := writeQuotedPrintable(&buf, msg.Text); err != nil {
+			return nil, nil, err
+		}
+		return [][2]string{
+			{"Content-Type", "text/plain; charset=utf-8"},
+			{"Content-Transfer-Encoding", "quoted-printable"},
+		}, buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	}
+	for _, p := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := writeQuotedPrintable(pw, p.content); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, nil, err
+	}
+	return [][2]string{{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()}}, buf.Bytes(), nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeHeader(buf *bytes.Buffer, header [][2]string) {
+	for _, h := range header {
+		fmt.Fprintf(buf, "%s: %s\r\n", h[0], h[1])
+	}
+	buf.WriteString("\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeBase64 writes data base64 encoded in lines of 76 characters.
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(w, encoded[:76]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := io.WriteString(w, encoded+"\r\n")
+	return err
+}
+
+func messageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(addressOnly(from), "@"); at >= 0 {
+		domain = addressOnly(from)[at+1:]
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+// addressOnly strips a display name, turning "TaskLift <a@b.c>" into "a@b.c".
+func addressOnly(addr string) string {
+	if parsed, err := mail.ParseAddress(addr); err == nil {
+		return parsed.Address
+	}
+	return strings.TrimSpace(addr)
+}
@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"task-manager/tracing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Job is a unit of periodic background work. It should return promptly
+// once ctx is cancelled.
+type Job func(ctx context.Context)
+
+type entry struct {
+	name     string
+	interval time.Duration
+	job      Job
+}
+
+// Scheduler runs registered jobs on fixed intervals inside the server process.
+type Scheduler struct {
+	mu      sync.Mutex
+	entries []entry
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func New() *Scheduler {
+	return &Scheduler{}
+}
+
+// Every registers job to run once on Start and then every interval.
+// Jobs must be registered before Start is called.
+func (s *Scheduler) Every(name string, interval time.Duration, job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry{name: name, interval: interval, job: job})
+}
+
+// Start launches one goroutine per registered job.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	for _, e := range s.entries {
+		s.wg.Add(1)
+		go s.loop(ctx, e)
+	}
+}
+
+// Stop cancels all jobs and waits for running ones to return.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, e entry) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		s.run(ctx, e)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run runs a job once, in a trace of its own.
+func (s *Scheduler) run(ctx context.Context, e entry) {
+	ctx, span := tracing.Start(ctx, "scheduled "+e.name)
+	defer span.End()
+	defer func() {
+		if err := recover(); err != nil {
+			slog.ErrorContext(ctx, "Scheduler job panicked", "job", e.name, "panic", err)
+			span.SetStatus(codes.Error, fmt.Sprint(err))
+		}
+	}()
+	e.job(ctx)
+}
@@ -0,0 +1,610 @@
+// Package schema creates the SQLite schema and keeps it up to date.
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// basic is the schema the first release created. Everything since is a
+// migration.
+const basic = `
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT UNIQUE NOT NULL,
+		email TEXT UNIQUE,
+		password TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS projects (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		description TEXT,
+		status TEXT DEFAULT 'active',
+		progress INTEGER DEFAULT 0,
+		due_date DATE,
+		team_members INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS tasks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		project_id INTEGER,
+		description TEXT NOT NULL,
+		priority TEXT DEFAULT 'medium',
+		done BOOLEAN DEFAULT 0,
+		due_date DATE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (project_id) REFERENCES projects(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS documents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		file_path TEXT NOT NULL,
+		file_type TEXT,
+		file_size INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS notes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		content TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	-- Insert test user
+	INSERT OR IGNORE INTO users (id, username, email, password) VALUES 
+	(1, 'testuser', 'test@example.com', 'test_password');
+	`
+
+// Apply creates the basic schema if it is missing and applies the
+// migrations db has not had yet.
+func Apply(db *sql.DB) error {
+	if _, err := db.Exec(basic); err != nil {
+		return fmt.Errorf("basic schema: %w", err)
+	}
+	return migrate(db)
+}
+
+// Latest is the version Apply brings a database to.
+func Latest() int {
+	return len(migrations)
+}
+
+// Version returns the latest migration applied to db.
+func Version(ctx context.Context, db *sql.DB) (int, error) {
+	var current int
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current)
+	return current, err
+}
+
+// migrations are applied in order on top of the basic schema. Each entry runs
+// once inside a transaction and its position (starting at 1) is recorded in
+// schema_migrations. Never edit an entry that has shipped; append a new one.
+var migrations = []string{
+	// 1: due-date reminders
+	`
+	ALTER TABLE tasks ADD COLUMN reminder_lead_minutes INTEGER;
+
+	CREATE TABLE IF NOT EXISTS user_settings (
+		user_id INTEGER PRIMARY KEY,
+		reminders_enabled BOOLEAN DEFAULT 1,
+		reminder_lead_minutes INTEGER,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS task_reminders (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		due_date TEXT NOT NULL,
+		sent_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (task_id, kind, due_date),
+		FOREIGN KEY (task_id) REFERENCES tasks(id)
+	);
+	`,
+
+	// 2: digest emails
+	`
+	ALTER TABLE user_settings ADD COLUMN digest_frequency TEXT DEFAULT 'off';
+	ALTER TABLE user_settings ADD COLUMN digest_hour INTEGER DEFAULT 8;
+	ALTER TABLE user_settings ADD COLUMN digest_weekday INTEGER DEFAULT 1;
+	ALTER TABLE user_settings ADD COLUMN timezone TEXT DEFAULT 'UTC';
+
+	CREATE TABLE IF NOT EXISTS digest_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		period TEXT NOT NULL,
+		sent_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (user_id, period),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS project_progress_snapshots (
+		user_id INTEGER NOT NULL,
+		project_id INTEGER NOT NULL,
+		progress INTEGER NOT NULL,
+		recorded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, project_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS app_settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	`,
+
+	// 3: outgoing webhooks
+	`
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		active BOOLEAN DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event_id TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT DEFAULT 'pending',
+		attempts INTEGER DEFAULT 0,
+		response_code INTEGER,
+		error TEXT,
+		next_attempt_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (webhook_id) REFERENCES webhooks(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		delivery_id INTEGER NOT NULL,
+		attempt INTEGER NOT NULL,
+		response_code INTEGER,
+		response_body TEXT,
+		error TEXT,
+		duration_ms INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_webhooks_user_id ON webhooks(user_id);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id);
+	`,
+
+	// 4: task tags and calendar feeds
+	`
+	CREATE TABLE IF NOT EXISTS task_tags (
+		task_id INTEGER NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (task_id, tag),
+		FOREIGN KEY (task_id) REFERENCES tasks(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS calendar_feeds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		token TEXT UNIQUE NOT NULL,
+		name TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		revoked_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_task_tags_tag ON task_tags(tag);
+	CREATE INDEX IF NOT EXISTS idx_calendar_feeds_user_id ON calendar_feeds(user_id);
+	`,
+
+	// 5: CalDAV resource names and the change log behind sync tokens.
+	// project_id 0 in task_changes stands for the inbox collection.
+	`
+	ALTER TABLE tasks ADD COLUMN ical_uid TEXT;
+	ALTER TABLE tasks ADD COLUMN ical_name TEXT;
+
+	CREATE TABLE IF NOT EXISTS task_changes (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		project_id INTEGER NOT NULL DEFAULT 0,
+		name TEXT NOT NULL,
+		changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_task_changes_collection ON task_changes(user_id, project_id, seq);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_ical_uid ON tasks(user_id, ical_uid) WHERE ical_uid IS NOT NULL;
+
+	CREATE TRIGGER IF NOT EXISTS task_changes_insert AFTER INSERT ON tasks
+	BEGIN
+		INSERT INTO task_changes (task_id, user_id, project_id, name)
+		VALUES (NEW.id, NEW.user_id, COALESCE(NEW.project_id, 0), COALESCE(NEW.ical_name, 'task-' || NEW.id || '.ics'));
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS task_changes_update AFTER UPDATE ON tasks
+	BEGIN
+		INSERT INTO task_changes (task_id, user_id, project_id, name)
+		SELECT OLD.id, OLD.user_id, COALESCE(OLD.project_id, 0), COALESCE(OLD.ical_name, 'task-' || OLD.id || '.ics')
+		WHERE COALESCE(OLD.project_id, 0) != COALESCE(NEW.project_id, 0)
+		   OR COALESCE(OLD.ical_name, '') != COALESCE(NEW.ical_name, '');
+		INSERT INTO task_changes (task_id, user_id, project_id, name)
+		VALUES (NEW.id, NEW.user_id, COALESCE(NEW.project_id, 0), COALESCE(NEW.ical_name, 'task-' || NEW.id || '.ics'));
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS task_changes_delete AFTER DELETE ON tasks
+	BEGIN
+		INSERT INTO task_changes (task_id, user_id, project_id, name)
+		VALUES (OLD.id, OLD.user_id, COALESCE(OLD.project_id, 0), COALESCE(OLD.ical_name, 'task-' || OLD.id || '.ics'));
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS task_changes_tag_insert AFTER INSERT ON task_tags
+	BEGIN
+		INSERT INTO task_changes (task_id, user_id, project_id, name)
+		SELECT id, user_id, COALESCE(project_id, 0), COALESCE(ical_name, 'task-' || id || '.ics')
+		FROM tasks WHERE id = NEW.task_id;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS task_changes_tag_delete AFTER DELETE ON task_tags
+	BEGIN
+		INSERT INTO task_changes (task_id, user_id, project_id, name)
+		SELECT id, user_id, COALESCE(project_id, 0), COALESCE(ical_name, 'task-' || id || '.ics')
+		FROM tasks WHERE id = OLD.task_id;
+	END;
+	`,
+
+	// 6: external ids for idempotent CSV imports
+	`
+	ALTER TABLE tasks ADD COLUMN external_id TEXT;
+	ALTER TABLE projects ADD COLUMN external_id TEXT;
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_external_id ON tasks(user_id, external_id) WHERE external_id IS NOT NULL;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_projects_external_id ON projects(user_id, external_id) WHERE external_id IS NOT NULL;
+	`,
+
+	// 7: background jobs, first used by the Trello/Todoist/Asana importers
+	`
+	CREATE TABLE IF NOT EXISTS background_jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'queued' CHECK(status IN ('queued', 'running', 'done', 'failed')),
+		progress INTEGER NOT NULL DEFAULT 0,
+		total INTEGER NOT NULL DEFAULT 0,
+		message TEXT NOT NULL DEFAULT '',
+		payload BLOB,
+		result TEXT,
+		error TEXT,
+		created_at DATETIME NOT NULL,
+		started_at DATETIME,
+		finished_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_background_jobs_status ON background_jobs(status, id);
+	CREATE INDEX IF NOT EXISTS idx_background_jobs_user ON background_jobs(user_id, id);
+	`,
+
+	// 8: the activity log, which only data.sql created so far, so that
+	// account archives can always export and restore it
+	`
+	CREATE TABLE IF NOT EXISTS activity_logs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		entity_type TEXT NOT NULL,
+		entity_id INTEGER NOT NULL,
+		description TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_activity_logs_user_id ON activity_logs(user_id);
+	`,
+
+	// 9: note revisions, seeded with each existing note's current text
+	`
+	CREATE TABLE IF NOT EXISTS note_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		note_id INTEGER NOT NULL,
+		author_id INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		content TEXT NOT NULL DEFAULT '',
+		coalescable BOOLEAN NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE,
+		FOREIGN KEY (author_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_note_revisions_note ON note_revisions(note_id, id);
+
+	INSERT INTO note_revisions (note_id, author_id, title, content, created_at, updated_at)
+	SELECT id, user_id, title, COALESCE(content, ''),
+	       COALESCE(updated_at, CURRENT_TIMESTAMP), COALESCE(updated_at, CURRENT_TIMESTAMP)
+	FROM notes;
+	`,
+
+	// 10: links from notes to notes, tasks and projects. target_id is NULL
+	// while a link is broken.
+	`
+	CREATE TABLE IF NOT EXISTS note_links (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		note_id INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		kind TEXT NOT NULL CHECK(kind IN ('wiki', 'ref')),
+		text TEXT NOT NULL,
+		target_key TEXT NOT NULL,
+		target_type TEXT CHECK(target_type IN ('note', 'task', 'project')),
+		target_id INTEGER,
+		FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_note_links_note ON note_links(note_id);
+	CREATE INDEX IF NOT EXISTS idx_note_links_target ON note_links(user_id, target_type, target_id);
+	CREATE INDEX IF NOT EXISTS idx_note_links_key ON note_links(user_id, target_key);
+	`,
+
+	// 11: notebooks, pinned notes and notes attached to a project
+	`
+	CREATE TABLE IF NOT EXISTS notebooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		parent_id INTEGER,
+		name TEXT NOT NULL,
+		sort_order TEXT NOT NULL DEFAULT 'updated' CHECK(sort_order IN ('updated', 'created', 'title', 'manual')),
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (parent_id) REFERENCES notebooks(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_notebooks_user ON notebooks(user_id, parent_id);
+
+	ALTER TABLE notes ADD COLUMN notebook_id INTEGER REFERENCES notebooks(id);
+	ALTER TABLE notes ADD COLUMN project_id INTEGER REFERENCES projects(id);
+	ALTER TABLE notes ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT 0;
+	ALTER TABLE notes ADD COLUMN position INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX IF NOT EXISTS idx_notes_notebook ON notes(user_id, notebook_id);
+	CREATE INDEX IF NOT EXISTS idx_notes_project ON notes(project_id);
+	`,
+
+	// 12: tasks created from a note's checklist remember the item they came
+	// from, so completing the task can tick it
+	`
+	ALTER TABLE tasks ADD COLUMN source_note_id INTEGER REFERENCES notes(id);
+	ALTER TABLE tasks ADD COLUMN source_item_index INTEGER;
+	ALTER TABLE tasks ADD COLUMN source_item_text TEXT;
+	CREATE INDEX IF NOT EXISTS idx_tasks_source_note ON tasks(source_note_id);
+	`,
+
+	// 13: private notes, encrypted with a per-user data key that is stored
+	// wrapped under the user's passphrase
+	`
+	CREATE TABLE IF NOT EXISTS note_keys (
+		user_id INTEGER PRIMARY KEY,
+		salt BLOB NOT NULL,
+		argon_time INTEGER NOT NULL,
+		argon_memory INTEGER NOT NULL,
+		argon_threads INTEGER NOT NULL,
+		wrapped_key BLOB NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	ALTER TABLE notes ADD COLUMN encrypted BOOLEAN NOT NULL DEFAULT 0;
+	`,
+
+	// 14: when tasks were completed, kept up to date by triggers so every
+	// way of completing a task records it. Tasks completed before this
+	// migration are taken to have been completed at their last update.
+	`
+	ALTER TABLE tasks ADD COLUMN completed_at DATETIME;
+	UPDATE tasks SET completed_at = COALESCE(updated_at, created_at) WHERE done = 1;
+	CREATE INDEX IF NOT EXISTS idx_tasks_completed ON tasks(user_id, completed_at);
+
+	CREATE TRIGGER IF NOT EXISTS tasks_completed_insert AFTER INSERT ON tasks
+	WHEN NEW.done AND NEW.completed_at IS NULL
+	BEGIN
+		UPDATE tasks SET completed_at = COALESCE(NEW.updated_at, CURRENT_TIMESTAMP) WHERE id = NEW.id;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS tasks_completed_update AFTER UPDATE OF done ON tasks
+	WHEN NEW.done != OLD.done
+	BEGIN
+		UPDATE tasks SET completed_at = CASE WHEN NEW.done THEN CURRENT_TIMESTAMP END WHERE id = NEW.id;
+	END;
+	`,
+
+	// 15: when work on a task started, and the history of every task's
+	// lifecycle, written by triggers like completed_at
+	`
+	ALTER TABLE tasks ADD COLUMN started_at DATETIME;
+
+	CREATE TABLE IF NOT EXISTS task_lifecycle (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		event TEXT NOT NULL CHECK(event IN ('created', 'started', 'completed', 'reopened')),
+		at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_task_lifecycle_user ON task_lifecycle(user_id, event, at);
+	CREATE INDEX IF NOT EXISTS idx_task_lifecycle_task ON task_lifecycle(task_id);
+
+	INSERT INTO task_lifecycle (task_id, user_id, event, at)
+	SELECT id, user_id, 'created', COALESCE(created_at, CURRENT_TIMESTAMP) FROM tasks;
+	INSERT INTO task_lifecycle (task_id, user_id, event, at)
+	SELECT id, user_id, 'completed', completed_at FROM tasks WHERE completed_at IS NOT NULL;
+
+	CREATE TRIGGER IF NOT EXISTS task_lifecycle_insert AFTER INSERT ON tasks
+	BEGIN
+		INSERT INTO task_lifecycle (task_id, user_id, event, at)
+		VALUES (NEW.id, NEW.user_id, 'created', COALESCE(NEW.created_at, CURRENT_TIMESTAMP));
+		INSERT INTO task_lifecycle (task_id, user_id, event, at)
+		SELECT NEW.id, NEW.user_id, 'started', NEW.started_at WHERE NEW.started_at IS NOT NULL;
+		INSERT INTO task_lifecycle (task_id, user_id, event, at)
+		SELECT NEW.id, NEW.user_id, 'completed', COALESCE(NEW.completed_at, NEW.updated_at, CURRENT_TIMESTAMP) WHERE NEW.done;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS task_lifecycle_done AFTER UPDATE OF done ON tasks
+	WHEN NEW.done != OLD.done
+	BEGIN
+		INSERT INTO task_lifecycle (task_id, user_id, event, at)
+		VALUES (NEW.id, NEW.user_id, CASE WHEN NEW.done THEN 'completed' ELSE 'reopened' END, CURRENT_TIMESTAMP);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS task_lifecycle_started AFTER UPDATE OF started_at ON tasks
+	WHEN NEW.started_at IS NOT NULL AND OLD.started_at IS NULL
+	BEGIN
+		INSERT INTO task_lifecycle (task_id, user_id, event, at)
+		VALUES (NEW.id, NEW.user_id, 'started', NEW.started_at);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS task_lifecycle_delete AFTER DELETE ON tasks
+	BEGIN
+		DELETE FROM task_lifecycle WHERE task_id = OLD.id;
+	END;
+	`,
+
+	// 16: analytics reports, generated on a schedule or on demand. A run is
+	// claimed per report and period like a digest delivery.
+	`
+	CREATE TABLE IF NOT EXISTS report_definitions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		metrics TEXT NOT NULL,
+		project_ids TEXT NOT NULL DEFAULT '',
+		range_days INTEGER NOT NULL DEFAULT 7,
+		granularity TEXT NOT NULL DEFAULT 'day',
+		formats TEXT NOT NULL DEFAULT 'csv,html',
+		frequency TEXT NOT NULL DEFAULT 'off',
+		weekday INTEGER NOT NULL DEFAULT 1,
+		hour INTEGER NOT NULL DEFAULT 8,
+		email BOOLEAN NOT NULL DEFAULT 0,
+		recipients TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS report_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		report_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		period TEXT NOT NULL,
+		from_date TEXT NOT NULL,
+		to_date TEXT NOT NULL,
+		csv_document_id INTEGER,
+		html_document_id INTEGER,
+		emailed_to TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		finished_at DATETIME,
+		UNIQUE (report_id, period),
+		FOREIGN KEY (report_id) REFERENCES report_definitions(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_report_definitions_user_id ON report_definitions(user_id);
+	`,
+	// 17: webhook receivers' response bodies are no longer kept, so a
+	// webhook cannot be used to read what an internal service answers
+	`
+	UPDATE webhook_delivery_attempts SET response_body = NULL;
+	`,
+
+	// 18: the CalDAV change log only records updates a client can see, and
+	// is pruned. The horizon of a collection is the newest change pruned
+	// from it; sync tokens older than that are no longer honoured.
+	`
+	DROP TRIGGER IF EXISTS task_changes_update;
+	CREATE TRIGGER task_changes_update AFTER UPDATE ON tasks
+	WHEN NEW.description IS NOT OLD.description
+	  OR NEW.done IS NOT OLD.done
+	  OR NEW.due_date IS NOT OLD.due_date
+	  OR NEW.priority IS NOT OLD.priority
+	  OR NEW.project_id IS NOT OLD.project_id
+	  OR NEW.ical_uid IS NOT OLD.ical_uid
+	  OR NEW.ical_name IS NOT OLD.ical_name
+	BEGIN
+		INSERT INTO task_changes (task_id, user_id, project_id, name)
+		SELECT OLD.id, OLD.user_id, COALESCE(OLD.project_id, 0), COALESCE(OLD.ical_name, 'task-' || OLD.id || '.ics')
+		WHERE COALESCE(OLD.project_id, 0) != COALESCE(NEW.project_id, 0)
+		   OR COALESCE(OLD.ical_name, '') != COALESCE(NEW.ical_name, '');
+		INSERT INTO task_changes (task_id, user_id, project_id, name)
+		VALUES (NEW.id, NEW.user_id, COALESCE(NEW.project_id, 0), COALESCE(NEW.ical_name, 'task-' || NEW.id || '.ics'));
+	END;
+
+	CREATE TABLE IF NOT EXISTS task_change_horizons (
+		user_id INTEGER NOT NULL,
+		project_id INTEGER NOT NULL,
+		seq INTEGER NOT NULL,
+		PRIMARY KEY (user_id, project_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_task_changes_changed_at ON task_changes(changed_at);
+	`,
+
+	// 19: calendar feed filters are stored with the feed, as a query
+	// string. Feeds from before keep NULL and still read them from the URL.
+	`
+	ALTER TABLE calendar_feeds ADD COLUMN filters TEXT;
+	`,
+}
+
+// migrate applies the migrations db has not had yet.
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+		slog.Info("Applied migration", "version", version)
+	}
+
+	return nil
+}
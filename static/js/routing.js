@@ -15,6 +15,9 @@ class TaskLiftRouter {
         this.currentRoute = 'overview';
         this.allTasks = [];
         this.currentFilter = 'all';
+        this.liveUpdates = false;
+        this.pendingChanges = new Set();
+        this.refreshTimer = null;
         this.init();
     }
 
@@ -37,6 +40,68 @@ class TaskLiftRouter {
         // Load initial route from URL hash
         const initialRoute = window.location.hash.replace('#', '') || 'overview';
         this.showPage(initialRoute, false);
+
+        this.connectLiveUpdates();
+    }
+
+    // LIVE UPDATES
+    // Changes made in other tabs (or by this one) arrive over /api/events.
+    // While the stream is open, actions rely on it instead of refetching.
+    connectLiveUpdates() {
+        if (!window.EventSource) return;
+
+        const source = new EventSource('/api/events');
+        source.onopen = () => { this.liveUpdates = true; };
+        source.onerror = () => { this.liveUpdates = false; };
+
+        const eventTypes = [
+            'task.created', 'task.updated', 'task.completed', 'task.deleted',
+            'project.created', 'project.updated', 'project.deleted',
+            'note.created', 'note.updated', 'note.deleted'
+        ];
+        eventTypes.forEach(type => {
+            source.addEventListener(type, () => this.scheduleRefresh(type.split('.')[0]));
+        });
+        // The server could not replay everything we missed, reload it all.
+        source.addEventListener('reset', () => this.scheduleRefresh('all'));
+    }
+
+    scheduleRefresh(kind) {
+        this.pendingChanges.add(kind);
+        clearTimeout(this.refreshTimer);
+        this.refreshTimer = setTimeout(() => {
+            const changes = this.pendingChanges;
+            this.pendingChanges = new Set();
+            this.refreshForChanges(changes);
+        }, 200);
+    }
+
+    async refreshForChanges(changes) {
+        const all = changes.has('all');
+        const tasksOrProjects = all || changes.has('task') || changes.has('project');
+
+        try {
+            switch (this.currentRoute) {
+                case 'tasks':
+                    if (tasksOrProjects) await this.loadTasks();
+                    break;
+                case 'projects':
+                    if (tasksOrProjects) await this.loadProjects();
+                    break;
+                case 'notes':
+                    if (all || changes.has('note')) await this.loadNotes();
+                    break;
+                case 'analytics':
+                    if (tasksOrProjects) await this.loadAnalytics();
+                    break;
+                case 'overview':
+                    if (tasksOrProjects) await this.loadOverview();
+                    return;
+            }
+            if (tasksOrProjects) await this.loadOverviewStats();
+        } catch (error) {
+            console.error('Error applying live update:', error);
+        }
     }
 
     navigateTo(route) {
@@ -346,8 +411,10 @@ class TaskLiftRouter {
         });
         
         if (response.ok) {
-            await this.loadTasks();
-            await this.loadOverviewStats();
+            if (!this.liveUpdates) {
+                await this.loadTasks();
+                await this.loadOverviewStats();
+            }
         } else {
             alert('Failed to update task');
         }
@@ -394,8 +461,10 @@ async confirmDeleteTask(taskId) {
         });
         
         if (response.ok) {
-            await this.loadTasks();
-            await this.loadOverviewStats();
+            if (!this.liveUpdates) {
+                await this.loadTasks();
+                await this.loadOverviewStats();
+            }
         } else {
             alert('Failed to delete task');
         }
@@ -547,8 +616,10 @@ async confirmDeleteProject(projectId) {
         });
         
         if (response.ok) {
-            await this.loadProjects();
-            await this.loadOverviewStats();
+            if (!this.liveUpdates) {
+                await this.loadProjects();
+                await this.loadOverviewStats();
+            }
         } else {
             alert('Failed to delete project');
         }
@@ -686,17 +757,41 @@ async confirmDeleteProject(projectId) {
                 <h4>${this.escapeHtml(note.title)}</h4>
                 <small>Updated ${this.formatDate(note.updated_at)}</small>
             </div>
-            <div class="note-content">
-                <p>${this.escapeHtml(note.content.substring(0, 150))}${note.content.length > 150 ? '...' : ''}</p>
-            </div>
+            <div class="note-content markdown-body">${note.content_html || ''}</div>
             <div class="note-actions">
                 <button onclick="router.editNote(${note.id})" class="note-action">Edit</button>
                 <button onclick="router.deleteNote(${note.id})" class="note-action">Delete</button>
             </div>
         `;
+        // content_html is sanitized by the server. Its task list checkboxes
+        // are rendered disabled; enable them and save clicks to the source.
+        div.querySelectorAll('.note-content input[type="checkbox"]').forEach((box, index) => {
+            box.disabled = false;
+            box.addEventListener('change', () => this.toggleNoteTask(note.id, index, box));
+        });
         return div;
     }
 
+    async toggleNoteTask(noteId, index, box) {
+        const formData = new FormData();
+        formData.append('id', noteId);
+        formData.append('index', index);
+        formData.append('checked', box.checked);
+        try {
+            const response = await fetch('/api/notes/tasks/toggle', {
+                method: 'POST',
+                body: formData
+            });
+            if (!response.ok) {
+                box.checked = !box.checked;
+                alert(await response.text());
+            }
+        } catch (error) {
+            box.checked = !box.checked;
+            console.error('Failed to update note task:', error);
+        }
+    }
+
     async editNote(noteId) {
         try {
             const response = await fetch('/api/notes');
@@ -746,7 +841,9 @@ async confirmDeleteProject(projectId) {
             });
             
             if (response.ok) {
-                await this.loadNotes();
+                if (!this.liveUpdates) {
+                    await this.loadNotes();
+                }
             } else {
                 alert('Failed to delete note');
             }
@@ -878,8 +975,10 @@ async function createTask(event) {
         
         if (response.ok) {
             form.closest('.modal').remove();
-            await router.loadTasks();
-            await router.loadOverviewStats();
+            if (!router.liveUpdates) {
+                await router.loadTasks();
+                await router.loadOverviewStats();
+            }
         } else {
             const contentType = response.headers.get('content-type');
             let errorMessage = 'Failed to create task';
@@ -1000,8 +1099,10 @@ async function updateTask(event, taskId) {
         
         if (response.ok) {
             form.closest('.modal').remove();
-            await router.loadTasks();
-            await router.loadOverviewStats();
+            if (!router.liveUpdates) {
+                await router.loadTasks();
+                await router.loadOverviewStats();
+            }
         } else {
             const contentType = response.headers.get('content-type');
             let errorMessage = 'Failed to update task';
@@ -1079,8 +1180,10 @@ async function submitProject(event) {
         
         if (response.ok) {
             form.closest('.modal').remove();
-            await router.loadProjects();
-            await router.loadOverviewStats();
+            if (!router.liveUpdates) {
+                await router.loadProjects();
+                await router.loadOverviewStats();
+            }
         } else {
             alert('Failed to create project. Please try again.');
         }
@@ -1150,8 +1253,10 @@ async function updateProject(event, projectId) {
         
         if (response.ok) {
             form.closest('.modal').remove();
-            await router.loadProjects();
-            await router.loadOverviewStats();
+            if (!router.liveUpdates) {
+                await router.loadProjects();
+                await router.loadOverviewStats();
+            }
         } else {
             alert('Failed to update project. Please try again.');
         }
@@ -1203,7 +1308,9 @@ async function submitNote(event) {
         
         if (response.ok) {
             form.closest('.modal').remove();
-            await router.loadNotes();
+            if (!router.liveUpdates) {
+                await router.loadNotes();
+            }
         } else {
             alert('Failed to create note. Please try again.');
         }
@@ -1256,7 +1363,9 @@ async function updateNote(event, noteId) {
         
         if (response.ok) {
             form.closest('.modal').remove();
-            await router.loadNotes();
+            if (!router.liveUpdates) {
+                await router.loadNotes();
+            }
         } else {
             alert('Failed to update note. Please try again.');
         }
@@ -0,0 +1,121 @@
+// Package tracing sets up OpenTelemetry tracing. Spans are exported over
+// OTLP/HTTP to a collector when one is configured, and trace context is read
+// from and passed on in W3C traceparent headers.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("task-manager")
+
+// Setup installs the tracer provider and the W3C propagators. Spans are
+// exported when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, e.g. to http://localhost:4318
+// for a local collector; the other standard OTEL_* variables such as
+// OTEL_SERVICE_NAME and OTEL_TRACES_SAMPLER apply as well. Without an
+// endpoint spans are still made, so trace IDs show up in logs and
+// responses, but they are not sent anywhere. The returned function flushes
+// the spans still buffered.
+func Setup(ctx context.Context) (shutdown func(context.Context) error, exporting bool, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "tasklift")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK())
+	if err != nil {
+		return nil, false, err
+	}
+	options := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+		exporting = true
+	}
+
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, exporting, nil
+}
+
+// Start starts a span for work done inside the server, as a child of the
+// span in ctx if there is one.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartClient starts a span for a call to another service, such as a
+// webhook receiver or a mail server.
+func StartClient(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// StartServer starts a span for a request the server handles, continuing
+// the trace of its traceparent header, if any.
+func StartServer(ctx context.Context, header http.Header, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+// Inject adds the trace context of ctx to the headers of an outgoing
+// request.
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// End ends span, marking it failed when err is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceID returns the hex ID of the trace ctx belongs to, or "" outside of
+// one.
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// Query starts a span for a database statement and returns the function
+// that ends it. Statements are only traced as part of a trace that is
+// already going, such as a request's, so background polling does not flood
+// the collector with one-span traces.
+func Query(ctx context.Context, operation, statement string) (done func(err error)) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return func(error) {}
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system.name", "sqlite"),
+		attribute.String("db.operation.name", operation),
+	}
+	if statement != "" {
+		attrs = append(attrs, attribute.String("db.query.text", statement))
+	}
+	_, span := tracer.Start(ctx, "db "+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return func(err error) {
+		End(span, err)
+	}
+}